@@ -0,0 +1,45 @@
+// Command audit-verify recomputes the security audit log's hash chain and
+// reports any entry that was altered, removed or inserted out of band. It
+// exits 1 when the chain is broken, so it can run from cron or CI.
+//
+//	go run ./cmd/audit-verify
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/joho/godotenv"
+
+	"secure-communication-ltd/backend/internal/repository"
+	"secure-communication-ltd/backend/internal/services"
+)
+
+func main() {
+	batch := flag.Int("batch", 1000, "entries read per query")
+	flag.Parse()
+
+	_ = godotenv.Load(".env")
+
+	db, err := repository.NewMySQL()
+	if err != nil {
+		log.Fatal("db connect error: ", err)
+	}
+	defer db.Close()
+
+	rep, err := services.VerifyAuditChain(db, *batch)
+	if err != nil {
+		log.Fatal("verify: ", err)
+	}
+	fmt.Printf("entries: %d\nlast id: %d\nlast hash: %s\n", rep.Entries, rep.LastID, rep.LastHash)
+	if len(rep.Problems) > 0 {
+		fmt.Printf("chain BROKEN (%d problem(s)):\n", len(rep.Problems))
+		for _, p := range rep.Problems {
+			fmt.Println("  " + p)
+		}
+		os.Exit(1)
+	}
+	fmt.Println("chain OK")
+}
@@ -0,0 +1,124 @@
+// Command bootstrap-admin creates the first administrator, or grants the
+// admin role to an existing account. It refuses to run once an admin exists
+// unless -force is given.
+//
+//	BOOTSTRAP_ADMIN_PASSWORD='...' go run ./cmd/bootstrap-admin -username alice -email alice@example.com
+//	go run ./cmd/bootstrap-admin -email alice@example.com   # promote an existing user
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/joho/godotenv"
+
+	"secure-communication-ltd/backend/config"
+	"secure-communication-ltd/backend/internal/repository"
+	"secure-communication-ltd/backend/internal/services"
+)
+
+func main() {
+	username := flag.String("username", "", "username for a new admin account")
+	email := flag.String("email", "", "email of the admin account (new or existing)")
+	force := flag.Bool("force", false, "run even if an admin already exists")
+	flag.Parse()
+
+	_ = godotenv.Load(".env")
+
+	if *email == "" {
+		log.Fatal("-email is required")
+	}
+	_, emailCanon, err := services.CanonicalEmail(*email)
+	if err != nil {
+		log.Fatal("invalid -email")
+	}
+
+	db, err := repository.NewMySQL()
+	if err != nil {
+		log.Fatal("db connect error: ", err)
+	}
+	defer db.Close()
+
+	var admins int
+	if err := db.Get(&admins, `
+		SELECT COUNT(*) FROM user_roles ur JOIN roles r ON r.id = ur.role_id WHERE r.name = ?
+	`, services.RoleAdmin); err != nil {
+		log.Fatal("count admins: ", err)
+	}
+	if admins > 0 && !*force {
+		log.Fatalf("%d admin(s) already exist; use the admin API or pass -force", admins)
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		log.Fatal("tx: ", err)
+	}
+	defer tx.Rollback()
+
+	var uid int64
+	err = tx.Get(&uid, `SELECT id FROM users WHERE email_canonical = ?`, emailCanon)
+	switch {
+	case err == sql.ErrNoRows:
+		if *username == "" {
+			log.Fatal("no account with that email; pass -username to create one")
+		}
+		pw := readPassword()
+		policyPath := os.Getenv("PASSWORD_POLICY_FILE")
+		if policyPath == "" {
+			policyPath = "config/password-policy.toml"
+		}
+		pol, _ := config.LoadPasswordPolicy(policyPath) // falls back to defaults
+		if err := services.ValidatePassword(pw, pol); err != nil {
+			log.Fatal("password rejected by policy: ", err)
+		}
+		uid, err = services.InsertUser(tx, services.NewUser{
+			Username: *username,
+			Email:    *email,
+			Password: pw,
+			Verified: true,
+		})
+		if err != nil {
+			log.Fatal("create user: ", err)
+		}
+		fmt.Printf("created user %d\n", uid)
+	case err != nil:
+		log.Fatal("lookup user: ", err)
+	default:
+		fmt.Printf("found existing user %d\n", uid)
+	}
+
+	if err := services.GrantRole(tx, uid, services.RoleAdmin, nil); err != nil {
+		log.Fatal("grant admin: ", err)
+	}
+	if slug := services.DefaultOrgSlug(); slug != "" {
+		if err := services.AddOrgMemberBySlug(tx, slug, uid, nil); err != nil {
+			log.Fatal("join default organization: ", err)
+		}
+	}
+	if _, err := tx.Exec(`UPDATE users SET is_active = TRUE, is_verified = TRUE WHERE id = ?`, uid); err != nil {
+		log.Fatal("activate user: ", err)
+	}
+	if err := tx.Commit(); err != nil {
+		log.Fatal("commit: ", err)
+	}
+	fmt.Printf("user %d is now an admin\n", uid)
+}
+
+// readPassword takes BOOTSTRAP_ADMIN_PASSWORD, or the first line of stdin,
+// so the password never appears in the process list or shell history.
+func readPassword() string {
+	if pw := os.Getenv("BOOTSTRAP_ADMIN_PASSWORD"); pw != "" {
+		return pw
+	}
+	fmt.Fprint(os.Stderr, "password: ")
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && line == "" {
+		log.Fatal("no password given")
+	}
+	return strings.TrimRight(line, "\r\n")
+}
@@ -0,0 +1,146 @@
+// Command identity-migrate backfills users.username_canonical,
+// users.username_skeleton and users.email_canonical for databases created
+// before identity canonicalization, and reports accounts that collide under
+// the new rules. See db/migrations/002_identity_canonical_columns.sql.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/joho/godotenv"
+
+	"secure-communication-ltd/backend/internal/repository"
+	"secure-communication-ltd/backend/internal/services"
+)
+
+type userIdent struct {
+	ID       int64  `db:"id"`
+	Username string `db:"username"`
+	Email    string `db:"email"`
+
+	usernameCanon string
+	skeleton      string
+	emailCanon    string
+}
+
+func main() {
+	apply := flag.Bool("apply", false, "write canonical columns (refused while collisions exist)")
+	flag.Parse()
+
+	_ = godotenv.Load(".env")
+
+	db, err := repository.NewMySQL()
+	if err != nil {
+		log.Fatal("db connect error: ", err)
+	}
+	defer db.Close()
+
+	var users []userIdent
+	if err := db.Select(&users, `SELECT id, username, email FROM users ORDER BY id`); err != nil {
+		log.Fatal("load users: ", err)
+	}
+
+	var warnings, renames []string
+	for i := range users {
+		u := &users[i]
+		if _, canon, err := services.CanonicalUsername(u.Username); err == nil {
+			u.usernameCanon = canon
+		} else if strings.Contains(u.Username, "@") {
+			// LoginLookup treats any identifier with '@' as an email, so this
+			// account could never sign in by username again.
+			u.usernameCanon = services.FoldUsername(u.Username)
+			renames = append(renames, fmt.Sprintf("user %d: username %q contains '@'", u.ID, u.Username))
+		} else {
+			// Keep the account reachable; the rule violation is only reported.
+			u.usernameCanon = services.FoldUsername(u.Username)
+			warnings = append(warnings, fmt.Sprintf("user %d: username %q does not meet the new rules: %v", u.ID, u.Username, err))
+		}
+		u.skeleton = services.UsernameSkeleton(u.usernameCanon)
+
+		if _, canon, err := services.CanonicalEmail(u.Email); err == nil {
+			u.emailCanon = canon
+		} else {
+			u.emailCanon = strings.ToLower(strings.TrimSpace(u.Email))
+			warnings = append(warnings, fmt.Sprintf("user %d: email %q is not a valid address", u.ID, u.Email))
+		}
+	}
+
+	var collisions []string
+	report := func(kind string, key func(userIdent) string) {
+		groups := map[string][]int64{}
+		for _, u := range users {
+			k := key(u)
+			groups[k] = append(groups[k], u.ID)
+		}
+		keys := make([]string, 0, len(groups))
+		for k, ids := range groups {
+			if len(ids) > 1 {
+				keys = append(keys, k)
+			}
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			collisions = append(collisions, fmt.Sprintf("%s %q shared by users %v", kind, k, groups[k]))
+		}
+	}
+	report("canonical username", func(u userIdent) string { return u.usernameCanon })
+	report("username skeleton", func(u userIdent) string { return u.skeleton })
+	report("canonical email", func(u userIdent) string { return u.emailCanon })
+
+	// A username equal to someone else's email made `WHERE email = ? OR username = ?` ambiguous.
+	byEmail := map[string]int64{}
+	for _, u := range users {
+		byEmail[u.emailCanon] = u.ID
+	}
+	for _, u := range users {
+		if other, ok := byEmail[u.usernameCanon]; ok && other != u.ID {
+			collisions = append(collisions, fmt.Sprintf("username of user %d equals the email of user %d", u.ID, other))
+		}
+	}
+
+	for _, w := range warnings {
+		fmt.Println("WARN ", w)
+	}
+	for _, r := range renames {
+		fmt.Println("RENAME ", r)
+	}
+	for _, c := range collisions {
+		fmt.Println("COLLISION ", c)
+	}
+	fmt.Printf("%d user(s), %d warning(s), %d rename(s), %d collision(s)\n",
+		len(users), len(warnings), len(renames), len(collisions))
+
+	if len(renames) > 0 || len(collisions) > 0 {
+		if *apply {
+			fmt.Println("refusing to apply: rename the listed accounts and resolve collisions first")
+		}
+		os.Exit(1)
+	}
+	if !*apply {
+		return
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		log.Fatal("tx: ", err)
+	}
+	defer tx.Rollback()
+	for _, u := range users {
+		if _, err := tx.Exec(`
+			UPDATE users
+			SET username_canonical = ?, username_skeleton = ?, email_canonical = ?
+			WHERE id = ?
+		`, u.usernameCanon, u.skeleton, u.emailCanon, u.ID); err != nil {
+			log.Fatalf("update user %d: %v", u.ID, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		log.Fatal("commit: ", err)
+	}
+	fmt.Println("canonical columns written; now apply db/migrations/003_identity_canonical_constraints.sql")
+}
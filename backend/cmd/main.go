@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 
@@ -17,6 +18,7 @@ import (
 	"secure-communication-ltd/backend/internal/handlers"
 	middlewarex "secure-communication-ltd/backend/internal/middleware"
 	"secure-communication-ltd/backend/internal/repository"
+	"secure-communication-ltd/backend/internal/services"
 )
 
 func main() {
@@ -42,35 +44,143 @@ func main() {
 	if err := config.WatchPolicy(ctx, policyPath); err != nil {
 		log.Printf("policy watch warning: %v", err)
 	}
+	services.RunAccountPurger(ctx, db, time.Hour)
+	services.RunCustomerTrashPurger(ctx, db, time.Hour)
+	if n, err := services.FailInterruptedImports(db); err != nil {
+		log.Printf("customer import cleanup warning: %v", err)
+	} else if n > 0 {
+		log.Printf("marked %d interrupted customer import(s) as failed", n)
+	}
+	services.RunImportJobPurger(ctx, db, time.Hour)
+
+	if _, err := services.CookieConfigFromEnv(); err != nil {
+		log.Fatal("cookie config error: ", err)
+	}
+
+	proxies, err := services.TrustedProxiesFromEnv()
+	if err != nil {
+		log.Fatal("trusted proxy config error: ", err)
+	}
+
+	// Forward audit events to the SOC's collector (off while SIEM_TARGET is empty)
+	siemCfg, err := services.SIEMConfigFromEnv()
+	if err != nil {
+		log.Fatal("SIEM config error: ", err)
+	}
+	var siem *services.SIEMExporter
+	if siemCfg != nil {
+		siem = services.NewSIEMExporter(siemCfg)
+		services.SetAuditExporter(siem)
+	}
 
 	e := echo.New()
 	e.HideBanner = true
+	// c.RealIP() is the client address for logging, rate limits and audit:
+	// forwarding headers count only when they come from a trusted proxy
+	e.IPExtractor = proxies.ClientIP
+	e.Use(middleware.RequestID()) // X-Request-Id, also recorded in the audit log
 	e.Use(middleware.Logger())
 	e.Use(middleware.Recover())
+	e.Use(middlewarex.SecurityHeaders())
 
+	origins := []string{
+		"http://localhost:5173", // Vite dev
+		"http://127.0.0.1:5173",
+		"http://localhost:3000", // Docker/nginx
+		"http://127.0.0.1:3000",
+	}
+	if fe := os.Getenv("FRONTEND_PUBLIC_URL"); fe != "" {
+		origins = append(origins, strings.TrimRight(fe, "/"))
+	}
 	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
-		AllowOrigins: []string{
-			"http://localhost:5173", // Vite dev
-			"http://127.0.0.1:5173",
-			"http://localhost:3000", // Docker/nginx
-			"http://127.0.0.1:3000",
-		},
-		AllowMethods:     []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodDelete, http.MethodOptions},
-		AllowHeaders:     []string{echo.HeaderContentType, echo.HeaderAuthorization},
+		AllowOrigins:     origins,
+		AllowMethods:     []string{http.MethodGet, http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete, http.MethodOptions},
+		AllowHeaders:     []string{echo.HeaderContentType, echo.HeaderAuthorization, echo.HeaderXCSRFToken, "If-Match", "If-None-Match"},
+		ExposeHeaders:    []string{"ETag"},
 		AllowCredentials: true,
 	}))
 
+	// CSRF for every state-changing route; the backend's own origin posts the OAuth consent form
+	backendURL := os.Getenv("BACKEND_PUBLIC_URL")
+	if backendURL == "" {
+		backendURL = "http://localhost:8080"
+	}
+	e.Use(middlewarex.CSRF(append(origins, backendURL)))
+
+	oidcProvider, err := services.NewOIDCProviderFromEnv()
+	if err != nil {
+		log.Fatal("oidc config error: ", err)
+	}
+	if !services.LocalLoginEnabled() && oidcProvider == nil {
+		log.Printf("warning: LOCAL_LOGIN_ENABLED=false and OIDC is not configured; nobody can sign in")
+	}
+
+	requireAuth := middlewarex.RequireAuth(db)
+	sessionOnly := middlewarex.RequireSession
+	noImpersonation := middlewarex.DenyImpersonation
+
 	e.GET("/health", func(c echo.Context) error { return c.String(http.StatusOK, "ok") })
 	e.GET("/hello", func(c echo.Context) error { return c.String(http.StatusOK, "Hello, Secure Backend!") })
 
+	e.GET("/api/csrf", handlers.CSRFToken())
 	e.POST("/api/register", handlers.Register(db))
 	e.GET("/api/verify-email", handlers.VerifyEmail(db))
 	e.POST("/api/login", handlers.Login(db))
-	e.POST("/api/logout", handlers.Logout())
-	e.GET("/api/me", handlers.Me(), middlewarex.RequireAuth)
+	e.POST("/api/logout", handlers.Logout(db))
+	e.GET("/api/security/lockdown", handlers.SecurityLockdownPage())
+	e.POST("/api/security/lockdown", handlers.SecurityLockdown(db))
+	e.GET("/api/me", handlers.Me(db), requireAuth)
 	e.POST("/api/login/mfa", handlers.LoginMFA(db))
-	e.POST("/api/customers", handlers.CreateCustomer(db), middlewarex.RequireAuth)
-	e.GET("/api/customers/search", handlers.SearchCustomers(db), middlewarex.RequireAuth)
+
+	// Single sign-on (OIDC)
+	e.GET("/api/auth/config", handlers.AuthConfig(oidcProvider))
+	if oidcProvider != nil {
+		e.GET("/api/auth/oidc/login", handlers.OIDCLogin(db, oidcProvider))
+		e.GET("/api/auth/oidc/callback", handlers.OIDCCallback(db, oidcProvider))
+	}
+
+	// Customers are tenant data: every route runs inside the session's active organization
+	requireOrg := middlewarex.RequireOrg(db)
+	e.POST("/api/customers", handlers.CreateCustomer(db),
+		requireAuth, requireOrg, middlewarex.RequirePermission(services.PermCustomersWrite))
+	e.GET("/api/customers", handlers.ListCustomers(db),
+		requireAuth, requireOrg, middlewarex.RequirePermission(services.PermCustomersRead))
+	e.GET("/api/customers/search", handlers.SearchCustomers(db),
+		requireAuth, requireOrg, middlewarex.RequirePermission(services.PermCustomersRead))
+	e.GET("/api/customers/:id", handlers.GetCustomer(db),
+		requireAuth, requireOrg, middlewarex.RequirePermission(services.PermCustomersRead))
+	e.PUT("/api/customers/:id", handlers.UpdateCustomer(db),
+		requireAuth, requireOrg, middlewarex.RequirePermission(services.PermCustomersWrite))
+	e.PATCH("/api/customers/:id", handlers.UpdateCustomer(db),
+		requireAuth, requireOrg, middlewarex.RequirePermission(services.PermCustomersWrite))
+	e.DELETE("/api/customers/:id", handlers.DeleteCustomer(db),
+		requireAuth, requireOrg, middlewarex.RequirePermission(services.PermCustomersWrite))
+	e.GET("/api/customers/:id/history", handlers.ListCustomerHistory(db),
+		requireAuth, requireOrg, middlewarex.RequirePermission(services.PermCustomersRead))
+	e.POST("/api/customers/:id/history/:version/restore", handlers.RestoreCustomerVersion(db),
+		requireAuth, requireOrg, middlewarex.RequirePermission(services.PermCustomersWrite))
+	e.POST("/api/customers/import", handlers.ImportCustomers(db),
+		requireAuth, requireOrg, noImpersonation, middlewarex.RequirePermission(services.PermCustomersWrite))
+	e.GET("/api/customers/import/:id", handlers.GetCustomerImport(db),
+		requireAuth, requireOrg, middlewarex.RequirePermission(services.PermCustomersWrite))
+	// Deleted customers wait in the trash; only admins see and restore them
+	e.GET("/api/customers/trash", handlers.ListCustomerTrash(db),
+		requireAuth, requireOrg, middlewarex.RequirePermission(services.PermUsersAdmin))
+	e.POST("/api/customers/trash/:id/restore", handlers.RestoreCustomerFromTrash(db),
+		requireAuth, requireOrg, middlewarex.RequirePermission(services.PermUsersAdmin))
+
+	// Organizations
+	e.GET("/api/orgs", handlers.ListMyOrgs(db), requireAuth)
+	e.POST("/api/orgs/switch", handlers.SwitchOrg(db), requireAuth, sessionOnly, noImpersonation)
+
+	// OAuth 2.0 authorization server (consent uses the browser session)
+	e.GET("/oauth/authorize", handlers.OAuthAuthorize(db))
+	e.POST("/oauth/authorize", handlers.OAuthAuthorizeDecision(db))
+	e.POST("/oauth/token", handlers.OAuthToken(db))
+	e.POST("/oauth/introspect", handlers.OAuthIntrospect(db))
+	e.POST("/oauth/revoke", handlers.OAuthRevoke(db))
+	e.GET("/api/oauth/grants", handlers.ListMyOAuthGrants(db), requireAuth, sessionOnly)
+	e.DELETE("/api/oauth/grants/:client_id", handlers.RevokeMyOAuthGrant(db), requireAuth, sessionOnly, noImpersonation)
 
 	// Forgot / Reset password
 	e.POST("/api/password/forgot", handlers.PasswordForgot(db))
@@ -81,9 +191,74 @@ func main() {
 		return c.JSON(http.StatusOK, p)
 	})
 	// Change password (authenticated)
-	e.POST("/api/password/change", handlers.ChangePassword(db), middlewarex.RequireAuth)
+	e.POST("/api/password/change", handlers.ChangePassword(db), requireAuth, sessionOnly, noImpersonation)
 	e.GET("/api/password/change/confirm", handlers.ChangePasswordConfirm(db))
 
+	// Personal data export / account deletion (authenticated, except the email landing)
+	e.GET("/api/me/logins", handlers.MyLoginHistory(db), requireAuth, sessionOnly)
+	e.GET("/api/me/sessions", handlers.ListMySessions(db), requireAuth, sessionOnly)
+	e.DELETE("/api/me/sessions", handlers.RevokeMyOtherSessions(db), requireAuth, sessionOnly, noImpersonation)
+	e.DELETE("/api/me/sessions/:id", handlers.RevokeMySession(db), requireAuth, sessionOnly, noImpersonation)
+	e.GET("/api/me/export", handlers.ExportMyData(db), requireAuth, sessionOnly, noImpersonation)
+	e.GET("/api/me/delete", handlers.AccountDeletionStatus(db), requireAuth, sessionOnly)
+	e.POST("/api/me/delete", handlers.RequestAccountDeletion(db), requireAuth, sessionOnly, noImpersonation)
+	e.POST("/api/me/delete/cancel", handlers.CancelAccountDeletion(db), requireAuth, sessionOnly, noImpersonation)
+
+	// API keys (managed from an interactive session only)
+	e.GET("/api/api-keys", handlers.ListAPIKeys(db), requireAuth, sessionOnly)
+	e.POST("/api/api-keys", handlers.CreateAPIKey(db), requireAuth, sessionOnly, noImpersonation)
+	e.DELETE("/api/api-keys/:id", handlers.RevokeAPIKey(db), requireAuth, sessionOnly, noImpersonation)
+	e.GET("/api/me/delete/confirm", handlers.ConfirmAccountDeletion(db))
+
+	// Administration (users:admin, interactive sessions only, never while impersonating)
+	admin := e.Group("/api/admin", requireAuth, sessionOnly, noImpersonation,
+		middlewarex.RequirePermission(services.PermUsersAdmin))
+	admin.GET("/roles", handlers.ListRoles(db))
+	admin.GET("/users", handlers.AdminListUsers(db))
+	admin.GET("/users/:id", handlers.AdminGetUser(db))
+	admin.DELETE("/users/:id", handlers.AdminDeleteUser(db))
+	admin.POST("/users/:id/activate", handlers.AdminSetUserActive(db, true))
+	admin.POST("/users/:id/deactivate", handlers.AdminSetUserActive(db, false))
+	admin.POST("/users/:id/password-reset", handlers.AdminForcePasswordReset(db))
+	admin.POST("/users/:id/unlock", handlers.AdminUnlockUser(db))
+	admin.POST("/users/:id/resend-verification", handlers.AdminResendVerification(db))
+	admin.POST("/users/:id/impersonate", handlers.AdminImpersonate(db))
+	admin.GET("/users/:id/roles", handlers.GetUserRoles(db))
+	admin.PUT("/users/:id/roles", handlers.SetUserRoles(db))
+	admin.GET("/orgs", handlers.AdminListOrgs(db))
+	admin.POST("/orgs", handlers.AdminCreateOrg(db))
+	admin.POST("/orgs/:id/members", handlers.AdminAddOrgMember(db))
+	admin.DELETE("/orgs/:id/members/:user_id", handlers.AdminRemoveOrgMember(db))
+	admin.POST("/service-accounts", handlers.AdminCreateServiceAccount(db))
+	admin.GET("/oauth/clients", handlers.AdminListOAuthClients(db))
+	admin.POST("/oauth/clients", handlers.AdminCreateOAuthClient(db))
+	admin.DELETE("/oauth/clients/:id", handlers.AdminRevokeOAuthClient(db))
+	admin.GET("/invites", handlers.AdminListInvites(db))
+	admin.POST("/invites", handlers.AdminCreateInvite(db))
+	admin.DELETE("/invites/:id", handlers.AdminRevokeInvite(db))
+
+	e.POST("/api/impersonation/stop", handlers.StopImpersonation(db), requireAuth, sessionOnly)
+
+	// Security audit log (audit:read, which admins hold by default)
+	e.GET("/api/admin/audit", handlers.AdminListAudit(db), requireAuth, sessionOnly, noImpersonation,
+		middlewarex.RequirePermission(services.PermAuditRead))
+
+	// SCIM 2.0 provisioning for the HR system (enabled by SCIM_BEARER_TOKEN)
+	if tok := os.Getenv("SCIM_BEARER_TOKEN"); tok != "" {
+		scim := e.Group("/scim/v2", middlewarex.RequireSCIMToken(tok))
+		scim.GET("/ServiceProviderConfig", handlers.SCIMServiceProviderConfig())
+		scim.GET("/Users", handlers.SCIMListUsers(db))
+		scim.POST("/Users", handlers.SCIMCreateUser(db))
+		scim.GET("/Users/:id", handlers.SCIMGetUser(db))
+		scim.PUT("/Users/:id", handlers.SCIMReplaceUser(db))
+		scim.PATCH("/Users/:id", handlers.SCIMPatchUser(db))
+		scim.DELETE("/Users/:id", handlers.SCIMDeleteUser(db))
+		scim.GET("/Groups", handlers.SCIMListGroups(db))
+		scim.GET("/Groups/:id", handlers.SCIMGetGroup(db))
+		scim.PUT("/Groups/:id", handlers.SCIMReplaceGroup(db))
+		scim.PATCH("/Groups/:id", handlers.SCIMPatchGroup(db))
+	}
+
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080"
@@ -105,4 +280,9 @@ func main() {
 	if err := e.Shutdown(ctxShutdown); err != nil {
 		log.Printf("server forced to shutdown: %v", err)
 	}
+	if siem != nil {
+		if err := siem.Close(ctxShutdown); err != nil {
+			log.Printf("siem flush: %v", err)
+		}
+	}
 }
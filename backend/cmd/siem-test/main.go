@@ -0,0 +1,124 @@
+// Command siem-test checks the SIEM export without the server. It sends a
+// sample login failure through the exporter configured by SIEM_* (or the
+// flags), and can play the collector itself with -listen.
+//
+//	go run ./cmd/siem-test -listen tcp://127.0.0.1:5514      # terminal 1
+//	go run ./cmd/siem-test -target tcp://127.0.0.1:5514      # terminal 2
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/joho/godotenv"
+
+	"secure-communication-ltd/backend/internal/services"
+)
+
+func main() {
+	listen := flag.String("listen", "", "print what arrives on udp://host:port or tcp://host:port, then exit on Ctrl-C")
+	target := flag.String("target", "", "override SIEM_TARGET")
+	format := flag.String("format", "", "override SIEM_FORMAT")
+	flag.Parse()
+
+	if *listen != "" {
+		if err := serve(*listen); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	_ = godotenv.Load(".env")
+	if *target != "" {
+		os.Setenv("SIEM_TARGET", *target)
+	}
+	if *format != "" {
+		os.Setenv("SIEM_FORMAT", *format)
+	}
+	os.Setenv("SIEM_EVENTS", "") // the sample must not be filtered out
+	cfg, err := services.SIEMConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if cfg == nil {
+		log.Fatal("SIEM_TARGET is not set (or pass -target)")
+	}
+
+	x := services.NewSIEMExporter(cfg)
+	x.Publish(&services.AuditEntry{
+		OccurredAt:  time.Now().UTC(),
+		EventType:   string(services.AuditLoginPassword),
+		Outcome:     services.AuditFailure,
+		ActorUserID: sql.NullInt64{Int64: 1, Valid: true},
+		ActorLabel:  "siem-test",
+		IP:          "192.0.2.1",
+		UserAgent:   "siem-test",
+		RequestID:   "siem-test",
+		Details:     sql.NullString{String: `{"reason":"bad_password"}`, Valid: true},
+		EntryHash:   services.AuditChainGenesis,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := x.Close(ctx); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("sent a %s event to %s\n", cfg.Format, cfg.Target.Redacted())
+}
+
+func serve(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	switch u.Scheme {
+	case "udp":
+		pc, err := net.ListenPacket("udp", u.Host)
+		if err != nil {
+			return err
+		}
+		log.Printf("listening on udp %s", pc.LocalAddr())
+		buf := make([]byte, 65536)
+		for {
+			n, from, err := pc.ReadFrom(buf)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%s: %s\n", from, buf[:n])
+		}
+	case "tcp":
+		ln, err := net.Listen("tcp", u.Host)
+		if err != nil {
+			return err
+		}
+		log.Printf("listening on tcp %s", ln.Addr())
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return err
+			}
+			go func() {
+				defer conn.Close()
+				// raw bytes: syslog over TCP is octet-counted, not newline-separated
+				buf := make([]byte, 65536)
+				for {
+					n, err := conn.Read(buf)
+					if n > 0 {
+						fmt.Printf("%s: %s\n", conn.RemoteAddr(), bytes.TrimRight(buf[:n], "\n"))
+					}
+					if err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}
+	return fmt.Errorf("-listen supports udp:// and tcp://")
+}
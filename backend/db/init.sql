@@ -11,12 +11,25 @@ CREATE TABLE IF NOT EXISTS users (
     id INT AUTO_INCREMENT PRIMARY KEY,
     username VARCHAR(150) NOT NULL UNIQUE,
     email VARCHAR(254) NOT NULL UNIQUE,
+    -- canonical forms (see services/identity.go); binary collation so uniqueness
+    -- is decided by our canonicalization, not by utf8mb4_unicode_ci folding
+    username_canonical VARCHAR(150) COLLATE utf8mb4_bin NOT NULL,
+    username_skeleton  VARCHAR(150) COLLATE utf8mb4_bin NOT NULL,
+    email_canonical    VARCHAR(254) COLLATE utf8mb4_bin NOT NULL,
     password_hmac CHAR(64) NOT NULL,        -- HMAC-SHA256 hex (64)
     salt VARBINARY(16) NOT NULL,            -- 16 random bytes
     is_active BOOLEAN NOT NULL DEFAULT TRUE,
     is_verified BOOLEAN NOT NULL DEFAULT FALSE,
+    is_service_account BOOLEAN NOT NULL DEFAULT FALSE,  -- machine identity: API keys only, no interactive login
     password_fp VARCHAR(64) NOT NULL DEFAULT '',  -- current password fingerprint
-    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+    scim_external_id VARCHAR(255) COLLATE utf8mb4_bin NULL,  -- HR system's id (SCIM externalId)
+    locked_at DATETIME NULL,                -- "this wasn't me" lockdown; cleared by a password reset
+    lockout_cleared_at DATETIME NULL,       -- admin cleared the failed-login lockout; older failures no longer count
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE KEY uq_users_username_canonical (username_canonical),
+    UNIQUE KEY uq_users_username_skeleton (username_skeleton),
+    UNIQUE KEY uq_users_email_canonical (email_canonical),
+    UNIQUE KEY uq_users_scim_external_id (scim_external_id)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
 
 CREATE TABLE IF NOT EXISTS password_history (
@@ -53,13 +66,64 @@ CREATE TABLE IF NOT EXISTS email_verification_tokens (
     INDEX idx_evt_user (user_id)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
 
+CREATE TABLE IF NOT EXISTS organizations (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    name VARCHAR(150) NOT NULL,
+    slug VARCHAR(64) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE KEY uq_org_slug (slug)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS organization_members (
+    org_id INT NOT NULL,
+    user_id INT NOT NULL,
+    added_by INT NULL,
+    joined_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_used_at DATETIME NULL,             -- last time this org was the active one
+    PRIMARY KEY (org_id, user_id),
+    FOREIGN KEY (org_id) REFERENCES organizations(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (added_by) REFERENCES users(id) ON DELETE SET NULL,
+    INDEX idx_om_user (user_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- Rejected attempts to act in an organization the user does not belong to
+CREATE TABLE IF NOT EXISTS org_access_denials (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    user_id INT NULL,
+    org_id INT NOT NULL,                    -- requested org (may not exist)
+    action VARCHAR(64) NOT NULL,
+    ip VARCHAR(45) NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL,
+    INDEX idx_oad_user_time (user_id, created_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
 CREATE TABLE IF NOT EXISTS customers (
     id INT AUTO_INCREMENT PRIMARY KEY,
+    org_id INT NOT NULL,
     name VARCHAR(255) NOT NULL,
-    email VARCHAR(255) NOT NULL UNIQUE,
+    email VARCHAR(255) NOT NULL,
     phone VARCHAR(40) NULL,
     notes TEXT NULL,
-    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+    tags JSON NOT NULL DEFAULT (JSON_ARRAY()),  -- ["vip", ...], see services.NormalizeTags
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    version INT NOT NULL DEFAULT 1,         -- bumped on every update; the ETag
+    deleted_at TIMESTAMP NULL,              -- in the trash; purged after CUSTOMER_TRASH_RETENTION_DAYS
+    -- email of a live customer only, so a trashed duplicate does not block the unique key
+    active_email VARCHAR(255) AS (IF(deleted_at IS NULL, email, NULL)) STORED,
+    FOREIGN KEY (org_id) REFERENCES organizations(id),
+    UNIQUE KEY uq_customers_org_email (org_id, active_email),
+    INDEX idx_customers_org_email (org_id, email),
+    INDEX idx_customers_org_created (org_id, created_at),
+    INDEX idx_customers_org_name (org_id, name),
+    INDEX idx_customers_tags ((CAST(tags AS CHAR(32) ARRAY))),
+    INDEX idx_customers_deleted (deleted_at),
+    -- search: all fields, and each one for field-scoped terms (email:...)
+    FULLTEXT KEY ft_customers_all (name, email, notes),
+    FULLTEXT KEY ft_customers_name (name),
+    FULLTEXT KEY ft_customers_email (email),
+    FULLTEXT KEY ft_customers_notes (notes)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
 
 CREATE TABLE IF NOT EXISTS login_attempts (
@@ -69,9 +133,11 @@ CREATE TABLE IF NOT EXISTS login_attempts (
     ip VARCHAR(45) NULL,
     attempt_time TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
     success BOOLEAN NOT NULL DEFAULT FALSE,
+    mfa_method VARCHAR(16) NULL,              -- completed sign-ins: email_otp or oidc
     FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL,
     INDEX idx_la_user_time (user_id, attempt_time),
-    INDEX idx_la_username_time (username, attempt_time)
+    INDEX idx_la_username_time (username, attempt_time),
+    INDEX idx_la_ip_time (ip, attempt_time)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
 
 CREATE TABLE IF NOT EXISTS login_otp_challenges (
@@ -101,8 +167,351 @@ CREATE TABLE IF NOT EXISTS password_change_requests (
   INDEX idx_pcr_user (user_id)
 ) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
 
+CREATE TABLE IF NOT EXISTS account_deletion_requests (
+  id             INT AUTO_INCREMENT PRIMARY KEY,
+  user_id        INT NULL,                -- NULL once the account has been purged
+  token_sha1     CHAR(40) NOT NULL,
+  expires_at     DATETIME NOT NULL,       -- confirmation link expiry
+  confirmed_at   DATETIME NULL,
+  scheduled_for  DATETIME NULL,           -- confirmed_at + cooling-off period
+  cancelled_at   DATETIME NULL,
+  completed_at   DATETIME NULL,
+  created_at     TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL,
+  UNIQUE KEY uq_adr_token (token_sha1),
+  INDEX idx_adr_user (user_id),
+  INDEX idx_adr_due (scheduled_for)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+CREATE TABLE IF NOT EXISTS roles (
+  id          INT AUTO_INCREMENT PRIMARY KEY,
+  name        VARCHAR(64) NOT NULL,
+  description VARCHAR(255) NOT NULL DEFAULT '',
+  created_at  TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  UNIQUE KEY uq_roles_name (name)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS permissions (
+  id          INT AUTO_INCREMENT PRIMARY KEY,
+  name        VARCHAR(64) NOT NULL,     -- "<resource>:<action>", see services/rbac.go
+  description VARCHAR(255) NOT NULL DEFAULT '',
+  UNIQUE KEY uq_permissions_name (name)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+  role_id       INT NOT NULL,
+  permission_id INT NOT NULL,
+  PRIMARY KEY (role_id, permission_id),
+  FOREIGN KEY (role_id) REFERENCES roles(id) ON DELETE CASCADE,
+  FOREIGN KEY (permission_id) REFERENCES permissions(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS user_roles (
+  user_id    INT NOT NULL,
+  role_id    INT NOT NULL,
+  granted_by INT NULL,
+  granted_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (user_id, role_id),
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+  FOREIGN KEY (role_id) REFERENCES roles(id) ON DELETE CASCADE,
+  FOREIGN KEY (granted_by) REFERENCES users(id) ON DELETE SET NULL,
+  INDEX idx_ur_role (role_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS api_keys (
+  id            INT AUTO_INCREMENT PRIMARY KEY,
+  user_id       INT NOT NULL,              -- owner (a person or a service account)
+  org_id        INT NULL,                  -- organization the key acts in
+  name          VARCHAR(100) NOT NULL,
+  prefix        CHAR(12) NOT NULL,         -- public lookup part of the key
+  secret_sha256 CHAR(64) NOT NULL,         -- SHA-256 hex of the secret part
+  scopes        VARCHAR(512) NOT NULL,     -- comma-separated permission names
+  expires_at    DATETIME NULL,
+  last_used_at  DATETIME NULL,
+  last_used_ip  VARCHAR(45) NULL,
+  created_by    INT NULL,
+  created_at    TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  revoked_at    DATETIME NULL,
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+  FOREIGN KEY (org_id) REFERENCES organizations(id) ON DELETE CASCADE,
+  FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE SET NULL,
+  UNIQUE KEY uq_api_keys_prefix (prefix),
+  INDEX idx_api_keys_user (user_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- External (OIDC) identities linked to local accounts
+CREATE TABLE IF NOT EXISTS user_identities (
+  id            INT AUTO_INCREMENT PRIMARY KEY,
+  user_id       INT NOT NULL,
+  issuer        VARCHAR(255) COLLATE utf8mb4_bin NOT NULL,
+  subject       VARCHAR(255) COLLATE utf8mb4_bin NOT NULL,
+  email         VARCHAR(255) NULL,          -- as last reported by the provider
+  created_at    TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  last_login_at DATETIME NULL,
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+  UNIQUE KEY uq_user_identities_sub (issuer, subject),
+  INDEX idx_user_identities_user (user_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- In-flight OIDC sign-ins (state is stored hashed; single use)
+CREATE TABLE IF NOT EXISTS oidc_login_states (
+  id            INT AUTO_INCREMENT PRIMARY KEY,
+  state_sha256  CHAR(64) NOT NULL,
+  nonce         VARCHAR(64) NOT NULL,
+  code_verifier VARCHAR(128) NOT NULL,
+  redirect_to   VARCHAR(255) NOT NULL,
+  expires_at    DATETIME NOT NULL,
+  consumed_at   DATETIME NULL,
+  created_at    TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  UNIQUE KEY uq_oidc_state (state_sha256),
+  INDEX idx_oidc_state_expires (expires_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- OAuth 2.0 authorization server: registered third-party applications
+CREATE TABLE IF NOT EXISTS oauth_clients (
+  id              INT AUTO_INCREMENT PRIMARY KEY,
+  client_id       VARCHAR(64) NOT NULL,
+  name            VARCHAR(100) NOT NULL,
+  secret_sha256   CHAR(64) NULL,              -- NULL = public client (PKCE only)
+  redirect_uris   TEXT NOT NULL,              -- newline-separated, exact match
+  scopes          VARCHAR(512) NOT NULL,      -- comma-separated permission names
+  grant_types     VARCHAR(100) NOT NULL,      -- comma-separated
+  service_user_id INT NULL,                   -- identity for client_credentials
+  created_by      INT NULL,
+  created_at      TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  revoked_at      DATETIME NULL,
+  FOREIGN KEY (service_user_id) REFERENCES users(id) ON DELETE SET NULL,
+  FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE SET NULL,
+  UNIQUE KEY uq_oauth_clients_client_id (client_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- Authorization requests waiting for the user's decision on the consent screen
+CREATE TABLE IF NOT EXISTS oauth_consent_requests (
+  id             INT AUTO_INCREMENT PRIMARY KEY,
+  handle_sha256  CHAR(64) NOT NULL,
+  client_id      INT NOT NULL,
+  user_id        INT NOT NULL,
+  org_id         INT NULL,
+  redirect_uri   VARCHAR(1000) NOT NULL,
+  scopes         VARCHAR(512) NOT NULL,
+  state          VARCHAR(500) NOT NULL,
+  code_challenge VARCHAR(128) NOT NULL,
+  expires_at     DATETIME NOT NULL,
+  consumed_at    DATETIME NULL,
+  created_at     TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (client_id) REFERENCES oauth_clients(id) ON DELETE CASCADE,
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+  FOREIGN KEY (org_id) REFERENCES organizations(id) ON DELETE SET NULL,
+  UNIQUE KEY uq_oauth_consent_handle (handle_sha256),
+  INDEX idx_oauth_consent_expires (expires_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS oauth_auth_codes (
+  id             INT AUTO_INCREMENT PRIMARY KEY,
+  code_sha256    CHAR(64) NOT NULL,
+  client_id      INT NOT NULL,
+  user_id        INT NOT NULL,
+  org_id         INT NULL,
+  redirect_uri   VARCHAR(1000) NOT NULL,
+  scopes         VARCHAR(512) NOT NULL,
+  code_challenge VARCHAR(128) NOT NULL,       -- PKCE S256
+  expires_at     DATETIME NOT NULL,
+  consumed_at    DATETIME NULL,
+  created_at     TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (client_id) REFERENCES oauth_clients(id) ON DELETE CASCADE,
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+  FOREIGN KEY (org_id) REFERENCES organizations(id) ON DELETE SET NULL,
+  UNIQUE KEY uq_oauth_codes_code (code_sha256)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS oauth_access_tokens (
+  id            INT AUTO_INCREMENT PRIMARY KEY,
+  token_sha256  CHAR(64) NOT NULL,
+  client_id     INT NOT NULL,
+  user_id       INT NOT NULL,                 -- the consenting user, or the client's service account
+  org_id        INT NULL,
+  scopes        VARCHAR(512) NOT NULL,
+  auth_code_id  INT NULL,                     -- revoked together if the code is replayed
+  expires_at    DATETIME NOT NULL,
+  last_used_at  DATETIME NULL,
+  revoked_at    DATETIME NULL,
+  created_at    TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (client_id) REFERENCES oauth_clients(id) ON DELETE CASCADE,
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+  FOREIGN KEY (org_id) REFERENCES organizations(id) ON DELETE CASCADE,
+  FOREIGN KEY (auth_code_id) REFERENCES oauth_auth_codes(id) ON DELETE SET NULL,
+  UNIQUE KEY uq_oauth_tokens_token (token_sha256),
+  INDEX idx_oauth_tokens_user (user_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- Admin-issued registration invites (token stored hashed; single use, bound to one email)
+CREATE TABLE IF NOT EXISTS registration_invites (
+  id              INT AUTO_INCREMENT PRIMARY KEY,
+  token_sha256    CHAR(64) NOT NULL,
+  email           VARCHAR(254) NOT NULL,
+  email_canonical VARCHAR(254) COLLATE utf8mb4_bin NOT NULL,
+  role_id         INT NULL,                   -- pre-assigned role; NULL = DEFAULT_USER_ROLE
+  expires_at      DATETIME NOT NULL,
+  used_at         DATETIME NULL,
+  used_by         INT NULL,
+  revoked_at      DATETIME NULL,
+  created_by      INT NULL,
+  created_at      TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (role_id) REFERENCES roles(id) ON DELETE CASCADE,
+  FOREIGN KEY (used_by) REFERENCES users(id) ON DELETE SET NULL,
+  FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE SET NULL,
+  UNIQUE KEY uq_registration_invites_token (token_sha256),
+  INDEX idx_registration_invites_email (email_canonical)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- Server-side sessions: the session JWT carries sid and is accepted only while
+-- its row is live (revocation before expiry)
+CREATE TABLE IF NOT EXISTS user_sessions (
+  id            INT AUTO_INCREMENT PRIMARY KEY,
+  sid           VARCHAR(32) COLLATE utf8mb4_bin NOT NULL,
+  user_id       INT NOT NULL,
+  device_sha256 CHAR(64) NOT NULL,
+  ip            VARCHAR(45) NOT NULL DEFAULT '',
+  user_agent    VARCHAR(255) NOT NULL DEFAULT '',
+  created_at    TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  expires_at    DATETIME NOT NULL,
+  revoked_at    DATETIME NULL,
+  impersonator_id INT NULL,     -- admin using the session on the user's behalf
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+  FOREIGN KEY (impersonator_id) REFERENCES users(id) ON DELETE CASCADE,
+  UNIQUE KEY uq_user_sessions_sid (sid),
+  INDEX idx_user_sessions_user (user_id, revoked_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- Devices (device cookie, last IP and user agent) each user has signed in from,
+-- for new-device alerts
+CREATE TABLE IF NOT EXISTS user_devices (
+  id            INT AUTO_INCREMENT PRIMARY KEY,
+  user_id       INT NOT NULL,
+  device_sha256 CHAR(64) NOT NULL,
+  ip            VARCHAR(45) NOT NULL DEFAULT '',
+  user_agent    VARCHAR(255) NOT NULL DEFAULT '',
+  first_seen_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  last_seen_at  TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  lockdown_at   DATETIME NULL,                -- its "this wasn't me" link was used
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+  UNIQUE KEY uq_user_devices_device (user_id, device_sha256)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- Customer change history: one row per version of a customer (create, update,
+-- delete, restore) with the full state and the field-level diff. No foreign
+-- key to customers, so the history outlives the customer.
+CREATE TABLE IF NOT EXISTS customer_history (
+  id            BIGINT AUTO_INCREMENT PRIMARY KEY,
+  org_id        INT NOT NULL,
+  customer_id   INT NOT NULL,
+  version       INT NOT NULL,
+  action        VARCHAR(16) NOT NULL,       -- baseline, create, update, delete, restore
+  actor_user_id INT NULL,
+  changed_at    TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  changes       JSON NOT NULL,              -- {"email": {"old": "...", "new": "..."}, ...}
+  snapshot      JSON NOT NULL,              -- state after the change (before it, for a delete)
+  details       JSON NULL,
+  FOREIGN KEY (org_id) REFERENCES organizations(id),
+  FOREIGN KEY (actor_user_id) REFERENCES users(id) ON DELETE SET NULL,
+  UNIQUE KEY uq_customer_history_version (customer_id, version),
+  INDEX idx_customer_history_org (org_id, customer_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- Background customer imports: progress while running, then the per-row
+-- report. Finished jobs are deleted after a week (the report has emails).
+CREATE TABLE IF NOT EXISTS customer_import_jobs (
+  id              BIGINT AUTO_INCREMENT PRIMARY KEY,
+  org_id          INT NOT NULL,
+  user_id         INT NULL,
+  format          VARCHAR(8) NOT NULL,        -- csv, jsonl
+  dry_run         BOOLEAN NOT NULL DEFAULT FALSE,
+  status          VARCHAR(16) NOT NULL,       -- queued, running, done, failed
+  bytes_total     BIGINT NOT NULL DEFAULT 0,
+  bytes_read      BIGINT NOT NULL DEFAULT 0,
+  rows_processed  INT NOT NULL DEFAULT 0,
+  created_count   INT NOT NULL DEFAULT 0,
+  updated_count   INT NOT NULL DEFAULT 0,
+  unchanged_count INT NOT NULL DEFAULT 0,
+  rejected_count  INT NOT NULL DEFAULT 0,
+  report          JSON NULL,
+  error           VARCHAR(255) NULL,
+  created_at      TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  started_at      TIMESTAMP NULL,
+  finished_at     TIMESTAMP NULL,
+  FOREIGN KEY (org_id) REFERENCES organizations(id),
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL,
+  INDEX idx_customer_import_jobs_status (status),
+  INDEX idx_customer_import_jobs_finished (finished_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- Security audit log: append-only (triggers below) and hash-chained. actor/org
+-- ids are kept without foreign keys so entries outlive the rows they mention.
+-- details is TEXT, not JSON, so the stored bytes are exactly what was hashed.
+CREATE TABLE IF NOT EXISTS audit_log (
+  id            BIGINT AUTO_INCREMENT PRIMARY KEY,
+  occurred_at   DATETIME(6) NOT NULL,
+  event_type    VARCHAR(64) NOT NULL,
+  outcome       VARCHAR(16) NOT NULL,
+  actor_user_id INT NULL,
+  actor_label   VARCHAR(254) NOT NULL DEFAULT '',
+  subject_type  VARCHAR(32) NOT NULL DEFAULT '',
+  subject_id    VARCHAR(64) NOT NULL DEFAULT '',
+  org_id        INT NULL,
+  ip            VARCHAR(45) NOT NULL DEFAULT '',
+  user_agent    VARCHAR(255) NOT NULL DEFAULT '',
+  request_id    VARCHAR(64) NOT NULL DEFAULT '',
+  details       TEXT COLLATE utf8mb4_bin NULL,
+  prev_hash     CHAR(64) NOT NULL,
+  entry_hash    CHAR(64) NOT NULL,
+  INDEX idx_audit_type_time (event_type, occurred_at),
+  INDEX idx_audit_actor_time (actor_user_id, occurred_at),
+  INDEX idx_audit_time (occurred_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- Single row: end of the chain. Appends lock it, so entries are chained in order;
+-- comparing it with the last entry detects entries removed from the end.
+CREATE TABLE IF NOT EXISTS audit_chain_head (
+  id        TINYINT PRIMARY KEY,
+  last_id   BIGINT NOT NULL,
+  last_hash CHAR(64) NOT NULL,
+  entries   BIGINT NOT NULL
+) ENGINE=InnoDB;
+
+INSERT INTO audit_chain_head (id, last_id, last_hash, entries)
+VALUES (1, 0, REPEAT('0', 64), 0);
+
+CREATE TRIGGER audit_log_no_update BEFORE UPDATE ON audit_log FOR EACH ROW
+  SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'audit_log is append-only';
+
+CREATE TRIGGER audit_log_no_delete BEFORE DELETE ON audit_log FOR EACH ROW
+  SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'audit_log is append-only';
+
+-- === Roles & permissions ===
+
+INSERT INTO permissions (name, description) VALUES
+  ('customers:read',   'Search and view customers'),
+  ('customers:write',  'Create and edit customers'),
+  ('customers:export', 'Export customer data'),
+  ('users:admin',      'Manage users and their roles'),
+  ('audit:read',       'View the security audit log');
+
+INSERT INTO roles (name, description) VALUES
+  ('admin',  'Full access, including user administration'),
+  ('staff',  'Day-to-day customer work'),
+  ('viewer', 'Read-only access to customers');
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r JOIN permissions p
+WHERE (r.name = 'admin')
+   OR (r.name = 'staff'  AND p.name IN ('customers:read', 'customers:write'))
+   OR (r.name = 'viewer' AND p.name IN ('customers:read'));
+
+-- === Organizations ===
+
+INSERT INTO organizations (name, slug) VALUES ('Default', 'default');
 
 -- === Demo data (for development only!) ===
 
-INSERT INTO customers (name, email, phone, notes)
-VALUES ('First Customer', 'customer1@example.com', '050-1234567', 'Demo notes');
+INSERT INTO customers (org_id, name, email, phone, notes)
+SELECT id, 'First Customer', 'customer1@example.com', '050-1234567', 'Demo notes'
+FROM organizations WHERE slug = 'default';
@@ -0,0 +1,19 @@
+-- Account self-deletion with a cooling-off period (existing databases only).
+
+USE secure_comm;
+
+CREATE TABLE IF NOT EXISTS account_deletion_requests (
+  id             INT AUTO_INCREMENT PRIMARY KEY,
+  user_id        INT NULL,                -- NULL once the account has been purged
+  token_sha1     CHAR(40) NOT NULL,
+  expires_at     DATETIME NOT NULL,       -- confirmation link expiry
+  confirmed_at   DATETIME NULL,
+  scheduled_for  DATETIME NULL,           -- confirmed_at + cooling-off period
+  cancelled_at   DATETIME NULL,
+  completed_at   DATETIME NULL,
+  created_at     TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL,
+  UNIQUE KEY uq_adr_token (token_sha1),
+  INDEX idx_adr_user (user_id),
+  INDEX idx_adr_due (scheduled_for)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
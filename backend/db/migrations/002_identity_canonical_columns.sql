@@ -0,0 +1,14 @@
+-- Identity canonicalization, step 1 of 2 (existing databases only; fresh
+-- installs get these columns from init.sql).
+--
+-- 1) Apply this file.
+-- 2) Run `go run ./cmd/identity-migrate` to report collisions, resolve them,
+--    then `go run ./cmd/identity-migrate -apply` to backfill the columns.
+-- 3) Apply 003_identity_canonical_constraints.sql.
+
+USE secure_comm;
+
+ALTER TABLE users
+  ADD COLUMN username_canonical VARCHAR(150) COLLATE utf8mb4_bin NULL AFTER email,
+  ADD COLUMN username_skeleton  VARCHAR(150) COLLATE utf8mb4_bin NULL AFTER username_canonical,
+  ADD COLUMN email_canonical    VARCHAR(254) COLLATE utf8mb4_bin NULL AFTER username_skeleton;
@@ -0,0 +1,12 @@
+-- Identity canonicalization, step 2 of 2. Run only after
+-- `go run ./cmd/identity-migrate -apply` reported no collisions.
+
+USE secure_comm;
+
+ALTER TABLE users
+  MODIFY username_canonical VARCHAR(150) COLLATE utf8mb4_bin NOT NULL,
+  MODIFY username_skeleton  VARCHAR(150) COLLATE utf8mb4_bin NOT NULL,
+  MODIFY email_canonical    VARCHAR(254) COLLATE utf8mb4_bin NOT NULL,
+  ADD UNIQUE KEY uq_users_username_canonical (username_canonical),
+  ADD UNIQUE KEY uq_users_username_skeleton (username_skeleton),
+  ADD UNIQUE KEY uq_users_email_canonical (email_canonical);
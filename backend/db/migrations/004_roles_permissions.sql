@@ -0,0 +1,63 @@
+-- Roles & permissions (existing databases only). Every account existing at
+-- this point is a person; they all get the default role.
+
+USE secure_comm;
+
+-- Role granted to existing accounts; keep in line with DEFAULT_USER_ROLE.
+SET @default_role = 'staff';
+
+CREATE TABLE IF NOT EXISTS roles (
+  id          INT AUTO_INCREMENT PRIMARY KEY,
+  name        VARCHAR(64) NOT NULL,
+  description VARCHAR(255) NOT NULL DEFAULT '',
+  created_at  TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  UNIQUE KEY uq_roles_name (name)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS permissions (
+  id          INT AUTO_INCREMENT PRIMARY KEY,
+  name        VARCHAR(64) NOT NULL,
+  description VARCHAR(255) NOT NULL DEFAULT '',
+  UNIQUE KEY uq_permissions_name (name)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+  role_id       INT NOT NULL,
+  permission_id INT NOT NULL,
+  PRIMARY KEY (role_id, permission_id),
+  FOREIGN KEY (role_id) REFERENCES roles(id) ON DELETE CASCADE,
+  FOREIGN KEY (permission_id) REFERENCES permissions(id) ON DELETE CASCADE
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS user_roles (
+  user_id    INT NOT NULL,
+  role_id    INT NOT NULL,
+  granted_by INT NULL,
+  granted_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  PRIMARY KEY (user_id, role_id),
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+  FOREIGN KEY (role_id) REFERENCES roles(id) ON DELETE CASCADE,
+  FOREIGN KEY (granted_by) REFERENCES users(id) ON DELETE SET NULL,
+  INDEX idx_ur_role (role_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+INSERT INTO permissions (name, description) VALUES
+  ('customers:read',   'Search and view customers'),
+  ('customers:write',  'Create and edit customers'),
+  ('customers:export', 'Export customer data'),
+  ('users:admin',      'Manage users and their roles');
+
+INSERT INTO roles (name, description) VALUES
+  ('admin',  'Full access, including user administration'),
+  ('staff',  'Day-to-day customer work'),
+  ('viewer', 'Read-only access to customers');
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r JOIN permissions p
+WHERE (r.name = 'admin')
+   OR (r.name = 'staff'  AND p.name IN ('customers:read', 'customers:write'))
+   OR (r.name = 'viewer' AND p.name IN ('customers:read'));
+
+-- Promote the admins afterwards with cmd/bootstrap-admin.
+INSERT INTO user_roles (user_id, role_id)
+SELECT u.id, r.id FROM users u JOIN roles r ON r.name = @default_role;
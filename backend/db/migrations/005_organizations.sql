@@ -0,0 +1,51 @@
+-- Multi-tenant organizations for existing databases (fresh installs get this
+-- from init.sql). Every existing customer and user is placed in the
+-- "default" organization; move them with the admin API afterwards.
+
+USE secure_comm;
+
+CREATE TABLE IF NOT EXISTS organizations (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    name VARCHAR(150) NOT NULL,
+    slug VARCHAR(64) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE KEY uq_org_slug (slug)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS organization_members (
+    org_id INT NOT NULL,
+    user_id INT NOT NULL,
+    added_by INT NULL,
+    joined_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    last_used_at DATETIME NULL,
+    PRIMARY KEY (org_id, user_id),
+    FOREIGN KEY (org_id) REFERENCES organizations(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (added_by) REFERENCES users(id) ON DELETE SET NULL,
+    INDEX idx_om_user (user_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS org_access_denials (
+    id INT AUTO_INCREMENT PRIMARY KEY,
+    user_id INT NULL,
+    org_id INT NOT NULL,
+    action VARCHAR(64) NOT NULL,
+    ip VARCHAR(45) NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL,
+    INDEX idx_oad_user_time (user_id, created_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+INSERT IGNORE INTO organizations (name, slug) VALUES ('Default', 'default');
+
+INSERT IGNORE INTO organization_members (org_id, user_id)
+SELECT o.id, u.id FROM organizations o CROSS JOIN users u WHERE o.slug = 'default';
+
+ALTER TABLE customers ADD COLUMN org_id INT NULL AFTER id;
+UPDATE customers SET org_id = (SELECT id FROM organizations WHERE slug = 'default');
+ALTER TABLE customers
+  MODIFY org_id INT NOT NULL,
+  ADD CONSTRAINT fk_customers_org FOREIGN KEY (org_id) REFERENCES organizations(id),
+  DROP INDEX email,
+  ADD UNIQUE KEY uq_customers_org_email (org_id, email),
+  ADD INDEX idx_customers_org_created (org_id, created_at);
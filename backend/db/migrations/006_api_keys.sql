@@ -0,0 +1,27 @@
+-- API keys for machine clients (existing databases only).
+
+USE secure_comm;
+
+ALTER TABLE users
+  ADD COLUMN is_service_account BOOLEAN NOT NULL DEFAULT FALSE AFTER is_verified;
+
+CREATE TABLE IF NOT EXISTS api_keys (
+  id            INT AUTO_INCREMENT PRIMARY KEY,
+  user_id       INT NOT NULL,
+  org_id        INT NULL,
+  name          VARCHAR(100) NOT NULL,
+  prefix        CHAR(12) NOT NULL,
+  secret_sha256 CHAR(64) NOT NULL,
+  scopes        VARCHAR(512) NOT NULL,
+  expires_at    DATETIME NULL,
+  last_used_at  DATETIME NULL,
+  last_used_ip  VARCHAR(45) NULL,
+  created_by    INT NULL,
+  created_at    TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  revoked_at    DATETIME NULL,
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+  FOREIGN KEY (org_id) REFERENCES organizations(id) ON DELETE CASCADE,
+  FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE SET NULL,
+  UNIQUE KEY uq_api_keys_prefix (prefix),
+  INDEX idx_api_keys_user (user_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,31 @@
+-- OIDC single sign-on (existing databases only).
+
+USE secure_comm;
+
+-- External (OIDC) identities linked to local accounts
+CREATE TABLE IF NOT EXISTS user_identities (
+  id            INT AUTO_INCREMENT PRIMARY KEY,
+  user_id       INT NOT NULL,
+  issuer        VARCHAR(255) COLLATE utf8mb4_bin NOT NULL,
+  subject       VARCHAR(255) COLLATE utf8mb4_bin NOT NULL,
+  email         VARCHAR(255) NULL,          -- as last reported by the provider
+  created_at    TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  last_login_at DATETIME NULL,
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+  UNIQUE KEY uq_user_identities_sub (issuer, subject),
+  INDEX idx_user_identities_user (user_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- In-flight OIDC sign-ins (state is stored hashed; single use)
+CREATE TABLE IF NOT EXISTS oidc_login_states (
+  id            INT AUTO_INCREMENT PRIMARY KEY,
+  state_sha256  CHAR(64) NOT NULL,
+  nonce         VARCHAR(64) NOT NULL,
+  code_verifier VARCHAR(128) NOT NULL,
+  redirect_to   VARCHAR(255) NOT NULL,
+  expires_at    DATETIME NOT NULL,
+  consumed_at   DATETIME NULL,
+  created_at    TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  UNIQUE KEY uq_oidc_state (state_sha256),
+  INDEX idx_oidc_state_expires (expires_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,80 @@
+-- OAuth 2.0 authorization server (existing databases only).
+
+USE secure_comm;
+
+-- OAuth 2.0 authorization server: registered third-party applications
+CREATE TABLE IF NOT EXISTS oauth_clients (
+  id              INT AUTO_INCREMENT PRIMARY KEY,
+  client_id       VARCHAR(64) NOT NULL,
+  name            VARCHAR(100) NOT NULL,
+  secret_sha256   CHAR(64) NULL,              -- NULL = public client (PKCE only)
+  redirect_uris   TEXT NOT NULL,              -- newline-separated, exact match
+  scopes          VARCHAR(512) NOT NULL,      -- comma-separated permission names
+  grant_types     VARCHAR(100) NOT NULL,      -- comma-separated
+  service_user_id INT NULL,                   -- identity for client_credentials
+  created_by      INT NULL,
+  created_at      TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  revoked_at      DATETIME NULL,
+  FOREIGN KEY (service_user_id) REFERENCES users(id) ON DELETE SET NULL,
+  FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE SET NULL,
+  UNIQUE KEY uq_oauth_clients_client_id (client_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- Authorization requests waiting for the user's decision on the consent screen
+CREATE TABLE IF NOT EXISTS oauth_consent_requests (
+  id             INT AUTO_INCREMENT PRIMARY KEY,
+  handle_sha256  CHAR(64) NOT NULL,
+  client_id      INT NOT NULL,
+  user_id        INT NOT NULL,
+  org_id         INT NULL,
+  redirect_uri   VARCHAR(1000) NOT NULL,
+  scopes         VARCHAR(512) NOT NULL,
+  state          VARCHAR(500) NOT NULL,
+  code_challenge VARCHAR(128) NOT NULL,
+  expires_at     DATETIME NOT NULL,
+  consumed_at    DATETIME NULL,
+  created_at     TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (client_id) REFERENCES oauth_clients(id) ON DELETE CASCADE,
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+  FOREIGN KEY (org_id) REFERENCES organizations(id) ON DELETE SET NULL,
+  UNIQUE KEY uq_oauth_consent_handle (handle_sha256),
+  INDEX idx_oauth_consent_expires (expires_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS oauth_auth_codes (
+  id             INT AUTO_INCREMENT PRIMARY KEY,
+  code_sha256    CHAR(64) NOT NULL,
+  client_id      INT NOT NULL,
+  user_id        INT NOT NULL,
+  org_id         INT NULL,
+  redirect_uri   VARCHAR(1000) NOT NULL,
+  scopes         VARCHAR(512) NOT NULL,
+  code_challenge VARCHAR(128) NOT NULL,       -- PKCE S256
+  expires_at     DATETIME NOT NULL,
+  consumed_at    DATETIME NULL,
+  created_at     TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (client_id) REFERENCES oauth_clients(id) ON DELETE CASCADE,
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+  FOREIGN KEY (org_id) REFERENCES organizations(id) ON DELETE SET NULL,
+  UNIQUE KEY uq_oauth_codes_code (code_sha256)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+CREATE TABLE IF NOT EXISTS oauth_access_tokens (
+  id            INT AUTO_INCREMENT PRIMARY KEY,
+  token_sha256  CHAR(64) NOT NULL,
+  client_id     INT NOT NULL,
+  user_id       INT NOT NULL,                 -- the consenting user, or the client's service account
+  org_id        INT NULL,
+  scopes        VARCHAR(512) NOT NULL,
+  auth_code_id  INT NULL,                     -- revoked together if the code is replayed
+  expires_at    DATETIME NOT NULL,
+  last_used_at  DATETIME NULL,
+  revoked_at    DATETIME NULL,
+  created_at    TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (client_id) REFERENCES oauth_clients(id) ON DELETE CASCADE,
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+  FOREIGN KEY (org_id) REFERENCES organizations(id) ON DELETE CASCADE,
+  FOREIGN KEY (auth_code_id) REFERENCES oauth_auth_codes(id) ON DELETE SET NULL,
+  UNIQUE KEY uq_oauth_tokens_token (token_sha256),
+  INDEX idx_oauth_tokens_user (user_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
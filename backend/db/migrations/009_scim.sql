@@ -0,0 +1,7 @@
+-- SCIM 2.0 provisioning (existing databases only).
+
+USE secure_comm;
+
+ALTER TABLE users
+  ADD COLUMN scim_external_id VARCHAR(255) COLLATE utf8mb4_bin NULL AFTER password_fp,
+  ADD UNIQUE KEY uq_users_scim_external_id (scim_external_id);
@@ -0,0 +1,23 @@
+-- Invite-only registration (existing databases only).
+
+USE secure_comm;
+
+-- Admin-issued registration invites (token stored hashed; single use, bound to one email)
+CREATE TABLE IF NOT EXISTS registration_invites (
+  id              INT AUTO_INCREMENT PRIMARY KEY,
+  token_sha256    CHAR(64) NOT NULL,
+  email           VARCHAR(254) NOT NULL,
+  email_canonical VARCHAR(254) COLLATE utf8mb4_bin NOT NULL,
+  role_id         INT NULL,                   -- pre-assigned role; NULL = DEFAULT_USER_ROLE
+  expires_at      DATETIME NOT NULL,
+  used_at         DATETIME NULL,
+  used_by         INT NULL,
+  revoked_at      DATETIME NULL,
+  created_by      INT NULL,
+  created_at      TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  FOREIGN KEY (role_id) REFERENCES roles(id) ON DELETE CASCADE,
+  FOREIGN KEY (used_by) REFERENCES users(id) ON DELETE SET NULL,
+  FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE SET NULL,
+  UNIQUE KEY uq_registration_invites_token (token_sha256),
+  INDEX idx_registration_invites_email (email_canonical)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
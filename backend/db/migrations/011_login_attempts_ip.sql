@@ -0,0 +1,5 @@
+-- Per-client login throttling (existing databases only).
+
+USE secure_comm;
+
+ALTER TABLE login_attempts ADD INDEX idx_la_ip_time (ip, attempt_time);
@@ -0,0 +1,51 @@
+-- Security audit log (existing databases only).
+
+USE secure_comm;
+
+-- Security audit log: append-only (triggers below) and hash-chained. actor/org
+-- ids are kept without foreign keys so entries outlive the rows they mention.
+-- details is TEXT, not JSON, so the stored bytes are exactly what was hashed.
+CREATE TABLE IF NOT EXISTS audit_log (
+  id            BIGINT AUTO_INCREMENT PRIMARY KEY,
+  occurred_at   DATETIME(6) NOT NULL,
+  event_type    VARCHAR(64) NOT NULL,
+  outcome       VARCHAR(16) NOT NULL,
+  actor_user_id INT NULL,
+  actor_label   VARCHAR(254) NOT NULL DEFAULT '',
+  subject_type  VARCHAR(32) NOT NULL DEFAULT '',
+  subject_id    VARCHAR(64) NOT NULL DEFAULT '',
+  org_id        INT NULL,
+  ip            VARCHAR(45) NOT NULL DEFAULT '',
+  user_agent    VARCHAR(255) NOT NULL DEFAULT '',
+  request_id    VARCHAR(64) NOT NULL DEFAULT '',
+  details       TEXT COLLATE utf8mb4_bin NULL,
+  prev_hash     CHAR(64) NOT NULL,
+  entry_hash    CHAR(64) NOT NULL,
+  INDEX idx_audit_type_time (event_type, occurred_at),
+  INDEX idx_audit_actor_time (actor_user_id, occurred_at),
+  INDEX idx_audit_time (occurred_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- Single row: end of the chain. Appends lock it, so entries are chained in order;
+-- comparing it with the last entry detects entries removed from the end.
+CREATE TABLE IF NOT EXISTS audit_chain_head (
+  id        TINYINT PRIMARY KEY,
+  last_id   BIGINT NOT NULL,
+  last_hash CHAR(64) NOT NULL,
+  entries   BIGINT NOT NULL
+) ENGINE=InnoDB;
+
+INSERT INTO audit_chain_head (id, last_id, last_hash, entries)
+VALUES (1, 0, REPEAT('0', 64), 0);
+
+CREATE TRIGGER audit_log_no_update BEFORE UPDATE ON audit_log FOR EACH ROW
+  SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'audit_log is append-only';
+
+CREATE TRIGGER audit_log_no_delete BEFORE DELETE ON audit_log FOR EACH ROW
+  SIGNAL SQLSTATE '45000' SET MESSAGE_TEXT = 'audit_log is append-only';
+
+INSERT INTO permissions (name, description) VALUES ('audit:read', 'View the security audit log');
+
+INSERT INTO role_permissions (role_id, permission_id)
+SELECT r.id, p.id FROM roles r JOIN permissions p
+WHERE r.name = 'admin' AND p.name = 'audit:read';
@@ -0,0 +1,39 @@
+-- Server-side sessions, new-device alerts and account lockdown (existing databases only).
+-- Session cookies issued before this migration stop working; users sign in again.
+
+USE secure_comm;
+
+ALTER TABLE users
+  ADD COLUMN locked_at DATETIME NULL AFTER scim_external_id;
+
+-- Server-side sessions: the session JWT carries sid and is accepted only while
+-- its row is live (revocation before expiry)
+CREATE TABLE IF NOT EXISTS user_sessions (
+  id            INT AUTO_INCREMENT PRIMARY KEY,
+  sid           VARCHAR(32) COLLATE utf8mb4_bin NOT NULL,
+  user_id       INT NOT NULL,
+  device_sha256 CHAR(64) NOT NULL,
+  ip            VARCHAR(45) NOT NULL DEFAULT '',
+  user_agent    VARCHAR(255) NOT NULL DEFAULT '',
+  created_at    TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  expires_at    DATETIME NOT NULL,
+  revoked_at    DATETIME NULL,
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+  UNIQUE KEY uq_user_sessions_sid (sid),
+  INDEX idx_user_sessions_user (user_id, revoked_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+-- Devices (device cookie, last IP and user agent) each user has signed in from,
+-- for new-device alerts
+CREATE TABLE IF NOT EXISTS user_devices (
+  id            INT AUTO_INCREMENT PRIMARY KEY,
+  user_id       INT NOT NULL,
+  device_sha256 CHAR(64) NOT NULL,
+  ip            VARCHAR(45) NOT NULL DEFAULT '',
+  user_agent    VARCHAR(255) NOT NULL DEFAULT '',
+  first_seen_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  last_seen_at  TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  lockdown_at   DATETIME NULL,                -- its "this wasn't me" link was used
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+  UNIQUE KEY uq_user_devices_device (user_id, device_sha256)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
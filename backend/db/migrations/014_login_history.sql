@@ -0,0 +1,7 @@
+-- Login history: how each completed sign-in was verified (existing databases only).
+-- Password-step failures keep mfa_method NULL; earlier rows are left as they are.
+
+USE secure_comm;
+
+ALTER TABLE login_attempts
+  ADD COLUMN mfa_method VARCHAR(16) NULL AFTER success;
@@ -0,0 +1,6 @@
+-- Admin user management: clearing failed-login lockouts (existing databases only).
+
+USE secure_comm;
+
+ALTER TABLE users
+  ADD COLUMN lockout_cleared_at DATETIME NULL AFTER locked_at;
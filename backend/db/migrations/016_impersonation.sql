@@ -0,0 +1,8 @@
+-- Admin impersonation sessions (existing databases only).
+
+USE secure_comm;
+
+ALTER TABLE user_sessions
+  ADD COLUMN impersonator_id INT NULL AFTER revoked_at,
+  ADD CONSTRAINT fk_user_sessions_impersonator
+    FOREIGN KEY (impersonator_id) REFERENCES users(id) ON DELETE CASCADE;
@@ -0,0 +1,6 @@
+-- Optimistic concurrency for customer updates (existing databases only).
+
+USE secure_comm;
+
+ALTER TABLE customers
+  ADD COLUMN version INT NOT NULL DEFAULT 1 AFTER created_at;
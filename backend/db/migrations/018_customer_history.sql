@@ -0,0 +1,26 @@
+-- Customer change history (existing databases only).
+-- Every existing customer gets a "baseline" entry with its current state.
+
+USE secure_comm;
+
+CREATE TABLE IF NOT EXISTS customer_history (
+  id            BIGINT AUTO_INCREMENT PRIMARY KEY,
+  org_id        INT NOT NULL,
+  customer_id   INT NOT NULL,
+  version       INT NOT NULL,
+  action        VARCHAR(16) NOT NULL,
+  actor_user_id INT NULL,
+  changed_at    TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  changes       JSON NOT NULL,
+  snapshot      JSON NOT NULL,
+  details       JSON NULL,
+  FOREIGN KEY (org_id) REFERENCES organizations(id),
+  FOREIGN KEY (actor_user_id) REFERENCES users(id) ON DELETE SET NULL,
+  UNIQUE KEY uq_customer_history_version (customer_id, version),
+  INDEX idx_customer_history_org (org_id, customer_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
+
+INSERT IGNORE INTO customer_history (org_id, customer_id, version, action, changed_at, changes, snapshot)
+SELECT org_id, id, version, 'baseline', NOW(), JSON_OBJECT(),
+       JSON_OBJECT('name', name, 'email', email, 'phone', COALESCE(phone, ''), 'notes', COALESCE(notes, ''))
+FROM customers;
@@ -0,0 +1,15 @@
+-- Soft delete for customers (existing databases only).
+-- The unique email key moves to a generated column that is NULL for trashed
+-- customers, so a deleted customer's email can be used again.
+
+USE secure_comm;
+
+ALTER TABLE customers
+  ADD COLUMN deleted_at TIMESTAMP NULL AFTER version,
+  ADD COLUMN active_email VARCHAR(255) AS (IF(deleted_at IS NULL, email, NULL)) STORED AFTER deleted_at,
+  ADD INDEX idx_customers_org_email (org_id, email),
+  ADD INDEX idx_customers_deleted (deleted_at);
+
+ALTER TABLE customers
+  DROP INDEX uq_customers_org_email,
+  ADD UNIQUE KEY uq_customers_org_email (org_id, active_email);
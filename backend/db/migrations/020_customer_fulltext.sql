@@ -0,0 +1,9 @@
+-- Full-text customer search (existing databases only).
+-- InnoDB builds one FULLTEXT index per statement.
+
+USE secure_comm;
+
+ALTER TABLE customers ADD FULLTEXT KEY ft_customers_all (name, email, notes);
+ALTER TABLE customers ADD FULLTEXT KEY ft_customers_name (name);
+ALTER TABLE customers ADD FULLTEXT KEY ft_customers_email (email);
+ALTER TABLE customers ADD FULLTEXT KEY ft_customers_notes (notes);
@@ -0,0 +1,8 @@
+-- Customer tags and listing indexes (existing databases only).
+
+USE secure_comm;
+
+ALTER TABLE customers
+  ADD COLUMN tags JSON NOT NULL DEFAULT (JSON_ARRAY()) AFTER notes,
+  ADD INDEX idx_customers_org_name (org_id, name),
+  ADD INDEX idx_customers_tags ((CAST(tags AS CHAR(32) ARRAY)));
@@ -0,0 +1,28 @@
+-- Background customer import jobs (existing databases only).
+
+USE secure_comm;
+
+CREATE TABLE IF NOT EXISTS customer_import_jobs (
+  id              BIGINT AUTO_INCREMENT PRIMARY KEY,
+  org_id          INT NOT NULL,
+  user_id         INT NULL,
+  format          VARCHAR(8) NOT NULL,        -- csv, jsonl
+  dry_run         BOOLEAN NOT NULL DEFAULT FALSE,
+  status          VARCHAR(16) NOT NULL,       -- queued, running, done, failed
+  bytes_total     BIGINT NOT NULL DEFAULT 0,
+  bytes_read      BIGINT NOT NULL DEFAULT 0,
+  rows_processed  INT NOT NULL DEFAULT 0,
+  created_count   INT NOT NULL DEFAULT 0,
+  updated_count   INT NOT NULL DEFAULT 0,
+  unchanged_count INT NOT NULL DEFAULT 0,
+  rejected_count  INT NOT NULL DEFAULT 0,
+  report          JSON NULL,
+  error           VARCHAR(255) NULL,
+  created_at      TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  started_at      TIMESTAMP NULL,
+  finished_at     TIMESTAMP NULL,
+  FOREIGN KEY (org_id) REFERENCES organizations(id),
+  FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL,
+  INDEX idx_customer_import_jobs_status (status),
+  INDEX idx_customer_import_jobs_finished (finished_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4;
@@ -0,0 +1,276 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	middlewarex "secure-communication-ltd/backend/internal/middleware"
+	"secure-communication-ltd/backend/internal/services"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo/v4"
+)
+
+type deleteAccountRequest struct {
+	Password string `json:"password"`
+}
+
+var deleteAccountMailTpl = template.Must(template.New("deleteAccount").Parse(`
+<h2>Confirm account deletion</h2>
+<p>Hi {{.Username}},</p>
+<p>We received a request to delete your account. If you confirm, your account will be
+permanently deleted after a {{.CoolingOff}} cooling-off period. You can cancel at any time
+before then by signing in.</p>
+<p>
+  <a href="{{.Link}}" style="display:inline-block;padding:10px 16px;border-radius:8px;background:#ff6b6b;color:#fff;text-decoration:none">
+    Confirm deletion
+  </a>
+</p>
+<p>If the button doesn't work, copy this URL:</p>
+<p><code>{{.Link}}</code></p>
+<p>This link expires in 24 hours. If you did not request this, ignore this email and consider changing your password.</p>
+`))
+
+// RequestAccountDeletion re-authenticates the user and emails a confirmation link.
+// Nothing is scheduled until the link is followed.
+func RequestAccountDeletion(db *sqlx.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		uid, err := middlewarex.UserIDFromCtx(c)
+		if err != nil {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		}
+
+		var req deleteAccountRequest
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid json"})
+		}
+		if req.Password == "" {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "missing fields"})
+		}
+
+		var (
+			curHash  string
+			curSalt  []byte
+			email    string
+			username string
+		)
+		err = db.QueryRowx(`
+			SELECT password_hmac, salt, email, username
+			FROM users
+			WHERE id = ?
+		`, uid).Scan(&curHash, &curSalt, &email, &username)
+		if errors.Is(err, sql.ErrNoRows) {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+
+		hx, err := services.HashPasswordHMACHex(req.Password, curSalt)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "hash error"})
+		}
+		if subtle.ConstantTimeCompare([]byte(hx), []byte(curHash)) != 1 {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "password is incorrect"})
+		}
+		// Same rule as AdminDeleteUser: someone must be left to administer
+		if last, err := services.IsLastActiveAdmin(db, uid); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		} else if last {
+			return c.JSON(http.StatusConflict, map[string]string{"error": "the last admin cannot delete their account; make someone else an admin first"})
+		}
+
+		tok, err := services.NewVerificationToken(24 * time.Hour)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "token error"})
+		}
+
+		tx, err := db.Beginx()
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "tx error"})
+		}
+		defer tx.Rollback()
+
+		// Only one pending request at a time
+		if _, err := tx.Exec(`
+			UPDATE account_deletion_requests
+			SET cancelled_at = NOW()
+			WHERE user_id = ? AND cancelled_at IS NULL AND completed_at IS NULL
+		`, uid); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+		if _, err := tx.Exec(`
+			INSERT INTO account_deletion_requests (user_id, token_sha1, expires_at)
+			VALUES (?, ?, ?)
+		`, uid, tok.SHA1Hex, tok.ExpiresAt); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+		if err := tx.Commit(); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "commit error"})
+		}
+
+		mailer, err := services.NewMailerFromEnv()
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "mailer error"})
+		}
+		base := os.Getenv("BACKEND_PUBLIC_URL")
+		if base == "" {
+			base = "http://localhost:8080"
+		}
+		link := strings.TrimRight(base, "/") + "/api/me/delete/confirm?token=" + url.QueryEscape(tok.Raw)
+
+		var buf bytes.Buffer
+		if err := deleteAccountMailTpl.Execute(&buf, struct {
+			Username   string
+			Link       string
+			CoolingOff string
+		}{
+			Username:   username,
+			Link:       link,
+			CoolingOff: humanDays(services.DeletionCoolingOff()),
+		}); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "template error"})
+		}
+		if err := mailer.Send(email, "Confirm account deletion", buf.String()); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "send mail error"})
+		}
+
+		return c.JSON(http.StatusOK, map[string]string{
+			"message": "Check your email to confirm the deletion of your account.",
+		})
+	}
+}
+
+// ConfirmAccountDeletion is the email landing page. It starts the cooling-off period.
+func ConfirmAccountDeletion(db *sqlx.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		raw := c.QueryParam("token")
+		if raw == "" {
+			return RenderVerificationPage(c, http.StatusBadRequest, false,
+				"Missing Token", "A confirmation token is required.")
+		}
+		sum := sha1.Sum([]byte(raw))
+		sha := hex.EncodeToString(sum[:])
+
+		var (
+			id          int64
+			userID      sql.NullInt64
+			expiresAt   time.Time
+			confirmedAt sql.NullTime
+			cancelledAt sql.NullTime
+		)
+		err := db.QueryRowx(`
+			SELECT id, user_id, expires_at, confirmed_at, cancelled_at
+			FROM account_deletion_requests
+			WHERE token_sha1 = ?
+		`, sha).Scan(&id, &userID, &expiresAt, &confirmedAt, &cancelledAt)
+		if err == sql.ErrNoRows {
+			return RenderVerificationPage(c, http.StatusBadRequest, false,
+				"Invalid Token", "This confirmation link is not valid.")
+		}
+		if err != nil {
+			return RenderVerificationPage(c, http.StatusInternalServerError, false,
+				"Server Error", "Could not read the deletion request.")
+		}
+		if !userID.Valid || confirmedAt.Valid || cancelledAt.Valid {
+			return RenderVerificationPage(c, http.StatusBadRequest, false,
+				"Already Used", "This confirmation link is no longer valid.")
+		}
+		if time.Now().After(expiresAt) {
+			return RenderVerificationPage(c, http.StatusBadRequest, false,
+				"Expired Link", "This confirmation link has expired. Please request deletion again.")
+		}
+
+		scheduled := time.Now().Add(services.DeletionCoolingOff())
+		res, err := db.Exec(`
+			UPDATE account_deletion_requests
+			SET confirmed_at = NOW(), scheduled_for = ?
+			WHERE id = ? AND confirmed_at IS NULL AND cancelled_at IS NULL
+		`, scheduled, id)
+		if err != nil {
+			return RenderVerificationPage(c, http.StatusInternalServerError, false,
+				"Server Error", "Could not schedule the deletion.")
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			// confirmed or cancelled since it was read
+			return RenderVerificationPage(c, http.StatusBadRequest, false,
+				"Already Used", "This confirmation link is no longer valid.")
+		}
+
+		return RenderVerificationPage(c, http.StatusOK, true,
+			"Deletion Scheduled",
+			"Your account will be permanently deleted on "+scheduled.UTC().Format("2006-01-02 15:04")+
+				" UTC. Sign in before then if you want to cancel.")
+	}
+}
+
+// AccountDeletionStatus reports whether a deletion is pending for the signed-in user.
+func AccountDeletionStatus(db *sqlx.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		uid, err := middlewarex.UserIDFromCtx(c)
+		if err != nil {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		}
+
+		var row struct {
+			ConfirmedAt  *time.Time `db:"confirmed_at"`
+			ScheduledFor *time.Time `db:"scheduled_for"`
+			ExpiresAt    time.Time  `db:"expires_at"`
+		}
+		err = db.Get(&row, `
+			SELECT confirmed_at, scheduled_for, expires_at
+			FROM account_deletion_requests
+			WHERE user_id = ? AND cancelled_at IS NULL AND completed_at IS NULL
+			ORDER BY id DESC
+			LIMIT 1
+		`, uid)
+		if err == sql.ErrNoRows || (err == nil && row.ConfirmedAt == nil && time.Now().After(row.ExpiresAt)) {
+			return c.JSON(http.StatusOK, map[string]any{"pending": false})
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+		return c.JSON(http.StatusOK, map[string]any{
+			"pending":       true,
+			"confirmed":     row.ConfirmedAt != nil,
+			"scheduled_for": row.ScheduledFor,
+		})
+	}
+}
+
+// CancelAccountDeletion cancels any pending or scheduled deletion.
+func CancelAccountDeletion(db *sqlx.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		uid, err := middlewarex.UserIDFromCtx(c)
+		if err != nil {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		}
+		res, err := db.Exec(`
+			UPDATE account_deletion_requests
+			SET cancelled_at = NOW()
+			WHERE user_id = ? AND cancelled_at IS NULL AND completed_at IS NULL
+		`, uid)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "no pending deletion"})
+		}
+		return c.JSON(http.StatusOK, map[string]string{"message": "account deletion cancelled"})
+	}
+}
+
+func humanDays(d time.Duration) string {
+	return strconv.Itoa(int(d/(24*time.Hour))) + "-day"
+}
@@ -0,0 +1,281 @@
+package handlers
+
+import (
+	"archive/zip"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	middlewarex "secure-communication-ltd/backend/internal/middleware"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo/v4"
+)
+
+type exportUser struct {
+	ID         int64     `db:"id" json:"id"`
+	Username   string    `db:"username" json:"username"`
+	Email      string    `db:"email" json:"email"`
+	IsActive   bool      `db:"is_active" json:"is_active"`
+	IsVerified bool      `db:"is_verified" json:"is_verified"`
+	CreatedAt  time.Time `db:"created_at" json:"created_at"`
+}
+
+type exportLoginAttempt struct {
+	AttemptTime time.Time `db:"attempt_time" json:"attempt_time"`
+	IP          *string   `db:"ip" json:"ip"`
+	Success     bool      `db:"success" json:"success"`
+	MFAMethod   *string   `db:"mfa_method" json:"mfa_method"`
+}
+
+type exportToken struct {
+	ExpiresAt time.Time  `db:"expires_at" json:"expires_at"`
+	UsedAt    *time.Time `db:"used_at" json:"used_at"`
+}
+
+type exportOTPChallenge struct {
+	CreatedAt  time.Time  `db:"created_at" json:"created_at"`
+	ExpiresAt  time.Time  `db:"expires_at" json:"expires_at"`
+	ConsumedAt *time.Time `db:"consumed_at" json:"consumed_at"`
+	Attempts   int        `db:"attempts" json:"attempts"`
+}
+
+type exportMembership struct {
+	OrgID    int64     `db:"org_id" json:"org_id"`
+	OrgName  string    `db:"name" json:"org_name"`
+	JoinedAt time.Time `db:"joined_at" json:"joined_at"`
+}
+
+type exportIdentity struct {
+	Issuer      string     `db:"issuer" json:"issuer"`
+	Subject     string     `db:"subject" json:"subject"`
+	Email       *string    `db:"email" json:"email"`
+	CreatedAt   time.Time  `db:"created_at" json:"created_at"`
+	LastLoginAt *time.Time `db:"last_login_at" json:"last_login_at"`
+}
+
+type exportDeletionRequest struct {
+	CreatedAt    time.Time  `db:"created_at" json:"created_at"`
+	ConfirmedAt  *time.Time `db:"confirmed_at" json:"confirmed_at"`
+	ScheduledFor *time.Time `db:"scheduled_for" json:"scheduled_for"`
+	CancelledAt  *time.Time `db:"cancelled_at" json:"cancelled_at"`
+}
+
+type exportDevice struct {
+	IP          string     `db:"ip" json:"ip"`
+	UserAgent   string     `db:"user_agent" json:"user_agent"`
+	FirstSeenAt time.Time  `db:"first_seen_at" json:"first_seen_at"`
+	LastSeenAt  time.Time  `db:"last_seen_at" json:"last_seen_at"`
+	LockdownAt  *time.Time `db:"lockdown_at" json:"lockdown_at"`
+}
+
+// accountExport is everything we hold about a user. Secrets (password hashes,
+// salts, fingerprints, token hashes, OTP hashes) are deliberately left out:
+// they are not meaningful to the user and must never leave the database.
+type accountExport struct {
+	GeneratedAt            time.Time               `json:"generated_at"`
+	User                   exportUser              `json:"user"`
+	Roles                  []string                `json:"roles"`
+	Organizations          []exportMembership      `json:"organizations"`
+	LinkedIdentities       []exportIdentity        `json:"linked_identities"`
+	LoginAttempts          []exportLoginAttempt    `json:"login_attempts"`
+	KnownDevices           []exportDevice          `json:"known_devices"`
+	PasswordChanges        []time.Time             `json:"password_changes"`
+	EmailVerifications     []exportToken           `json:"email_verification_tokens"`
+	PasswordResets         []exportToken           `json:"password_reset_tokens"`
+	PasswordChangeRequests []exportToken           `json:"password_change_requests"`
+	LoginOTPChallenges     []exportOTPChallenge    `json:"login_otp_challenges"`
+	DeletionRequests       []exportDeletionRequest `json:"account_deletion_requests"`
+}
+
+func loadAccountExport(db *sqlx.DB, uid int64) (*accountExport, error) {
+	out := &accountExport{
+		GeneratedAt:            time.Now().UTC(),
+		Roles:                  []string{},
+		Organizations:          []exportMembership{},
+		LinkedIdentities:       []exportIdentity{},
+		LoginAttempts:          []exportLoginAttempt{},
+		KnownDevices:           []exportDevice{},
+		PasswordChanges:        []time.Time{},
+		EmailVerifications:     []exportToken{},
+		PasswordResets:         []exportToken{},
+		PasswordChangeRequests: []exportToken{},
+		LoginOTPChallenges:     []exportOTPChallenge{},
+		DeletionRequests:       []exportDeletionRequest{},
+	}
+
+	if err := db.Get(&out.User, `
+		SELECT id, username, email, is_active, is_verified, created_at
+		FROM users
+		WHERE id = ?
+	`, uid); err != nil {
+		return nil, err
+	}
+
+	if err := db.Select(&out.Roles, `
+		SELECT r.name FROM user_roles ur JOIN roles r ON r.id = ur.role_id
+		WHERE ur.user_id = ?
+		ORDER BY r.name
+	`, uid); err != nil {
+		return nil, err
+	}
+	if err := db.Select(&out.Organizations, `
+		SELECT m.org_id, o.name, m.joined_at
+		FROM organization_members m JOIN organizations o ON o.id = m.org_id
+		WHERE m.user_id = ?
+		ORDER BY o.name
+	`, uid); err != nil {
+		return nil, err
+	}
+	if err := db.Select(&out.LinkedIdentities, `
+		SELECT issuer, subject, email, created_at, last_login_at
+		FROM user_identities
+		WHERE user_id = ?
+		ORDER BY id
+	`, uid); err != nil {
+		return nil, err
+	}
+	if err := db.Select(&out.LoginAttempts, `
+		SELECT attempt_time, ip, success, mfa_method
+		FROM login_attempts
+		WHERE user_id = ?
+		ORDER BY attempt_time DESC
+	`, uid); err != nil {
+		return nil, err
+	}
+	if err := db.Select(&out.KnownDevices, `
+		SELECT ip, user_agent, first_seen_at, last_seen_at, lockdown_at
+		FROM user_devices
+		WHERE user_id = ?
+		ORDER BY last_seen_at DESC
+	`, uid); err != nil {
+		return nil, err
+	}
+	if err := db.Select(&out.PasswordChanges, `
+		SELECT changed_at FROM password_history
+		WHERE user_id = ?
+		ORDER BY changed_at DESC
+	`, uid); err != nil {
+		return nil, err
+	}
+	if err := db.Select(&out.EmailVerifications, `
+		SELECT expires_at, used_at FROM email_verification_tokens WHERE user_id = ? ORDER BY id DESC
+	`, uid); err != nil {
+		return nil, err
+	}
+	if err := db.Select(&out.PasswordResets, `
+		SELECT expires_at, used_at FROM password_reset_tokens WHERE user_id = ? ORDER BY id DESC
+	`, uid); err != nil {
+		return nil, err
+	}
+	if err := db.Select(&out.PasswordChangeRequests, `
+		SELECT expires_at, used_at FROM password_change_requests WHERE user_id = ? ORDER BY id DESC
+	`, uid); err != nil {
+		return nil, err
+	}
+	if err := db.Select(&out.LoginOTPChallenges, `
+		SELECT created_at, expires_at, consumed_at, attempts
+		FROM login_otp_challenges
+		WHERE user_id = ?
+		ORDER BY id DESC
+	`, uid); err != nil {
+		return nil, err
+	}
+	if err := db.Select(&out.DeletionRequests, `
+		SELECT created_at, confirmed_at, scheduled_for, cancelled_at
+		FROM account_deletion_requests
+		WHERE user_id = ?
+		ORDER BY id DESC
+	`, uid); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// ExportMyData returns the signed-in user's personal data.
+// ?format=json (default) returns one JSON document, ?format=zip returns an
+// archive with one JSON file per section.
+func ExportMyData(db *sqlx.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		uid, err := middlewarex.UserIDFromCtx(c)
+		if err != nil {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		}
+
+		format := c.QueryParam("format")
+		if format == "" {
+			format = "json"
+		}
+		if format != "json" && format != "zip" {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "format must be json or zip"})
+		}
+
+		exp, err := loadAccountExport(db, uid)
+		if errors.Is(err, sql.ErrNoRows) {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+
+		stamp := exp.GeneratedAt.Format("20060102-150405")
+		c.Response().Header().Set(echo.HeaderCacheControl, "no-store")
+
+		if format == "json" {
+			c.Response().Header().Set(echo.HeaderContentDisposition,
+				fmt.Sprintf(`attachment; filename="my-data-%s.json"`, stamp))
+			return c.JSON(http.StatusOK, exp)
+		}
+
+		sections := []struct {
+			name string
+			data any
+		}{
+			{"user.json", map[string]any{
+				"user":              exp.User,
+				"roles":             exp.Roles,
+				"organizations":     exp.Organizations,
+				"linked_identities": exp.LinkedIdentities,
+			}},
+			{"login_attempts.json", exp.LoginAttempts},
+			{"known_devices.json", exp.KnownDevices},
+			{"password_changes.json", exp.PasswordChanges},
+			{"tokens.json", map[string]any{
+				"email_verification_tokens": exp.EmailVerifications,
+				"password_reset_tokens":     exp.PasswordResets,
+				"password_change_requests":  exp.PasswordChangeRequests,
+				"login_otp_challenges":      exp.LoginOTPChallenges,
+			}},
+			{"account_deletion_requests.json", exp.DeletionRequests},
+		}
+
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		for _, s := range sections {
+			w, err := zw.CreateHeader(&zip.FileHeader{
+				Name:     s.name,
+				Method:   zip.Deflate,
+				Modified: exp.GeneratedAt,
+			})
+			if err != nil {
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "export error"})
+			}
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(s.data); err != nil {
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "export error"})
+			}
+		}
+		if err := zw.Close(); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "export error"})
+		}
+
+		c.Response().Header().Set(echo.HeaderContentDisposition,
+			fmt.Sprintf(`attachment; filename="my-data-%s.zip"`, stamp))
+		return c.Blob(http.StatusOK, "application/zip", buf.Bytes())
+	}
+}
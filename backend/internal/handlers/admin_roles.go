@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	middlewarex "secure-communication-ltd/backend/internal/middleware"
+	"secure-communication-ltd/backend/internal/services"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo/v4"
+)
+
+type RoleDTO struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+type setUserRolesRequest struct {
+	Roles []string `json:"roles"`
+}
+
+// ListRoles returns every role with its permissions.
+func ListRoles(db *sqlx.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var rows []struct {
+			Name        string         `db:"name"`
+			Description string         `db:"description"`
+			Permission  sql.NullString `db:"permission"`
+		}
+		if err := db.Select(&rows, `
+			SELECT r.name, r.description, p.name AS permission
+			FROM roles r
+			LEFT JOIN role_permissions rp ON rp.role_id = r.id
+			LEFT JOIN permissions p ON p.id = rp.permission_id
+			ORDER BY r.name, p.name
+		`); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+
+		out := []RoleDTO{}
+		for _, r := range rows {
+			if len(out) == 0 || out[len(out)-1].Name != r.Name {
+				out = append(out, RoleDTO{Name: r.Name, Description: r.Description, Permissions: []string{}})
+			}
+			if r.Permission.Valid {
+				last := &out[len(out)-1]
+				last.Permissions = append(last.Permissions, r.Permission.String)
+			}
+		}
+		return c.JSON(http.StatusOK, map[string]any{"items": out})
+	}
+}
+
+// GetUserRoles returns the roles and effective permissions of one user.
+func GetUserRoles(db *sqlx.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		uid, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil || uid <= 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid user id"})
+		}
+		var exists int
+		if err := db.Get(&exists, `SELECT COUNT(*) FROM users WHERE id = ?`, uid); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+		if exists == 0 {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "user not found"})
+		}
+		roles, perms, err := services.LoadUserAccess(db, uid)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+		return c.JSON(http.StatusOK, map[string]any{
+			"user_id":     uid,
+			"roles":       roles,
+			"permissions": perms,
+		})
+	}
+}
+
+// SetUserRoles replaces a user's roles. The change applies to the user's next request.
+// Removing the admin role from the last admin is refused so the system cannot lock itself out.
+func SetUserRoles(db *sqlx.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		actor, err := middlewarex.UserIDFromCtx(c)
+		if err != nil {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		}
+		uid, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil || uid <= 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid user id"})
+		}
+
+		var req setUserRolesRequest
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid json"})
+		}
+		roles := make([]string, 0, len(req.Roles))
+		for _, r := range req.Roles {
+			if r = strings.TrimSpace(r); r != "" {
+				roles = append(roles, r)
+			}
+		}
+
+		tx, err := db.Beginx()
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "tx error"})
+		}
+		defer tx.Rollback()
+
+		var exists int
+		if err := tx.Get(&exists, `SELECT COUNT(*) FROM users WHERE id = ? FOR UPDATE`, uid); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+		if exists == 0 {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "user not found"})
+		}
+
+		if err := services.SetUserRoles(tx, uid, roles, actor); err != nil {
+			if errors.Is(err, services.ErrUnknownRole) {
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": "unknown role"})
+			}
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+
+		admins, err := services.AdminCount(tx)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+		if admins == 0 {
+			middlewarex.Audit(c, db, services.AuditEvent{
+				Type: services.AuditRolesChanged, Outcome: services.AuditDenied,
+				SubjectType: "user", SubjectID: strconv.FormatInt(uid, 10),
+				Details: map[string]any{"reason": "last_admin", "roles": roles},
+			})
+			return c.JSON(http.StatusConflict, map[string]string{"error": "cannot remove the last admin"})
+		}
+
+		prev, _, err := services.LoadUserAccess(db, uid) // committed state, before this change
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+		if err := tx.Commit(); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "commit error"})
+		}
+
+		cur, perms, err := services.LoadUserAccess(db, uid)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+		middlewarex.Audit(c, db, services.AuditEvent{
+			Type: services.AuditRolesChanged, SubjectType: "user", SubjectID: strconv.FormatInt(uid, 10),
+			Details: map[string]any{"before": prev, "after": cur},
+		})
+		return c.JSON(http.StatusOK, map[string]any{
+			"user_id":     uid,
+			"roles":       cur,
+			"permissions": perms,
+		})
+	}
+}
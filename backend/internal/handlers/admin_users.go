@@ -0,0 +1,352 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"secure-communication-ltd/backend/config"
+	middlewarex "secure-communication-ltd/backend/internal/middleware"
+	"secure-communication-ltd/backend/internal/services"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo/v4"
+)
+
+type adminUserDTO struct {
+	ID          int64      `db:"id" json:"id"`
+	Username    string     `db:"username" json:"username"`
+	Email       string     `db:"email" json:"email"`
+	IsActive    bool       `db:"is_active" json:"is_active"`
+	IsVerified  bool       `db:"is_verified" json:"is_verified"`
+	IsService   bool       `db:"is_service_account" json:"is_service_account"`
+	LockedAt    *time.Time `db:"locked_at" json:"locked_at"`
+	CreatedAt   time.Time  `db:"created_at" json:"created_at"`
+	LastLoginAt *time.Time `db:"last_login_at" json:"last_login_at"`
+}
+
+// adminUserSelect reads adminUserDTO columns; the last login comes from the
+// login history (completed sign-ins only).
+const adminUserSelect = `
+	SELECT u.id, u.username, u.email, u.is_active, u.is_verified, u.is_service_account,
+	       u.locked_at, u.created_at,
+	       (SELECT MAX(la.attempt_time) FROM login_attempts la
+	        WHERE la.user_id = u.id AND la.success = 1) AS last_login_at
+	FROM users u`
+
+// AdminListUsers lists accounts, newest first. Filters: q (substring of the
+// username or email), status (active, inactive, locked, unverified, service);
+// page/size as in the audit log.
+func AdminListUsers(db *sqlx.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		where := []string{"1=1"}
+		args := []any{}
+
+		if q := strings.ToLower(strings.TrimSpace(c.QueryParam("q"))); q != "" {
+			where = append(where, "(u.username_canonical LIKE ? OR u.email_canonical LIKE ?)")
+			like := "%" + services.LikeEscape(q) + "%"
+			args = append(args, like, like)
+		}
+		switch c.QueryParam("status") {
+		case "":
+		case "active":
+			where = append(where, "u.is_active = TRUE")
+		case "inactive":
+			where = append(where, "u.is_active = FALSE")
+		case "locked":
+			where = append(where, "u.locked_at IS NOT NULL")
+		case "unverified":
+			where = append(where, "u.is_verified = FALSE")
+		case "service":
+			where = append(where, "u.is_service_account = TRUE")
+		default:
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid status"})
+		}
+
+		page := 1
+		size := 50
+		if p, err := strconv.Atoi(c.QueryParam("page")); err == nil && p > 0 {
+			page = p
+		}
+		if s, err := strconv.Atoi(c.QueryParam("size")); err == nil {
+			size = min(max(s, 1), 200)
+		}
+		cond := strings.Join(where, " AND ")
+
+		var total int
+		if err := db.Get(&total, `SELECT COUNT(*) FROM users u WHERE `+cond, args...); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+		items := []adminUserDTO{}
+		if err := db.Select(&items, adminUserSelect+`
+			WHERE `+cond+`
+			ORDER BY u.id DESC
+			LIMIT ? OFFSET ?
+		`, append(args, size, (page-1)*size)...); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+		return c.JSON(http.StatusOK, map[string]any{
+			"items": items,
+			"page":  page,
+			"size":  size,
+			"total": total,
+		})
+	}
+}
+
+// AdminGetUser returns one account with its sign-in status: last login, MFA
+// methods, roles and whether failed password attempts currently lock it out.
+func AdminGetUser(db *sqlx.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		u, err := loadAdminUser(db, c.Param("id"))
+		if errors.Is(err, sql.ErrNoRows) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "user not found"})
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+
+		var lastIP *string
+		if err := db.Get(&lastIP, `
+			SELECT ip FROM login_attempts
+			WHERE user_id = ? AND success = 1
+			ORDER BY attempt_time DESC, id DESC
+			LIMIT 1
+		`, u.ID); err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+
+		// Lockout counts failures per typed identifier (username or email), as in Login
+		pol := config.GetPolicy()
+		var failures int
+		if err := db.Get(&failures, `
+			SELECT COALESCE(MAX(n), 0) FROM (
+				SELECT COUNT(*) AS n FROM login_attempts la
+				JOIN users u ON u.id = la.user_id
+				WHERE la.user_id = ? AND la.success = 0
+				  AND la.attempt_time > (NOW() - INTERVAL ? MINUTE)
+				  AND la.attempt_time > COALESCE(u.lockout_cleared_at, '1000-01-01')
+				GROUP BY la.username
+			) t
+		`, u.ID, pol.LockoutMinutes); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+
+		var ssoIdentities int
+		if err := db.Get(&ssoIdentities, `SELECT COUNT(*) FROM user_identities WHERE user_id = ?`, u.ID); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+		// Every interactive password sign-in of a verified account takes the
+		// email OTP step; SSO sign-ins rely on the identity provider's MFA.
+		mfa := []string{}
+		if u.IsVerified && !u.IsService {
+			mfa = append(mfa, "email_otp")
+		}
+		if ssoIdentities > 0 {
+			mfa = append(mfa, "oidc")
+		}
+
+		roles, _, err := services.LoadUserAccess(db, u.ID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+		return c.JSON(http.StatusOK, map[string]any{
+			"user":            u,
+			"last_login_ip":   lastIP,
+			"mfa_enrolled":    len(mfa) > 0,
+			"mfa_methods":     mfa,
+			"roles":           roles,
+			"failed_attempts": failures,
+			"locked_out":      pol.MaxLoginAttempts > 0 && failures >= pol.MaxLoginAttempts,
+		})
+	}
+}
+
+func loadAdminUser(db sqlx.Queryer, id string) (adminUserDTO, error) {
+	var u adminUserDTO
+	uid, err := strconv.ParseInt(id, 10, 64)
+	if err != nil || uid <= 0 {
+		return u, sql.ErrNoRows
+	}
+	err = sqlx.Get(db, &u, adminUserSelect+` WHERE u.id = ?`, uid)
+	return u, err
+}
+
+// AdminSetUserActive returns the handler that activates (active=true) or
+// deactivates an account. Deactivation takes effect at once: sessions, API
+// keys and OAuth tokens of inactive users are refused. Admins cannot
+// deactivate themselves or the last active admin.
+func AdminSetUserActive(db *sqlx.DB, active bool) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		actor, err := middlewarex.UserIDFromCtx(c)
+		if err != nil {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		}
+		u, err := loadAdminUser(db, c.Param("id"))
+		if errors.Is(err, sql.ErrNoRows) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "user not found"})
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+		event := services.AuditUserActivated
+		if !active {
+			event = services.AuditUserDeactivated
+		}
+		subject := strconv.FormatInt(u.ID, 10)
+
+		if !active {
+			reason := ""
+			if u.ID == actor {
+				reason = "self"
+			} else if last, err := services.IsLastActiveAdmin(db, u.ID); err != nil {
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+			} else if last {
+				reason = "last_admin"
+			}
+			if reason != "" {
+				middlewarex.Audit(c, db, services.AuditEvent{
+					Type: event, Outcome: services.AuditDenied, SubjectType: "user", SubjectID: subject,
+					Details: map[string]any{"reason": reason},
+				})
+				return c.JSON(http.StatusConflict, map[string]string{"error": "cannot deactivate your own account or the last admin"})
+			}
+		}
+
+		if _, err := db.Exec(`UPDATE users SET is_active = ? WHERE id = ?`, active, u.ID); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+		if u.IsActive != active {
+			middlewarex.Audit(c, db, services.AuditEvent{Type: event, SubjectType: "user", SubjectID: subject})
+		}
+		return c.JSON(http.StatusOK, map[string]any{"user_id": u.ID, "is_active": active})
+	}
+}
+
+// AdminForcePasswordReset makes the current password unusable, signs out
+// every session and emails the user a reset link.
+func AdminForcePasswordReset(db *sqlx.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		u, err := loadAdminUser(db, c.Param("id"))
+		if errors.Is(err, sql.ErrNoRows) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "user not found"})
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+		if u.IsService {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "service accounts have no password"})
+		}
+		if err := services.DisablePassword(db, u.ID); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+		revoked, err := services.RevokeUserSessions(db, u.ID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+		mailErr := sendPasswordReset(db, u.ID, u.Email)
+		middlewarex.Audit(c, db, services.AuditEvent{
+			Type: services.AuditUserPasswordReset, SubjectType: "user", SubjectID: strconv.FormatInt(u.ID, 10),
+			Details: map[string]any{"sessions_revoked": revoked, "email_sent": mailErr == nil},
+		})
+		if mailErr != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{
+				"error": "password disabled but the reset email could not be sent: " + mailErr.Error(),
+			})
+		}
+		return c.JSON(http.StatusOK, map[string]string{"message": "password reset email sent"})
+	}
+}
+
+// AdminUnlockUser clears the failed-login lockout (earlier failures stop
+// counting) and a "this wasn't me" lockdown. The per-IP throttle is not
+// per account and is left alone.
+func AdminUnlockUser(db *sqlx.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		u, err := loadAdminUser(db, c.Param("id"))
+		if errors.Is(err, sql.ErrNoRows) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "user not found"})
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+		if _, err := db.Exec(`UPDATE users SET lockout_cleared_at = NOW(), locked_at = NULL WHERE id = ?`, u.ID); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+		middlewarex.Audit(c, db, services.AuditEvent{
+			Type: services.AuditUserUnlocked, SubjectType: "user", SubjectID: strconv.FormatInt(u.ID, 10),
+			Details: map[string]any{"lockdown_cleared": u.LockedAt != nil},
+		})
+		return c.JSON(http.StatusOK, map[string]string{"message": "user unlocked"})
+	}
+}
+
+// AdminResendVerification emails a fresh verification link to an unverified account.
+func AdminResendVerification(db *sqlx.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		u, err := loadAdminUser(db, c.Param("id"))
+		if errors.Is(err, sql.ErrNoRows) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "user not found"})
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+		if u.IsVerified {
+			return c.JSON(http.StatusConflict, map[string]string{"error": "email already verified"})
+		}
+		if err := sendVerificationEmail(db, u.ID, u.Username, u.Email); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		}
+		middlewarex.Audit(c, db, services.AuditEvent{
+			Type: services.AuditVerificationResent, SubjectType: "user", SubjectID: strconv.FormatInt(u.ID, 10),
+		})
+		return c.JSON(http.StatusOK, map[string]string{"message": "verification email sent"})
+	}
+}
+
+// AdminDeleteUser removes an account at once, with the same anonymization as
+// a self-service deletion (no cooling-off period). Admins cannot delete
+// themselves or the last active admin.
+func AdminDeleteUser(db *sqlx.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		actor, err := middlewarex.UserIDFromCtx(c)
+		if err != nil {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		}
+		u, err := loadAdminUser(db, c.Param("id"))
+		if errors.Is(err, sql.ErrNoRows) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "user not found"})
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+		subject := strconv.FormatInt(u.ID, 10)
+
+		reason := ""
+		if u.ID == actor {
+			reason = "self"
+		} else if last, err := services.IsLastActiveAdmin(db, u.ID); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		} else if last {
+			reason = "last_admin"
+		}
+		if reason != "" {
+			middlewarex.Audit(c, db, services.AuditEvent{
+				Type: services.AuditUserDeleted, Outcome: services.AuditDenied, SubjectType: "user", SubjectID: subject,
+				Details: map[string]any{"reason": reason},
+			})
+			return c.JSON(http.StatusConflict, map[string]string{"error": "cannot delete your own account or the last admin"})
+		}
+
+		if err := services.PurgeAccount(db, u.ID); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+		middlewarex.Audit(c, db, services.AuditEvent{
+			Type: services.AuditUserDeleted, SubjectType: "user", SubjectID: subject,
+		})
+		return c.JSON(http.StatusOK, map[string]string{"message": "user deleted"})
+	}
+}
@@ -0,0 +1,212 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"secure-communication-ltd/backend/internal/services"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+var adminUserColumns = []string{"id", "username", "email", "is_active", "is_verified", "is_service_account",
+	"locked_at", "created_at", "last_login_at"}
+
+// expectAdminUser expects loadAdminUser to find user id.
+func expectAdminUser(mock sqlmock.Sqlmock, id int64, active, service bool, lockedAt any) {
+	mock.ExpectQuery(`FROM users u WHERE u.id = \?`).WithArgs(id).
+		WillReturnRows(sqlmock.NewRows(adminUserColumns).
+			AddRow(id, "bob", "bob@example.com", active, true, service, lockedAt, time.Now(), nil))
+}
+
+// expectLastAdmin expects services.IsLastActiveAdmin to answer last for id.
+func expectLastAdmin(mock sqlmock.Sqlmock, id int64, last bool) {
+	others := 1
+	if last {
+		others = 0
+	}
+	mock.ExpectQuery(`WHERE r.name = \? AND u.is_active AND u.id <> \?`).WithArgs(services.RoleAdmin, id).
+		WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(others))
+	mock.ExpectQuery(`WHERE r.name = \? AND ur.user_id = \?`).WithArgs(services.RoleAdmin, id).
+		WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(1))
+}
+
+func TestAdminListUsers(t *testing.T) {
+	db, mock := newMockDB(t)
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM users u WHERE 1=1 AND \(u.username_canonical LIKE \? OR u.email_canonical LIKE \?\) AND u.locked_at IS NOT NULL`).
+		WithArgs(`%bob\_%`, `%bob\_%`).WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(41))
+	mock.ExpectQuery(`u.locked_at IS NOT NULL\s+ORDER BY u.id DESC\s+LIMIT \? OFFSET \?`).
+		WithArgs(`%bob\_%`, `%bob\_%`, 20, 20).
+		WillReturnRows(sqlmock.NewRows(adminUserColumns).AddRow(8, "bob_", "bob@example.com", true, true, false, time.Now(), time.Now(), nil))
+
+	c, rec := signedInContext(http.MethodGet, "/api/admin/users", "/api/admin/users?q=+Bob_+&status=locked&page=2&size=20", "", 1, 0)
+	if err := AdminListUsers(db)(c); err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Items             []adminUserDTO
+		Page, Size, Total int
+	}
+	if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &got) != nil ||
+		len(got.Items) != 1 || got.Page != 2 || got.Size != 20 || got.Total != 41 {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+
+	c, rec = signedInContext(http.MethodGet, "/api/admin/users", "/api/admin/users?status=banned", "", 1, 0)
+	if err := AdminListUsers(db)(c); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown status: %d, want 400", rec.Code)
+	}
+}
+
+func TestAdminGetUserNotFound(t *testing.T) {
+	db, _ := newMockDB(t)
+	for _, id := range []string{"0", "-3", "x"} { // refused before any query
+		c, rec := signedInContext(http.MethodGet, "/api/admin/users/:id", "/api/admin/users/"+id, "", 1, 0, "id", id)
+		if err := AdminGetUser(db)(c); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("id %q: status %d, want 404", id, rec.Code)
+		}
+	}
+}
+
+// Admins cannot deactivate or delete themselves or the last active admin;
+// the refusal is audited.
+func TestAdminRefusesSelfAndLastAdmin(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		target int64
+		last   bool
+	}{
+		{"self", 1, false},
+		{"last admin", 2, true},
+	} {
+		t.Run("deactivate "+tc.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			expectAdminUser(mock, tc.target, true, false, nil)
+			if tc.last {
+				expectLastAdmin(mock, tc.target, true)
+			}
+			expectAudit(t, mock, services.AuditUserDeactivated, services.AuditDenied)
+
+			id := strconv.FormatInt(tc.target, 10)
+			c, rec := signedInContext(http.MethodPost, "/api/admin/users/:id/deactivate", "/api/admin/users/"+id+"/deactivate", "", 1, 0, "id", id)
+			if err := AdminSetUserActive(db, false)(c); err != nil {
+				t.Fatal(err)
+			}
+			if rec.Code != http.StatusConflict {
+				t.Fatalf("status %d, want 409", rec.Code)
+			}
+		})
+		t.Run("delete "+tc.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			expectAdminUser(mock, tc.target, true, false, nil)
+			if tc.last {
+				expectLastAdmin(mock, tc.target, true)
+			}
+			expectAudit(t, mock, services.AuditUserDeleted, services.AuditDenied)
+
+			id := strconv.FormatInt(tc.target, 10)
+			c, rec := signedInContext(http.MethodDelete, "/api/admin/users/:id", "/api/admin/users/"+id, "", 1, 0, "id", id)
+			if err := AdminDeleteUser(db)(c); err != nil {
+				t.Fatal(err)
+			}
+			if rec.Code != http.StatusConflict {
+				t.Fatalf("status %d, want 409", rec.Code)
+			}
+		})
+	}
+}
+
+func TestAdminSetUserActive(t *testing.T) {
+	t.Run("deactivate", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		expectAdminUser(mock, 2, true, false, nil)
+		expectLastAdmin(mock, 2, false)
+		mock.ExpectExec(`UPDATE users SET is_active = \? WHERE id = \?`).WithArgs(false, int64(2)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		expectAudit(t, mock, services.AuditUserDeactivated, services.AuditSuccess)
+
+		c, rec := signedInContext(http.MethodPost, "/api/admin/users/:id/deactivate", "/api/admin/users/2/deactivate", "", 1, 0, "id", "2")
+		if err := AdminSetUserActive(db, false)(c); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body)
+		}
+	})
+	t.Run("activate an active user", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		expectAdminUser(mock, 1, true, false, nil)
+		// activating oneself is fine; nothing changed, so nothing is audited
+		mock.ExpectExec(`UPDATE users SET is_active = \? WHERE id = \?`).WithArgs(true, int64(1)).
+			WillReturnResult(sqlmock.NewResult(0, 0))
+
+		c, rec := signedInContext(http.MethodPost, "/api/admin/users/:id/activate", "/api/admin/users/1/activate", "", 1, 0, "id", "1")
+		if err := AdminSetUserActive(db, true)(c); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status %d: %s", rec.Code, rec.Body)
+		}
+	})
+}
+
+// Without a mailer the password stays disabled, the sessions revoked and the
+// reset audited; the response says the email was not sent.
+func TestAdminForcePasswordReset(t *testing.T) {
+	t.Setenv("HMAC_SECRET", "reset-test-key")
+	t.Setenv("SMTP_HOST", "")
+	db, mock := newMockDB(t)
+	expectAdminUser(mock, 2, true, false, nil)
+	mock.ExpectExec(`UPDATE users SET password_hmac = \?, salt = \?, password_fp = \? WHERE id = \?`).
+		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), int64(2)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE user_sessions SET revoked_at = NOW\(\)`).WithArgs(int64(2)).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(`UPDATE password_reset_tokens SET used_at = NOW\(\)`).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(`INSERT INTO password_reset_tokens`).WillReturnResult(sqlmock.NewResult(1, 1))
+	expectAudit(t, mock, services.AuditUserPasswordReset, services.AuditSuccess)
+
+	c, rec := signedInContext(http.MethodPost, "/api/admin/users/:id/reset-password", "/api/admin/users/2/reset-password", "", 1, 0, "id", "2")
+	if err := AdminForcePasswordReset(db)(c); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusInternalServerError || !strings.Contains(rec.Body.String(), "password disabled") {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+
+	// service accounts have no password to reset
+	db, mock = newMockDB(t)
+	expectAdminUser(mock, 3, true, true, nil)
+	c, rec = signedInContext(http.MethodPost, "/api/admin/users/:id/reset-password", "/api/admin/users/3/reset-password", "", 1, 0, "id", "3")
+	if err := AdminForcePasswordReset(db)(c); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("service account: status %d, want 400", rec.Code)
+	}
+}
+
+func TestAdminUnlockUser(t *testing.T) {
+	db, mock := newMockDB(t)
+	expectAdminUser(mock, 2, true, false, time.Now())
+	mock.ExpectExec(`UPDATE users SET lockout_cleared_at = NOW\(\), locked_at = NULL WHERE id = \?`).WithArgs(int64(2)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectAudit(t, mock, services.AuditUserUnlocked, services.AuditSuccess)
+
+	c, rec := signedInContext(http.MethodPost, "/api/admin/users/:id/unlock", "/api/admin/users/2/unlock", "", 1, 0, "id", "2")
+	if err := AdminUnlockUser(db)(c); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,303 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	middlewarex "secure-communication-ltd/backend/internal/middleware"
+	"secure-communication-ltd/backend/internal/services"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	apiKeyDefaultDays = 90
+	apiKeyMaxDays     = 365
+)
+
+type createAPIKeyRequest struct {
+	Name          string   `json:"name"`
+	Scopes        []string `json:"scopes"`
+	ExpiresInDays int      `json:"expires_in_days"` // 0 = default (90), max 365
+	OwnerUserID   int64    `json:"owner_user_id,omitempty"`
+	OrgID         int64    `json:"org_id,omitempty"` // default: caller's active organization
+}
+
+type apiKeyDTO struct {
+	services.APIKey
+	Scopes []string `json:"scopes"`
+}
+
+type createServiceAccountRequest struct {
+	Username string   `json:"username"`
+	Email    string   `json:"email"`
+	Roles    []string `json:"roles"`
+	OrgID    int64    `json:"org_id,omitempty"`
+}
+
+// keyOwner resolves whose keys a request is about: the caller, or (for
+// admins) a service account given by owner_user_id.
+func keyOwner(db *sqlx.DB, claims *services.Claims, ownerID int64) (int64, int, string) {
+	if ownerID == 0 || ownerID == claims.UserID {
+		return claims.UserID, 0, ""
+	}
+	if !claims.HasPermission(services.PermUsersAdmin) {
+		return 0, http.StatusForbidden, "forbidden"
+	}
+	var isService bool
+	err := db.Get(&isService, `SELECT is_service_account FROM users WHERE id = ?`, ownerID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, http.StatusNotFound, "owner not found"
+	}
+	if err != nil {
+		return 0, http.StatusInternalServerError, "db error"
+	}
+	if !isService {
+		return 0, http.StatusBadRequest, "keys can only be issued for yourself or a service account"
+	}
+	return ownerID, 0, ""
+}
+
+// CreateAPIKey issues a key. The full token is returned once and cannot be retrieved again.
+func CreateAPIKey(db *sqlx.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		claims, err := middlewarex.ClaimsFromCtx(c)
+		if err != nil {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		}
+
+		var req createAPIKeyRequest
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid json"})
+		}
+		req.Name = strings.TrimSpace(req.Name)
+		if req.Name == "" || utf8.RuneCountInString(req.Name) > 100 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "name is required (max 100 characters)"})
+		}
+		days := req.ExpiresInDays
+		if days == 0 {
+			days = apiKeyDefaultDays
+		}
+		if days < 1 || days > apiKeyMaxDays {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "expires_in_days must be 1-365"})
+		}
+
+		owner, code, msg := keyOwner(db, claims, req.OwnerUserID)
+		if code != 0 {
+			return c.JSON(code, map[string]string{"error": msg})
+		}
+
+		// A key can never carry more than its owner currently has
+		_, ownerPerms, err := services.LoadUserAccess(db, owner)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+		scopes := []string{}
+		seen := map[string]bool{}
+		for _, s := range req.Scopes {
+			s = strings.TrimSpace(s)
+			if s == "" || seen[s] {
+				continue
+			}
+			if !services.HasPermission(ownerPerms, s) {
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": "scope not held by the key owner: " + s})
+			}
+			seen[s] = true
+			scopes = append(scopes, s)
+		}
+		if len(scopes) == 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "at least one scope is required"})
+		}
+
+		orgID := req.OrgID
+		if orgID == 0 {
+			orgID = claims.OrgID
+		}
+		var orgArg any
+		if orgID != 0 {
+			ok, err := services.IsOrgMember(db, owner, orgID)
+			if err != nil {
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+			}
+			if !ok {
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": "key owner is not a member of this organization"})
+			}
+			orgArg = orgID
+		}
+
+		token, prefix, secretHash, err := services.NewAPIKeyToken()
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "token error"})
+		}
+		expires := time.Now().Add(time.Duration(days) * 24 * time.Hour)
+
+		res, err := db.Exec(`
+			INSERT INTO api_keys (user_id, org_id, name, prefix, secret_sha256, scopes, expires_at, created_by)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		`, owner, orgArg, req.Name, prefix, secretHash, strings.Join(scopes, ","), expires, claims.UserID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+		id, _ := res.LastInsertId()
+		middlewarex.Audit(c, db, services.AuditEvent{
+			Type: services.AuditAPIKeyCreated, OrgID: orgID,
+			SubjectType: "api_key", SubjectID: strconv.FormatInt(id, 10),
+			Details: map[string]any{"owner_user_id": owner, "prefix": prefix, "scopes": scopes, "expires_at": expires.UTC()},
+		})
+
+		return c.JSON(http.StatusCreated, map[string]any{
+			"id":         id,
+			"name":       req.Name,
+			"prefix":     prefix,
+			"scopes":     scopes,
+			"org_id":     orgArg,
+			"expires_at": expires.UTC(),
+			"token":      token,
+			"message":    "Store this token now; it will not be shown again.",
+		})
+	}
+}
+
+// ListAPIKeys lists the caller's keys (or a service account's, for admins).
+func ListAPIKeys(db *sqlx.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		claims, err := middlewarex.ClaimsFromCtx(c)
+		if err != nil {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		}
+		var ownerID int64
+		if v := c.QueryParam("owner_user_id"); v != "" {
+			if ownerID, err = strconv.ParseInt(v, 10, 64); err != nil {
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid owner_user_id"})
+			}
+		}
+		owner, code, msg := keyOwner(db, claims, ownerID)
+		if code != 0 {
+			return c.JSON(code, map[string]string{"error": msg})
+		}
+
+		var keys []services.APIKey
+		if err := db.Select(&keys, `
+			SELECT id, user_id, org_id, name, prefix, scopes, expires_at, last_used_at,
+			       last_used_ip, created_at, revoked_at
+			FROM api_keys
+			WHERE user_id = ?
+			ORDER BY created_at DESC
+		`, owner); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+		items := make([]apiKeyDTO, 0, len(keys))
+		for _, k := range keys {
+			items = append(items, apiKeyDTO{APIKey: k, Scopes: k.ScopeList()})
+		}
+		return c.JSON(http.StatusOK, map[string]any{"items": items})
+	}
+}
+
+// RevokeAPIKey revokes one of the caller's keys (admins may also revoke service account keys).
+func RevokeAPIKey(db *sqlx.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		claims, err := middlewarex.ClaimsFromCtx(c)
+		if err != nil {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		}
+		id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil || id <= 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid key id"})
+		}
+
+		var owner int64
+		err = db.Get(&owner, `SELECT user_id FROM api_keys WHERE id = ?`, id)
+		if errors.Is(err, sql.ErrNoRows) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "key not found"})
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+		if _, code, _ := keyOwner(db, claims, owner); code != 0 {
+			// Do not reveal other users' key IDs
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "key not found"})
+		}
+
+		if _, err := db.Exec(`
+			UPDATE api_keys SET revoked_at = NOW() WHERE id = ? AND revoked_at IS NULL
+		`, id); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+		middlewarex.Audit(c, db, services.AuditEvent{
+			Type: services.AuditAPIKeyRevoked, SubjectType: "api_key", SubjectID: strconv.FormatInt(id, 10),
+			Details: map[string]any{"owner_user_id": owner},
+		})
+		return c.JSON(http.StatusOK, map[string]string{"message": "key revoked"})
+	}
+}
+
+// AdminCreateServiceAccount creates a machine identity that can only authenticate with API keys.
+func AdminCreateServiceAccount(db *sqlx.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		actor, err := middlewarex.UserIDFromCtx(c)
+		if err != nil {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		}
+		var req createServiceAccountRequest
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid json"})
+		}
+
+		tx, err := db.Beginx()
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "tx error"})
+		}
+		defer tx.Rollback()
+
+		uid, err := services.InsertUser(tx, services.NewUser{
+			Username: req.Username,
+			Email:    req.Email,
+			Verified: true,
+		})
+		if errors.Is(err, services.ErrIdentityTaken) {
+			return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+		}
+		if services.IsIdentityValidationError(err) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "insert error"})
+		}
+		if _, err := tx.Exec(`UPDATE users SET is_service_account = TRUE WHERE id = ?`, uid); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+		if err := services.SetUserRoles(tx, uid, req.Roles, actor); err != nil {
+			if errors.Is(err, services.ErrUnknownRole) {
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": "unknown role"})
+			}
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+		if req.OrgID != 0 {
+			res, err := tx.Exec(`
+				INSERT INTO organization_members (org_id, user_id, added_by)
+				SELECT id, ?, ? FROM organizations WHERE id = ?
+			`, uid, actor, req.OrgID)
+			if err != nil {
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+			}
+			if n, _ := res.RowsAffected(); n == 0 {
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": "organization not found"})
+			}
+		} else if slug := services.DefaultOrgSlug(); slug != "" {
+			if err := services.AddOrgMemberBySlug(tx, slug, uid, &actor); err != nil {
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "commit error"})
+		}
+		return c.JSON(http.StatusCreated, map[string]any{"id": uid, "username": strings.TrimSpace(req.Username)})
+	}
+}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"secure-communication-ltd/backend/internal/services"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo/v4"
+)
+
+type auditEntryDTO struct {
+	services.AuditEntry
+	ActorUserID *int64          `json:"actor_user_id"`
+	OrgID       *int64          `json:"org_id"`
+	Details     json.RawMessage `json:"details,omitempty"`
+}
+
+// AdminListAudit searches the security audit log, newest first. Filters:
+// type (exact, or a prefix ending in "." such as "auth."), outcome, actor_id,
+// subject_type, subject_id, ip, request_id, from/to (RFC 3339); page/size as
+// in customer search.
+func AdminListAudit(db *sqlx.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		where := []string{"1=1"}
+		args := []any{}
+
+		if t := strings.TrimSpace(c.QueryParam("type")); t != "" {
+			if strings.HasSuffix(t, ".") {
+				where = append(where, "event_type LIKE ?")
+				args = append(args, strings.ReplaceAll(t, "_", `\_`)+"%")
+			} else {
+				where = append(where, "event_type = ?")
+				args = append(args, t)
+			}
+		}
+		for _, f := range []struct{ param, col string }{
+			{"outcome", "outcome"},
+			{"subject_type", "subject_type"},
+			{"subject_id", "subject_id"},
+			{"ip", "ip"},
+			{"request_id", "request_id"},
+		} {
+			if v := strings.TrimSpace(c.QueryParam(f.param)); v != "" {
+				where = append(where, f.col+" = ?")
+				args = append(args, v)
+			}
+		}
+		if v := c.QueryParam("actor_id"); v != "" {
+			id, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid actor_id"})
+			}
+			where = append(where, "actor_user_id = ?")
+			args = append(args, id)
+		}
+		for _, f := range []struct{ param, op string }{{"from", ">="}, {"to", "<"}} {
+			if v := c.QueryParam(f.param); v != "" {
+				t, err := time.Parse(time.RFC3339, v)
+				if err != nil {
+					return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid " + f.param + " (RFC 3339 expected)"})
+				}
+				where = append(where, "occurred_at "+f.op+" ?")
+				args = append(args, t.UTC())
+			}
+		}
+
+		page := 1
+		size := 50
+		if p, err := strconv.Atoi(c.QueryParam("page")); err == nil && p > 0 {
+			page = p
+		}
+		if s, err := strconv.Atoi(c.QueryParam("size")); err == nil {
+			size = min(max(s, 1), 200)
+		}
+		cond := strings.Join(where, " AND ")
+
+		var total int
+		if err := db.Get(&total, `SELECT COUNT(*) FROM audit_log WHERE `+cond, args...); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+		var rows []services.AuditEntry
+		if err := db.Select(&rows, `
+			SELECT id, occurred_at, event_type, outcome, actor_user_id, actor_label, subject_type,
+			       subject_id, org_id, ip, user_agent, request_id, details, prev_hash, entry_hash
+			FROM audit_log
+			WHERE `+cond+`
+			ORDER BY id DESC
+			LIMIT ? OFFSET ?
+		`, append(args, size, (page-1)*size)...); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+
+		items := make([]auditEntryDTO, 0, len(rows))
+		for _, r := range rows {
+			d := auditEntryDTO{AuditEntry: r}
+			if r.ActorUserID.Valid {
+				d.ActorUserID = &r.ActorUserID.Int64
+			}
+			if r.OrgID.Valid {
+				d.OrgID = &r.OrgID.Int64
+			}
+			if r.Details.Valid {
+				d.Details = json.RawMessage(r.Details.String)
+			}
+			items = append(items, d)
+		}
+		return c.JSON(http.StatusOK, map[string]any{
+			"items": items,
+			"page":  page,
+			"size":  size,
+			"total": total,
+		})
+	}
+}
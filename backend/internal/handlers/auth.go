@@ -2,10 +2,12 @@ package handlers
 
 import (
 	"bytes"
+	"errors"
 	"html/template"
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -13,6 +15,7 @@ import (
 	"github.com/labstack/echo/v4"
 
 	"secure-communication-ltd/backend/config"
+	middlewarex "secure-communication-ltd/backend/internal/middleware"
 	"secure-communication-ltd/backend/internal/services"
 )
 
@@ -20,31 +23,67 @@ type RegisterRequest struct {
 	Username string `json:"username"`
 	Email    string `json:"email"`
 	Password string `json:"password"`
+	Invite   string `json:"invite,omitempty"` // token from an admin-issued invitation
 }
 
 func Register(db *sqlx.DB) echo.HandlerFunc {
 	return func(c echo.Context) error {
+		if !services.LocalLoginEnabled() {
+			return localLoginDisabled(c)
+		}
 		pol := config.GetPolicy()
 
 		var req RegisterRequest
 		if err := c.Bind(&req); err != nil {
 			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid json"})
 		}
-		req.Username = strings.TrimSpace(req.Username)
-		req.Email = strings.TrimSpace(req.Email)
-
-		if req.Username == "" || req.Email == "" || req.Password == "" {
+		if strings.TrimSpace(req.Username) == "" || strings.TrimSpace(req.Email) == "" || req.Password == "" {
 			return c.JSON(http.StatusBadRequest, map[string]string{"error": "missing fields"})
 		}
-		if !looksLikeEmail(req.Email) {
+
+		// Canonicalize identifiers: uniqueness and lookups use the canonical forms
+		username, usernameCanon, err := services.CanonicalUsername(req.Username)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+		}
+		email, emailCanon, err := services.CanonicalEmail(req.Email)
+		if err != nil {
 			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid email"})
 		}
+		skeleton := services.UsernameSkeleton(usernameCanon)
+
+		// Registration mode: checked before anything reveals whether an identity exists
+		invite := strings.TrimSpace(req.Invite)
+		if invite == "" && !services.RegistrationAllowedWithoutInvite(services.RegistrationMode(), emailCanon) {
+			middlewarex.Audit(c, db, services.AuditEvent{
+				Type: services.AuditUserRegistered, Outcome: services.AuditDenied, ActorLabel: email,
+				Details: map[string]any{"reason": "registration_closed"},
+			})
+			return c.JSON(http.StatusForbidden, map[string]string{"error": services.ErrRegistrationClosed.Error()})
+		}
+		if invite != "" {
+			ok, err := services.RegistrationInviteValid(db, invite, emailCanon)
+			if err != nil {
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+			}
+			if !ok {
+				middlewarex.Audit(c, db, services.AuditEvent{
+					Type: services.AuditUserRegistered, Outcome: services.AuditDenied, ActorLabel: email,
+					Details: map[string]any{"reason": "invite_invalid"},
+				})
+				return c.JSON(http.StatusForbidden, map[string]string{"error": services.ErrInviteInvalid.Error()})
+			}
+		}
+
 		if err := services.ValidatePassword(req.Password, pol); err != nil {
 			return c.JSON(http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
 		}
 
 		var exists int
-		if err := db.Get(&exists, `SELECT COUNT(*) FROM users WHERE username = ? OR email = ?`, req.Username, req.Email); err != nil {
+		if err := db.Get(&exists, `
+			SELECT COUNT(*) FROM users
+			WHERE username_canonical = ? OR username_skeleton = ? OR email_canonical = ?
+		`, usernameCanon, skeleton, emailCanon); err != nil {
 			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
 		}
 		if exists > 0 {
@@ -64,40 +103,64 @@ func Register(db *sqlx.DB) echo.HandlerFunc {
 			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "fingerprint error"})
 		}
 
-		res, err := db.Exec(`
-			INSERT INTO users (username, email, password_hmac, salt, password_fp, is_verified)
-			VALUES (?, ?, ?, ?, ?, FALSE)
-		`, req.Username, req.Email, hashHex, salt, fpHex)
+		tx, err := db.Beginx()
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "insert error"})
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "tx error"})
 		}
-		uid, _ := res.LastInsertId()
+		defer tx.Rollback()
 
-		// verification token
-		vTok, err := services.NewVerificationToken(24 * time.Hour)
+		res, err := tx.Exec(`
+			INSERT INTO users (username, email, username_canonical, username_skeleton, email_canonical,
+			                   password_hmac, salt, password_fp, is_verified)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?, FALSE)
+		`, username, email, usernameCanon, skeleton, emailCanon, hashHex, salt, fpHex)
 		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "token error"})
-		}
-		if _, err := db.Exec(`
-			INSERT INTO email_verification_tokens (user_id, token_sha1, expires_at)
-			VALUES (?, ?, ?)
-		`, uid, vTok.SHA1Hex, vTok.ExpiresAt); err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "token save error"})
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "insert error"})
 		}
+		uid, _ := res.LastInsertId()
 
-		// email (HTML built safely via html/template)
-		mailer, err := services.NewMailerFromEnv()
-		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "mailer error"})
-		}
-		base := os.Getenv("BACKEND_PUBLIC_URL")
-		if base == "" {
-			base = "http://localhost:8080"
+		role := services.DefaultRole()
+		if invite != "" {
+			invited, err := services.ConsumeRegistrationInvite(tx, invite, emailCanon, uid)
+			if errors.Is(err, services.ErrInviteInvalid) {
+				return c.JSON(http.StatusForbidden, map[string]string{"error": err.Error()})
+			}
+			if err != nil {
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "invite error"})
+			}
+			if invited != "" {
+				role = invited
+			}
+		}
+		if err := services.GrantRole(tx, uid, role, nil); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "role error"})
+		}
+		if slug := services.DefaultOrgSlug(); slug != "" {
+			if err := services.AddOrgMemberBySlug(tx, slug, uid, nil); err != nil {
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "organization error"})
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "commit error"})
+		}
+		middlewarex.Audit(c, db, services.AuditEvent{
+			Type: services.AuditUserRegistered, ActorUserID: uid, ActorLabel: username,
+			SubjectType: "user", SubjectID: strconv.FormatInt(uid, 10),
+			Details: map[string]any{"role": role, "invited": invite != ""},
+		})
+
+		if err := sendVerificationEmail(db, uid, username, email); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 		}
-		verifyURL := strings.TrimRight(base, "/") + "/api/verify-email?token=" + url.QueryEscape(vTok.Raw)
 
-		// HTML template with automatic escaping
-		htmlTpl := template.Must(template.New("verifyEmail").Parse(`
+		return c.JSON(http.StatusOK, map[string]string{
+			"message": "User registered. Please check your email to verify your account.",
+		})
+	}
+}
+
+// HTML template with automatic escaping
+var verifyEmailMailTpl = template.Must(template.New("verifyEmail").Parse(`
 <h2>Verify your email</h2>
 <p>Hi {{.Username}}, thanks for registering.</p>
 <p>
@@ -109,29 +172,43 @@ func Register(db *sqlx.DB) echo.HandlerFunc {
 <p><code>{{.Link}}</code></p>
 `))
 
-		data := struct {
-			Username string
-			Link     string
-		}{
-			Username: req.Username,
-			Link:     verifyURL,
-		}
-
-		var buf bytes.Buffer
-		if err := htmlTpl.Execute(&buf, data); err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "template error"})
-		}
-
-		if err := mailer.Send(req.Email, "Verify your email", buf.String()); err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "send mail error"})
-		}
-
-		return c.JSON(http.StatusOK, map[string]string{
-			"message": "User registered. Please check your email to verify your account.",
-		})
+// sendVerificationEmail issues a 24-hour email verification token for userID
+// and mails the link. Returned errors are safe to show to the client.
+func sendVerificationEmail(db *sqlx.DB, userID int64, username, email string) error {
+	vTok, err := services.NewVerificationToken(24 * time.Hour)
+	if err != nil {
+		return errors.New("token error")
+	}
+	if _, err := db.Exec(`
+		INSERT INTO email_verification_tokens (user_id, token_sha1, expires_at)
+		VALUES (?, ?, ?)
+	`, userID, vTok.SHA1Hex, vTok.ExpiresAt); err != nil {
+		return errors.New("token save error")
 	}
-}
 
-func looksLikeEmail(s string) bool {
-	return strings.Count(s, "@") == 1 && len(s) >= 6 && strings.Contains(s, ".")
+	mailer, err := services.NewMailerFromEnv()
+	if err != nil {
+		return errors.New("mailer error")
+	}
+	base := os.Getenv("BACKEND_PUBLIC_URL")
+	if base == "" {
+		base = "http://localhost:8080"
+	}
+	verifyURL := strings.TrimRight(base, "/") + "/api/verify-email?token=" + url.QueryEscape(vTok.Raw)
+
+	data := struct {
+		Username string
+		Link     string
+	}{
+		Username: username,
+		Link:     verifyURL,
+	}
+	var buf bytes.Buffer
+	if err := verifyEmailMailTpl.Execute(&buf, data); err != nil {
+		return errors.New("template error")
+	}
+	if err := mailer.Send(email, "Verify your email", buf.String()); err != nil {
+		return errors.New("send mail error")
+	}
+	return nil
 }
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"secure-communication-ltd/backend/internal/services"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+)
+
+func registerContext(body string) (echo.Context, *httptest.ResponseRecorder) {
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/register", strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	return echo.New().NewContext(req, rec), rec
+}
+
+// Closed registration is refused, and audited, before anything looks up
+// whether the username or email is taken.
+func TestRegisterRequiresInvite(t *testing.T) {
+	const body = `{"username":"alice","email":"alice@example.com","password":"Correct-horse-battery-9"}`
+	t.Run("no invite", func(t *testing.T) {
+		t.Setenv("REGISTRATION_MODE", services.RegistrationModeInvite)
+		db, mock := newMockDB(t)
+		expectAudit(t, mock, services.AuditUserRegistered, services.AuditDenied)
+
+		c, rec := registerContext(body)
+		if err := Register(db)(c); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), services.ErrRegistrationClosed.Error()) {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+		}
+	})
+	t.Run("domain not allowed", func(t *testing.T) {
+		t.Setenv("REGISTRATION_MODE", services.RegistrationModeDomains)
+		t.Setenv("REGISTRATION_ALLOWED_DOMAINS", "corp.example")
+		db, mock := newMockDB(t)
+		expectAudit(t, mock, services.AuditUserRegistered, services.AuditDenied)
+
+		c, rec := registerContext(body)
+		if err := Register(db)(c); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != http.StatusForbidden {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+		}
+	})
+	t.Run("invalid invite", func(t *testing.T) {
+		t.Setenv("REGISTRATION_MODE", services.RegistrationModeOpen) // an invite is checked in every mode
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(`FROM registration_invites`).
+			WithArgs(services.HashSHA256Hex("bad-invite"), "alice@example.com").
+			WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(0))
+		expectAudit(t, mock, services.AuditUserRegistered, services.AuditDenied)
+
+		c, rec := registerContext(strings.TrimSuffix(body, "}") + `,"invite":"bad-invite"}`)
+		if err := Register(db)(c); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != http.StatusForbidden || !strings.Contains(rec.Body.String(), services.ErrInviteInvalid.Error()) {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+		}
+	})
+}
@@ -0,0 +1,18 @@
+package handlers
+
+import (
+	"net/http"
+
+	middlewarex "secure-communication-ltd/backend/internal/middleware"
+
+	"github.com/labstack/echo/v4"
+)
+
+// CSRFToken hands the SPA the token it must echo in X-CSRF-Token on every
+// POST/PUT/PATCH/DELETE. The matching cookie is set by the CSRF middleware.
+func CSRFToken() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		c.Response().Header().Set(echo.HeaderCacheControl, "no-store")
+		return c.JSON(http.StatusOK, map[string]string{"csrf_token": middlewarex.CSRFToken(c)})
+	}
+}
@@ -1,12 +1,16 @@
 package handlers
 
 import (
+	"database/sql"
+	"errors"
 	"html"
 	"net/http"
+	"strconv"
 	"strings"
 	"unicode/utf8"
 
 	middlewarex "secure-communication-ltd/backend/internal/middleware"
+	"secure-communication-ltd/backend/internal/services"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/labstack/echo/v4"
@@ -19,10 +23,11 @@ var notesPolicy = func() *bluemonday.Policy {
 }()
 
 type CreateCustomerRequest struct {
-	Name  string `json:"name"`
-	Email string `json:"email"`
-	Phone string `json:"phone,omitempty"`
-	Notes string `json:"notes,omitempty"`
+	Name  string        `json:"name"`
+	Email string        `json:"email"`
+	Phone string        `json:"phone,omitempty"`
+	Notes string        `json:"notes,omitempty"`
+	Tags  services.Tags `json:"tags,omitempty"`
 }
 
 type CreateCustomerResponse struct {
@@ -32,65 +37,455 @@ type CreateCustomerResponse struct {
 
 func CreateCustomer(db *sqlx.DB) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		// Must be logged in
-		if _, err := middlewarex.UserIDFromCtx(c); err != nil {
+		// Must be logged in, inside an organization
+		uid, err := middlewarex.UserIDFromCtx(c)
+		if err != nil {
 			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
 		}
+		orgID, err := middlewarex.OrgIDFromCtx(c)
+		if err != nil {
+			return c.JSON(http.StatusForbidden, map[string]string{"error": "no active organization"})
+		}
 
 		var req CreateCustomerRequest
 		if err := c.Bind(&req); err != nil {
 			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid json"})
 		}
 
-		// Basic normalization
-		req.Name = strings.TrimSpace(req.Name)
-		req.Email = strings.TrimSpace(req.Email)
-		req.Phone = strings.TrimSpace(req.Phone)
+		in, msg := normalizeCustomer(req)
+		if msg != "" {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": msg})
+		}
 
-		// Basic validation
-		if req.Name == "" || req.Email == "" {
-			return c.JSON(http.StatusBadRequest, map[string]string{"error": "name and email are required"})
+		id, err := insertCustomer(db, orgID, uid, in)
+		if errors.Is(err, errCustomerNotCreated) {
+			return c.JSON(http.StatusConflict, map[string]string{"error": "could not create customer"})
 		}
-		if !strings.Contains(req.Email, "@") || !strings.Contains(req.Email, ".") {
-			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid email"})
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
 		}
-		if utf8.RuneCountInString(req.Name) > 255 {
-			return c.JSON(http.StatusBadRequest, map[string]string{"error": "name too long"})
+
+		middlewarex.Audit(c, db, services.AuditEvent{
+			Type: services.AuditCustomerCreated, OrgID: orgID,
+			SubjectType: "customer", SubjectID: strconv.FormatInt(id, 10),
+		})
+		c.Response().Header().Set("ETag", customerETag(CustomerDTO{Version: 1}))
+		return c.JSON(http.StatusCreated, CreateCustomerResponse{ID: id, Name: in.Name})
+	}
+}
+
+var errCustomerNotCreated = errors.New("customer not created")
+
+// insertCustomer stores a new, normalized customer as version 1, with its
+// history entry. errCustomerNotCreated means the row was refused (e.g. its
+// email is taken).
+func insertCustomer(db *sqlx.DB, orgID, actorID int64, in CreateCustomerRequest) (int64, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Save to DB with a parameterized query
+	res, err := tx.Exec(`
+		INSERT INTO customers (org_id, name, email, phone, notes, tags)
+		VALUES (?, ?, ?, ?, ?, ?)`,
+		orgID, in.Name, in.Email, in.Phone, in.Notes, in.Tags,
+	)
+	if err != nil {
+		return 0, errCustomerNotCreated
+	}
+	id, _ := res.LastInsertId()
+
+	after := services.CustomerFields(in)
+	if err := services.RecordCustomerVersion(tx, orgID, id, 1, services.CustomerActionCreate, actorID, nil, &after, nil); err != nil {
+		return 0, err
+	}
+	return id, tx.Commit()
+}
+
+// normalizeCustomer trims and validates customer fields and sanitizes the
+// notes (plain text only). Every write path goes through it; a non-empty
+// message is the validation error to return.
+func normalizeCustomer(req CreateCustomerRequest) (CreateCustomerRequest, string) {
+	// Basic normalization
+	req.Name = strings.TrimSpace(req.Name)
+	req.Email = strings.TrimSpace(req.Email)
+	req.Phone = strings.TrimSpace(req.Phone)
+
+	// Basic validation
+	if req.Name == "" || req.Email == "" {
+		return req, "name and email are required"
+	}
+	if !strings.Contains(req.Email, "@") || !strings.Contains(req.Email, ".") {
+		return req, "invalid email"
+	}
+	if utf8.RuneCountInString(req.Name) > 255 {
+		return req, "name too long"
+	}
+	if utf8.RuneCountInString(req.Email) > 255 {
+		return req, "email too long"
+	}
+	if utf8.RuneCountInString(req.Phone) > 40 {
+		return req, "phone too long"
+	}
+
+	// Light normalization for notes before sanitation
+	notes := strings.ReplaceAll(req.Notes, "\r\n", "\n")
+	notes = strings.ReplaceAll(notes, "\x00", "")
+	notes = strings.TrimSpace(notes)
+
+	// Server-side sanitation: removes all HTML/JS and leaves plain text
+	safeNotes := notesPolicy.Sanitize(notes)
+
+	// Unescape entities to regular text characters (", ', &) - safe because there are no more tags/JS
+	safeNotes = html.UnescapeString(safeNotes)
+
+	// Length limit after filtering
+	if utf8.RuneCountInString(safeNotes) > 10000 {
+		safeNotes = string([]rune(safeNotes)[:10000])
+	}
+	req.Notes = safeNotes
+
+	tags, err := services.NormalizeTags(req.Tags)
+	if err != nil {
+		return req, "invalid tags: at most 20, each up to 32 letters, digits, '-' or '_'"
+	}
+	req.Tags = tags
+	return req, ""
+}
+
+// PatchCustomerRequest changes only the fields that are present.
+type PatchCustomerRequest struct {
+	Name  *string   `json:"name"`
+	Email *string   `json:"email"`
+	Phone *string   `json:"phone"`
+	Notes *string   `json:"notes"`
+	Tags  *[]string `json:"tags"`
+}
+
+// mergeCustomerPatch applies the fields present in p to cur. keepNotes means
+// p has no notes: the caller keeps the stored ones, which are already
+// sanitized, after normalizing.
+func mergeCustomerPatch(cur CustomerDTO, p PatchCustomerRequest) (req CreateCustomerRequest, keepNotes bool) {
+	req = CreateCustomerRequest{Name: cur.Name, Email: cur.Email, Phone: deref(cur.Phone), Tags: cur.Tags}
+	for _, f := range []struct{ dst, src *string }{
+		{&req.Name, p.Name}, {&req.Email, p.Email}, {&req.Phone, p.Phone}, {&req.Notes, p.Notes},
+	} {
+		if f.src != nil {
+			*f.dst = *f.src
 		}
-		if utf8.RuneCountInString(req.Email) > 255 {
-			return c.JSON(http.StatusBadRequest, map[string]string{"error": "email too long"})
+	}
+	if p.Tags != nil {
+		req.Tags = *p.Tags
+	}
+	return req, p.Notes == nil
+}
+
+const customerColumns = `id, name, email, phone, notes, tags, created_at, version`
+
+// loadCustomer returns a customer of the organization (sql.ErrNoRows if it
+// does not exist there or is in the trash).
+func loadCustomer(db sqlx.Queryer, orgID int64, idParam string) (CustomerDTO, error) {
+	var cu CustomerDTO
+	id, err := strconv.ParseInt(idParam, 10, 64)
+	if err != nil || id <= 0 {
+		return cu, sql.ErrNoRows
+	}
+	err = sqlx.Get(db, &cu, `SELECT `+customerColumns+` FROM customers WHERE id = ? AND org_id = ? AND deleted_at IS NULL`, id, orgID)
+	return cu, err
+}
+
+// customerLookupError answers a failed loadCustomer.
+func customerLookupError(c echo.Context, err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "customer not found"})
+	}
+	return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+}
+
+// customerAccessError answers a failed loadCustomer at the start of a by-ID
+// read or write. A customer of another organization is a 404 like a missing
+// one, but the attempt is recorded as a cross-tenant access denial.
+func customerAccessError(c echo.Context, db *sqlx.DB, err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		recordForeignCustomerAccess(c, db)
+	}
+	return customerLookupError(c, err)
+}
+
+// recordForeignCustomerAccess records a denial, the way RequireOrg does, if
+// the customer of the :id parameter belongs to an organization other than
+// the active one.
+func recordForeignCustomerAccess(c echo.Context, db *sqlx.DB) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		return
+	}
+	orgID, err := middlewarex.OrgIDFromCtx(c)
+	if err != nil {
+		return
+	}
+	var owner int64
+	if err := db.Get(&owner, `SELECT org_id FROM customers WHERE id = ?`, id); err != nil || owner == orgID {
+		return
+	}
+	uid, _ := middlewarex.UserIDFromCtx(c)
+	action := c.Request().Method + " " + c.Path()
+	services.RecordOrgAccessDenial(db, uid, owner, action, c.RealIP())
+	middlewarex.Audit(c, db, services.AuditEvent{
+		Type: services.AuditOrgAccessDenied, Outcome: services.AuditDenied,
+		SubjectType: "customer", SubjectID: strconv.FormatInt(id, 10),
+		Details: map[string]any{"action": action, "customer_org_id": owner},
+	})
+}
+
+// customerETag is the strong entity tag of a customer version.
+func customerETag(cu CustomerDTO) string {
+	return `"` + strconv.FormatInt(cu.Version, 10) + `"`
+}
+
+// etagListed reports whether an If-Match / If-None-Match header names etag
+// (or is "*").
+func etagListed(header, etag string) bool {
+	for _, t := range strings.Split(header, ",") {
+		if t = strings.TrimSpace(t); t == "*" || t == etag {
+			return true
 		}
-		if utf8.RuneCountInString(req.Phone) > 40 {
-			return c.JSON(http.StatusBadRequest, map[string]string{"error": "phone too long"})
+	}
+	return false
+}
+
+// checkIfMatch enforces optimistic concurrency on a write: If-Match must name
+// the customer's current ETag. Otherwise the response is written here (428
+// without the header, 412 with the current representation when the customer
+// has changed) and ok is false.
+func checkIfMatch(c echo.Context, cu CustomerDTO) (ok bool, err error) {
+	h := c.Request().Header.Get("If-Match")
+	if h == "" {
+		return false, c.JSON(http.StatusPreconditionRequired, map[string]string{"error": "If-Match header required"})
+	}
+	if !etagListed(h, customerETag(cu)) {
+		return false, customerPreconditionFailed(c, cu)
+	}
+	return true, nil
+}
+
+func customerPreconditionFailed(c echo.Context, cu CustomerDTO) error {
+	c.Response().Header().Set("ETag", customerETag(cu))
+	return c.JSON(http.StatusPreconditionFailed, map[string]any{
+		"error":   "customer was changed by someone else",
+		"current": cu,
+	})
+}
+
+// GetCustomer returns one customer with its ETag (304 for a matching If-None-Match).
+func GetCustomer(db *sqlx.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		orgID, err := middlewarex.OrgIDFromCtx(c)
+		if err != nil {
+			return c.JSON(http.StatusForbidden, map[string]string{"error": "no active organization"})
+		}
+		cu, err := loadCustomer(db, orgID, c.Param("id"))
+		if err != nil {
+			return customerAccessError(c, db, err)
+		}
+		etag := customerETag(cu)
+		c.Response().Header().Set("ETag", etag)
+		if h := c.Request().Header.Get("If-None-Match"); h != "" && etagListed(h, etag) {
+			return c.NoContent(http.StatusNotModified)
+		}
+		return c.JSON(http.StatusOK, cu)
+	}
+}
+
+// UpdateCustomer handles PUT (replace: the body is validated like a create,
+// omitted optional fields are cleared) and PATCH (only the fields present
+// change). Both go through the same normalization and sanitization as
+// CreateCustomer, and need If-Match with the customer's current ETag.
+func UpdateCustomer(db *sqlx.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		uid, err := middlewarex.UserIDFromCtx(c)
+		if err != nil {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		}
+		orgID, err := middlewarex.OrgIDFromCtx(c)
+		if err != nil {
+			return c.JSON(http.StatusForbidden, map[string]string{"error": "no active organization"})
+		}
+		cur, err := loadCustomer(db, orgID, c.Param("id"))
+		if err != nil {
+			return customerAccessError(c, db, err)
+		}
+		if ok, err := checkIfMatch(c, cur); !ok {
+			return err
+		}
+
+		var req CreateCustomerRequest
+		keepNotes := false
+		if c.Request().Method == http.MethodPatch {
+			var p PatchCustomerRequest
+			if err := c.Bind(&p); err != nil {
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid json"})
+			}
+			req, keepNotes = mergeCustomerPatch(cur, p)
+		} else if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid json"})
 		}
 
-		// Light normalization for notes before sanitation
-		notes := strings.ReplaceAll(req.Notes, "\r\n", "\n")
-		notes = strings.ReplaceAll(notes, "\x00", "")
-		notes = strings.TrimSpace(notes)
+		in, msg := normalizeCustomer(req)
+		if msg != "" {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": msg})
+		}
+		if keepNotes {
+			in.Notes = deref(cur.Notes)
+		}
 
-		// Server-side sanitation: removes all HTML/JS and leaves plain text
-		safeNotes := notesPolicy.Sanitize(notes)
+		if taken, err := customerEmailTaken(db, orgID, in.Email, cur.ID); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		} else if taken {
+			return c.JSON(http.StatusConflict, map[string]string{"error": "a customer with this email already exists"})
+		}
 
-		// Unescape entities to regular text characters (", ', &) - safe because there are no more tags/JS
-		safeNotes = html.UnescapeString(safeNotes)
+		before, after := customerFields(cur), services.CustomerFields(in)
+		if err := writeCustomerVersion(db, orgID, cur, services.CustomerActionUpdate, uid, &after, nil); err != nil {
+			if errors.Is(err, errCustomerChanged) {
+				return customerChangedMeanwhile(c, db, orgID)
+			}
+			return c.JSON(http.StatusConflict, map[string]string{"error": "could not update customer"})
+		}
 
-		// Length limit after filtering
-		if utf8.RuneCountInString(safeNotes) > 10000 {
-			safeNotes = string([]rune(safeNotes)[:10000])
+		if diff := services.DiffCustomer(&before, &after); len(diff) > 0 {
+			middlewarex.Audit(c, db, services.AuditEvent{
+				Type: services.AuditCustomerUpdated, OrgID: orgID,
+				SubjectType: "customer", SubjectID: strconv.FormatInt(cur.ID, 10),
+				Details: map[string]any{"fields": services.ChangedFields(diff)},
+			})
 		}
 
-		// Save to DB with a parameterized query
-		res, err := db.Exec(`
-			INSERT INTO customers (name, email, phone, notes)
-			VALUES (?, ?, ?, ?)`,
-			req.Name, req.Email, req.Phone, safeNotes,
-		)
+		updated, err := loadCustomer(db, orgID, c.Param("id"))
 		if err != nil {
-			return c.JSON(http.StatusConflict, map[string]string{"error": "could not create customer"})
+			return customerLookupError(c, err)
 		}
+		c.Response().Header().Set("ETag", customerETag(updated))
+		return c.JSON(http.StatusOK, updated)
+	}
+}
+
+// DeleteCustomer moves a customer to the trash; like updates, it needs
+// If-Match. It is gone from search and reads at once, an admin can restore it
+// until services.CustomerTrashRetention has passed, and then it is purged.
+func DeleteCustomer(db *sqlx.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		uid, err := middlewarex.UserIDFromCtx(c)
+		if err != nil {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		}
+		orgID, err := middlewarex.OrgIDFromCtx(c)
+		if err != nil {
+			return c.JSON(http.StatusForbidden, map[string]string{"error": "no active organization"})
+		}
+		cu, err := loadCustomer(db, orgID, c.Param("id"))
+		if err != nil {
+			return customerAccessError(c, db, err)
+		}
+		if ok, err := checkIfMatch(c, cu); !ok {
+			return err
+		}
+		if err := writeCustomerVersion(db, orgID, cu, services.CustomerActionDelete, uid, nil, nil); err != nil {
+			if errors.Is(err, errCustomerChanged) {
+				return customerChangedMeanwhile(c, db, orgID)
+			}
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+		middlewarex.Audit(c, db, services.AuditEvent{
+			Type: services.AuditCustomerDeleted, OrgID: orgID,
+			SubjectType: "customer", SubjectID: strconv.FormatInt(cu.ID, 10),
+		})
+		return c.JSON(http.StatusOK, map[string]string{"message": "customer deleted"})
+	}
+}
+
+// customerEmailTaken reports whether another live customer of the
+// organization (not exceptID) has email; trashed customers do not count.
+func customerEmailTaken(db sqlx.Queryer, orgID int64, email string, exceptID int64) (bool, error) {
+	var n int
+	err := sqlx.Get(db, &n, `
+		SELECT COUNT(*) FROM customers WHERE org_id = ? AND email = ? AND id <> ? AND deleted_at IS NULL
+	`, orgID, email, exceptID)
+	return n > 0, err
+}
+
+var errCustomerChanged = errors.New("customer changed")
+
+// writeCustomerVersion stores the next version of cur: after is the new state
+// (nil for a delete, which moves the customer to the trash; an undelete takes
+// it out again). The row only changes if it is still at cur.Version
+// (errCustomerChanged otherwise), and the history entry is written in the same
+// transaction.
+func writeCustomerVersion(db *sqlx.DB, orgID int64, cur CustomerDTO, action string, actorID int64,
+	after *services.CustomerFields, details map[string]any) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	before := customerFields(cur)
+	var res sql.Result
+	switch action {
+	case services.CustomerActionDelete:
+		res, err = tx.Exec(`
+			UPDATE customers SET deleted_at = NOW(), version = version + 1
+			WHERE id = ? AND org_id = ? AND version = ? AND deleted_at IS NULL`,
+			cur.ID, orgID, cur.Version,
+		)
+	case services.CustomerActionUndelete:
+		res, err = tx.Exec(`
+			UPDATE customers SET deleted_at = NULL, version = version + 1
+			WHERE id = ? AND org_id = ? AND version = ? AND deleted_at IS NOT NULL`,
+			cur.ID, orgID, cur.Version,
+		)
+	default:
+		res, err = tx.Exec(`
+			UPDATE customers SET name = ?, email = ?, phone = ?, notes = ?, tags = ?, version = version + 1
+			WHERE id = ? AND org_id = ? AND version = ? AND deleted_at IS NULL`,
+			after.Name, after.Email, after.Phone, after.Notes, after.Tags, cur.ID, orgID, cur.Version,
+		)
+	}
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errCustomerChanged
+	}
+	from, to := &before, after
+	if action == services.CustomerActionUndelete {
+		from, to = nil, &before // back from the trash in the state it was deleted in
+	}
+	if err := services.RecordCustomerVersion(tx, orgID, cur.ID, cur.Version+1, action, actorID, from, to, details); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func customerFields(cu CustomerDTO) services.CustomerFields {
+	return services.CustomerFields{Name: cu.Name, Email: cu.Email, Phone: deref(cu.Phone), Notes: deref(cu.Notes), Tags: cu.Tags}
+}
+
+// customerChangedMeanwhile answers a write whose version check failed in the
+// database: 412 with the current state, or 404 if the customer is gone.
+func customerChangedMeanwhile(c echo.Context, db *sqlx.DB, orgID int64) error {
+	cu, err := loadCustomer(db, orgID, c.Param("id"))
+	if err != nil {
+		return customerLookupError(c, err)
+	}
+	return customerPreconditionFailed(c, cu)
+}
 
-		id, _ := res.LastInsertId()
-		return c.JSON(http.StatusCreated, CreateCustomerResponse{ID: id, Name: req.Name})
+func deref(s *string) string {
+	if s == nil {
+		return ""
 	}
+	return *s
 }
@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	middlewarex "secure-communication-ltd/backend/internal/middleware"
+	"secure-communication-ltd/backend/internal/services"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo/v4"
+)
+
+type customerHistoryRow struct {
+	Version       int64          `db:"version"`
+	Action        string         `db:"action"`
+	ActorUserID   sql.NullInt64  `db:"actor_user_id"`
+	ActorUsername sql.NullString `db:"actor_username"`
+	ChangedAt     time.Time      `db:"changed_at"`
+	Changes       string         `db:"changes"`
+	Snapshot      string         `db:"snapshot"`
+	Details       sql.NullString `db:"details"`
+}
+
+type customerHistoryDTO struct {
+	Version       int64           `json:"version"`
+	Action        string          `json:"action"`
+	ActorUserID   *int64          `json:"actor_user_id"`
+	ActorUsername *string         `json:"actor_username"`
+	ChangedAt     time.Time       `json:"changed_at"`
+	Changes       json.RawMessage `json:"changes"`
+	Snapshot      json.RawMessage `json:"snapshot"`
+	Details       json.RawMessage `json:"details,omitempty"`
+}
+
+const customerHistorySelect = `
+	SELECT h.version, h.action, h.actor_user_id, u.username AS actor_username, h.changed_at,
+	       h.changes, h.snapshot, h.details
+	FROM customer_history h
+	LEFT JOIN users u ON u.id = h.actor_user_id`
+
+// ListCustomerHistory returns every version of a customer, newest first, with
+// who changed what. It also works for deleted customers.
+func ListCustomerHistory(db *sqlx.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		orgID, err := middlewarex.OrgIDFromCtx(c)
+		if err != nil {
+			return c.JSON(http.StatusForbidden, map[string]string{"error": "no active organization"})
+		}
+		id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil || id <= 0 {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "customer not found"})
+		}
+
+		var rows []customerHistoryRow
+		if err := db.Select(&rows, customerHistorySelect+`
+			WHERE h.org_id = ? AND h.customer_id = ?
+			ORDER BY h.version DESC
+		`, orgID, id); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+		if len(rows) == 0 {
+			if _, err := loadCustomer(db, orgID, c.Param("id")); err != nil {
+				return customerAccessError(c, db, err)
+			}
+		}
+
+		items := make([]customerHistoryDTO, 0, len(rows))
+		for _, r := range rows {
+			items = append(items, r.dto())
+		}
+		return c.JSON(http.StatusOK, map[string]any{"customer_id": id, "items": items})
+	}
+}
+
+func (r customerHistoryRow) dto() customerHistoryDTO {
+	d := customerHistoryDTO{
+		Version:   r.Version,
+		Action:    r.Action,
+		ChangedAt: r.ChangedAt,
+		Changes:   json.RawMessage(r.Changes),
+		Snapshot:  json.RawMessage(r.Snapshot),
+	}
+	if r.ActorUserID.Valid {
+		d.ActorUserID = &r.ActorUserID.Int64
+	}
+	if r.ActorUsername.Valid {
+		d.ActorUsername = &r.ActorUsername.String
+	}
+	if r.Details.Valid {
+		d.Details = json.RawMessage(r.Details.String)
+	}
+	return d
+}
+
+// RestoreCustomerVersion makes the state of an earlier version current again,
+// as a new version. A customer that still exists needs If-Match like any
+// update. One in the trash must be taken out of it first (409); one deleted
+// before the trash existed is recreated under its old ID.
+func RestoreCustomerVersion(db *sqlx.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		uid, err := middlewarex.UserIDFromCtx(c)
+		if err != nil {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		}
+		orgID, err := middlewarex.OrgIDFromCtx(c)
+		if err != nil {
+			return c.JSON(http.StatusForbidden, map[string]string{"error": "no active organization"})
+		}
+		id, err1 := strconv.ParseInt(c.Param("id"), 10, 64)
+		version, err2 := strconv.ParseInt(c.Param("version"), 10, 64)
+		if err1 != nil || err2 != nil {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "version not found"})
+		}
+
+		var h customerHistoryRow
+		err = db.Get(&h, customerHistorySelect+`
+			WHERE h.org_id = ? AND h.customer_id = ? AND h.version = ?
+		`, orgID, id, version)
+		if errors.Is(err, sql.ErrNoRows) {
+			recordForeignCustomerAccess(c, db)
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "version not found"})
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+		if h.Action == services.CustomerActionDelete {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "a deletion cannot be restored; pick an earlier version"})
+		}
+		var target services.CustomerFields
+		if err := json.Unmarshal([]byte(h.Snapshot), &target); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "history error"})
+		}
+		if taken, err := customerEmailTaken(db, orgID, target.Email, id); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		} else if taken {
+			return c.JSON(http.StatusConflict, map[string]string{"error": "a customer with this email already exists"})
+		}
+		details := map[string]any{"restored_from": version}
+
+		cur, err := loadCustomer(db, orgID, c.Param("id"))
+		switch {
+		case err == nil:
+			if ok, err := checkIfMatch(c, cur); !ok {
+				return err
+			}
+			err = writeCustomerVersion(db, orgID, cur, services.CustomerActionRestore, uid, &target, details)
+			if errors.Is(err, errCustomerChanged) {
+				return customerChangedMeanwhile(c, db, orgID)
+			}
+		case errors.Is(err, sql.ErrNoRows):
+			if _, terr := loadTrashedCustomer(db, orgID, c.Param("id")); terr == nil {
+				return c.JSON(http.StatusConflict, map[string]string{"error": "customer is in the trash; restore it from there first"})
+			} else if !errors.Is(terr, sql.ErrNoRows) {
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+			}
+			err = recreateCustomer(db, orgID, id, uid, target, details)
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "could not restore customer"})
+		}
+
+		middlewarex.Audit(c, db, services.AuditEvent{
+			Type: services.AuditCustomerRestored, OrgID: orgID,
+			SubjectType: "customer", SubjectID: strconv.FormatInt(id, 10),
+			Details: details,
+		})
+		restored, err := loadCustomer(db, orgID, c.Param("id"))
+		if err != nil {
+			return customerLookupError(c, err)
+		}
+		c.Response().Header().Set("ETag", customerETag(restored))
+		return c.JSON(http.StatusOK, restored)
+	}
+}
+
+// recreateCustomer inserts a deleted customer again under its old ID, as the
+// version after its last history entry.
+func recreateCustomer(db *sqlx.DB, orgID, id, actorID int64, f services.CustomerFields, details map[string]any) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var last int64
+	if err := tx.Get(&last, `
+		SELECT COALESCE(MAX(version), 0) FROM customer_history WHERE customer_id = ? FOR UPDATE
+	`, id); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`
+		INSERT INTO customers (id, org_id, name, email, phone, notes, tags, version)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		id, orgID, f.Name, f.Email, f.Phone, f.Notes, f.Tags, last+1,
+	); err != nil {
+		return err
+	}
+	if err := services.RecordCustomerVersion(tx, orgID, id, last+1, services.CustomerActionRestore, actorID, nil, &f, details); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"secure-communication-ltd/backend/internal/services"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+var customerHistoryColumns = []string{"version", "action", "actor_user_id", "actor_username", "changed_at",
+	"changes", "snapshot", "details"}
+
+const restoredSnapshot = `{"name":"Acme","email":"ops@acme.example","phone":"","notes":"","tags":["vip"]}`
+
+// expectHistoryEntry expects the lookup of version 2 of customer 42.
+func expectHistoryEntry(mock sqlmock.Sqlmock, action string) {
+	mock.ExpectQuery(`FROM customer_history h\s+LEFT JOIN users u ON u.id = h.actor_user_id\s+WHERE h.org_id = \? AND h.customer_id = \? AND h.version = \?`).
+		WithArgs(int64(1), int64(42), int64(2)).
+		WillReturnRows(sqlmock.NewRows(customerHistoryColumns).
+			AddRow(2, action, 7, "alice", time.Now(), `{}`, restoredSnapshot, nil))
+}
+
+func TestListCustomerHistory(t *testing.T) {
+	db, mock := newMockDB(t)
+	mock.ExpectQuery(`WHERE h.org_id = \? AND h.customer_id = \?\s+ORDER BY h.version DESC`).WithArgs(int64(1), int64(42)).
+		WillReturnRows(sqlmock.NewRows(customerHistoryColumns).
+			AddRow(3, services.CustomerActionRestore, 7, "alice", time.Now(), `{"phone":{"old":"1","new":""}}`, restoredSnapshot, `{"restored_from":1}`).
+			AddRow(2, services.CustomerActionUpdate, 9, nil, time.Now(), `{"phone":{"old":"","new":"1"}}`, restoredSnapshot, nil).
+			AddRow(1, services.CustomerActionBaseline, nil, nil, time.Now(), `{}`, restoredSnapshot, nil))
+
+	c, rec := signedInContext(http.MethodGet, "/api/customers/:id/history", "/api/customers/42/history", "", 7, 1, "id", "42")
+	if err := ListCustomerHistory(db)(c); err != nil {
+		t.Fatal(err)
+	}
+	var got struct{ Items []map[string]any }
+	if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &got) != nil || len(got.Items) != 3 {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	if d, _ := got.Items[0]["details"].(map[string]any); d["restored_from"] != float64(1) {
+		t.Errorf("details not passed through: %v", got.Items[0])
+	}
+	// a deleted user's entries keep the ID; the baseline has no actor at all
+	if it := got.Items[1]; it["actor_user_id"] != float64(9) || it["actor_username"] != nil || it["details"] != nil {
+		t.Errorf("entry of a deleted user: %v", it)
+	}
+	if it := got.Items[2]; it["actor_user_id"] != nil || it["action"] != services.CustomerActionBaseline {
+		t.Errorf("baseline: %v", it)
+	}
+}
+
+// A customer without history that is not the organization's is a 404.
+func TestListCustomerHistoryUnknown(t *testing.T) {
+	db, mock := newMockDB(t)
+	mock.ExpectQuery(`FROM customer_history h`).WillReturnRows(sqlmock.NewRows(customerHistoryColumns))
+	mock.ExpectQuery(`FROM customers WHERE id = \? AND org_id = \?`).WillReturnRows(sqlmock.NewRows(customerColumnList))
+	mock.ExpectQuery(`SELECT org_id FROM customers WHERE id = \?`).WillReturnRows(sqlmock.NewRows([]string{"org_id"}))
+
+	c, rec := signedInContext(http.MethodGet, "/api/customers/:id/history", "/api/customers/42/history", "", 7, 1, "id", "42")
+	if err := ListCustomerHistory(db)(c); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("status %d, want 404", rec.Code)
+	}
+}
+
+// An existing customer gets the old state as its next version, guarded by If-Match.
+func TestRestoreCustomerVersion(t *testing.T) {
+	db, mock := newMockDB(t)
+	expectHistoryEntry(mock, services.CustomerActionUpdate)
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM customers WHERE org_id = \? AND email = \?`).
+		WithArgs(int64(1), "ops@acme.example", int64(42)).WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(0))
+	expectCustomer(mock, 3)
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE customers SET name = \?, email = \?, phone = \?, notes = \?, tags = \?`).
+		WithArgs("Acme", "ops@acme.example", "", "", `["vip"]`, int64(42), int64(1), int64(3)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO customer_history`).
+		WithArgs(int64(1), int64(42), int64(4), services.CustomerActionRestore, int64(7), sqlmock.AnyArg(), restoredSnapshot, `{"restored_from":2}`).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	expectAudit(t, mock, services.AuditCustomerRestored, services.AuditSuccess)
+	expectCustomer(mock, 4)
+
+	c, rec := signedInContext(http.MethodPost, "/api/customers/:id/history/:version/restore",
+		"/api/customers/42/history/2/restore", "", 7, 1, "id", "42", "version", "2")
+	c.Request().Header.Set("If-Match", `"3"`)
+	if err := RestoreCustomerVersion(db)(c); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") != `"4"` {
+		t.Fatalf("status %d, ETag %s: %s", rec.Code, rec.Header().Get("ETag"), rec.Body)
+	}
+}
+
+// A customer deleted before the trash existed comes back under its old ID.
+func TestRestoreCustomerVersionRecreates(t *testing.T) {
+	db, mock := newMockDB(t)
+	expectHistoryEntry(mock, services.CustomerActionUpdate)
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM customers WHERE org_id = \? AND email = \?`).
+		WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(0))
+	mock.ExpectQuery(`FROM customers WHERE id = \? AND org_id = \? AND deleted_at IS NULL`).
+		WillReturnRows(sqlmock.NewRows(customerColumnList))
+	mock.ExpectQuery(`WHERE c.id = \? AND c.org_id = \? AND c.deleted_at IS NOT NULL`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT COALESCE\(MAX\(version\), 0\) FROM customer_history WHERE customer_id = \? FOR UPDATE`).
+		WithArgs(int64(42)).WillReturnRows(sqlmock.NewRows([]string{"v"}).AddRow(5))
+	mock.ExpectExec(`INSERT INTO customers \(id, org_id, name, email, phone, notes, tags, version\)`).
+		WithArgs(int64(42), int64(1), "Acme", "ops@acme.example", "", "", `["vip"]`, int64(6)).
+		WillReturnResult(sqlmock.NewResult(42, 1))
+	mock.ExpectExec(`INSERT INTO customer_history`).
+		WithArgs(int64(1), int64(42), int64(6), services.CustomerActionRestore, int64(7), sqlmock.AnyArg(), restoredSnapshot, `{"restored_from":2}`).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	expectAudit(t, mock, services.AuditCustomerRestored, services.AuditSuccess)
+	expectCustomer(mock, 6)
+
+	// nothing to match against: no If-Match needed
+	c, rec := signedInContext(http.MethodPost, "/api/customers/:id/history/:version/restore",
+		"/api/customers/42/history/2/restore", "", 7, 1, "id", "42", "version", "2")
+	if err := RestoreCustomerVersion(db)(c); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") != `"6"` {
+		t.Fatalf("status %d, ETag %s: %s", rec.Code, rec.Header().Get("ETag"), rec.Body)
+	}
+}
+
+func TestRestoreCustomerVersionRefuses(t *testing.T) {
+	t.Run("a deletion", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		expectHistoryEntry(mock, services.CustomerActionDelete)
+
+		c, rec := signedInContext(http.MethodPost, "/api/customers/:id/history/:version/restore",
+			"/api/customers/42/history/2/restore", "", 7, 1, "id", "42", "version", "2")
+		if err := RestoreCustomerVersion(db)(c); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("status %d, want 400", rec.Code)
+		}
+	})
+	t.Run("email taken", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		expectHistoryEntry(mock, services.CustomerActionUpdate)
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM customers WHERE org_id = \? AND email = \?`).
+			WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(1))
+
+		c, rec := signedInContext(http.MethodPost, "/api/customers/:id/history/:version/restore",
+			"/api/customers/42/history/2/restore", "", 7, 1, "id", "42", "version", "2")
+		if err := RestoreCustomerVersion(db)(c); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != http.StatusConflict {
+			t.Fatalf("status %d, want 409", rec.Code)
+		}
+	})
+	t.Run("in the trash", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		expectHistoryEntry(mock, services.CustomerActionUpdate)
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM customers WHERE org_id = \? AND email = \?`).
+			WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(0))
+		mock.ExpectQuery(`FROM customers WHERE id = \? AND org_id = \? AND deleted_at IS NULL`).
+			WillReturnRows(sqlmock.NewRows(customerColumnList))
+		mock.ExpectQuery(`WHERE c.id = \? AND c.org_id = \? AND c.deleted_at IS NOT NULL`).
+			WillReturnRows(sqlmock.NewRows(append(customerColumnList, "deleted_at", "deleted_by")).
+				AddRow(42, "Acme", "ops@acme.example", nil, nil, `[]`, "2026-01-02 03:04:05", 4, time.Now(), "alice"))
+
+		c, rec := signedInContext(http.MethodPost, "/api/customers/:id/history/:version/restore",
+			"/api/customers/42/history/2/restore", "", 7, 1, "id", "42", "version", "2")
+		if err := RestoreCustomerVersion(db)(c); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != http.StatusConflict {
+			t.Fatalf("status %d, want 409", rec.Code)
+		}
+	})
+}
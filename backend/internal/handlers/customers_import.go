@@ -0,0 +1,506 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	middlewarex "secure-communication-ltd/backend/internal/middleware"
+	"secure-communication-ltd/backend/internal/services"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo/v4"
+)
+
+// Import limits. Uploads over importSyncMaxBytes (or of unknown length) run
+// as a background job.
+const (
+	importMaxBytes     = 50 << 20
+	importSyncMaxBytes = 1 << 20
+	importMaxRows      = 50000
+	importMaxLineBytes = 64 << 10 // one JSON line
+	importProgressRows = 100      // rows between progress updates of a job
+)
+
+// Row outcomes of an import.
+const (
+	importCreated   = "created"
+	importUpdated   = "updated"
+	importUnchanged = "unchanged"
+	importRejected  = "rejected"
+)
+
+var importColumns = map[string]bool{"name": true, "email": true, "phone": true, "notes": true, "tags": true}
+
+// importAbort stops an import: the upload itself is unusable (bad header,
+// too many rows, ...). Rows already written stay.
+type importAbort struct{ msg string }
+
+func (e importAbort) Error() string { return e.msg }
+
+type importRowResult struct {
+	Row    int      `json:"row"` // 1 = first data row
+	Status string   `json:"status"`
+	ID     int64    `json:"id,omitempty"`
+	Email  string   `json:"email,omitempty"`
+	Fields []string `json:"fields,omitempty"` // changed fields of an update
+	Error  string   `json:"error,omitempty"`
+}
+
+// customerImport applies rows to the organization's customers, upserting on
+// email, and keeps the report.
+type customerImport struct {
+	db      *sqlx.DB
+	orgID   int64
+	actorID int64
+	dryRun  bool
+	created map[string]bool // emails a dry run would create, so repeats count as updates
+
+	counts services.ImportCounts
+	rows   []importRowResult
+}
+
+func newCustomerImport(db *sqlx.DB, orgID, actorID int64, dryRun bool) *customerImport {
+	return &customerImport{db: db, orgID: orgID, actorID: actorID, dryRun: dryRun, created: map[string]bool{}, rows: []importRowResult{}}
+}
+
+func (imp *customerImport) add(r importRowResult) {
+	imp.rows = append(imp.rows, r)
+	imp.counts.Processed++
+	switch r.Status {
+	case importCreated:
+		imp.counts.Created++
+	case importUpdated:
+		imp.counts.Updated++
+	case importUnchanged:
+		imp.counts.Unchanged++
+	default:
+		imp.counts.Rejected++
+	}
+}
+
+// apply upserts one row. Fields missing from the row keep their stored value
+// on an update, exactly like PATCH; every value goes through normalizeCustomer.
+func (imp *customerImport) apply(row int, p PatchCustomerRequest) importRowResult {
+	r := importRowResult{Row: row, Status: importRejected}
+	if p.Email == nil || strings.TrimSpace(*p.Email) == "" {
+		r.Error = "email is required"
+		return r
+	}
+	r.Email = strings.TrimSpace(*p.Email)
+
+	var cur CustomerDTO
+	err := imp.db.Get(&cur, `
+		SELECT `+customerColumns+` FROM customers WHERE org_id = ? AND email = ? AND deleted_at IS NULL
+	`, imp.orgID, r.Email)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		r.Error = "db error"
+		return r
+	}
+
+	if errors.Is(err, sql.ErrNoRows) {
+		key := strings.ToLower(r.Email)
+		req, _ := mergeCustomerPatch(CustomerDTO{}, p)
+		in, msg := normalizeCustomer(req)
+		switch {
+		case msg != "":
+			r.Error = msg
+		case imp.dryRun && imp.created[key]:
+			r.Status = importUpdated
+		case imp.dryRun:
+			imp.created[key] = true
+			r.Status = importCreated
+		default:
+			id, err := insertCustomer(imp.db, imp.orgID, imp.actorID, in)
+			if errors.Is(err, errCustomerNotCreated) {
+				r.Error = "could not create customer"
+			} else if err != nil {
+				r.Error = "db error"
+			} else {
+				r.Status, r.ID = importCreated, id
+			}
+		}
+		return r
+	}
+
+	r.ID = cur.ID
+	req, keepNotes := mergeCustomerPatch(cur, p)
+	in, msg := normalizeCustomer(req)
+	if msg != "" {
+		r.Error = msg
+		return r
+	}
+	if keepNotes {
+		in.Notes = deref(cur.Notes)
+	}
+	before, after := customerFields(cur), services.CustomerFields(in)
+	diff := services.DiffCustomer(&before, &after)
+	if len(diff) == 0 {
+		r.Status = importUnchanged
+		return r
+	}
+	if !imp.dryRun {
+		err := writeCustomerVersion(imp.db, imp.orgID, cur, services.CustomerActionUpdate, imp.actorID, &after, nil)
+		if errors.Is(err, errCustomerChanged) {
+			r.Error = "customer changed during the import"
+			return r
+		}
+		if err != nil {
+			r.Error = "could not update customer"
+			return r
+		}
+	}
+	r.Status, r.Fields = importUpdated, services.ChangedFields(diff)
+	return r
+}
+
+// run streams rows from body and applies them; progress, if set, is called
+// every importProgressRows rows.
+func (imp *customerImport) run(body io.Reader, format string, progress func()) error {
+	each := func(row int, p PatchCustomerRequest, rowErr string) error {
+		if row > importMaxRows {
+			return importAbort{fmt.Sprintf("more than %d rows", importMaxRows)}
+		}
+		if rowErr != "" {
+			imp.add(importRowResult{Row: row, Status: importRejected, Error: rowErr})
+		} else {
+			imp.add(imp.apply(row, p))
+		}
+		if progress != nil && row%importProgressRows == 0 {
+			progress()
+		}
+		return nil
+	}
+	if format == "csv" {
+		return readCustomerCSV(body, each)
+	}
+	return readCustomerJSONL(body, each)
+}
+
+func (imp *customerImport) summary() map[string]any {
+	return map[string]any{
+		"dry_run": imp.dryRun,
+		"counts":  imp.counts,
+		"rows":    imp.rows,
+	}
+}
+
+// readCustomerCSV reads a CSV upload with a header row naming the columns
+// (name, email, phone, notes, tags; tags separated by ';'). Columns that are
+// missing keep the stored values; an empty cell clears the field.
+func readCustomerCSV(body io.Reader, each func(int, PatchCustomerRequest, string) error) error {
+	r := csv.NewReader(body)
+	r.ReuseRecord = true
+	header, err := r.Read()
+	if err == io.EOF {
+		return importAbort{"empty file"}
+	}
+	if err != nil {
+		return importAbort{"invalid header: " + err.Error()}
+	}
+	cols := make([]string, len(header))
+	seen := map[string]bool{}
+	for i, h := range header {
+		h = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))
+		if !importColumns[h] || seen[h] {
+			return importAbort{fmt.Sprintf("invalid header: unknown or repeated column %q", h)}
+		}
+		seen[h] = true
+		cols[i] = h
+	}
+	if !seen["email"] {
+		return importAbort{"invalid header: an email column is required"}
+	}
+
+	for row := 1; ; row++ {
+		rec, err := r.Read()
+		if err == io.EOF {
+			return nil
+		}
+		var pe *csv.ParseError
+		if errors.As(err, &pe) {
+			if err := each(row, PatchCustomerRequest{}, "invalid csv: "+pe.Err.Error()); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		var p PatchCustomerRequest
+		for i, v := range rec {
+			switch cols[i] {
+			case "name":
+				p.Name = &v
+			case "email":
+				p.Email = &v
+			case "phone":
+				p.Phone = &v
+			case "notes":
+				p.Notes = &v
+			case "tags":
+				tags := []string{}
+				if strings.TrimSpace(v) != "" {
+					tags = strings.Split(v, ";")
+				}
+				p.Tags = &tags
+			}
+		}
+		if err := each(row, p, ""); err != nil {
+			return err
+		}
+	}
+}
+
+// readCustomerJSONL reads one JSON object per line, with the fields of
+// PATCH /api/customers/:id. Blank lines are skipped.
+func readCustomerJSONL(body io.Reader, each func(int, PatchCustomerRequest, string) error) error {
+	sc := bufio.NewScanner(body)
+	sc.Buffer(make([]byte, 0, 4096), importMaxLineBytes)
+	row := 0
+	for sc.Scan() {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		row++
+		var p PatchCustomerRequest
+		dec := json.NewDecoder(bytes.NewReader(line))
+		dec.DisallowUnknownFields()
+		rowErr := ""
+		if err := dec.Decode(&p); err != nil || dec.More() {
+			rowErr = "invalid json"
+		}
+		if err := each(row, p, rowErr); err != nil {
+			return err
+		}
+	}
+	if errors.Is(sc.Err(), bufio.ErrTooLong) {
+		return importAbort{fmt.Sprintf("row %d: line longer than %d bytes", row+1, importMaxLineBytes)}
+	}
+	return sc.Err()
+}
+
+// importFormat picks the format from ?format= or the Content-Type.
+func importFormat(c echo.Context) (string, bool) {
+	switch f := c.QueryParam("format"); f {
+	case "csv", "jsonl":
+		return f, true
+	case "":
+	default:
+		return "", false
+	}
+	ct := strings.ToLower(c.Request().Header.Get(echo.HeaderContentType))
+	switch {
+	case strings.HasPrefix(ct, "text/csv"):
+		return "csv", true
+	case strings.HasPrefix(ct, "application/x-ndjson"), strings.HasPrefix(ct, "application/jsonl"),
+		strings.HasPrefix(ct, "application/x-jsonlines"):
+		return "jsonl", true
+	}
+	return "", false
+}
+
+// ImportCustomers bulk-creates and updates customers from the request body,
+// CSV or JSON lines, upserting on email. Each row gets the validation and
+// sanitization of a single write; rejected rows do not stop the import.
+// dry_run=true reports what would happen without writing. Small uploads
+// answer with the report right away; larger ones (or async=true) are spooled
+// to a temporary file and run as a job polled at GET /api/customers/import/:id.
+func ImportCustomers(db *sqlx.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		claims, err := middlewarex.ClaimsFromCtx(c)
+		if err != nil {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		}
+		orgID, err := middlewarex.OrgIDFromCtx(c)
+		if err != nil {
+			return c.JSON(http.StatusForbidden, map[string]string{"error": "no active organization"})
+		}
+		format, ok := importFormat(c)
+		if !ok {
+			return c.JSON(http.StatusUnsupportedMediaType, map[string]string{"error": "send text/csv or application/x-ndjson (or ?format=csv|jsonl)"})
+		}
+		dryRun := c.QueryParam("dry_run") == "true"
+		body := http.MaxBytesReader(c.Response(), c.Request().Body, importMaxBytes)
+		imp := newCustomerImport(db, orgID, claims.UserID, dryRun)
+
+		// The job may outlive the request, so the audit event is filled in now
+		ev := services.AuditEvent{
+			Type: services.AuditCustomersImported, ActorUserID: claims.UserID, ActorLabel: claims.Username,
+			OrgID: orgID, IP: c.RealIP(), UserAgent: c.Request().UserAgent(),
+			RequestID: c.Response().Header().Get(echo.HeaderXRequestID),
+		}
+
+		length := c.Request().ContentLength
+		if c.QueryParam("async") != "true" && length >= 0 && length <= importSyncMaxBytes {
+			err := imp.run(body, format, nil)
+			var abort importAbort
+			var tooBig *http.MaxBytesError
+			switch {
+			case errors.As(err, &abort):
+				return c.JSON(http.StatusBadRequest, map[string]any{"error": abort.msg, "counts": imp.counts, "rows": imp.rows})
+			case errors.As(err, &tooBig):
+				return c.JSON(http.StatusRequestEntityTooLarge, map[string]string{"error": "upload too large"})
+			case err != nil:
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": "could not read upload"})
+			}
+			if !dryRun {
+				ev.Details = map[string]any{"format": format, "counts": imp.counts}
+				middlewarex.Audit(c, db, ev)
+			}
+			return c.JSON(http.StatusOK, imp.summary())
+		}
+
+		// Spool the upload, so the job does not depend on the request
+		f, err := os.CreateTemp("", "customer-import-*")
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "could not store upload"})
+		}
+		n, err := io.Copy(f, body)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(f.Name())
+			var tooBig *http.MaxBytesError
+			if errors.As(err, &tooBig) {
+				return c.JSON(http.StatusRequestEntityTooLarge, map[string]string{"error": "upload too large"})
+			}
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "could not read upload"})
+		}
+		jobID, err := services.CreateImportJob(db, orgID, claims.UserID, format, dryRun, n)
+		if err != nil {
+			os.Remove(f.Name())
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+		go runCustomerImportJob(db, jobID, f.Name(), format, imp, ev)
+
+		loc := "/api/customers/import/" + strconv.FormatInt(jobID, 10)
+		c.Response().Header().Set(echo.HeaderLocation, loc)
+		return c.JSON(http.StatusAccepted, map[string]any{"job_id": jobID, "status": services.ImportQueued, "status_url": loc})
+	}
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+// runCustomerImportJob runs a spooled import and removes the file afterwards.
+func runCustomerImportJob(db *sqlx.DB, jobID int64, path, format string, imp *customerImport, ev services.AuditEvent) {
+	defer os.Remove(path)
+	fail := func(msg string) {
+		if err := services.FailImportJob(db, jobID, imp.counts, msg); err != nil {
+			log.Printf("[customer-import] job %d: %v", jobID, err)
+		}
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		fail("could not read upload")
+		return
+	}
+	defer f.Close()
+	if err := services.StartImportJob(db, jobID); err != nil {
+		log.Printf("[customer-import] job %d: %v", jobID, err)
+	}
+
+	cr := &countingReader{r: f}
+	err = imp.run(cr, format, func() {
+		if err := services.UpdateImportProgress(db, jobID, imp.counts, cr.n); err != nil {
+			log.Printf("[customer-import] job %d: %v", jobID, err)
+		}
+	})
+	var abort importAbort
+	switch {
+	case errors.As(err, &abort):
+		fail(abort.msg)
+	case err != nil:
+		fail("could not read upload")
+	default:
+		if err := services.FinishImportJob(db, jobID, imp.counts, imp.rows); err != nil {
+			log.Printf("[customer-import] job %d: %v", jobID, err)
+		}
+	}
+	if !imp.dryRun {
+		ev.SubjectType, ev.SubjectID = "customer_import", strconv.FormatInt(jobID, 10)
+		ev.Details = map[string]any{"format": format, "counts": imp.counts}
+		if err != nil {
+			ev.Outcome = services.AuditFailure
+		}
+		if err := services.AppendAudit(db, ev); err != nil {
+			log.Printf("[audit] %s: %v", ev.Type, err)
+		}
+	}
+}
+
+type importJobDTO struct {
+	ID         int64           `db:"id" json:"id"`
+	Status     string          `db:"status" json:"status"`
+	Format     string          `db:"format" json:"format"`
+	DryRun     bool            `db:"dry_run" json:"dry_run"`
+	BytesTotal int64           `db:"bytes_total" json:"bytes_total"`
+	BytesRead  int64           `db:"bytes_read" json:"bytes_read"`
+	Error      *string         `db:"error" json:"error,omitempty"`
+	CreatedAt  time.Time       `db:"created_at" json:"created_at"`
+	StartedAt  *time.Time      `db:"started_at" json:"started_at"`
+	FinishedAt *time.Time      `db:"finished_at" json:"finished_at"`
+	Report     sql.NullString  `db:"report" json:"-"`
+	Rows       json.RawMessage `db:"-" json:"rows,omitempty"` // the per-row report, once done
+	services.ImportCounts
+}
+
+// GetCustomerImport reports the progress of an import job the user started in
+// the active organization, and the per-row report once it is done. Other
+// users' jobs are not found: their reports list customer emails.
+func GetCustomerImport(db *sqlx.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		claims, err := middlewarex.ClaimsFromCtx(c)
+		if err != nil {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		}
+		orgID, err := middlewarex.OrgIDFromCtx(c)
+		if err != nil {
+			return c.JSON(http.StatusForbidden, map[string]string{"error": "no active organization"})
+		}
+		id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "import not found"})
+		}
+		var job importJobDTO
+		err = db.Get(&job, `
+			SELECT id, status, format, dry_run, bytes_total, bytes_read, error, created_at, started_at, finished_at,
+			       report, rows_processed, created_count, updated_count, unchanged_count, rejected_count
+			FROM customer_import_jobs
+			WHERE id = ? AND org_id = ? AND user_id = ?
+		`, id, orgID, claims.UserID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "import not found"})
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+		if job.Report.Valid {
+			job.Rows = json.RawMessage(job.Report.String)
+		}
+		return c.JSON(http.StatusOK, job)
+	}
+}
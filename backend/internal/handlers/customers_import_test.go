@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"secure-communication-ltd/backend/internal/services"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// importedRow is what readCustomerCSV / readCustomerJSONL hand to each,
+// with pointers flattened ("<nil>" = column absent).
+type importedRow struct {
+	Row                       int
+	Name, Email, Phone, Notes string
+	Tags                      string
+	Err                       string
+}
+
+func flattenImport(row int, p PatchCustomerRequest, rowErr string) importedRow {
+	s := func(v *string) string {
+		if v == nil {
+			return "<nil>"
+		}
+		return *v
+	}
+	r := importedRow{Row: row, Name: s(p.Name), Email: s(p.Email), Phone: s(p.Phone), Notes: s(p.Notes),
+		Tags: "<nil>", Err: rowErr}
+	if p.Tags != nil {
+		r.Tags = strings.Join(*p.Tags, "|")
+	}
+	return r
+}
+
+func readImport(format, body string) ([]importedRow, error) {
+	var rows []importedRow
+	each := func(row int, p PatchCustomerRequest, rowErr string) error {
+		rows = append(rows, flattenImport(row, p, rowErr))
+		return nil
+	}
+	var err error
+	if format == "csv" {
+		err = readCustomerCSV(strings.NewReader(body), each)
+	} else {
+		err = readCustomerJSONL(strings.NewReader(body), each)
+	}
+	return rows, err
+}
+
+func TestReadCustomerCSV(t *testing.T) {
+	rows, err := readImport("csv", "\ufeffEmail, Name ,tags\n"+
+		"a@example.org,Alice,vip;eu\n"+
+		"b@example.org,,\n"+
+		"c@example.org,Carol\n"+ // wrong number of fields: rejected, the import goes on
+		`d@example.org,"Dave ""D"", Jr.",x`+"\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []importedRow{
+		{Row: 1, Name: "Alice", Email: "a@example.org", Phone: "<nil>", Notes: "<nil>", Tags: "vip|eu"},
+		// empty cells clear the field; absent columns keep it
+		{Row: 2, Name: "", Email: "b@example.org", Phone: "<nil>", Notes: "<nil>", Tags: ""},
+		{Row: 3, Name: "<nil>", Email: "<nil>", Phone: "<nil>", Notes: "<nil>", Tags: "<nil>",
+			Err: "invalid csv: wrong number of fields"},
+		{Row: 4, Name: `Dave "D", Jr.`, Email: "d@example.org", Phone: "<nil>", Notes: "<nil>", Tags: "x"},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Fatalf("rows:\n got %+v\nwant %+v", rows, want)
+	}
+}
+
+func TestReadCustomerCSVAborts(t *testing.T) {
+	for body, msg := range map[string]string{
+		"":                      "empty file",
+		"name,phone\nAlice,1\n": "an email column is required",
+		"email,company\nx,y\n":  `unknown or repeated column "company"`,
+		"email,EMAIL\nx,y\n":    `unknown or repeated column "email"`,
+		"email,\"name\nAlice\n": "invalid header",
+	} {
+		_, err := readImport("csv", body)
+		var abort importAbort
+		if !errors.As(err, &abort) || !strings.Contains(abort.msg, msg) {
+			t.Errorf("%q: err = %v, want abort %q", body, err, msg)
+		}
+	}
+}
+
+func TestReadCustomerJSONL(t *testing.T) {
+	rows, err := readImport("jsonl", `{"email":"a@example.org","tags":["vip"]}`+"\n"+
+		"\n   \n"+ // blank lines are not rows
+		`{"email":"b@example.org","password":"x"}`+"\n"+
+		`{"email":"c@example.org"} {"email":"d@example.org"}`+"\n"+
+		`not json`+"\n"+
+		`{"email":"e@example.org","notes":null}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []importedRow{
+		{Row: 1, Name: "<nil>", Email: "a@example.org", Phone: "<nil>", Notes: "<nil>", Tags: "vip"},
+		{Row: 2, Name: "<nil>", Email: "b@example.org", Phone: "<nil>", Notes: "<nil>", Tags: "<nil>", Err: "invalid json"},
+		{Row: 3, Name: "<nil>", Email: "c@example.org", Phone: "<nil>", Notes: "<nil>", Tags: "<nil>", Err: "invalid json"},
+		{Row: 4, Name: "<nil>", Email: "<nil>", Phone: "<nil>", Notes: "<nil>", Tags: "<nil>", Err: "invalid json"},
+		{Row: 5, Name: "<nil>", Email: "e@example.org", Phone: "<nil>", Notes: "<nil>", Tags: "<nil>"},
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Fatalf("rows:\n got %+v\nwant %+v", rows, want)
+	}
+
+	_, err = readImport("jsonl", `{"email":"a@example.org"}`+"\n"+strings.Repeat(" ", importMaxLineBytes+1)+"x\n")
+	var abort importAbort
+	if !errors.As(err, &abort) || !strings.Contains(abort.msg, "row 2: line longer than") {
+		t.Fatalf("err = %v, want an abort at row 2", err)
+	}
+}
+
+// A dry run writes nothing; an email seen twice counts as created, then updated.
+func TestCustomerImportDryRun(t *testing.T) {
+	db, mock := newMockDB(t)
+	for range 3 {
+		mock.ExpectQuery(`FROM customers WHERE org_id = \? AND email = \? AND deleted_at IS NULL`).
+			WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	}
+	imp := newCustomerImport(db, 1, 7, true)
+	err := imp.run(strings.NewReader("email,name\nnew@example.org,New\nNEW@example.org,Again\nbad,Bad\n,Nobody\n"), "csv", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := services.ImportCounts{Processed: 4, Created: 1, Updated: 1, Rejected: 2}
+	if imp.counts != want {
+		t.Fatalf("counts = %+v, want %+v", imp.counts, want)
+	}
+	if r := imp.rows[3]; r.Status != importRejected || r.Error != "email is required" {
+		t.Fatalf("row without email: %+v", r)
+	}
+}
+
+var importJobColumns = []string{
+	"id", "status", "format", "dry_run", "bytes_total", "bytes_read", "error", "created_at", "started_at", "finished_at",
+	"report", "rows_processed", "created_count", "updated_count", "unchanged_count", "rejected_count",
+}
+
+// Only the user who started an import sees it: the report lists customer emails.
+func TestGetCustomerImportIsScopedToItsCreator(t *testing.T) {
+	t.Run("creator", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(`FROM customer_import_jobs\s+WHERE id = \? AND org_id = \? AND user_id = \?`).
+			WithArgs(int64(3), int64(1), int64(7)).
+			WillReturnRows(sqlmock.NewRows(importJobColumns).AddRow(3, services.ImportDone, "csv", false, 100, 100, nil,
+				time.Now(), time.Now(), time.Now(), `[{"row":1,"status":"created","email":"a@example.org"}]`, 1, 1, 0, 0, 0))
+
+		c, rec := signedInContext(http.MethodGet, "/api/customers/import/:id", "/api/customers/import/3", "", 7, 1, "id", "3")
+		if err := GetCustomerImport(db)(c); err != nil {
+			t.Fatal(err)
+		}
+		var got struct {
+			Status string            `json:"status"`
+			Rows   []importRowResult `json:"rows"`
+		}
+		if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &got) != nil ||
+			got.Status != services.ImportDone || len(got.Rows) != 1 {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+		}
+	})
+	t.Run("another user", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(`FROM customer_import_jobs`).WithArgs(int64(3), int64(1), int64(8)).
+			WillReturnRows(sqlmock.NewRows(importJobColumns))
+
+		c, rec := signedInContext(http.MethodGet, "/api/customers/import/:id", "/api/customers/import/3", "", 8, 1, "id", "3")
+		if err := GetCustomerImport(db)(c); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != http.StatusNotFound {
+			t.Fatalf("status = %d, want 404", rec.Code)
+		}
+	})
+}
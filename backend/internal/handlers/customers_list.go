@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	middlewarex "secure-communication-ltd/backend/internal/middleware"
+	"secure-communication-ltd/backend/internal/services"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo/v4"
+)
+
+// Sort fields of the customer listing, and their default direction.
+var customerSorts = map[string]string{
+	"created_at": "desc",
+	"name":       "asc",
+	"email":      "asc",
+}
+
+// The approximate total counts at most this many customers.
+const customerTotalCap = 10000
+
+// customerCursor is the position after the last row of a page. Clients get it
+// base64url-encoded and must treat it as opaque.
+type customerCursor struct {
+	Sort  string `json:"s"`
+	Order string `json:"o"`
+	Value string `json:"v"` // sort column of the last row
+	ID    int64  `json:"id"`
+}
+
+func (cur customerCursor) encode() string {
+	b, _ := json.Marshal(cur)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCustomerCursor(s string) (customerCursor, bool) {
+	var cur customerCursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || json.Unmarshal(b, &cur) != nil || cur.ID <= 0 {
+		return cur, false
+	}
+	return cur, true
+}
+
+// parseCustomerDate accepts RFC 3339 or a plain date (UTC midnight).
+func parseCustomerDate(s string) (t time.Time, dateOnly, ok bool) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t.UTC(), false, true
+	}
+	t, err := time.Parse(time.DateOnly, s)
+	return t, true, err == nil
+}
+
+// ListCustomers pages through the organization's customers with keyset
+// (cursor) pagination, so deep pages stay fast and rows inserted meanwhile
+// neither repeat nor go missing.
+//
+// Query: sort (created_at, name, email), order (asc, desc), size (1-200),
+// cursor (next_cursor of the previous page), created_from / created_to
+// (RFC 3339 or YYYY-MM-DD, inclusive), has_phone (true, false), tag
+// (repeatable; all must be present) and total=true for a count that is exact
+// up to customerTotalCap. With page instead of cursor, the answer has the
+// page/size/total shape of SearchCustomers (offset paging, exact total).
+func ListCustomers(db *sqlx.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		orgID, err := middlewarex.OrgIDFromCtx(c)
+		if err != nil {
+			return c.JSON(http.StatusForbidden, map[string]string{"error": "no active organization"})
+		}
+
+		sort := c.QueryParam("sort")
+		if sort == "" {
+			sort = "created_at"
+		}
+		order, ok := customerSorts[sort]
+		if !ok {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid sort"})
+		}
+		switch o := c.QueryParam("order"); o {
+		case "":
+		case "asc", "desc":
+			order = o
+		default:
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid order"})
+		}
+		size := 50
+		if s, err := strconv.Atoi(c.QueryParam("size")); err == nil {
+			size = min(max(s, 1), 200)
+		}
+
+		// Filters
+		where := []string{"org_id = ?", "deleted_at IS NULL"}
+		args := []any{orgID}
+		if v := c.QueryParam("created_from"); v != "" {
+			t, _, ok := parseCustomerDate(v)
+			if !ok {
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid created_from"})
+			}
+			where = append(where, "created_at >= ?")
+			args = append(args, t)
+		}
+		if v := c.QueryParam("created_to"); v != "" {
+			t, dateOnly, ok := parseCustomerDate(v)
+			if !ok {
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid created_to"})
+			}
+			if dateOnly {
+				// the whole day: before the next midnight
+				where = append(where, "created_at < ?")
+				args = append(args, t.AddDate(0, 0, 1))
+			} else {
+				where = append(where, "created_at <= ?")
+				args = append(args, t)
+			}
+		}
+		switch c.QueryParam("has_phone") {
+		case "":
+		case "true":
+			where = append(where, "COALESCE(phone, '') <> ''")
+		case "false":
+			where = append(where, "COALESCE(phone, '') = ''")
+		default:
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid has_phone"})
+		}
+		tags, err := services.NormalizeTags(c.QueryParams()["tag"])
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid tag"})
+		}
+		for _, t := range tags {
+			where = append(where, "? MEMBER OF (tags)")
+			args = append(args, t)
+		}
+		filtered := strings.Join(where, " AND ")
+
+		if p, err := strconv.Atoi(c.QueryParam("page")); err == nil && c.QueryParam("cursor") == "" {
+			page := max(p, 1)
+			var total int
+			if err := db.Get(&total, `SELECT COUNT(*) FROM customers WHERE `+filtered, args...); err != nil {
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+			}
+			rows := []CustomerDTO{}
+			if err := db.Select(&rows, `
+				SELECT `+customerColumns+`
+				FROM customers
+				WHERE `+filtered+`
+				ORDER BY `+sort+` `+order+`, id `+order+`
+				LIMIT ? OFFSET ?
+			`, append(args, size, (page-1)*size)...); err != nil {
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+			}
+			return c.JSON(http.StatusOK, map[string]any{
+				"items": rows,
+				"page":  page,
+				"size":  size,
+				"total": total,
+			})
+		}
+
+		// Position
+		cond := append([]string{}, where...)
+		condArgs := append([]any{}, args...)
+		if v := c.QueryParam("cursor"); v != "" {
+			cur, ok := decodeCustomerCursor(v)
+			if !ok || cur.Sort != sort || cur.Order != order {
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid cursor"})
+			}
+			var val any = cur.Value
+			if sort == "created_at" {
+				t, err := time.Parse(time.RFC3339Nano, cur.Value)
+				if err != nil {
+					return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid cursor"})
+				}
+				val = t.UTC()
+			}
+			cmp := ">"
+			if order == "desc" {
+				cmp = "<"
+			}
+			cond = append(cond, "("+sort+" "+cmp+" ? OR ("+sort+" = ? AND id "+cmp+" ?))")
+			condArgs = append(condArgs, val, val, cur.ID)
+		}
+
+		rows := []CustomerDTO{}
+		if err := db.Select(&rows, `
+			SELECT `+customerColumns+`
+			FROM customers
+			WHERE `+strings.Join(cond, " AND ")+`
+			ORDER BY `+sort+` `+order+`, id `+order+`
+			LIMIT ?
+		`, append(condArgs, size+1)...); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+
+		var next *string
+		if len(rows) > size {
+			rows = rows[:size]
+			last := rows[size-1]
+			cur := customerCursor{Sort: sort, Order: order, ID: last.ID}
+			switch sort {
+			case "created_at":
+				cur.Value = last.CreatedAt
+			case "name":
+				cur.Value = last.Name
+			case "email":
+				cur.Value = last.Email
+			}
+			s := cur.encode()
+			next = &s
+		}
+
+		resp := map[string]any{
+			"items":       rows,
+			"size":        size,
+			"next_cursor": next,
+		}
+		if c.QueryParam("total") == "true" {
+			var total int
+			if err := db.Get(&total, `
+				SELECT COUNT(*) FROM (SELECT 1 FROM customers WHERE `+filtered+` LIMIT ?) t
+			`, append(args, customerTotalCap+1)...); err != nil {
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+			}
+			resp["total"] = min(total, customerTotalCap)
+			resp["total_approximate"] = total > customerTotalCap
+		}
+		return c.JSON(http.StatusOK, resp)
+	}
+}
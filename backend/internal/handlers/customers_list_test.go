@@ -0,0 +1,154 @@
+package handlers
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+type customerPage struct {
+	Items            []CustomerDTO
+	Size             int
+	NextCursor       *string `json:"next_cursor"`
+	Total            *int
+	TotalApproximate bool `json:"total_approximate"`
+}
+
+func TestListCustomersKeyset(t *testing.T) {
+	created := time.Date(2026, 10, 1, 12, 0, 0, 123000000, time.UTC)
+	db, mock := newMockDB(t)
+	mock.ExpectQuery(`WHERE org_id = \? AND deleted_at IS NULL\s+ORDER BY created_at desc, id desc\s+LIMIT \?`).
+		WithArgs(int64(1), 3).
+		WillReturnRows(sqlmock.NewRows(customerColumnList).
+			AddRow(9, "C", "c@x.example", nil, nil, `[]`, created.Add(time.Hour), 1).
+			AddRow(8, "B", "b@x.example", nil, nil, `[]`, created, 1).
+			AddRow(7, "A", "a@x.example", nil, nil, `[]`, created, 1))
+
+	c, rec := signedInContext(http.MethodGet, "/api/customers", "/api/customers?size=2", "", 7, 1)
+	if err := ListCustomers(db)(c); err != nil {
+		t.Fatal(err)
+	}
+	var got customerPage
+	if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &got) != nil || len(got.Items) != 2 ||
+		got.NextCursor == nil || got.Total != nil {
+		t.Fatalf("first page: status %d: %s", rec.Code, rec.Body)
+	}
+	cur, ok := decodeCustomerCursor(*got.NextCursor)
+	if !ok || cur.ID != 8 || cur.Sort != "created_at" || cur.Order != "desc" {
+		t.Fatalf("cursor: %+v", cur)
+	}
+
+	// the next page starts after (created, 8); ties on the sort column go by id
+	mock.ExpectQuery(`AND \(created_at < \? OR \(created_at = \? AND id < \?\)\)\s+ORDER BY created_at desc, id desc`).
+		WithArgs(int64(1), created, created, int64(8), 3).
+		WillReturnRows(sqlmock.NewRows(customerColumnList).
+			AddRow(7, "A", "a@x.example", nil, nil, `[]`, created, 1))
+
+	c, rec = signedInContext(http.MethodGet, "/api/customers", "/api/customers?size=2&cursor="+*got.NextCursor, "", 7, 1)
+	if err := ListCustomers(db)(c); err != nil {
+		t.Fatal(err)
+	}
+	got = customerPage{}
+	if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &got) != nil || len(got.Items) != 1 ||
+		got.Items[0].ID != 7 || got.NextCursor != nil {
+		t.Fatalf("last page: status %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestListCustomersFilters(t *testing.T) {
+	db, mock := newMockDB(t)
+	from := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	filter := `WHERE org_id = \? AND deleted_at IS NULL AND created_at >= \? AND created_at < \? ` +
+		`AND COALESCE\(phone, ''\) <> '' AND \? MEMBER OF \(tags\) AND \? MEMBER OF \(tags\)`
+	args := []driver.Value{int64(1), from, from.AddDate(0, 1, 0), "b2b", "vip"}
+	mock.ExpectQuery(filter + `\s+ORDER BY name asc, id asc\s+LIMIT \?`).WithArgs(append(args, 51)...).
+		WillReturnRows(sqlmock.NewRows(customerColumnList))
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM \(SELECT 1 FROM customers ` + filter + ` LIMIT \?\) t`).
+		WithArgs(append(args, customerTotalCap+1)...).WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(customerTotalCap + 1))
+
+	// created_to as a date is the whole day; tags are normalized
+	c, rec := signedInContext(http.MethodGet, "/api/customers",
+		"/api/customers?sort=name&created_from=2026-01-01&created_to=2026-01-31&has_phone=true&tag=VIP&tag=b2b&tag=vip&total=true", "", 7, 1)
+	if err := ListCustomers(db)(c); err != nil {
+		t.Fatal(err)
+	}
+	var got customerPage
+	if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &got) != nil ||
+		got.Total == nil || *got.Total != customerTotalCap || !got.TotalApproximate {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+}
+
+// With page instead of cursor the listing pages by offset with an exact total.
+func TestListCustomersOffsetPaging(t *testing.T) {
+	db, mock := newMockDB(t)
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM customers WHERE org_id = \? AND deleted_at IS NULL AND created_at <= \?`).
+		WithArgs(int64(1), time.Date(2026, 2, 1, 9, 0, 0, 0, time.UTC)).WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(45))
+	mock.ExpectQuery(`ORDER BY email desc, id desc\s+LIMIT \? OFFSET \?`).
+		WithArgs(int64(1), time.Date(2026, 2, 1, 9, 0, 0, 0, time.UTC), 20, 40).
+		WillReturnRows(sqlmock.NewRows(customerColumnList))
+
+	c, rec := signedInContext(http.MethodGet, "/api/customers",
+		"/api/customers?sort=email&order=desc&page=3&size=20&created_to=2026-02-01T10:00:00%2B01:00", "", 7, 1)
+	if err := ListCustomers(db)(c); err != nil {
+		t.Fatal(err)
+	}
+	var got struct{ Page, Size, Total int }
+	if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &got) != nil ||
+		got.Page != 3 || got.Size != 20 || got.Total != 45 {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestListCustomersRejects(t *testing.T) {
+	nameCursor := customerCursor{Sort: "name", Order: "asc", Value: "B", ID: 8}.encode()
+	badTime := customerCursor{Sort: "created_at", Order: "desc", Value: "yesterday", ID: 8}.encode()
+	db, _ := newMockDB(t) // refused before any query
+	for _, query := range []string{
+		"sort=id",
+		"sort=name%3Bdrop",
+		"order=up",
+		"has_phone=yes",
+		"created_from=01/02/2026",
+		"created_to=2026-13-01",
+		"tag=a%20b",
+		"cursor=!!",
+		"cursor=e30",           // {} has no id
+		"cursor=" + nameCursor, // made for another sort
+		"sort=name&order=desc&cursor=" + nameCursor, // and another order
+		"cursor=" + badTime,
+	} {
+		c, rec := signedInContext(http.MethodGet, "/api/customers", "/api/customers?"+query, "", 7, 1)
+		if err := ListCustomers(db)(c); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want 400", query, rec.Code)
+		}
+	}
+}
+
+func TestParseCustomerDate(t *testing.T) {
+	for s, want := range map[string]struct {
+		t        time.Time
+		dateOnly bool
+	}{
+		"2026-03-04":                {time.Date(2026, 3, 4, 0, 0, 0, 0, time.UTC), true},
+		"2026-03-04T10:00:00Z":      {time.Date(2026, 3, 4, 10, 0, 0, 0, time.UTC), false},
+		"2026-03-04T10:00:00-02:00": {time.Date(2026, 3, 4, 12, 0, 0, 0, time.UTC), false},
+	} {
+		got, dateOnly, ok := parseCustomerDate(s)
+		if !ok || !got.Equal(want.t) || got.Location() != time.UTC || dateOnly != want.dateOnly {
+			t.Errorf("parseCustomerDate(%q) = %v, %v, %v", s, got, dateOnly, ok)
+		}
+	}
+	for _, s := range []string{"", "2026-3-4", "2026-03-04 10:00", "tomorrow"} {
+		if _, _, ok := parseCustomerDate(s); ok {
+			t.Errorf("parseCustomerDate(%q) accepted", s)
+		}
+	}
+}
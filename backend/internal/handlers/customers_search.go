@@ -5,22 +5,44 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"unicode/utf8"
+
+	middlewarex "secure-communication-ltd/backend/internal/middleware"
+	"secure-communication-ltd/backend/internal/services"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/labstack/echo/v4"
 )
 
 type CustomerDTO struct {
-	ID        int64   `db:"id" json:"id"`
-	Name      string  `db:"name" json:"name"`
-	Email     string  `db:"email" json:"email"`
-	Phone     *string `db:"phone" json:"phone,omitempty"`
-	Notes     *string `db:"notes" json:"notes,omitempty"`
-	CreatedAt string  `db:"created_at" json:"created_at"`
+	ID        int64         `db:"id" json:"id"`
+	Name      string        `db:"name" json:"name"`
+	Email     string        `db:"email" json:"email"`
+	Phone     *string       `db:"phone" json:"phone,omitempty"`
+	Notes     *string       `db:"notes" json:"notes,omitempty"`
+	Tags      services.Tags `db:"tags" json:"tags"`
+	CreatedAt string        `db:"created_at" json:"created_at"`
+	Version   int64         `db:"version" json:"version"` // ETag of GET /api/customers/:id
+}
+
+// customerSearchHit is a search result: the customer, its relevance and the
+// matching text of each field with the matches in <mark> (HTML-escaped).
+type customerSearchHit struct {
+	CustomerDTO
+	Score      float64           `db:"score" json:"score"`
+	Highlights map[string]string `db:"-" json:"highlights,omitempty"`
+	Total      int               `db:"total" json:"-"`
 }
 
+// SearchCustomers ranks the organization's customers by full-text relevance
+// (see services.ParseCustomerQuery for the query syntax), newest first on a
+// tie. The total comes from the same query (COUNT(*) OVER ()).
 func SearchCustomers(db *sqlx.DB) echo.HandlerFunc {
 	return func(c echo.Context) error {
+		orgID, err := middlewarex.OrgIDFromCtx(c)
+		if err != nil {
+			return c.JSON(http.StatusForbidden, map[string]string{"error": "no active organization"})
+		}
 
 		q := strings.TrimSpace(c.QueryParam("q"))
 		pageStr := c.QueryParam("page")
@@ -44,37 +66,50 @@ func SearchCustomers(db *sqlx.DB) echo.HandlerFunc {
 		}
 		offset := (page - 1) * size
 
-		if len([]rune(q)) < 2 {
+		if utf8.RuneCountInString(q) > 256 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "query too long"})
+		}
+		terms := services.ParseCustomerQuery(q)
+		if utf8.RuneCountInString(q) < 2 || len(terms) == 0 {
 			return c.JSON(http.StatusOK, map[string]interface{}{
-				"items": []CustomerDTO{},
+				"items": []customerSearchHit{},
 				"page":  page,
 				"size":  size,
 				"total": 0,
 			})
 		}
+		where, whereArgs, score, scoreArgs := services.BuildCustomerSearch(terms)
 
-		pat := "%" + q + "%"
-
-		// total count
-		var total int
-		if err := db.Get(&total, `
-			SELECT COUNT(*) FROM customers
-			WHERE name  LIKE ? OR email LIKE ? OR notes LIKE ?
-		`, pat, pat, pat); err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
-		}
-
-		var rows []CustomerDTO
+		rows := []customerSearchHit{}
+		args := append(scoreArgs, orgID)
+		args = append(args, whereArgs...)
 		if err := db.Select(&rows, `
-			SELECT id, name, email, phone, notes, created_at
+			SELECT id, name, email, phone, notes, tags, created_at, version,
+			       `+score+` AS score, COUNT(*) OVER () AS total
 			FROM customers
-			WHERE name  LIKE ? OR email LIKE ? OR notes LIKE ?
-			ORDER BY created_at DESC
+			WHERE org_id = ? AND deleted_at IS NULL AND `+where+`
+			ORDER BY score DESC, created_at DESC, id DESC
 			LIMIT ? OFFSET ?
-		`, pat, pat, pat, size, offset); err != nil {
+		`, append(args, size, offset)...); err != nil {
 			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
 		}
 
+		total := 0
+		if len(rows) > 0 {
+			total = rows[0].Total
+		} else if page > 1 {
+			// Past the last page: the window count has no row to ride on
+			if err := db.Get(&total, `
+				SELECT COUNT(*) FROM customers
+				WHERE org_id = ? AND deleted_at IS NULL AND `+where,
+				append([]any{orgID}, whereArgs...)...); err != nil {
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+			}
+		}
+		for i := range rows {
+			rows[i].Highlights = customerHighlights(terms, rows[i].CustomerDTO)
+		}
+
 		return c.JSON(http.StatusOK, map[string]interface{}{
 			"items": rows,
 			"page":  page,
@@ -83,3 +118,13 @@ func SearchCustomers(db *sqlx.DB) echo.HandlerFunc {
 		})
 	}
 }
+
+func customerHighlights(terms []services.SearchTerm, cu CustomerDTO) map[string]string {
+	out := map[string]string{}
+	for field, text := range map[string]string{"name": cu.Name, "email": cu.Email, "notes": deref(cu.Notes)} {
+		if h, ok := services.HighlightCustomerField(terms, field, text); ok {
+			out[field] = h
+		}
+	}
+	return out
+}
@@ -0,0 +1,303 @@
+package handlers
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"secure-communication-ltd/backend/internal/services"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo/v4"
+)
+
+// A by-ID read or write of another organization's customer is a 404, like a
+// missing one, and is recorded as a cross-tenant denial.
+func TestForeignCustomerAccessIsRecorded(t *testing.T) {
+	for _, tc := range []struct {
+		method  string
+		handler func(*sqlx.DB) echo.HandlerFunc
+	}{
+		{http.MethodGet, GetCustomer},
+		{http.MethodPut, UpdateCustomer},
+		{http.MethodPatch, UpdateCustomer},
+		{http.MethodDelete, DeleteCustomer},
+	} {
+		t.Run(tc.method, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			mock.ExpectQuery(`FROM customers WHERE id = \? AND org_id = \? AND deleted_at IS NULL`).
+				WithArgs(int64(42), int64(1)).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+			mock.ExpectQuery(`SELECT org_id FROM customers WHERE id = \?`).WithArgs(int64(42)).
+				WillReturnRows(sqlmock.NewRows([]string{"org_id"}).AddRow(2))
+			mock.ExpectExec(`INSERT INTO org_access_denials`).
+				WithArgs(int64(7), int64(2), tc.method+" /api/customers/:id", sqlmock.AnyArg()).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+			expectAudit(t, mock, services.AuditOrgAccessDenied, services.AuditDenied)
+
+			c, rec := signedInContext(tc.method, "/api/customers/:id", "/api/customers/42", "", 7, 1, "id", "42")
+			if err := tc.handler(db)(c); err != nil {
+				t.Fatal(err)
+			}
+			if rec.Code != http.StatusNotFound {
+				t.Fatalf("status = %d, want 404", rec.Code)
+			}
+		})
+	}
+}
+
+// Missing customers, and those in the organization's own trash, are not denials.
+func TestMissingCustomerIsNotADenial(t *testing.T) {
+	for name, owner := range map[string]*sqlmock.Rows{
+		"missing": sqlmock.NewRows([]string{"org_id"}),
+		"trashed": sqlmock.NewRows([]string{"org_id"}).AddRow(1),
+	} {
+		t.Run(name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			mock.ExpectQuery(`FROM customers WHERE id = \? AND org_id = \?`).
+				WillReturnRows(sqlmock.NewRows([]string{"id"}))
+			mock.ExpectQuery(`SELECT org_id FROM customers WHERE id = \?`).WillReturnRows(owner)
+
+			c, rec := signedInContext(http.MethodGet, "/api/customers/:id", "/api/customers/42", "", 7, 1, "id", "42")
+			if err := GetCustomer(db)(c); err != nil {
+				t.Fatal(err)
+			}
+			if rec.Code != http.StatusNotFound {
+				t.Fatalf("status = %d, want 404", rec.Code)
+			}
+		})
+	}
+}
+
+func TestRestoreForeignCustomerVersionIsRecorded(t *testing.T) {
+	db, mock := newMockDB(t)
+	mock.ExpectQuery(`FROM customer_history h`).WithArgs(int64(1), int64(42), int64(3)).
+		WillReturnRows(sqlmock.NewRows([]string{"version"}))
+	mock.ExpectQuery(`SELECT org_id FROM customers WHERE id = \?`).
+		WillReturnRows(sqlmock.NewRows([]string{"org_id"}).AddRow(2))
+	mock.ExpectExec(`INSERT INTO org_access_denials`).WillReturnResult(sqlmock.NewResult(1, 1))
+	expectAudit(t, mock, services.AuditOrgAccessDenied, services.AuditDenied)
+
+	c, rec := signedInContext(http.MethodPost, "/api/customers/:id/history/:version/restore",
+		"/api/customers/42/history/3/restore", "", 7, 1, "id", "42", "version", "3")
+	if err := RestoreCustomerVersion(db)(c); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want 404", rec.Code)
+	}
+}
+
+var customerColumnList = []string{"id", "name", "email", "phone", "notes", "tags", "created_at", "version"}
+
+// expectCustomer expects loadCustomer to find customer 42 of organization 1
+// at version.
+func expectCustomer(mock sqlmock.Sqlmock, version int64) {
+	mock.ExpectQuery(`FROM customers WHERE id = \? AND org_id = \? AND deleted_at IS NULL`).WithArgs(int64(42), int64(1)).
+		WillReturnRows(sqlmock.NewRows(customerColumnList).
+			AddRow(42, "Acme", "ops@acme.example", "+1 555", "call first", `["vip"]`, "2026-01-02 03:04:05", version))
+}
+
+func TestGetCustomer(t *testing.T) {
+	db, mock := newMockDB(t)
+	expectCustomer(mock, 3)
+
+	c, rec := signedInContext(http.MethodGet, "/api/customers/:id", "/api/customers/42", "", 7, 1, "id", "42")
+	if err := GetCustomer(db)(c); err != nil {
+		t.Fatal(err)
+	}
+	var got CustomerDTO
+	if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &got) != nil {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	if got.ID != 42 || got.Name != "Acme" || deref(got.Notes) != "call first" || len(got.Tags) != 1 || got.Version != 3 {
+		t.Errorf("customer: %+v", got)
+	}
+	if etag := rec.Header().Get("ETag"); etag != `"3"` {
+		t.Errorf("ETag = %s, want \"3\"", etag)
+	}
+}
+
+// expectCustomerWrite expects writeCustomerVersion to store version 4 of
+// customer 42 with action, then the audit entry and (for updates) the reload.
+func expectCustomerWrite(t *testing.T, mock sqlmock.Sqlmock, action string, update ...driver.Value) {
+	t.Helper()
+	mock.ExpectBegin()
+	if action == services.CustomerActionDelete {
+		mock.ExpectExec(`UPDATE customers SET deleted_at = NOW\(\), version = version \+ 1`).
+			WithArgs(int64(42), int64(1), int64(3)).WillReturnResult(sqlmock.NewResult(0, 1))
+	} else {
+		mock.ExpectExec(`UPDATE customers SET name = \?, email = \?, phone = \?, notes = \?, tags = \?, version = version \+ 1`).
+			WithArgs(append(update, int64(42), int64(1), int64(3))...).WillReturnResult(sqlmock.NewResult(0, 1))
+	}
+	mock.ExpectExec(`INSERT INTO customer_history`).
+		WithArgs(int64(1), int64(42), int64(4), action, int64(7), sqlmock.AnyArg(), sqlmock.AnyArg(), nil).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+}
+
+func TestUpdateCustomer(t *testing.T) {
+	for _, tc := range []struct {
+		name, method, body string
+		update             []driver.Value // name, email, phone, notes, tags
+	}{
+		// PUT replaces: omitted fields are cleared, notes are sanitized
+		{"put", http.MethodPut, `{"name":" Acme Corp ","email":"ops@acme.example","notes":"<b>hi</b> &amp; bye","tags":["B2B","vip"]}`,
+			[]driver.Value{"Acme Corp", "ops@acme.example", "", "hi & bye", `["b2b","vip"]`}},
+		// PATCH changes only what is present; stored notes are kept as they are
+		{"patch", http.MethodPatch, `{"phone":" +49 30 "}`,
+			[]driver.Value{"Acme", "ops@acme.example", "+49 30", "call first", `["vip"]`}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			expectCustomer(mock, 3)
+			mock.ExpectQuery(`SELECT COUNT\(\*\) FROM customers WHERE org_id = \? AND email = \? AND id <> \?`).
+				WithArgs(int64(1), "ops@acme.example", int64(42)).WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(0))
+			expectCustomerWrite(t, mock, services.CustomerActionUpdate, tc.update...)
+			expectAudit(t, mock, services.AuditCustomerUpdated, services.AuditSuccess)
+			expectCustomer(mock, 4)
+
+			c, rec := signedInContext(tc.method, "/api/customers/:id", "/api/customers/42", tc.body, 7, 1, "id", "42")
+			c.Request().Header.Set("If-Match", `"3"`)
+			if err := UpdateCustomer(db)(c); err != nil {
+				t.Fatal(err)
+			}
+			if rec.Code != http.StatusOK || rec.Header().Get("ETag") != `"4"` {
+				t.Fatalf("status %d, ETag %s: %s", rec.Code, rec.Header().Get("ETag"), rec.Body)
+			}
+		})
+	}
+}
+
+func TestUpdateCustomerRejects(t *testing.T) {
+	for _, tc := range []struct {
+		name, method, body string
+		taken              bool
+		want               int
+	}{
+		{"no name", http.MethodPut, `{"email":"ops@acme.example"}`, false, http.StatusBadRequest},
+		{"invalid email", http.MethodPatch, `{"email":"ops"}`, false, http.StatusBadRequest},
+		{"invalid tag", http.MethodPatch, `{"tags":["a b"]}`, false, http.StatusBadRequest},
+		{"invalid json", http.MethodPut, `{"name":`, false, http.StatusBadRequest},
+		{"email taken", http.MethodPatch, `{"email":"sales@acme.example"}`, true, http.StatusConflict},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			expectCustomer(mock, 3)
+			if tc.taken {
+				mock.ExpectQuery(`SELECT COUNT\(\*\) FROM customers WHERE org_id = \? AND email = \?`).
+					WithArgs(int64(1), "sales@acme.example", int64(42)).WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(1))
+			}
+
+			c, rec := signedInContext(tc.method, "/api/customers/:id", "/api/customers/42", tc.body, 7, 1, "id", "42")
+			c.Request().Header.Set("If-Match", `"3"`)
+			if err := UpdateCustomer(db)(c); err != nil {
+				t.Fatal(err)
+			}
+			if rec.Code != tc.want {
+				t.Fatalf("status %d, want %d: %s", rec.Code, tc.want, rec.Body)
+			}
+		})
+	}
+}
+
+func TestDeleteCustomer(t *testing.T) {
+	db, mock := newMockDB(t)
+	expectCustomer(mock, 3)
+	expectCustomerWrite(t, mock, services.CustomerActionDelete)
+	expectAudit(t, mock, services.AuditCustomerDeleted, services.AuditSuccess)
+
+	c, rec := signedInContext(http.MethodDelete, "/api/customers/:id", "/api/customers/42", "", 7, 1, "id", "42")
+	c.Request().Header.Set("If-Match", `"3"`)
+	if err := DeleteCustomer(db)(c); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+}
+
+// Writes need If-Match with the current ETag: 428 without it, 412 with the
+// current state when it names another version.
+func TestCustomerWritesNeedIfMatch(t *testing.T) {
+	for _, tc := range []struct {
+		method, ifMatch string
+		want            int
+	}{
+		{http.MethodPut, "", http.StatusPreconditionRequired},
+		{http.MethodPatch, "", http.StatusPreconditionRequired},
+		{http.MethodDelete, "", http.StatusPreconditionRequired},
+		{http.MethodPut, `"2"`, http.StatusPreconditionFailed},
+		{http.MethodPatch, `W/"3"`, http.StatusPreconditionFailed}, // weak tags never match
+		{http.MethodDelete, `"1", "2"`, http.StatusPreconditionFailed},
+	} {
+		db, mock := newMockDB(t)
+		expectCustomer(mock, 3)
+
+		c, rec := signedInContext(tc.method, "/api/customers/:id", "/api/customers/42", `{"phone":"1"}`, 7, 1, "id", "42")
+		if tc.ifMatch != "" {
+			c.Request().Header.Set("If-Match", tc.ifMatch)
+		}
+		handler := UpdateCustomer
+		if tc.method == http.MethodDelete {
+			handler = DeleteCustomer
+		}
+		if err := handler(db)(c); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != tc.want {
+			t.Errorf("%s If-Match %s: status %d, want %d", tc.method, tc.ifMatch, rec.Code, tc.want)
+		}
+		if tc.want == http.StatusPreconditionFailed {
+			var got struct{ Current CustomerDTO }
+			if json.Unmarshal(rec.Body.Bytes(), &got) != nil || got.Current.Version != 3 || rec.Header().Get("ETag") != `"3"` {
+				t.Errorf("%s If-Match %s: no current state: %s", tc.method, tc.ifMatch, rec.Body)
+			}
+		}
+	}
+}
+
+// A write that loses the race in the database (the version moved between the
+// read and the conditional UPDATE) is a 412 too.
+func TestCustomerWriteLosesRace(t *testing.T) {
+	db, mock := newMockDB(t)
+	expectCustomer(mock, 3)
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE customers SET deleted_at = NOW\(\)`).WithArgs(int64(42), int64(1), int64(3)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	expectCustomer(mock, 4)
+
+	c, rec := signedInContext(http.MethodDelete, "/api/customers/:id", "/api/customers/42", "", 7, 1, "id", "42")
+	c.Request().Header.Set("If-Match", "*")
+	if err := DeleteCustomer(db)(c); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusPreconditionFailed || rec.Header().Get("ETag") != `"4"` {
+		t.Fatalf("status %d, ETag %s", rec.Code, rec.Header().Get("ETag"))
+	}
+}
+
+func TestGetCustomerNotModified(t *testing.T) {
+	for ifNoneMatch, want := range map[string]int{
+		`"3"`:        http.StatusNotModified,
+		`"1", "3"`:   http.StatusNotModified,
+		"*":          http.StatusNotModified,
+		`"2"`:        http.StatusOK,
+		`"3`:         http.StatusOK,
+		`"3"x, "33"`: http.StatusOK,
+	} {
+		db, mock := newMockDB(t)
+		expectCustomer(mock, 3)
+
+		c, rec := signedInContext(http.MethodGet, "/api/customers/:id", "/api/customers/42", "", 7, 1, "id", "42")
+		c.Request().Header.Set("If-None-Match", ifNoneMatch)
+		if err := GetCustomer(db)(c); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != want || rec.Header().Get("ETag") != `"3"` {
+			t.Errorf("If-None-Match %s: status %d, want %d", ifNoneMatch, rec.Code, want)
+		}
+	}
+}
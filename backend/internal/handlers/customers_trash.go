@@ -0,0 +1,138 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	middlewarex "secure-communication-ltd/backend/internal/middleware"
+	"secure-communication-ltd/backend/internal/services"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo/v4"
+)
+
+type trashedCustomerDTO struct {
+	CustomerDTO
+	DeletedAt time.Time `db:"deleted_at" json:"deleted_at"`
+	DeletedBy *string   `db:"deleted_by" json:"deleted_by"` // null once the user is gone
+	PurgeAt   time.Time `db:"-" json:"purge_at"`
+}
+
+// The delete is the customer's latest history entry, which names who did it.
+const trashedCustomerSelect = `
+	SELECT c.id, c.name, c.email, c.phone, c.notes, c.tags, c.created_at, c.version, c.deleted_at,
+	       u.username AS deleted_by
+	FROM customers c
+	LEFT JOIN customer_history h ON h.customer_id = c.id AND h.version = c.version
+	LEFT JOIN users u ON u.id = h.actor_user_id`
+
+// loadTrashedCustomer returns a customer of the organization that is in the
+// trash (sql.ErrNoRows otherwise).
+func loadTrashedCustomer(db sqlx.Queryer, orgID int64, idParam string) (trashedCustomerDTO, error) {
+	var cu trashedCustomerDTO
+	id, err := strconv.ParseInt(idParam, 10, 64)
+	if err != nil || id <= 0 {
+		return cu, sql.ErrNoRows
+	}
+	err = sqlx.Get(db, &cu, trashedCustomerSelect+`
+		WHERE c.id = ? AND c.org_id = ? AND c.deleted_at IS NOT NULL
+	`, id, orgID)
+	cu.PurgeAt = cu.DeletedAt.Add(services.CustomerTrashRetention())
+	return cu, err
+}
+
+// ListCustomerTrash lists the organization's deleted customers, most recently
+// deleted first, with when each one will be purged.
+func ListCustomerTrash(db *sqlx.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		orgID, err := middlewarex.OrgIDFromCtx(c)
+		if err != nil {
+			return c.JSON(http.StatusForbidden, map[string]string{"error": "no active organization"})
+		}
+		page := 1
+		size := 50
+		if p, err := strconv.Atoi(c.QueryParam("page")); err == nil && p > 0 {
+			page = p
+		}
+		if s, err := strconv.Atoi(c.QueryParam("size")); err == nil {
+			size = min(max(s, 1), 200)
+		}
+
+		var total int
+		if err := db.Get(&total, `
+			SELECT COUNT(*) FROM customers WHERE org_id = ? AND deleted_at IS NOT NULL
+		`, orgID); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+		items := []trashedCustomerDTO{}
+		if err := db.Select(&items, trashedCustomerSelect+`
+			WHERE c.org_id = ? AND c.deleted_at IS NOT NULL
+			ORDER BY c.deleted_at DESC, c.id DESC
+			LIMIT ? OFFSET ?
+		`, orgID, size, (page-1)*size); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+		retention := services.CustomerTrashRetention()
+		for i := range items {
+			items[i].PurgeAt = items[i].DeletedAt.Add(retention)
+		}
+		return c.JSON(http.StatusOK, map[string]any{
+			"items":          items,
+			"page":           page,
+			"size":           size,
+			"total":          total,
+			"retention_days": int(retention.Hours() / 24),
+		})
+	}
+}
+
+// RestoreCustomerFromTrash takes a customer out of the trash in the state it
+// was deleted in. It fails with 409 if a live customer now has its email.
+func RestoreCustomerFromTrash(db *sqlx.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		uid, err := middlewarex.UserIDFromCtx(c)
+		if err != nil {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		}
+		orgID, err := middlewarex.OrgIDFromCtx(c)
+		if err != nil {
+			return c.JSON(http.StatusForbidden, map[string]string{"error": "no active organization"})
+		}
+		cu, err := loadTrashedCustomer(db, orgID, c.Param("id"))
+		if errors.Is(err, sql.ErrNoRows) {
+			recordForeignCustomerAccess(c, db)
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "customer not in trash"})
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+		if taken, err := customerEmailTaken(db, orgID, cu.Email, cu.ID); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		} else if taken {
+			return c.JSON(http.StatusConflict, map[string]string{"error": "a customer with this email already exists"})
+		}
+
+		err = writeCustomerVersion(db, orgID, cu.CustomerDTO, services.CustomerActionUndelete, uid, nil, nil)
+		if errors.Is(err, errCustomerChanged) {
+			// Restored or purged in the meantime
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "customer not in trash"})
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "could not restore customer"})
+		}
+		middlewarex.Audit(c, db, services.AuditEvent{
+			Type: services.AuditCustomerUndeleted, OrgID: orgID,
+			SubjectType: "customer", SubjectID: strconv.FormatInt(cu.ID, 10),
+		})
+
+		restored, err := loadCustomer(db, orgID, c.Param("id"))
+		if err != nil {
+			return customerLookupError(c, err)
+		}
+		c.Response().Header().Set("ETag", customerETag(restored))
+		return c.JSON(http.StatusOK, restored)
+	}
+}
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"secure-communication-ltd/backend/internal/services"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+var trashedCustomerColumns = append(append([]string{}, customerColumnList...), "deleted_at", "deleted_by")
+
+// expectTrashedCustomer expects loadTrashedCustomer to find customer 42 of
+// organization 1, deleted at version 4.
+func expectTrashedCustomer(mock sqlmock.Sqlmock, deletedAt time.Time) {
+	mock.ExpectQuery(`WHERE c.id = \? AND c.org_id = \? AND c.deleted_at IS NOT NULL`).WithArgs(int64(42), int64(1)).
+		WillReturnRows(sqlmock.NewRows(trashedCustomerColumns).
+			AddRow(42, "Acme", "ops@acme.example", nil, "call first", `["vip"]`, "2026-01-02 03:04:05", 4, deletedAt, "alice"))
+}
+
+func TestListCustomerTrash(t *testing.T) {
+	t.Setenv("CUSTOMER_TRASH_RETENTION_DAYS", "10")
+	deletedAt := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+	db, mock := newMockDB(t)
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM customers WHERE org_id = \? AND deleted_at IS NOT NULL`).WithArgs(int64(1)).
+		WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(21))
+	mock.ExpectQuery(`ORDER BY c.deleted_at DESC, c.id DESC\s+LIMIT \? OFFSET \?`).WithArgs(int64(1), 10, 10).
+		WillReturnRows(sqlmock.NewRows(trashedCustomerColumns).
+			AddRow(42, "Acme", "ops@acme.example", nil, nil, `[]`, "2026-01-02 03:04:05", 4, deletedAt, nil))
+
+	c, rec := signedInContext(http.MethodGet, "/api/customers/trash", "/api/customers/trash?page=2&size=10", "", 7, 1)
+	if err := ListCustomerTrash(db)(c); err != nil {
+		t.Fatal(err)
+	}
+	var got struct {
+		Items         []trashedCustomerDTO
+		Total         int
+		RetentionDays int `json:"retention_days"`
+	}
+	if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &got) != nil || len(got.Items) != 1 ||
+		got.Total != 21 || got.RetentionDays != 10 {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	if it := got.Items[0]; !it.PurgeAt.Equal(deletedAt.Add(10*24*time.Hour)) || it.DeletedBy != nil {
+		t.Errorf("item: %+v", it)
+	}
+}
+
+// A customer comes back from the trash in the state it was deleted in, as a
+// new version.
+func TestRestoreCustomerFromTrash(t *testing.T) {
+	db, mock := newMockDB(t)
+	expectTrashedCustomer(mock, time.Now())
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM customers WHERE org_id = \? AND email = \?`).
+		WithArgs(int64(1), "ops@acme.example", int64(42)).WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(0))
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE customers SET deleted_at = NULL, version = version \+ 1\s+WHERE id = \? AND org_id = \? AND version = \? AND deleted_at IS NOT NULL`).
+		WithArgs(int64(42), int64(1), int64(4)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO customer_history`).
+		WithArgs(int64(1), int64(42), int64(5), services.CustomerActionUndelete, int64(7), sqlmock.AnyArg(),
+			`{"name":"Acme","email":"ops@acme.example","phone":"","notes":"call first","tags":["vip"]}`, nil).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	expectAudit(t, mock, services.AuditCustomerUndeleted, services.AuditSuccess)
+	mock.ExpectQuery(`FROM customers WHERE id = \? AND org_id = \? AND deleted_at IS NULL`).
+		WillReturnRows(sqlmock.NewRows(customerColumnList).
+			AddRow(42, "Acme", "ops@acme.example", nil, "call first", `["vip"]`, "2026-01-02 03:04:05", 5))
+
+	c, rec := signedInContext(http.MethodPost, "/api/customers/trash/:id/restore", "/api/customers/trash/42/restore", "", 7, 1, "id", "42")
+	if err := RestoreCustomerFromTrash(db)(c); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK || rec.Header().Get("ETag") != `"5"` {
+		t.Fatalf("status %d, ETag %s: %s", rec.Code, rec.Header().Get("ETag"), rec.Body)
+	}
+}
+
+func TestRestoreCustomerFromTrashRefuses(t *testing.T) {
+	t.Run("email taken", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		expectTrashedCustomer(mock, time.Now())
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM customers WHERE org_id = \? AND email = \?`).
+			WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(1))
+
+		c, rec := signedInContext(http.MethodPost, "/api/customers/trash/:id/restore", "/api/customers/trash/42/restore", "", 7, 1, "id", "42")
+		if err := RestoreCustomerFromTrash(db)(c); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != http.StatusConflict {
+			t.Fatalf("status %d, want 409", rec.Code)
+		}
+	})
+	t.Run("restored or purged meanwhile", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		expectTrashedCustomer(mock, time.Now())
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM customers WHERE org_id = \? AND email = \?`).
+			WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(0))
+		mock.ExpectBegin()
+		mock.ExpectExec(`UPDATE customers SET deleted_at = NULL`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectRollback()
+
+		c, rec := signedInContext(http.MethodPost, "/api/customers/trash/:id/restore", "/api/customers/trash/42/restore", "", 7, 1, "id", "42")
+		if err := RestoreCustomerFromTrash(db)(c); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != http.StatusNotFound {
+			t.Fatalf("status %d, want 404", rec.Code)
+		}
+	})
+	t.Run("not in the trash", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(`WHERE c.id = \? AND c.org_id = \? AND c.deleted_at IS NOT NULL`).
+			WillReturnRows(sqlmock.NewRows(trashedCustomerColumns))
+		mock.ExpectQuery(`SELECT org_id FROM customers WHERE id = \?`).
+			WillReturnRows(sqlmock.NewRows([]string{"org_id"}).AddRow(1)) // live, not trashed
+
+		c, rec := signedInContext(http.MethodPost, "/api/customers/trash/:id/restore", "/api/customers/trash/42/restore", "", 7, 1, "id", "42")
+		if err := RestoreCustomerFromTrash(db)(c); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != http.StatusNotFound {
+			t.Fatalf("status %d, want 404", rec.Code)
+		}
+	})
+}
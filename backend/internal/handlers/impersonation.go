@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	middlewarex "secure-communication-ltd/backend/internal/middleware"
+	"secure-communication-ltd/backend/internal/services"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo/v4"
+)
+
+// AdminImpersonate signs the admin in as another user for ImpersonationTTL,
+// so support can see what the user sees. The session is separate from the
+// admin's own, which is restored by StopImpersonation. Its claims name both
+// people, every request made with it is audited, and sensitive actions are
+// refused (middlewarex.DenyImpersonation). Admins and service accounts
+// cannot be impersonated.
+func AdminImpersonate(db *sqlx.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		admin, err := middlewarex.ClaimsFromCtx(c)
+		if err != nil {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		}
+		u, err := loadAdminUser(db, c.Param("id"))
+		if errors.Is(err, sql.ErrNoRows) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "user not found"})
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+		subject := strconv.FormatInt(u.ID, 10)
+
+		_, perms, err := services.LoadUserAccess(db, u.ID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+		reason := ""
+		switch {
+		case u.ID == admin.UserID:
+			reason = "self"
+		case u.IsService:
+			reason = "service_account"
+		case services.HasPermission(perms, services.PermUsersAdmin):
+			reason = "target_is_admin"
+		}
+		if reason != "" {
+			middlewarex.Audit(c, db, services.AuditEvent{
+				Type: services.AuditImpersonationStart, Outcome: services.AuditDenied,
+				SubjectType: "user", SubjectID: subject, Details: map[string]any{"reason": reason},
+			})
+			return c.JSON(http.StatusForbidden, map[string]string{"error": "this user cannot be impersonated"})
+		}
+
+		claims, err := services.NewSessionClaims(db, u.ID)
+		if errors.Is(err, services.ErrAccountInactive) || errors.Is(err, services.ErrAccountLocked) {
+			return c.JSON(http.StatusConflict, map[string]string{"error": "account disabled"})
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "token error"})
+		}
+		claims.ImpersonatorID, claims.ImpersonatorName = admin.UserID, admin.Username
+		claims.SessionID, err = services.CreateImpersonationSession(db, u.ID, admin.UserID,
+			services.HashSHA256Hex(deviceID(c)), c.RealIP(), c.Request().UserAgent(), services.ImpersonationTTL)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "session error"})
+		}
+		token, err := services.CreateJWT(claims, services.ImpersonationTTL)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "token error"})
+		}
+
+		// Park the admin's own session; RequireSession guarantees the cookie is there
+		if ck, err := c.Cookie(services.SessionCookieName()); err == nil {
+			c.SetCookie(services.NewCookie(services.ImpersonatorCookie, ck.Value, sessionTTL))
+		}
+		c.SetCookie(services.NewCookie(services.SessionCookie, token, services.ImpersonationTTL))
+
+		middlewarex.Audit(c, db, services.AuditEvent{
+			Type: services.AuditImpersonationStart, SubjectType: "user", SubjectID: subject,
+			Details: map[string]any{"session_id": claims.SessionID},
+		})
+		return c.JSON(http.StatusOK, map[string]any{
+			"user_id":    u.ID,
+			"username":   u.Username,
+			"expires_at": time.Now().Add(services.ImpersonationTTL).UTC(),
+		})
+	}
+}
+
+// StopImpersonation ends the impersonation session and gives the admin their
+// own session back, if it is still live (otherwise they are signed out).
+func StopImpersonation(db *sqlx.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		claims, err := middlewarex.ClaimsFromCtx(c)
+		if err != nil {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		}
+		if !claims.Impersonating() {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "not impersonating"})
+		}
+		if err := services.RevokeSession(db, claims.UserID, claims.SessionID); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+		middlewarex.Audit(c, db, services.AuditEvent{
+			Type: services.AuditImpersonationEnd, SubjectType: "user", SubjectID: strconv.FormatInt(claims.UserID, 10),
+		})
+
+		restored := false
+		if admin := parkedAdminSession(c, db); admin != nil && admin.UserID == claims.ImpersonatorID {
+			ck, _ := c.Cookie(services.CookieName(services.ImpersonatorCookie, "/"))
+			c.SetCookie(services.NewCookie(services.SessionCookie, ck.Value, time.Until(admin.ExpiresAt.Time)))
+			restored = true
+		} else {
+			c.SetCookie(services.ExpiredCookie(services.SessionCookie))
+		}
+		c.SetCookie(services.ExpiredCookie(services.ImpersonatorCookie))
+		return c.JSON(http.StatusOK, map[string]any{"restored": restored})
+	}
+}
+
+// parkedAdminSession returns the claims of the session parked by
+// AdminImpersonate, if it is still live.
+func parkedAdminSession(c echo.Context, db *sqlx.DB) *services.Claims {
+	ck, err := c.Cookie(services.CookieName(services.ImpersonatorCookie, "/"))
+	if err != nil {
+		return nil
+	}
+	cl, err := services.ParseJWT(ck.Value)
+	if err != nil {
+		return nil
+	}
+	if ok, err := services.SessionActive(db, cl.UserID, cl.SessionID); err != nil || !ok {
+		return nil
+	}
+	return cl
+}
@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+
+	middlewarex "secure-communication-ltd/backend/internal/middleware"
+	"secure-communication-ltd/backend/internal/services"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// expectAccess expects services.LoadUserAccess for uid.
+func expectAccess(mock sqlmock.Sqlmock, uid int64, perms ...string) {
+	mock.ExpectQuery(`SELECT r.name`).WithArgs(uid).WillReturnRows(sqlmock.NewRows([]string{"name"}))
+	rows := sqlmock.NewRows([]string{"name"})
+	for _, p := range perms {
+		rows.AddRow(p)
+	}
+	mock.ExpectQuery(`SELECT DISTINCT p.name`).WithArgs(uid).WillReturnRows(rows)
+}
+
+// Admins cannot impersonate themselves, service accounts or other admins;
+// refusals are audited.
+func TestAdminImpersonateRefuses(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		target  int64
+		service bool
+		perms   []string
+	}{
+		{"self", 1, false, nil},
+		{"service account", 2, true, nil},
+		{"admin", 3, false, []string{services.PermUsersAdmin}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			expectAdminUser(mock, tc.target, true, tc.service, nil)
+			expectAccess(mock, tc.target, tc.perms...)
+			expectAudit(t, mock, services.AuditImpersonationStart, services.AuditDenied)
+
+			id := strconv.FormatInt(tc.target, 10)
+			c, rec := signedInContext(http.MethodPost, "/api/admin/users/:id/impersonate", "/api/admin/users/"+id+"/impersonate", "", 1, 0, "id", id)
+			if err := AdminImpersonate(db)(c); err != nil {
+				t.Fatal(err)
+			}
+			if rec.Code != http.StatusForbidden || len(rec.Result().Cookies()) != 0 {
+				t.Fatalf("status %d, cookies %v", rec.Code, rec.Result().Cookies())
+			}
+		})
+	}
+}
+
+// The admin's own session is parked and replaced by a session of the user
+// that names the admin.
+func TestAdminImpersonate(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+	db, mock := newMockDB(t)
+	expectAdminUser(mock, 2, true, false, nil)
+	expectAccess(mock, 2, services.PermCustomersRead)
+	mock.ExpectQuery(`SELECT username, is_active, locked_at IS NOT NULL AS is_locked FROM users WHERE id = \?`).WithArgs(int64(2)).
+		WillReturnRows(sqlmock.NewRows([]string{"username", "is_active", "is_locked"}).AddRow("bob", true, false))
+	expectAccess(mock, 2, services.PermCustomersRead)
+	mock.ExpectQuery(`SELECT org_id FROM organization_members`).WithArgs(int64(2)).
+		WillReturnRows(sqlmock.NewRows([]string{"org_id"}).AddRow(4))
+	mock.ExpectExec(`INSERT INTO user_sessions \(sid, user_id, impersonator_id,`).
+		WithArgs(sqlmock.AnyArg(), int64(2), int64(1), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	expectAudit(t, mock, services.AuditImpersonationStart, services.AuditSuccess)
+
+	c, rec := signedInContext(http.MethodPost, "/api/admin/users/:id/impersonate", "/api/admin/users/2/impersonate", "", 1, 0, "id", "2")
+	c.Request().AddCookie(&http.Cookie{Name: services.SessionCookieName(), Value: "admin-token"})
+	if err := AdminImpersonate(db)(c); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	cookies := map[string]string{}
+	for _, ck := range rec.Result().Cookies() {
+		cookies[ck.Name] = ck.Value
+	}
+	if cookies[services.CookieName(services.ImpersonatorCookie, "/")] != "admin-token" {
+		t.Errorf("admin session not parked: %v", cookies)
+	}
+	cl, err := services.ParseJWT(cookies[services.SessionCookieName()])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cl.UserID != 2 || cl.ImpersonatorID != 1 || cl.ImpersonatorName != "alice" || cl.SessionID == "" || !cl.Impersonating() {
+		t.Errorf("claims: %+v", cl)
+	}
+}
+
+func TestStopImpersonation(t *testing.T) {
+	t.Setenv("JWT_SECRET", "test-secret")
+	adminToken, err := services.CreateJWT(&services.Claims{UserID: 1, Username: "alice", SessionID: "sid-admin"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		name     string
+		parked   bool
+		live     int
+		restored bool
+	}{
+		{"admin session restored", true, 1, true},
+		{"admin session revoked meanwhile", true, 0, false},
+		{"nothing parked", false, 0, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			mock.ExpectExec(`UPDATE user_sessions SET revoked_at = NOW\(\)`).WithArgs("sid-imp", int64(2)).
+				WillReturnResult(sqlmock.NewResult(0, 1))
+			expectAudit(t, mock, services.AuditImpersonationEnd, services.AuditSuccess)
+			if tc.parked {
+				mock.ExpectQuery(`FROM user_sessions s`).WithArgs("sid-admin", int64(1)).
+					WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(tc.live))
+			}
+
+			c, rec := signedInContext(http.MethodPost, "/api/impersonation/stop", "/api/impersonation/stop", "", 2, 0)
+			cl := c.Get(middlewarex.CtxClaimsKey).(*services.Claims)
+			cl.SessionID, cl.ImpersonatorID, cl.ImpersonatorName = "sid-imp", 1, "alice"
+			if tc.parked {
+				c.Request().AddCookie(&http.Cookie{Name: services.CookieName(services.ImpersonatorCookie, "/"), Value: adminToken})
+			}
+			if err := StopImpersonation(db)(c); err != nil {
+				t.Fatal(err)
+			}
+			var got struct{ Restored bool }
+			if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &got) != nil || got.Restored != tc.restored {
+				t.Fatalf("status %d: %s", rec.Code, rec.Body)
+			}
+			for _, ck := range rec.Result().Cookies() {
+				switch ck.Name {
+				case services.SessionCookieName():
+					if tc.restored != (ck.Value == adminToken && ck.MaxAge > 0) {
+						t.Errorf("session cookie: %+v", ck)
+					}
+				case services.CookieName(services.ImpersonatorCookie, "/"):
+					if ck.MaxAge >= 0 {
+						t.Errorf("parked session not cleared: %+v", ck)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestStopImpersonationNotImpersonating(t *testing.T) {
+	db, _ := newMockDB(t)
+	c, rec := signedInContext(http.MethodPost, "/api/impersonation/stop", "/api/impersonation/stop", "", 1, 0)
+	if err := StopImpersonation(db)(c); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status %d, want 400", rec.Code)
+	}
+}
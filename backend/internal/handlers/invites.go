@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	middlewarex "secure-communication-ltd/backend/internal/middleware"
+	"secure-communication-ltd/backend/internal/services"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo/v4"
+)
+
+type createInviteRequest struct {
+	Email          string `json:"email"`
+	Role           string `json:"role,omitempty"`             // default: DEFAULT_USER_ROLE
+	ExpiresInHours int    `json:"expires_in_hours,omitempty"` // default: 7 days
+}
+
+type inviteDTO struct {
+	ID        int64      `json:"id"`
+	Email     string     `json:"email"`
+	Role      string     `json:"role,omitempty"`
+	Status    string     `json:"status"` // pending, used, expired, revoked
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+var inviteMailTpl = template.Must(template.New("invite").Parse(`
+<h2>You're invited</h2>
+<p>You have been invited to create an account with the email address {{.Email}}.</p>
+<p>
+  <a href="{{.Link}}" style="display:inline-block;padding:10px 16px;border-radius:8px;background:#4f9cff;color:#fff;text-decoration:none">
+    Create your account
+  </a>
+</p>
+<p>If the button doesn't work, copy this URL:</p>
+<p><code>{{.Link}}</code></p>
+<p>This invitation can be used once and expires on {{.Expires}}.</p>
+`))
+
+// AdminCreateInvite issues a single-use registration invite for one email
+// address and emails the sign-up link to it.
+func AdminCreateInvite(db *sqlx.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		actor, err := middlewarex.UserIDFromCtx(c)
+		if err != nil {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		}
+		var req createInviteRequest
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid json"})
+		}
+		ttl := services.InviteTTL
+		if req.ExpiresInHours < 0 || req.ExpiresInHours > 30*24 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "expires_in_hours must be between 1 and 720"})
+		}
+		if req.ExpiresInHours > 0 {
+			ttl = time.Duration(req.ExpiresInHours) * time.Hour
+		}
+		email, _, err := services.CanonicalEmail(req.Email)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid email"})
+		}
+
+		raw, id, err := services.CreateRegistrationInvite(db, email, strings.TrimSpace(req.Role), ttl, actor)
+		if errors.Is(err, services.ErrUnknownRole) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "unknown role"})
+		}
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+
+		fe := os.Getenv("FRONTEND_PUBLIC_URL")
+		if fe == "" {
+			fe = "http://localhost:3000"
+		}
+		link := strings.TrimRight(fe, "/") + "/register?invite=" + url.QueryEscape(raw) + "&email=" + url.QueryEscape(email)
+		expires := time.Now().Add(ttl)
+
+		mailer, err := services.NewMailerFromEnv()
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "mailer error"})
+		}
+		var buf bytes.Buffer
+		if err := inviteMailTpl.Execute(&buf, struct{ Email, Link, Expires string }{
+			Email: email, Link: link, Expires: expires.UTC().Format("2006-01-02 15:04 UTC"),
+		}); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "template error"})
+		}
+		if err := mailer.Send(email, "You're invited to create an account", buf.String()); err != nil {
+			// the invite is useless if it never arrives
+			_, _ = db.Exec(`UPDATE registration_invites SET revoked_at = NOW() WHERE id = ?`, id)
+			return c.JSON(http.StatusBadGateway, map[string]string{"error": "send mail error"})
+		}
+
+		return c.JSON(http.StatusCreated, map[string]any{
+			"id":         id,
+			"email":      email,
+			"role":       strings.TrimSpace(req.Role),
+			"expires_at": expires,
+			"message":    "Invitation sent.",
+		})
+	}
+}
+
+// AdminListInvites lists invitations, newest first.
+func AdminListInvites(db *sqlx.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var rows []services.RegistrationInvite
+		if err := db.Select(&rows, `
+			SELECT i.id, i.email, r.name AS role_name, i.expires_at, i.used_at, i.used_by,
+			       i.revoked_at, i.created_by, i.created_at
+			FROM registration_invites i LEFT JOIN roles r ON r.id = i.role_id
+			ORDER BY i.created_at DESC
+			LIMIT 500
+		`); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+		now := time.Now()
+		items := make([]inviteDTO, 0, len(rows))
+		for _, r := range rows {
+			d := inviteDTO{ID: r.ID, Email: r.Email, Role: r.Role.String, ExpiresAt: r.ExpiresAt, CreatedAt: r.CreatedAt}
+			switch {
+			case r.UsedAt.Valid:
+				d.Status, d.UsedAt = "used", &r.UsedAt.Time
+			case r.RevokedAt.Valid:
+				d.Status = "revoked"
+			case now.After(r.ExpiresAt):
+				d.Status = "expired"
+			default:
+				d.Status = "pending"
+			}
+			items = append(items, d)
+		}
+		return c.JSON(http.StatusOK, map[string]any{"items": items})
+	}
+}
+
+// AdminRevokeInvite withdraws a pending invitation.
+func AdminRevokeInvite(db *sqlx.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil || id <= 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid invite id"})
+		}
+		res, err := db.Exec(`
+			UPDATE registration_invites SET revoked_at = NOW()
+			WHERE id = ? AND used_at IS NULL AND revoked_at IS NULL
+		`, id)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "pending invite not found"})
+		}
+		return c.JSON(http.StatusOK, map[string]string{"message": "invite revoked"})
+	}
+}
@@ -3,13 +3,13 @@ package handlers
 import (
 	"crypto/subtle"
 	"database/sql"
-	"net"
 	"net/http"
 	"os"
 	"strconv"
 	"strings"
 
 	"secure-communication-ltd/backend/config"
+	middlewarex "secure-communication-ltd/backend/internal/middleware"
 	"secure-communication-ltd/backend/internal/services"
 
 	"github.com/jmoiron/sqlx"
@@ -29,10 +29,15 @@ type userRow struct {
 	Salt       []byte `db:"salt"`
 	IsActive   bool   `db:"is_active"`
 	IsVerified bool   `db:"is_verified"`
+	IsService  bool   `db:"is_service_account"`
+	IsLocked   bool   `db:"is_locked"`
 }
 
 func Login(db *sqlx.DB) echo.HandlerFunc {
 	return func(c echo.Context) error {
+		if !services.LocalLoginEnabled() {
+			return localLoginDisabled(c)
+		}
 		pol := config.GetPolicy()
 		var req LoginRequest
 		if err := c.Bind(&req); err != nil {
@@ -43,27 +48,60 @@ func Login(db *sqlx.DB) echo.HandlerFunc {
 			return c.JSON(http.StatusBadRequest, map[string]string{"error": "missing fields"})
 		}
 
+		// Usernames never contain '@', so the identifier maps to exactly one column.
+		// Attempts are logged under the canonical key so "Alice" and "alice" share a lockout.
+		lookupCol, loginKey, lookupOK := services.LoginLookup(req.ID)
+
 		//  Lockout window check (failed password attempts)
 		var failCount int
 		if err := db.Get(&failCount, `
-			SELECT COUNT(*) FROM login_attempts
-			WHERE username = ? AND success = 0
-			  AND attempt_time > (NOW() - INTERVAL ? MINUTE)
-		`, req.ID, pol.LockoutMinutes); err != nil {
+			SELECT COUNT(*) FROM login_attempts la
+			WHERE la.username = ? AND la.success = 0
+			  AND la.attempt_time > (NOW() - INTERVAL ? MINUTE)
+			  AND la.attempt_time > COALESCE((SELECT lockout_cleared_at FROM users WHERE id = la.user_id), '1000-01-01')
+		`, loginKey, pol.LockoutMinutes); err != nil {
 			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
 		}
 		if pol.MaxLoginAttempts > 0 && failCount >= pol.MaxLoginAttempts {
+			middlewarex.Audit(c, db, services.AuditEvent{
+				Type: services.AuditLoginPassword, Outcome: services.AuditDenied, ActorLabel: loginKey,
+				Details: map[string]any{"reason": "account_locked"},
+			})
 			return c.JSON(http.StatusTooManyRequests, map[string]string{"error": "account temporarily locked"})
 		}
 
-		//  Fetch user by email OR username
+		// Per-client throttle across all accounts (password spraying)
+		ip := c.RealIP()
+		if limit := loginMaxAttemptsPerIP(); limit > 0 {
+			var ipFails int
+			if err := db.Get(&ipFails, `
+				SELECT COUNT(*) FROM login_attempts
+				WHERE ip = ? AND success = 0
+				  AND attempt_time > (NOW() - INTERVAL ? MINUTE)
+			`, ip, pol.LockoutMinutes); err != nil {
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+			}
+			if ipFails >= limit {
+				middlewarex.Audit(c, db, services.AuditEvent{
+					Type: services.AuditLoginPassword, Outcome: services.AuditDenied, ActorLabel: loginKey,
+					Details: map[string]any{"reason": "ip_throttled"},
+				})
+				return c.JSON(http.StatusTooManyRequests, map[string]string{"error": "too many failed attempts; try again later"})
+			}
+		}
+
+		//  Fetch user by canonical email or username
 		var u userRow
-		err := db.Get(&u, `
-			SELECT id, username, email, password_hmac, salt, is_active, is_verified
-			FROM users
-			WHERE email = ? OR username = ?
-			LIMIT 1
-		`, req.ID, req.ID)
+		err := sql.ErrNoRows
+		if lookupOK {
+			err = db.Get(&u, `
+				SELECT id, username, email, password_hmac, salt, is_active, is_verified, is_service_account,
+				       locked_at IS NOT NULL AS is_locked
+				FROM users
+				WHERE `+lookupCol+` = ?
+				LIMIT 1
+			`, loginKey)
+		}
 
 		knownUser := (err == nil)
 		userIDForLog := sql.NullInt64{}
@@ -84,13 +122,11 @@ func Login(db *sqlx.DB) echo.HandlerFunc {
 			_, _ = services.HashPasswordHMACHex(req.Password, dummySalt)
 		}
 
-		ip := clientIP(c.Request())
-
 		//  Check password + statuses
 		ok := false
 		if knownUser {
 			if subtle.ConstantTimeCompare([]byte(computed), []byte(u.PassHMAC)) == 1 &&
-				u.IsActive && u.IsVerified {
+				u.IsActive && u.IsVerified && !u.IsService && !u.IsLocked {
 				ok = true
 			}
 		}
@@ -100,7 +136,12 @@ func Login(db *sqlx.DB) echo.HandlerFunc {
 			_, _ = db.Exec(`
 				INSERT INTO login_attempts (user_id, username, ip, success)
 				VALUES (?, ?, ?, 0)
-			`, userIDForLog, req.ID, ip)
+			`, userIDForLog, loginKey, ip)
+			middlewarex.Audit(c, db, services.AuditEvent{
+				Type: services.AuditLoginPassword, Outcome: services.AuditFailure,
+				ActorUserID: userIDForLog.Int64, ActorLabel: loginKey,
+				Details: map[string]any{"reason": loginFailureReason(knownUser, &u)},
+			})
 			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "invalid credentials"})
 		}
 
@@ -130,6 +171,11 @@ func Login(db *sqlx.DB) echo.HandlerFunc {
 			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "otp start error"})
 		}
 
+		middlewarex.Audit(c, db, services.AuditEvent{
+			Type: services.AuditLoginPassword, ActorUserID: u.ID, ActorLabel: u.Username,
+			Details: map[string]any{"mfa": "email_otp"},
+		})
+
 		// Tell client to show OTP code screen (step 2)
 		return c.JSON(http.StatusOK, map[string]any{
 			"mfa_required": true,
@@ -139,10 +185,31 @@ func Login(db *sqlx.DB) echo.HandlerFunc {
 	}
 }
 
-func clientIP(r *http.Request) string {
-	host, _, err := net.SplitHostPort(r.RemoteAddr)
-	if err != nil {
-		return r.RemoteAddr
+// loginFailureReason classifies a failed password step for the audit log only;
+// the client always gets the same "invalid credentials".
+func loginFailureReason(known bool, u *userRow) string {
+	switch {
+	case !known:
+		return "unknown_user"
+	case u.IsService:
+		return "service_account"
+	case !u.IsActive:
+		return "account_disabled"
+	case !u.IsVerified:
+		return "email_unverified"
+	case u.IsLocked:
+		return "account_locked_down"
+	}
+	return "bad_password"
+}
+
+// loginMaxAttemptsPerIP reads LOGIN_MAX_ATTEMPTS_PER_IP: failed password
+// attempts allowed from one client address per lockout window (default 20, 0 = off).
+func loginMaxAttemptsPerIP() int {
+	if v := os.Getenv("LOGIN_MAX_ATTEMPTS_PER_IP"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 {
+			return n
+		}
 	}
-	return host
+	return 20
 }
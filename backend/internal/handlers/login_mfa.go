@@ -2,10 +2,12 @@ package handlers
 
 import (
 	"database/sql"
+	"errors"
 	"net/http"
 	"strings"
 	"time"
 
+	middlewarex "secure-communication-ltd/backend/internal/middleware"
 	"secure-communication-ltd/backend/internal/services"
 
 	"github.com/jmoiron/sqlx"
@@ -26,6 +28,9 @@ type otpRow struct {
 
 func LoginMFA(db *sqlx.DB) echo.HandlerFunc {
 	return func(c echo.Context) error {
+		if !services.LocalLoginEnabled() {
+			return localLoginDisabled(c)
+		}
 		var req MFALoginRequest
 		if err := c.Bind(&req); err != nil {
 			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid json"})
@@ -36,11 +41,15 @@ func LoginMFA(db *sqlx.DB) echo.HandlerFunc {
 			return c.JSON(http.StatusBadRequest, map[string]string{"error": "missing fields"})
 		}
 
-		// find user
+		// find user (same identifier rules as the password step)
+		lookupCol, loginKey, lookupOK := services.LoginLookup(id)
+		if !lookupOK {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "invalid code"})
+		}
 		var userID int64
 		if err := db.Get(&userID, `
-			SELECT id FROM users WHERE email = ? OR username = ? LIMIT 1
-		`, id, id); err != nil {
+			SELECT id FROM users WHERE `+lookupCol+` = ? LIMIT 1
+		`, loginKey); err != nil {
 			if err == sql.ErrNoRows {
 				return c.JSON(http.StatusUnauthorized, map[string]string{"error": "invalid code"})
 			}
@@ -57,6 +66,7 @@ func LoginMFA(db *sqlx.DB) echo.HandlerFunc {
 			LIMIT 1
 		`, userID)
 		if err == sql.ErrNoRows {
+			auditMFAFailure(c, db, userID, loginKey, "no_active_code")
 			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "no active code"})
 		}
 		if err != nil {
@@ -66,6 +76,7 @@ func LoginMFA(db *sqlx.DB) echo.HandlerFunc {
 		// optional: lock after too many attempts
 		if ch.Attempts >= 5 {
 			_, _ = db.Exec(`UPDATE login_otp_challenges SET consumed_at = NOW() WHERE id = ?`, ch.ID)
+			auditMFAFailure(c, db, userID, loginKey, "too_many_attempts")
 			return c.JSON(http.StatusTooManyRequests, map[string]string{"error": "too many attempts"})
 		}
 
@@ -82,6 +93,7 @@ func LoginMFA(db *sqlx.DB) echo.HandlerFunc {
 
 		if ok == 0 {
 			_, _ = db.Exec(`UPDATE login_otp_challenges SET attempts = attempts + 1 WHERE id = ?`, ch.ID)
+			auditMFAFailure(c, db, userID, loginKey, "bad_code")
 			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "invalid code"})
 		}
 
@@ -90,25 +102,30 @@ func LoginMFA(db *sqlx.DB) echo.HandlerFunc {
 			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "consume error"})
 		}
 
-		var username string
-		_ = db.Get(&username, `SELECT username FROM users WHERE id = ?`, userID)
-
-		token, err := services.CreateJWT(userID, username, 24*time.Hour)
-		if err != nil {
+		if err := signIn(c, db, userID); err != nil {
+			if errors.Is(err, services.ErrAccountInactive) || errors.Is(err, services.ErrAccountLocked) {
+				auditMFAFailure(c, db, userID, loginKey, "account_disabled")
+				return c.JSON(http.StatusForbidden, map[string]string{"error": "account disabled"})
+			}
 			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "token error"})
 		}
 
-		cookie := &http.Cookie{
-			Name:     services.CookieName, // As used in login.go
-			Value:    token,
-			Path:     "/",
-			Expires:  time.Now().Add(24 * time.Hour),
-			HttpOnly: true,
-			SameSite: http.SameSiteStrictMode,
-			Secure:   false, // Set to true in HTTPS
-		}
-		c.SetCookie(cookie)
-
+		_, _ = db.Exec(`
+			INSERT INTO login_attempts (user_id, username, ip, success, mfa_method)
+			VALUES (?, ?, ?, 1, 'email_otp')
+		`, userID, loginKey, c.RealIP())
+		middlewarex.Audit(c, db, services.AuditEvent{
+			Type: services.AuditLoginMFA, ActorUserID: userID, ActorLabel: loginKey,
+			Details: map[string]any{"method": "email_otp"},
+		})
 		return c.JSON(http.StatusOK, map[string]string{"message": "ok"})
 	}
 }
+
+func auditMFAFailure(c echo.Context, db *sqlx.DB, userID int64, loginKey, reason string) {
+	middlewarex.Audit(c, db, services.AuditEvent{
+		Type: services.AuditLoginMFA, Outcome: services.AuditFailure,
+		ActorUserID: userID, ActorLabel: loginKey,
+		Details: map[string]any{"reason": reason},
+	})
+}
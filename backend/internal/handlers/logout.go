@@ -2,26 +2,33 @@ package handlers
 
 import (
 	"net/http"
-	"time"
 
+	middlewarex "secure-communication-ltd/backend/internal/middleware"
 	"secure-communication-ltd/backend/internal/services"
 
+	"github.com/jmoiron/sqlx"
 	"github.com/labstack/echo/v4"
 )
 
-func Logout() echo.HandlerFunc {
+func Logout(db *sqlx.DB) echo.HandlerFunc {
 	return func(c echo.Context) error {
+		// Revoke the session server-side too; audit only a real session
+		if claims := endCurrentSession(c, db); claims != nil {
+			middlewarex.Audit(c, db, services.AuditEvent{
+				Type: services.AuditLogout, ActorUserID: claims.UserID, ActorLabel: claims.Username,
+			})
+		}
+
+		// Leaving an impersonation session signs the admin out too
+		if _, err := c.Cookie(services.CookieName(services.ImpersonatorCookie, "/")); err == nil {
+			if admin := parkedAdminSession(c, db); admin != nil {
+				_ = services.RevokeSession(db, admin.UserID, admin.SessionID)
+			}
+			c.SetCookie(services.ExpiredCookie(services.ImpersonatorCookie))
+		}
+
 		// Delete the cookie (set expiration to the past)
-		c.SetCookie(&http.Cookie{
-			Name:     services.CookieName,
-			Value:    "",
-			Path:     "/",
-			Expires:  time.Unix(0, 0),
-			MaxAge:   -1,
-			HttpOnly: true,
-			SameSite: http.SameSiteStrictMode,
-			Secure:   false,
-		})
+		c.SetCookie(services.ExpiredCookie(services.SessionCookie))
 		return c.JSON(http.StatusOK, map[string]string{"message": "logged out"})
 	}
 }
@@ -1,26 +1,59 @@
 package handlers
 
 import (
+	"database/sql"
 	"net/http"
+	"time"
 
-	"secure-communication-ltd/backend/internal/services"
+	middlewarex "secure-communication-ltd/backend/internal/middleware"
 
+	"github.com/jmoiron/sqlx"
 	"github.com/labstack/echo/v4"
 )
 
-func Me() echo.HandlerFunc {
+func Me(db *sqlx.DB) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		cookie, err := c.Cookie(services.CookieName)
-		if err != nil || cookie.Value == "" {
-			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
-		}
-		claims, err := services.ParseJWT(cookie.Value)
+		claims, err := middlewarex.ClaimsFromCtx(c)
 		if err != nil {
 			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
 		}
+
+		// Last completed sign-in, from the login history rather than the token
+		var last struct {
+			At *time.Time `db:"attempt_time"`
+			IP *string    `db:"ip"`
+		}
+		err = db.Get(&last, `
+			SELECT attempt_time, ip FROM login_attempts
+			WHERE user_id = ? AND success = 1
+			ORDER BY attempt_time DESC, id DESC
+			LIMIT 1
+		`, claims.UserID)
+		if err != nil && err != sql.ErrNoRows {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+
+		// Banner for the UI: an admin is using this session on the user's behalf
+		var impersonation map[string]any
+		if claims.Impersonating() {
+			impersonation = map[string]any{
+				"impersonator_id":       claims.ImpersonatorID,
+				"impersonator_username": claims.ImpersonatorName,
+				"expires_at":            claims.ExpiresAt.Time.UTC(),
+			}
+		}
+
 		return c.JSON(http.StatusOK, map[string]any{
-			"user_id":  claims.UserID,
-			"username": claims.Username,
+			"user_id":       claims.UserID,
+			"username":      claims.Username,
+			"roles":         claims.Roles,
+			"permissions":   claims.Permissions,
+			"org_id":        claims.OrgID,
+			"auth_method":   claims.AuthMethod,
+			"last_login_at": last.At,
+			"last_login_ip": last.IP,
+			"impersonating": claims.Impersonating(),
+			"impersonation": impersonation,
 		})
 	}
 }
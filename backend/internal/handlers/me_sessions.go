@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	middlewarex "secure-communication-ltd/backend/internal/middleware"
+	"secure-communication-ltd/backend/internal/services"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo/v4"
+)
+
+type loginHistoryDTO struct {
+	Time      time.Time `db:"attempt_time" json:"time"`
+	IP        *string   `db:"ip" json:"ip"`
+	Success   bool      `db:"success" json:"success"`
+	MFAMethod *string   `db:"mfa_method" json:"mfa_method"` // email_otp or oidc; null for password-step failures
+}
+
+// MyLoginHistory lists the caller's recent sign-ins and failed password
+// attempts, newest first; page/size as in the audit log.
+func MyLoginHistory(db *sqlx.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		uid, err := middlewarex.UserIDFromCtx(c)
+		if err != nil {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		}
+		page := 1
+		size := 50
+		if p, err := strconv.Atoi(c.QueryParam("page")); err == nil && p > 0 {
+			page = p
+		}
+		if s, err := strconv.Atoi(c.QueryParam("size")); err == nil {
+			size = min(max(s, 1), 200)
+		}
+
+		var total int
+		if err := db.Get(&total, `SELECT COUNT(*) FROM login_attempts WHERE user_id = ?`, uid); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+		items := []loginHistoryDTO{}
+		if err := db.Select(&items, `
+			SELECT attempt_time, ip, success, mfa_method
+			FROM login_attempts
+			WHERE user_id = ?
+			ORDER BY attempt_time DESC, id DESC
+			LIMIT ? OFFSET ?
+		`, uid, size, (page-1)*size); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+		return c.JSON(http.StatusOK, map[string]any{
+			"items": items,
+			"page":  page,
+			"size":  size,
+			"total": total,
+		})
+	}
+}
+
+type sessionDTO struct {
+	ID           int64     `db:"id" json:"id"`
+	IP           string    `db:"ip" json:"ip"`
+	UserAgent    string    `db:"user_agent" json:"user_agent"`
+	Browser      string    `db:"-" json:"browser"`
+	CreatedAt    time.Time `db:"created_at" json:"created_at"`
+	ExpiresAt    time.Time `db:"expires_at" json:"expires_at"`
+	Current      bool      `db:"current" json:"current"`
+	Impersonated bool      `db:"impersonated" json:"impersonated"` // an admin used it on the user's behalf
+}
+
+// ListMySessions lists the caller's live sessions, newest first. The one
+// making the request is flagged current.
+func ListMySessions(db *sqlx.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		claims, err := middlewarex.ClaimsFromCtx(c)
+		if err != nil {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		}
+		rows := []sessionDTO{}
+		if err := db.Select(&rows, `
+			SELECT id, ip, user_agent, created_at, expires_at, sid = ? AS current,
+			       impersonator_id IS NOT NULL AS impersonated
+			FROM user_sessions
+			WHERE user_id = ? AND revoked_at IS NULL AND expires_at > NOW()
+			ORDER BY created_at DESC, id DESC
+		`, claims.SessionID, claims.UserID); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+		for i := range rows {
+			rows[i].Browser = describeUserAgent(rows[i].UserAgent)
+		}
+		return c.JSON(http.StatusOK, map[string]any{"items": rows})
+	}
+}
+
+// RevokeMySession signs out one of the caller's sessions. Revoking the
+// current one also clears the cookie, like logout.
+func RevokeMySession(db *sqlx.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		claims, err := middlewarex.ClaimsFromCtx(c)
+		if err != nil {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		}
+		id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid id"})
+		}
+		sid, ok, err := services.RevokeSessionByID(db, claims.UserID, id)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+		if !ok {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "session not found"})
+		}
+		current := sid == claims.SessionID
+		middlewarex.Audit(c, db, services.AuditEvent{
+			Type: services.AuditSessionRevoked, SubjectType: "session", SubjectID: c.Param("id"),
+			Details: map[string]any{"current": current},
+		})
+		if current {
+			c.SetCookie(services.ExpiredCookie(services.SessionCookie))
+		}
+		return c.JSON(http.StatusOK, map[string]any{"message": "session revoked", "current": current})
+	}
+}
+
+// RevokeMyOtherSessions signs out every session of the caller except the
+// one making the request.
+func RevokeMyOtherSessions(db *sqlx.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		claims, err := middlewarex.ClaimsFromCtx(c)
+		if err != nil {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		}
+		n, err := services.RevokeOtherSessions(db, claims.UserID, claims.SessionID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+		if n > 0 {
+			middlewarex.Audit(c, db, services.AuditEvent{
+				Type: services.AuditSessionRevoked, SubjectType: "user", SubjectID: strconv.FormatInt(claims.UserID, 10),
+				Details: map[string]any{"others": n},
+			})
+		}
+		return c.JSON(http.StatusOK, map[string]any{"revoked": n})
+	}
+}
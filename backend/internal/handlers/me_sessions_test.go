@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	middlewarex "secure-communication-ltd/backend/internal/middleware"
+	"secure-communication-ltd/backend/internal/services"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/labstack/echo/v4"
+)
+
+func withSession(c echo.Context, sid string) {
+	c.Get(middlewarex.CtxClaimsKey).(*services.Claims).SessionID = sid
+}
+
+func TestMyLoginHistoryPaging(t *testing.T) {
+	for _, tc := range []struct {
+		query              string
+		size, offset, page int
+	}{
+		{"", 50, 0, 1},
+		{"?page=3&size=20", 20, 40, 3},
+		{"?page=0&size=0", 1, 0, 1},
+		{"?page=-2&size=5000", 200, 0, 1},
+		{"?page=x&size=y", 50, 0, 1},
+	} {
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(`SELECT COUNT\(\*\) FROM login_attempts WHERE user_id = \?`).WithArgs(int64(7)).
+			WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(1))
+		mock.ExpectQuery(`FROM login_attempts\s+WHERE user_id = \?`).WithArgs(int64(7), tc.size, tc.offset).
+			WillReturnRows(sqlmock.NewRows([]string{"attempt_time", "ip", "success", "mfa_method"}).
+				AddRow(time.Now(), "198.51.100.7", true, "email_otp"))
+
+		c, rec := signedInContext(http.MethodGet, "/api/me/logins", "/api/me/logins"+tc.query, "", 7, 0)
+		if err := MyLoginHistory(db)(c); err != nil {
+			t.Fatal(err)
+		}
+		var got struct{ Page, Size, Total int }
+		if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &got) != nil ||
+			got.Page != tc.page || got.Size != tc.size || got.Total != 1 {
+			t.Errorf("%s: status %d: %s", tc.query, rec.Code, rec.Body)
+		}
+	}
+}
+
+func TestListMySessions(t *testing.T) {
+	db, mock := newMockDB(t)
+	mock.ExpectQuery(`FROM user_sessions\s+WHERE user_id = \? AND revoked_at IS NULL AND expires_at > NOW\(\)`).
+		WithArgs("sid-1", int64(7)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "ip", "user_agent", "created_at", "expires_at", "current", "impersonated"}).
+			AddRow(2, "198.51.100.7", "Mozilla/5.0 (Windows NT 10.0) AppleWebKit/537.36 Chrome/120.0 Safari/537.36 Edg/120.0",
+				time.Now(), time.Now().Add(time.Hour), true, false).
+			AddRow(1, "203.0.113.9", "Mozilla/5.0 (iPhone; CPU iPhone OS 17_0 like Mac OS X) Version/17.0 Mobile Safari/604.1",
+				time.Now(), time.Now().Add(time.Hour), false, true))
+
+	c, rec := signedInContext(http.MethodGet, "/api/me/sessions", "/api/me/sessions", "", 7, 0)
+	withSession(c, "sid-1")
+	if err := ListMySessions(db)(c); err != nil {
+		t.Fatal(err)
+	}
+	var got struct{ Items []sessionDTO }
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil || len(got.Items) != 2 {
+		t.Fatalf("status %d: %s", rec.Code, rec.Body)
+	}
+	if s := got.Items[0]; s.Browser != "Edge on Windows" || !s.Current || s.Impersonated {
+		t.Errorf("first session: %+v", s)
+	}
+	if s := got.Items[1]; s.Browser != "Safari on iOS" || s.Current || !s.Impersonated {
+		t.Errorf("second session: %+v", s)
+	}
+}
+
+func TestDescribeUserAgent(t *testing.T) {
+	for ua, want := range map[string]string{
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 14_0) AppleWebKit/605.1.15 Version/17.0 Safari/605.1.15": "Safari on macOS",
+		"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 Chrome/120.0 Safari/537.36":                  "Chrome on Linux",
+		"Mozilla/5.0 (Linux; Android 14) AppleWebKit/537.36 Chrome/120.0 Mobile Safari/537.36":           "Chrome on Android",
+		"Mozilla/5.0 (X11; CrOS x86_64 14541.0.0) AppleWebKit/537.36 Chrome/120.0 Safari/537.36":         "Chrome on ChromeOS",
+		"Mozilla/5.0 (Windows NT 10.0; rv:121.0) Gecko/20100101 Firefox/121.0":                           "Firefox on Windows",
+		"Mozilla/5.0 (Windows NT 10.0) AppleWebKit/537.36 Chrome/120.0 Safari/537.36 OPR/105.0":          "Opera on Windows",
+		"curl/8.4.0": "curl on unknown OS",
+		"":           "Unknown browser on unknown OS",
+	} {
+		if got := describeUserAgent(ua); got != want {
+			t.Errorf("describeUserAgent(%q) = %q, want %q", ua, got, want)
+		}
+	}
+}
+
+func TestRevokeMySession(t *testing.T) {
+	t.Run("current", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(`SELECT sid FROM user_sessions`).WithArgs(int64(2), int64(7)).
+			WillReturnRows(sqlmock.NewRows([]string{"sid"}).AddRow("sid-1"))
+		mock.ExpectExec(`UPDATE user_sessions SET revoked_at = NOW\(\)`).WithArgs("sid-1", int64(7)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		expectAudit(t, mock, services.AuditSessionRevoked, services.AuditSuccess)
+
+		c, rec := signedInContext(http.MethodDelete, "/api/me/sessions/:id", "/api/me/sessions/2", "", 7, 0, "id", "2")
+		withSession(c, "sid-1")
+		if err := RevokeMySession(db)(c); err != nil {
+			t.Fatal(err)
+		}
+		cookies := rec.Result().Cookies()
+		if rec.Code != http.StatusOK || len(cookies) != 1 || cookies[0].Name != services.SessionCookieName() || cookies[0].MaxAge >= 0 {
+			t.Fatalf("status %d, cookies %v: %s", rec.Code, cookies, rec.Body)
+		}
+	})
+	t.Run("another device", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(`SELECT sid FROM user_sessions`).WillReturnRows(sqlmock.NewRows([]string{"sid"}).AddRow("sid-2"))
+		mock.ExpectExec(`UPDATE user_sessions SET revoked_at = NOW\(\)`).WillReturnResult(sqlmock.NewResult(0, 1))
+		expectAudit(t, mock, services.AuditSessionRevoked, services.AuditSuccess)
+
+		c, rec := signedInContext(http.MethodDelete, "/api/me/sessions/:id", "/api/me/sessions/3", "", 7, 0, "id", "3")
+		withSession(c, "sid-1")
+		if err := RevokeMySession(db)(c); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != http.StatusOK || len(rec.Result().Cookies()) != 0 {
+			t.Fatalf("status %d, cookies %v", rec.Code, rec.Result().Cookies())
+		}
+	})
+	t.Run("not the caller's", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(`SELECT sid FROM user_sessions`).WithArgs(int64(9), int64(7)).
+			WillReturnRows(sqlmock.NewRows([]string{"sid"}))
+
+		c, rec := signedInContext(http.MethodDelete, "/api/me/sessions/:id", "/api/me/sessions/9", "", 7, 0, "id", "9")
+		if err := RevokeMySession(db)(c); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != http.StatusNotFound {
+			t.Fatalf("status = %d, want 404", rec.Code)
+		}
+	})
+}
+
+func TestRevokeMyOtherSessions(t *testing.T) {
+	for _, n := range []int64{0, 3} {
+		db, mock := newMockDB(t)
+		mock.ExpectExec(`WHERE user_id = \? AND sid <> \?`).WithArgs(int64(7), "sid-1").
+			WillReturnResult(sqlmock.NewResult(0, n))
+		if n > 0 { // audited only when something was revoked
+			expectAudit(t, mock, services.AuditSessionRevoked, services.AuditSuccess)
+		}
+
+		c, rec := signedInContext(http.MethodDelete, "/api/me/sessions", "/api/me/sessions", "", 7, 0)
+		withSession(c, "sid-1")
+		if err := RevokeMyOtherSessions(db)(c); err != nil {
+			t.Fatal(err)
+		}
+		var got struct{ Revoked int64 }
+		if json.Unmarshal(rec.Body.Bytes(), &got) != nil || got.Revoked != n {
+			t.Errorf("n=%d: status %d: %s", n, rec.Code, rec.Body)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"database/sql/driver"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	middlewarex "secure-communication-ltd/backend/internal/middleware"
+	"secure-communication-ltd/backend/internal/services"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo/v4"
+)
+
+func newMockDB(t *testing.T) (*sqlx.DB, sqlmock.Sqlmock) {
+	t.Helper()
+	raw, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		raw.Close()
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+	return sqlx.NewDb(raw, "mysql"), mock
+}
+
+// expectAudit expects one services.AppendAudit of typ with outcome.
+func expectAudit(t *testing.T, mock sqlmock.Sqlmock, typ services.AuditEventType, outcome string) {
+	t.Helper()
+	t.Setenv("AUDIT_HMAC_KEY", "test-audit-key")
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT last_hash FROM audit_chain_head`).
+		WillReturnRows(sqlmock.NewRows([]string{"last_hash"}).AddRow(services.AuditChainGenesis))
+	args := make([]driver.Value, 14)
+	for i := range args {
+		args[i] = sqlmock.AnyArg()
+	}
+	args[1], args[2] = string(typ), outcome
+	mock.ExpectExec(`INSERT INTO audit_log`).WithArgs(args...).WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectExec(`UPDATE audit_chain_head`).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+}
+
+// signedInContext is a request to route (e.g. "/api/customers/:id") as user
+// uid in organization orgID, as RequireAuth and RequireOrg leave it.
+func signedInContext(method, route, target, body string, uid, orgID int64, params ...string) (echo.Context, *httptest.ResponseRecorder) {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	if body != "" {
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	}
+	rec := httptest.NewRecorder()
+	e := echo.New()
+	e.Add(method, route, func(echo.Context) error { return nil }) // sizes the path parameters
+	c := e.NewContext(req, rec)
+	c.SetPath(route)
+	var names, values []string
+	for i := 0; i+1 < len(params); i += 2 {
+		names, values = append(names, params[i]), append(values, params[i+1])
+	}
+	c.SetParamNames(names...)
+	c.SetParamValues(values...)
+	c.Set(middlewarex.CtxUserIDKey, uid)
+	c.Set(middlewarex.CtxClaimsKey, &services.Claims{UserID: uid, Username: "alice", OrgID: orgID})
+	if orgID != 0 {
+		c.Set(middlewarex.CtxOrgIDKey, orgID)
+	}
+	return c, rec
+}
@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"html/template"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	middlewarex "secure-communication-ltd/backend/internal/middleware"
+	"secure-communication-ltd/backend/internal/services"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo/v4"
+)
+
+// signIn starts the session of a completed sign-in (after the OTP step, or
+// SSO) and warns the user by email when it comes from a device not seen
+// before. The warning is best-effort: it never fails the sign-in.
+func signIn(c echo.Context, db *sqlx.DB, userID int64) error {
+	if err := startSession(c, db, userID); err != nil {
+		return err
+	}
+	ip, ua := c.RealIP(), c.Request().UserAgent()
+	devID, isNew, err := services.RecordSignInDevice(db, userID, services.HashSHA256Hex(deviceID(c)), ip, ua)
+	if err != nil {
+		log.Printf("[new-device] record: %v", err)
+		return nil
+	}
+	if !isNew {
+		return nil
+	}
+	middlewarex.Audit(c, db, services.AuditEvent{
+		Type: services.AuditNewDeviceSignIn, ActorUserID: userID,
+		SubjectType: "device", SubjectID: strconv.FormatInt(devID, 10),
+		Details: map[string]any{"browser": describeUserAgent(ua)},
+	})
+	if err := sendNewDeviceAlert(db, userID, devID, ip, ua, time.Now()); err != nil {
+		log.Printf("[new-device] alert for user %d: %v", userID, err)
+	}
+	return nil
+}
+
+var newDeviceMailTpl = template.Must(template.New("newDevice").Parse(`
+<h2>New sign-in to your account</h2>
+<p>Hi {{.Username}}, your account was just signed in to from a device we haven't seen before.</p>
+<table cellpadding="4">
+  <tr><td><b>Time</b></td><td>{{.When}}</td></tr>
+  <tr><td><b>IP address</b></td><td>{{.IP}}</td></tr>
+  <tr><td><b>Browser</b></td><td>{{.Browser}}</td></tr>
+  <tr><td><b>User agent</b></td><td><code>{{.UserAgent}}</code></td></tr>
+</table>
+<p>If this was you, you can ignore this email.</p>
+<p>If it wasn't, secure your account now: this signs out every session, revokes your API keys and app authorizations, locks the account and sends you a password reset link.</p>
+<p>
+  <a href="{{.Link}}" style="display:inline-block;padding:10px 16px;border-radius:8px;background:#ff6b6b;color:#fff;text-decoration:none">
+    This wasn't me
+  </a>
+</p>
+<p>If the button doesn't work, copy this URL:</p>
+<p><code>{{.Link}}</code></p>
+<p>This link can be used once and expires on {{.Expires}}.</p>
+`))
+
+func sendNewDeviceAlert(db *sqlx.DB, userID, devID int64, ip, ua string, when time.Time) error {
+	var u struct {
+		Username string `db:"username"`
+		Email    string `db:"email"`
+	}
+	if err := db.Get(&u, `SELECT username, email FROM users WHERE id = ?`, userID); err != nil {
+		return err
+	}
+	expires := when.Add(services.LockdownLinkTTL)
+	token, err := services.NewLockdownToken(userID, devID, expires)
+	if err != nil {
+		return err
+	}
+	base := os.Getenv("BACKEND_PUBLIC_URL")
+	if base == "" {
+		base = "http://localhost:8080"
+	}
+	link := strings.TrimRight(base, "/") + "/api/security/lockdown?token=" + url.QueryEscape(token)
+
+	mailer, err := services.NewMailerFromEnv()
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := newDeviceMailTpl.Execute(&buf, map[string]string{
+		"Username":  u.Username,
+		"When":      when.UTC().Format("2006-01-02 15:04:05 UTC"),
+		"IP":        ip,
+		"Browser":   describeUserAgent(ua),
+		"UserAgent": ua,
+		"Link":      link,
+		"Expires":   expires.UTC().Format("2006-01-02 15:04 UTC"),
+	}); err != nil {
+		return err
+	}
+	return mailer.Send(u.Email, "New sign-in to your account", buf.String())
+}
+
+// describeUserAgent gives a short "Browser on OS" summary for people; the
+// full string is shown next to it. Order matters: Edge and Opera also claim
+// Chrome, and Chrome also claims Safari.
+func describeUserAgent(ua string) string {
+	pick := func(rules [][2]string, def string) string {
+		for _, r := range rules {
+			if strings.Contains(ua, r[0]) {
+				return r[1]
+			}
+		}
+		return def
+	}
+	browser := pick([][2]string{
+		{"Edg/", "Edge"}, {"OPR/", "Opera"}, {"Firefox/", "Firefox"},
+		{"Chrome/", "Chrome"}, {"Safari/", "Safari"}, {"curl/", "curl"},
+	}, "Unknown browser")
+	osName := pick([][2]string{
+		{"Windows", "Windows"}, {"Android", "Android"}, {"iPhone", "iOS"}, {"iPad", "iPadOS"},
+		{"Mac OS X", "macOS"}, {"CrOS", "ChromeOS"}, {"Linux", "Linux"},
+	}, "unknown OS")
+	return browser + " on " + osName
+}
+
+// SecurityLockdownPage is the "this wasn't me" landing page from a
+// new-device alert. It only asks for confirmation: mail scanners fetch links
+// in emails, so following the link must not change anything.
+func SecurityLockdownPage() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		token := c.QueryParam("token")
+		if _, _, err := services.ParseLockdownToken(token); err != nil {
+			return RenderVerificationPage(c, http.StatusBadRequest, false,
+				"Invalid Link", "This link is not valid or has expired.")
+		}
+		return RenderLockdownPage(c, lockdownPageData{
+			Action:    "/api/security/lockdown",
+			Token:     token,
+			CSRFToken: middlewarex.CSRFToken(c),
+		})
+	}
+}
+
+// SecurityLockdown handles the confirmation form: it locks the account,
+// revokes all sessions and credentials, and emails a password reset link.
+// Resetting the password unlocks the account.
+func SecurityLockdown(db *sqlx.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		userID, devID, err := services.ParseLockdownToken(c.FormValue("token"))
+		if err != nil {
+			return RenderVerificationPage(c, http.StatusBadRequest, false,
+				"Invalid Link", "This link is not valid or has expired.")
+		}
+		err = services.LockDownAccount(db, userID, devID)
+		if errors.Is(err, services.ErrLockdownLink) {
+			return RenderVerificationPage(c, http.StatusBadRequest, false,
+				"Already Used", "This link was already used. Check your email for the password reset link.")
+		}
+		if err != nil {
+			return RenderVerificationPage(c, http.StatusInternalServerError, false,
+				"Server Error", "Your account could not be secured. Please try again or contact support.")
+		}
+
+		var email string
+		if err := db.Get(&email, `SELECT email FROM users WHERE id = ?`, userID); err == nil {
+			if err := sendPasswordReset(db, userID, email); err != nil {
+				log.Printf("[lockdown] reset mail for user %d: %v", userID, err)
+			}
+		}
+		middlewarex.Audit(c, db, services.AuditEvent{
+			Type: services.AuditAccountLockdown, ActorUserID: userID,
+			SubjectType: "user", SubjectID: strconv.FormatInt(userID, 10),
+			Details: map[string]any{"device_id": devID},
+		})
+		c.SetCookie(services.ExpiredCookie(services.SessionCookie))
+
+		return RenderVerificationPage(c, http.StatusOK, true, "Account Secured",
+			"All sessions were signed out and your account is locked. We sent you a link to set a new password; "+
+				"the account unlocks once you do.")
+	}
+}
@@ -0,0 +1,336 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	middlewarex "secure-communication-ltd/backend/internal/middleware"
+	"secure-communication-ltd/backend/internal/services"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo/v4"
+)
+
+// oauthRedirect sends the user agent back to the client with result params,
+// keeping any query the registered redirect URI already has.
+func oauthRedirect(c echo.Context, redirectURI string, params url.Values) error {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		return RenderVerificationPage(c, http.StatusBadRequest, false, "Authorization failed", "Invalid redirect URI.")
+	}
+	q := u.Query()
+	for k, vs := range params {
+		for _, v := range vs {
+			if v != "" {
+				q.Set(k, v)
+			}
+		}
+	}
+	u.RawQuery = q.Encode()
+	return c.Redirect(http.StatusFound, u.String())
+}
+
+func oauthRedirectError(c echo.Context, redirectURI, state, code, desc string) error {
+	return oauthRedirect(c, redirectURI, url.Values{"error": {code}, "error_description": {desc}, "state": {state}})
+}
+
+// oauthSession resolves the signed-in browser user for the authorization
+// endpoint. Only an interactive session can grant consent.
+func oauthSession(db *sqlx.DB, c echo.Context) (*services.Claims, error) {
+	claims, err := middlewarex.Authenticate(db, c)
+	if err != nil {
+		return nil, err
+	}
+	if claims.AuthMethod != services.AuthSession {
+		return nil, middlewarex.ErrUnauthenticated
+	}
+	return claims, nil
+}
+
+// impersonationRefused answers a consent request made in an impersonation
+// session: granting applications access is the user's own decision.
+func impersonationRefused(c echo.Context) error {
+	return RenderVerificationPage(c, http.StatusForbidden, false,
+		"Not available", "Applications cannot be authorized while impersonating a user.")
+}
+
+// OAuthAuthorize validates an authorization request and shows the consent screen.
+// Errors before the client and redirect URI are verified are shown to the user;
+// later errors are returned to the client by redirect (RFC 6749 4.1.2.1).
+func OAuthAuthorize(db *sqlx.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		claims, err := oauthSession(db, c)
+		if errors.Is(err, middlewarex.ErrUnauthenticated) {
+			return RenderVerificationPage(c, http.StatusUnauthorized, false,
+				"Sign in required", "Please sign in, then open the authorization link again.")
+		}
+		if err != nil {
+			return RenderVerificationPage(c, http.StatusInternalServerError, false, "Authorization failed", "Please try again.")
+		}
+		if claims.Impersonating() {
+			return impersonationRefused(c)
+		}
+
+		client, err := services.LoadOAuthClient(db, c.QueryParam("client_id"))
+		if err != nil || !client.AllowsGrant(services.GrantAuthorizationCode) {
+			return RenderVerificationPage(c, http.StatusBadRequest, false,
+				"Authorization failed", "Unknown application.")
+		}
+		redirectURI := c.QueryParam("redirect_uri")
+		if redirectURI == "" && len(client.RedirectURIList()) == 1 {
+			redirectURI = client.RedirectURIList()[0]
+		}
+		if !client.HasRedirectURI(redirectURI) {
+			return RenderVerificationPage(c, http.StatusBadRequest, false,
+				"Authorization failed", "The application sent an unregistered redirect URI.")
+		}
+
+		state := c.QueryParam("state")
+		if c.QueryParam("response_type") != "code" {
+			return oauthRedirectError(c, redirectURI, state, "unsupported_response_type", "only response_type=code is supported")
+		}
+		challenge := c.QueryParam("code_challenge")
+		if challenge == "" || c.QueryParam("code_challenge_method") != "S256" {
+			return oauthRedirectError(c, redirectURI, state, "invalid_request", "PKCE with code_challenge_method=S256 is required")
+		}
+
+		requested := services.ParseScope(c.QueryParam("scope"))
+		if len(requested) == 0 {
+			return oauthRedirectError(c, redirectURI, state, "invalid_scope", "scope is required")
+		}
+		_, userPerms, err := services.LoadUserAccess(db, claims.UserID)
+		if err != nil {
+			return oauthRedirectError(c, redirectURI, state, "server_error", "")
+		}
+		// Grant what the client may ask for and the user actually has
+		granted := []string{}
+		for _, s := range requested {
+			if !services.HasPermission(client.ScopeList(), s) {
+				return oauthRedirectError(c, redirectURI, state, "invalid_scope", "scope not allowed for this client: "+s)
+			}
+			if services.HasPermission(userPerms, s) {
+				granted = append(granted, s)
+			}
+		}
+		if len(granted) == 0 {
+			return oauthRedirectError(c, redirectURI, state, "access_denied", "the user holds none of the requested scopes")
+		}
+
+		var orgID *int64
+		if claims.OrgID != 0 {
+			orgID = &claims.OrgID
+		}
+		handle, err := services.CreateOAuthConsentRequest(db, &services.OAuthConsentRequest{
+			ClientID:      client.ID,
+			UserID:        claims.UserID,
+			OrgID:         orgID,
+			RedirectURI:   redirectURI,
+			Scopes:        strings.Join(granted, ","),
+			State:         state,
+			CodeChallenge: challenge,
+		})
+		if err != nil {
+			return oauthRedirectError(c, redirectURI, state, "server_error", "")
+		}
+
+		scopes := []consentScope{}
+		q, args, err := sqlx.In(`SELECT name, description FROM permissions WHERE name IN (?) ORDER BY name`, granted)
+		if err == nil {
+			err = db.Select(&scopes, db.Rebind(q), args...)
+		}
+		if err != nil {
+			return oauthRedirectError(c, redirectURI, state, "server_error", "")
+		}
+		host := redirectURI
+		if u, err := url.Parse(redirectURI); err == nil {
+			host = u.Host
+		}
+		return RenderConsentPage(c, consentPageData{
+			ClientName:   client.Name,
+			Username:     claims.Username,
+			Scopes:       scopes,
+			RedirectHost: host,
+			Handle:       handle,
+			Action:       "/oauth/authorize",
+			CSRFToken:    middlewarex.CSRFToken(c),
+			RedirectURI:  redirectURI,
+		})
+	}
+}
+
+// OAuthAuthorizeDecision handles the consent form and redirects back to the client.
+func OAuthAuthorizeDecision(db *sqlx.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		claims, err := oauthSession(db, c)
+		if err != nil {
+			return RenderVerificationPage(c, http.StatusUnauthorized, false,
+				"Sign in required", "Your session has ended. Please sign in and start again.")
+		}
+		if claims.Impersonating() {
+			return impersonationRefused(c)
+		}
+		req, err := services.ConsumeOAuthConsentRequest(db, c.FormValue("consent"), claims.UserID)
+		if errors.Is(err, services.ErrOAuthConsent) {
+			return RenderVerificationPage(c, http.StatusBadRequest, false,
+				"Authorization failed", "This request has expired or was already answered. Please start again.")
+		}
+		if err != nil {
+			return RenderVerificationPage(c, http.StatusInternalServerError, false, "Authorization failed", "Please try again.")
+		}
+
+		if c.FormValue("decision") != "allow" {
+			return oauthRedirectError(c, req.RedirectURI, req.State, "access_denied", "the user denied the request")
+		}
+		code, err := services.IssueOAuthCode(db, req)
+		if err != nil {
+			return oauthRedirectError(c, req.RedirectURI, req.State, "server_error", "")
+		}
+		return oauthRedirect(c, req.RedirectURI, url.Values{"code": {code}, "state": {req.State}})
+	}
+}
+
+// oauthClientFromRequest authenticates the client with HTTP Basic or
+// client_id/client_secret form fields (RFC 6749 2.3.1).
+func oauthClientFromRequest(db *sqlx.DB, c echo.Context) (*services.OAuthClient, error) {
+	id, secret, ok := c.Request().BasicAuth()
+	if ok {
+		if v, err := url.QueryUnescape(id); err == nil {
+			id = v
+		}
+		if v, err := url.QueryUnescape(secret); err == nil {
+			secret = v
+		}
+	} else {
+		id, secret = c.FormValue("client_id"), c.FormValue("client_secret")
+	}
+	return services.AuthenticateOAuthClient(db, id, secret)
+}
+
+func oauthErrorJSON(c echo.Context, err error) error {
+	var oe *services.OAuthError
+	if !errors.As(err, &oe) {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+	}
+	status := http.StatusBadRequest
+	if oe.Code == "invalid_client" {
+		status = http.StatusUnauthorized
+		c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Basic realm="oauth"`)
+	}
+	return c.JSON(status, map[string]string{"error": oe.Code, "error_description": oe.Description})
+}
+
+// OAuthToken is the token endpoint (authorization_code, client_credentials).
+func OAuthToken(db *sqlx.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		c.Response().Header().Set(echo.HeaderCacheControl, "no-store")
+		c.Response().Header().Set("Pragma", "no-cache")
+
+		client, err := oauthClientFromRequest(db, c)
+		if err != nil {
+			return oauthErrorJSON(c, err)
+		}
+
+		var resp *services.OAuthTokenResponse
+		switch c.FormValue("grant_type") {
+		case services.GrantAuthorizationCode:
+			resp, err = services.RedeemOAuthCode(db, client, c.FormValue("code"),
+				c.FormValue("redirect_uri"), c.FormValue("code_verifier"))
+		case services.GrantClientCredentials:
+			resp, err = services.IssueClientCredentialsToken(db, client, c.FormValue("scope"))
+		default:
+			err = &services.OAuthError{Code: "unsupported_grant_type", Description: "unsupported grant_type"}
+		}
+		if err != nil {
+			return oauthErrorJSON(c, err)
+		}
+		return c.JSON(http.StatusOK, resp)
+	}
+}
+
+// OAuthIntrospect is the token introspection endpoint (confidential clients only).
+func OAuthIntrospect(db *sqlx.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		client, err := oauthClientFromRequest(db, c)
+		if err != nil {
+			return oauthErrorJSON(c, err)
+		}
+		if !client.Confidential() {
+			return oauthErrorJSON(c, &services.OAuthError{Code: "invalid_client", Description: "introspection requires a confidential client"})
+		}
+		out, err := services.IntrospectOAuthToken(db, client, c.FormValue("token"))
+		if err != nil {
+			return oauthErrorJSON(c, err)
+		}
+		return c.JSON(http.StatusOK, out)
+	}
+}
+
+// OAuthRevoke is the token revocation endpoint.
+func OAuthRevoke(db *sqlx.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		client, err := oauthClientFromRequest(db, c)
+		if err != nil {
+			return oauthErrorJSON(c, err)
+		}
+		if err := services.RevokeOAuthToken(db, client, c.FormValue("token")); err != nil {
+			return oauthErrorJSON(c, err)
+		}
+		return c.NoContent(http.StatusOK)
+	}
+}
+
+type oauthGrantDTO struct {
+	ClientID   string     `db:"client_id" json:"client_id"`
+	ClientName string     `db:"name" json:"client_name"`
+	Scopes     string     `db:"scopes" json:"-"`
+	ScopeList  []string   `db:"-" json:"scopes"`
+	GrantedAt  time.Time  `db:"granted_at" json:"granted_at"`
+	LastUsedAt *time.Time `db:"last_used_at" json:"last_used_at"`
+}
+
+// ListMyOAuthGrants lists applications holding a live access token for the caller.
+func ListMyOAuthGrants(db *sqlx.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		uid, err := middlewarex.UserIDFromCtx(c)
+		if err != nil {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		}
+		rows := []oauthGrantDTO{}
+		if err := db.Select(&rows, `
+			SELECT c.client_id, c.name, GROUP_CONCAT(DISTINCT t.scopes) AS scopes,
+			       MIN(t.created_at) AS granted_at, MAX(t.last_used_at) AS last_used_at
+			FROM oauth_access_tokens t
+			JOIN oauth_clients c ON c.id = t.client_id
+			WHERE t.user_id = ? AND t.revoked_at IS NULL AND t.expires_at > NOW() AND c.revoked_at IS NULL
+			GROUP BY c.client_id, c.name
+			ORDER BY c.name
+		`, uid); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+		for i := range rows {
+			rows[i].ScopeList = services.ParseScope(strings.ReplaceAll(rows[i].Scopes, ",", " "))
+		}
+		return c.JSON(http.StatusOK, map[string]any{"items": rows})
+	}
+}
+
+// RevokeMyOAuthGrant revokes every token the caller granted to one application.
+func RevokeMyOAuthGrant(db *sqlx.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		uid, err := middlewarex.UserIDFromCtx(c)
+		if err != nil {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		}
+		if _, err := db.Exec(`
+			UPDATE oauth_access_tokens t JOIN oauth_clients c ON c.id = t.client_id
+			SET t.revoked_at = NOW()
+			WHERE t.user_id = ? AND c.client_id = ? AND t.revoked_at IS NULL
+		`, uid, c.Param("client_id")); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+		return c.JSON(http.StatusOK, map[string]string{"message": "access revoked"})
+	}
+}
@@ -0,0 +1,200 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	middlewarex "secure-communication-ltd/backend/internal/middleware"
+	"secure-communication-ltd/backend/internal/services"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo/v4"
+)
+
+type createOAuthClientRequest struct {
+	Name          string   `json:"name"`
+	RedirectURIs  []string `json:"redirect_uris"`
+	Scopes        []string `json:"scopes"`
+	GrantTypes    []string `json:"grant_types"`  // default: ["authorization_code"]
+	Confidential  *bool    `json:"confidential"` // default: true
+	ServiceUserID int64    `json:"service_user_id,omitempty"`
+}
+
+type oauthClientDTO struct {
+	services.OAuthClient
+	RedirectURIs []string `json:"redirect_uris"`
+	Scopes       []string `json:"scopes"`
+	GrantTypes   []string `json:"grant_types"`
+	Confidential bool     `json:"confidential"`
+}
+
+func toOAuthClientDTO(c services.OAuthClient) oauthClientDTO {
+	return oauthClientDTO{
+		OAuthClient:  c,
+		RedirectURIs: c.RedirectURIList(),
+		Scopes:       c.ScopeList(),
+		GrantTypes:   c.GrantTypeList(),
+		Confidential: c.Confidential(),
+	}
+}
+
+// AdminCreateOAuthClient registers a third-party application. The client
+// secret (confidential clients) is returned once.
+func AdminCreateOAuthClient(db *sqlx.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		actor, err := middlewarex.UserIDFromCtx(c)
+		if err != nil {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		}
+		var req createOAuthClientRequest
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid json"})
+		}
+		req.Name = strings.TrimSpace(req.Name)
+		if req.Name == "" || utf8.RuneCountInString(req.Name) > 100 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "name is required (max 100 characters)"})
+		}
+		confidential := req.Confidential == nil || *req.Confidential
+		if len(req.GrantTypes) == 0 {
+			req.GrantTypes = []string{services.GrantAuthorizationCode}
+		}
+
+		grants := map[string]bool{}
+		for _, g := range req.GrantTypes {
+			if g != services.GrantAuthorizationCode && g != services.GrantClientCredentials {
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": "unsupported grant type: " + g})
+			}
+			grants[g] = true
+		}
+		if grants[services.GrantAuthorizationCode] {
+			if len(req.RedirectURIs) == 0 {
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": "redirect_uris are required for authorization_code"})
+			}
+			for _, u := range req.RedirectURIs {
+				if strings.ContainsAny(u, "\r\n") || !services.ValidRedirectURI(u) {
+					return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid redirect URI: " + u})
+				}
+			}
+		}
+		var serviceUser any
+		if grants[services.GrantClientCredentials] {
+			if !confidential {
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": "client_credentials requires a confidential client"})
+			}
+			var isService bool
+			err := db.Get(&isService, `SELECT is_service_account FROM users WHERE id = ?`, req.ServiceUserID)
+			if errors.Is(err, sql.ErrNoRows) || (err == nil && !isService) {
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": "client_credentials requires service_user_id of a service account"})
+			}
+			if err != nil {
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+			}
+			serviceUser = req.ServiceUserID
+		}
+
+		var known []string
+		if err := db.Select(&known, `SELECT name FROM permissions`); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+		scopes := services.ParseScope(strings.Join(req.Scopes, " "))
+		if len(scopes) == 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "at least one scope is required"})
+		}
+		for _, s := range scopes {
+			if !services.HasPermission(known, s) {
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": "unknown scope: " + s})
+			}
+		}
+
+		clientID, secret, secretHash, err := services.NewOAuthClientCredentials(confidential)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "token error"})
+		}
+		grantList := make([]string, 0, len(grants))
+		for _, g := range []string{services.GrantAuthorizationCode, services.GrantClientCredentials} {
+			if grants[g] {
+				grantList = append(grantList, g)
+			}
+		}
+		res, err := db.Exec(`
+			INSERT INTO oauth_clients (client_id, name, secret_sha256, redirect_uris, scopes, grant_types, service_user_id, created_by)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		`, clientID, req.Name, secretHash, strings.Join(req.RedirectURIs, "\n"), strings.Join(scopes, ","),
+			strings.Join(grantList, ","), serviceUser, actor)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+		id, _ := res.LastInsertId()
+
+		out := map[string]any{
+			"id":            id,
+			"client_id":     clientID,
+			"name":          req.Name,
+			"redirect_uris": req.RedirectURIs,
+			"scopes":        scopes,
+			"grant_types":   grantList,
+			"confidential":  confidential,
+		}
+		if confidential {
+			out["client_secret"] = secret
+			out["message"] = "Store the client secret now; it will not be shown again."
+		}
+		return c.JSON(http.StatusCreated, out)
+	}
+}
+
+// AdminListOAuthClients lists registered applications.
+func AdminListOAuthClients(db *sqlx.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var rows []services.OAuthClient
+		if err := db.Select(&rows, `
+			SELECT id, client_id, name, secret_sha256, redirect_uris, scopes, grant_types,
+			       service_user_id, created_at, revoked_at
+			FROM oauth_clients
+			ORDER BY created_at DESC
+		`); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+		items := make([]oauthClientDTO, 0, len(rows))
+		for _, r := range rows {
+			items = append(items, toOAuthClientDTO(r))
+		}
+		return c.JSON(http.StatusOK, map[string]any{"items": items})
+	}
+}
+
+// AdminRevokeOAuthClient disables an application and all of its tokens.
+func AdminRevokeOAuthClient(db *sqlx.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil || id <= 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid client id"})
+		}
+		tx, err := db.Beginx()
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "tx error"})
+		}
+		defer tx.Rollback()
+
+		res, err := tx.Exec(`UPDATE oauth_clients SET revoked_at = NOW() WHERE id = ? AND revoked_at IS NULL`, id)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "client not found"})
+		}
+		if _, err := tx.Exec(`
+			UPDATE oauth_access_tokens SET revoked_at = NOW() WHERE client_id = ? AND revoked_at IS NULL
+		`, id); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+		if err := tx.Commit(); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "commit error"})
+		}
+		return c.JSON(http.StatusOK, map[string]string{"message": "client revoked"})
+	}
+}
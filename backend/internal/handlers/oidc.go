@@ -0,0 +1,185 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	middlewarex "secure-communication-ltd/backend/internal/middleware"
+	"secure-communication-ltd/backend/internal/services"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo/v4"
+)
+
+// The raw state also lives in a short-lived cookie so a callback can only be
+// completed by the browser that started the sign-in (login CSRF protection).
+const (
+	oidcStateCookie     = "oidc_state"
+	oidcStateCookiePath = "/api/auth/oidc"
+	oidcDefaultRedirect = "/dashboard"
+)
+
+// AuthConfig tells the sign-in page which methods are available. p is nil when SSO is not configured.
+func AuthConfig(p *services.OIDCProvider) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		out := map[string]any{
+			"local_login_enabled": services.LocalLoginEnabled(),
+			"registration_mode":   services.RegistrationMode(),
+			"oidc_enabled":        p != nil,
+		}
+		if p != nil {
+			out["oidc_provider_name"] = p.Config.ProviderName
+			out["oidc_login_url"] = "/api/auth/oidc/login"
+		}
+		return c.JSON(http.StatusOK, out)
+	}
+}
+
+// safeRedirectPath only accepts a local path on the frontend ("/x", not "//host" or "/\host").
+func safeRedirectPath(p string) string {
+	if p == "" || !strings.HasPrefix(p, "/") || strings.HasPrefix(p, "//") ||
+		strings.ContainsAny(p, "\\\r\n") || len(p) > 255 {
+		return oidcDefaultRedirect
+	}
+	return p
+}
+
+// OIDCLogin starts the authorization code flow and redirects to the provider.
+// ?redirect=/path chooses where the frontend lands afterwards.
+func OIDCLogin(db *sqlx.DB, p *services.OIDCProvider) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		state, st, err := services.NewOIDCLoginState(db, safeRedirectPath(c.QueryParam("redirect")))
+		if err != nil {
+			return RenderVerificationPage(c, http.StatusInternalServerError, false,
+				"Sign-in unavailable", "Could not start single sign-on. Please try again.")
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request().Context(), 10*time.Second)
+		defer cancel()
+		authURL, err := p.AuthCodeURL(ctx, state, st.Nonce, st.CodeVerifier)
+		if err != nil {
+			log.Printf("[oidc] %v", err)
+			return RenderVerificationPage(c, http.StatusBadGateway, false,
+				"Sign-in unavailable", "The identity provider is not reachable. Please try again later.")
+		}
+
+		// Lax: the cookie must come back on the top-level redirect from the provider
+		c.SetCookie(services.NewCookie(oidcStateCookie, state, 10*time.Minute,
+			services.WithCookiePath(oidcStateCookiePath), services.WithSameSiteLax()))
+		c.Response().Header().Set(echo.HeaderCacheControl, "no-store")
+		return c.Redirect(http.StatusFound, authURL)
+	}
+}
+
+// OIDCCallback completes the flow: checks state, redeems the code, validates
+// the ID token, maps the identity to a user and starts the normal session.
+func OIDCCallback(db *sqlx.DB, p *services.OIDCProvider) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		fail := func(code int, msg string) error {
+			return RenderVerificationPage(c, code, false, "Sign-in failed", msg)
+		}
+
+		// Always clear the state cookie; it is single use
+		c.SetCookie(services.ExpiredCookie(oidcStateCookie,
+			services.WithCookiePath(oidcStateCookiePath), services.WithSameSiteLax()))
+
+		if e := c.QueryParam("error"); e != "" {
+			return fail(http.StatusUnauthorized, "The identity provider did not complete the sign-in.")
+		}
+		state := c.QueryParam("state")
+		code := c.QueryParam("code")
+		ck, err := c.Cookie(services.CookieName(oidcStateCookie, oidcStateCookiePath))
+		if state == "" || code == "" || err != nil ||
+			subtle.ConstantTimeCompare([]byte(ck.Value), []byte(state)) != 1 {
+			return fail(http.StatusBadRequest, "This sign-in link is invalid or was started in another browser.")
+		}
+
+		st, err := services.ConsumeOIDCLoginState(db, state)
+		if errors.Is(err, services.ErrOIDCState) {
+			return fail(http.StatusBadRequest, "This sign-in has expired. Please start again.")
+		}
+		if err != nil {
+			return fail(http.StatusInternalServerError, "Something went wrong. Please try again.")
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request().Context(), 10*time.Second)
+		defer cancel()
+		rawIDToken, err := p.Exchange(ctx, code, st.CodeVerifier)
+		if err != nil {
+			log.Printf("[oidc] %v", err)
+			return fail(http.StatusBadGateway, "The identity provider rejected the sign-in.")
+		}
+		ident, err := p.VerifyIDToken(ctx, rawIDToken, st.Nonce)
+		if err != nil {
+			log.Printf("[oidc] %v", err)
+			auditOIDCFailure(c, db, "", "id_token_invalid")
+			return fail(http.StatusUnauthorized, "The identity provider response could not be verified.")
+		}
+
+		uid, err := services.ResolveOIDCUser(db, p.Config, ident)
+		switch {
+		case errors.Is(err, services.ErrOIDCDomainForbidden):
+			auditOIDCFailure(c, db, ident.Email, "domain_forbidden")
+		case errors.Is(err, services.ErrOIDCNotProvisioned):
+			auditOIDCFailure(c, db, ident.Email, "not_provisioned")
+		case errors.Is(err, services.ErrOIDCAccountDisabled):
+			auditOIDCFailure(c, db, ident.Email, "account_disabled")
+		}
+		switch {
+		case errors.Is(err, services.ErrOIDCDomainForbidden):
+			return fail(http.StatusForbidden, "Your email domain is not allowed to sign in here.")
+		case errors.Is(err, services.ErrOIDCNotProvisioned):
+			return fail(http.StatusForbidden, "No account is set up for you yet. Please contact an administrator.")
+		case errors.Is(err, services.ErrOIDCAccountDisabled):
+			return fail(http.StatusForbidden, "This account is disabled.")
+		case err != nil:
+			return fail(http.StatusInternalServerError, "Something went wrong. Please try again.")
+		}
+
+		_, _ = db.Exec(`
+			INSERT INTO login_attempts (user_id, username, ip, success, mfa_method)
+			VALUES (?, ?, ?, 1, 'oidc')
+		`, uid, "oidc:"+truncateRunes(ident.Subject, 145), c.RealIP())
+
+		if err := signIn(c, db, uid); err != nil {
+			return fail(http.StatusInternalServerError, "Something went wrong. Please try again.")
+		}
+		middlewarex.Audit(c, db, services.AuditEvent{
+			Type: services.AuditLoginOIDC, ActorUserID: uid, ActorLabel: ident.Email,
+			Details: map[string]any{"issuer": p.Config.Issuer},
+		})
+
+		fe := os.Getenv("FRONTEND_PUBLIC_URL")
+		if fe == "" {
+			fe = "http://localhost:3000"
+		}
+		return c.Redirect(http.StatusFound, strings.TrimRight(fe, "/")+st.RedirectTo)
+	}
+}
+
+func auditOIDCFailure(c echo.Context, db *sqlx.DB, label, reason string) {
+	middlewarex.Audit(c, db, services.AuditEvent{
+		Type: services.AuditLoginOIDC, Outcome: services.AuditDenied, ActorLabel: label,
+		Details: map[string]any{"reason": reason},
+	})
+}
+
+func truncateRunes(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n])
+}
+
+// localLoginDisabled is the response for password endpoints when
+// LOCAL_LOGIN_ENABLED=false.
+func localLoginDisabled(c echo.Context) error {
+	return c.JSON(http.StatusForbidden, map[string]string{"error": "password sign-in is disabled; use single sign-on"})
+}
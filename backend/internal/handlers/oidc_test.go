@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"secure-communication-ltd/backend/internal/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// A callback whose state differs from the browser's state cookie is refused
+// before the state is looked up or the code is redeemed.
+func TestOIDCCallbackStateMismatch(t *testing.T) {
+	var calls atomic.Int32
+	idp := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		http.NotFound(w, r)
+	}))
+	defer idp.Close()
+	p := services.NewOIDCProvider(services.OIDCConfig{Issuer: idp.URL, ClientID: "backend"}, idp.Client())
+
+	for name, cookie := range map[string]string{"other state": "state-a", "no cookie": ""} {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/auth/oidc/callback?state=state-b&code=code-1", nil)
+			if cookie != "" {
+				req.AddCookie(&http.Cookie{Name: services.CookieName(oidcStateCookie, oidcStateCookiePath), Value: cookie})
+			}
+			rec := httptest.NewRecorder()
+			c := echo.New().NewContext(req, rec)
+			// nil db: the handler must not get as far as the state table
+			if err := OIDCCallback(nil, p)(c); err != nil {
+				t.Fatal(err)
+			}
+			if rec.Code != http.StatusBadRequest {
+				t.Fatalf("status = %d, want 400", rec.Code)
+			}
+		})
+	}
+	if n := calls.Load(); n != 0 {
+		t.Fatalf("provider contacted %d times", n)
+	}
+}
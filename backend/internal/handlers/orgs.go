@@ -0,0 +1,229 @@
+package handlers
+
+import (
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	middlewarex "secure-communication-ltd/backend/internal/middleware"
+	"secure-communication-ltd/backend/internal/services"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo/v4"
+)
+
+var reOrgSlug = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,62}$`)
+
+type OrgDTO struct {
+	ID        int64     `db:"id" json:"id"`
+	Name      string    `db:"name" json:"name"`
+	Slug      string    `db:"slug" json:"slug"`
+	CreatedAt time.Time `db:"created_at" json:"created_at"`
+}
+
+type switchOrgRequest struct {
+	OrgID int64 `json:"org_id"`
+}
+
+type createOrgRequest struct {
+	Name string `json:"name"`
+	Slug string `json:"slug"`
+}
+
+type addOrgMemberRequest struct {
+	UserID int64 `json:"user_id"`
+}
+
+// ListMyOrgs returns the organizations the signed-in user belongs to.
+func ListMyOrgs(db *sqlx.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		claims, err := middlewarex.ClaimsFromCtx(c)
+		if err != nil {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		}
+		rows := []OrgDTO{}
+		if err := db.Select(&rows, `
+			SELECT o.id, o.name, o.slug, o.created_at
+			FROM organization_members m
+			JOIN organizations o ON o.id = m.org_id
+			WHERE m.user_id = ?
+			ORDER BY o.name
+		`, claims.UserID); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+		return c.JSON(http.StatusOK, map[string]any{
+			"items":         rows,
+			"active_org_id": claims.OrgID,
+		})
+	}
+}
+
+// SwitchOrg makes another organization the active one and re-issues the session.
+// Switching to an organization the user is not a member of is rejected and recorded.
+func SwitchOrg(db *sqlx.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		uid, err := middlewarex.UserIDFromCtx(c)
+		if err != nil {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		}
+		var req switchOrgRequest
+		if err := c.Bind(&req); err != nil || req.OrgID <= 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid org_id"})
+		}
+
+		ok, err := services.IsOrgMember(db, uid, req.OrgID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+		if !ok {
+			services.RecordOrgAccessDenial(db, uid, req.OrgID, "org.switch", c.RealIP())
+			middlewarex.Audit(c, db, services.AuditEvent{
+				Type: services.AuditOrgAccessDenied, Outcome: services.AuditDenied,
+				SubjectType: "organization", SubjectID: strconv.FormatInt(req.OrgID, 10),
+				Details: map[string]any{"action": "org.switch"},
+			})
+			return c.JSON(http.StatusForbidden, map[string]string{"error": "not a member of this organization"})
+		}
+
+		if _, err := db.Exec(`
+			UPDATE organization_members SET last_used_at = NOW() WHERE user_id = ? AND org_id = ?
+		`, uid, req.OrgID); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+		// last_used_at only makes it the default of later sign-ins: it has
+		// one-second resolution, so this session names the org itself.
+		if err := startSessionInOrg(c, db, uid, req.OrgID); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "token error"})
+		}
+		return c.JSON(http.StatusOK, map[string]any{"active_org_id": req.OrgID})
+	}
+}
+
+// AdminListOrgs returns all organizations with their member counts.
+func AdminListOrgs(db *sqlx.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		type orgWithCount struct {
+			OrgDTO
+			Members int `db:"members" json:"members"`
+		}
+		rows := []orgWithCount{}
+		if err := db.Select(&rows, `
+			SELECT o.id, o.name, o.slug, o.created_at, COUNT(m.user_id) AS members
+			FROM organizations o
+			LEFT JOIN organization_members m ON m.org_id = o.id
+			GROUP BY o.id, o.name, o.slug, o.created_at
+			ORDER BY o.name
+		`); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+		return c.JSON(http.StatusOK, map[string]any{"items": rows})
+	}
+}
+
+// AdminCreateOrg creates an organization. Admin org changes are audited
+// (admin.org_*) like the user administration.
+func AdminCreateOrg(db *sqlx.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var req createOrgRequest
+		if err := c.Bind(&req); err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid json"})
+		}
+		req.Name = strings.TrimSpace(req.Name)
+		req.Slug = strings.ToLower(strings.TrimSpace(req.Slug))
+		if req.Name == "" || utf8.RuneCountInString(req.Name) > 150 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "name is required (max 150 characters)"})
+		}
+		if !reOrgSlug.MatchString(req.Slug) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "slug must be 2-63 lowercase letters, digits or '-'"})
+		}
+
+		res, err := db.Exec(`INSERT INTO organizations (name, slug) VALUES (?, ?)`, req.Name, req.Slug)
+		if err != nil {
+			middlewarex.Audit(c, db, services.AuditEvent{
+				Type: services.AuditOrgCreated, Outcome: services.AuditFailure, SubjectType: "organization",
+				Details: map[string]any{"slug": req.Slug},
+			})
+			return c.JSON(http.StatusConflict, map[string]string{"error": "could not create organization"})
+		}
+		id, _ := res.LastInsertId()
+		middlewarex.Audit(c, db, services.AuditEvent{
+			Type: services.AuditOrgCreated, OrgID: id,
+			SubjectType: "organization", SubjectID: strconv.FormatInt(id, 10),
+			Details: map[string]any{"name": req.Name, "slug": req.Slug},
+		})
+		return c.JSON(http.StatusCreated, map[string]any{"id": id, "name": req.Name, "slug": req.Slug})
+	}
+}
+
+// AdminAddOrgMember adds a user to an organization.
+func AdminAddOrgMember(db *sqlx.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		actor, err := middlewarex.UserIDFromCtx(c)
+		if err != nil {
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+		}
+		orgID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil || orgID <= 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid org id"})
+		}
+		var req addOrgMemberRequest
+		if err := c.Bind(&req); err != nil || req.UserID <= 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid user_id"})
+		}
+
+		res, err := db.Exec(`
+			INSERT IGNORE INTO organization_members (org_id, user_id, added_by)
+			SELECT o.id, u.id, ?
+			FROM organizations o JOIN users u ON u.id = ?
+			WHERE o.id = ?
+		`, actor, req.UserID, orgID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			ok, err := services.IsOrgMember(db, req.UserID, orgID)
+			if err != nil {
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+			}
+			if !ok {
+				return c.JSON(http.StatusNotFound, map[string]string{"error": "organization or user not found"})
+			}
+		} else {
+			middlewarex.Audit(c, db, services.AuditEvent{
+				Type: services.AuditOrgMemberAdded, OrgID: orgID,
+				SubjectType: "user", SubjectID: strconv.FormatInt(req.UserID, 10),
+			})
+		}
+		return c.JSON(http.StatusOK, map[string]any{"org_id": orgID, "user_id": req.UserID})
+	}
+}
+
+// AdminRemoveOrgMember removes a user from an organization. Live sessions lose
+// access on their next request (RequireOrg re-checks membership).
+func AdminRemoveOrgMember(db *sqlx.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		orgID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+		if err != nil || orgID <= 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid org id"})
+		}
+		uid, err := strconv.ParseInt(c.Param("user_id"), 10, 64)
+		if err != nil || uid <= 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid user id"})
+		}
+		res, err := db.Exec(`DELETE FROM organization_members WHERE org_id = ? AND user_id = ?`, orgID, uid)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "membership not found"})
+		}
+		middlewarex.Audit(c, db, services.AuditEvent{
+			Type: services.AuditOrgMemberRemoved, OrgID: orgID,
+			SubjectType: "user", SubjectID: strconv.FormatInt(uid, 10),
+		})
+		return c.JSON(http.StatusOK, map[string]string{"message": "member removed"})
+	}
+}
@@ -0,0 +1,90 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"secure-communication-ltd/backend/internal/services"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestAdminCreateOrgIsAudited(t *testing.T) {
+	db, mock := newMockDB(t)
+	mock.ExpectExec(`INSERT INTO organizations`).WithArgs("Sales", "sales").
+		WillReturnResult(sqlmock.NewResult(5, 1))
+	expectAudit(t, mock, services.AuditOrgCreated, services.AuditSuccess)
+
+	c, rec := signedInContext(http.MethodPost, "/api/admin/orgs", "/api/admin/orgs",
+		`{"name":" Sales ","slug":"Sales"}`, 1, 0)
+	if err := AdminCreateOrg(db)(c); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestAdminCreateOrgConflictIsAudited(t *testing.T) {
+	db, mock := newMockDB(t)
+	mock.ExpectExec(`INSERT INTO organizations`).WillReturnError(errors.New("duplicate slug"))
+	expectAudit(t, mock, services.AuditOrgCreated, services.AuditFailure)
+
+	c, rec := signedInContext(http.MethodPost, "/api/admin/orgs", "/api/admin/orgs",
+		`{"name":"Sales","slug":"sales"}`, 1, 0)
+	if err := AdminCreateOrg(db)(c); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("status = %d, want 409", rec.Code)
+	}
+}
+
+func TestAdminOrgMembershipIsAudited(t *testing.T) {
+	t.Run("add", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectExec(`INSERT IGNORE INTO organization_members`).WithArgs(int64(1), int64(9), int64(5)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		expectAudit(t, mock, services.AuditOrgMemberAdded, services.AuditSuccess)
+
+		c, rec := signedInContext(http.MethodPost, "/api/admin/orgs/:id/members", "/api/admin/orgs/5/members",
+			`{"user_id":9}`, 1, 0, "id", "5")
+		if err := AdminAddOrgMember(db)(c); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+		}
+	})
+	t.Run("add existing member", func(t *testing.T) {
+		// Nothing changed: no audit entry
+		db, mock := newMockDB(t)
+		mock.ExpectExec(`INSERT IGNORE INTO organization_members`).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery(`FROM organization_members`).WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(1))
+
+		c, rec := signedInContext(http.MethodPost, "/api/admin/orgs/:id/members", "/api/admin/orgs/5/members",
+			`{"user_id":9}`, 1, 0, "id", "5")
+		if err := AdminAddOrgMember(db)(c); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+		}
+	})
+	t.Run("remove", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectExec(`DELETE FROM organization_members`).WithArgs(int64(5), int64(9)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		expectAudit(t, mock, services.AuditOrgMemberRemoved, services.AuditSuccess)
+
+		c, rec := signedInContext(http.MethodDelete, "/api/admin/orgs/:id/members/:user_id",
+			"/api/admin/orgs/5/members/9", "", 1, 0, "id", "5", "user_id", "9")
+		if err := AdminRemoveOrgMember(db)(c); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != http.StatusOK {
+			t.Fatalf("status = %d: %s", rec.Code, rec.Body)
+		}
+	})
+}
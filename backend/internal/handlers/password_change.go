@@ -6,6 +6,7 @@ import (
 	"errors"
 	"html/template"
 	"net/http"
+	"strconv"
 	"time"
 
 	"secure-communication-ltd/backend/config"
@@ -70,6 +71,10 @@ func ChangePassword(db *sqlx.DB) echo.HandlerFunc {
 			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "hash error"})
 		}
 		if oldHex != curHash {
+			middlewarex.Audit(c, db, services.AuditEvent{
+				Type: services.AuditPasswordChanged, Outcome: services.AuditFailure,
+				Details: map[string]any{"reason": "old_password_incorrect"},
+			})
 			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "old password is incorrect"})
 		}
 
@@ -184,6 +189,9 @@ func ChangePassword(db *sqlx.DB) echo.HandlerFunc {
 		if err := tx.Commit(); err != nil {
 			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "commit error"})
 		}
+		middlewarex.Audit(c, db, services.AuditEvent{
+			Type: services.AuditPasswordChanged, SubjectType: "user", SubjectID: strconv.FormatInt(uid, 10),
+		})
 
 		// 11) Best-effort email (SAFE HTML via template)
 		if mailer, err := services.NewMailerFromEnv(); err == nil && email != "" {
@@ -207,17 +215,7 @@ func ChangePassword(db *sqlx.DB) echo.HandlerFunc {
 		}
 
 		// 12) Invalidate session (force re-login)
-		cookie := &http.Cookie{
-			Name:     services.CookieName,
-			Value:    "",
-			Path:     "/",
-			MaxAge:   -1,
-			Expires:  time.Unix(0, 0),
-			HttpOnly: true,
-			SameSite: http.SameSiteStrictMode,
-			Secure:   false, // set true in production behind HTTPS
-		}
-		c.SetCookie(cookie)
+		c.SetCookie(services.ExpiredCookie(services.SessionCookie))
 
 		return c.JSON(http.StatusOK, map[string]string{
 			"message": "password changed; please sign in again",
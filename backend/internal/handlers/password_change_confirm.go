@@ -5,9 +5,11 @@ import (
 	"database/sql"
 	"encoding/hex"
 	"net/http"
+	"strconv"
 	"time"
 
 	"secure-communication-ltd/backend/config"
+	middlewarex "secure-communication-ltd/backend/internal/middleware"
 	"secure-communication-ltd/backend/internal/services"
 
 	"github.com/jmoiron/sqlx"
@@ -122,18 +124,14 @@ func ChangePasswordConfirm(db *sqlx.DB) echo.HandlerFunc {
 			return RenderVerificationPage(c, http.StatusInternalServerError, false,
 				"Server Error", "Could not commit the password change.")
 		}
+		middlewarex.Audit(c, db, services.AuditEvent{
+			Type: services.AuditPasswordChanged, ActorUserID: userID,
+			SubjectType: "user", SubjectID: strconv.FormatInt(userID, 10),
+			Details: map[string]any{"via": "email_confirmation"},
+		})
 
 		// invalidate session cookie
-		http.SetCookie(c.Response(), &http.Cookie{
-			Name:     services.CookieName,
-			Value:    "",
-			Path:     "/",
-			MaxAge:   -1,
-			Expires:  time.Unix(0, 0),
-			HttpOnly: true,
-			SameSite: http.SameSiteStrictMode,
-			Secure:   false, // set true in HTTPS production
-		})
+		c.SetCookie(services.ExpiredCookie(services.SessionCookie))
 
 		return RenderVerificationPage(c, http.StatusOK, true,
 			"Password Changed",
@@ -4,13 +4,16 @@ import (
 	"bytes"
 	"crypto/sha1"
 	"encoding/hex"
+	"errors"
 	"html/template"
 	"net/http"
 	"net/url"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
+	middlewarex "secure-communication-ltd/backend/internal/middleware"
 	"secure-communication-ltd/backend/internal/services"
 
 	"github.com/jmoiron/sqlx"
@@ -24,54 +27,50 @@ type forgotReq struct {
 // Always return a generic response — even if the email does not exist.
 func PasswordForgot(db *sqlx.DB) echo.HandlerFunc {
 	return func(c echo.Context) error {
+		if !services.LocalLoginEnabled() {
+			return localLoginDisabled(c)
+		}
 		var req forgotReq
 		if err := c.Bind(&req); err != nil {
 			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid json"})
 		}
-		email := strings.TrimSpace(req.Email)
-		if email == "" || !strings.Contains(email, "@") {
+		_, emailCanon, err := services.CanonicalEmail(req.Email)
+		if err != nil {
 			// Generic response (don't reveal anything)
 			return c.JSON(http.StatusOK, map[string]string{"message": "If this email exists, a reset link has been sent."})
 		}
 
-		// Is there such a user and are they verified?
-		var userID int64
-		if err := db.Get(&userID, `SELECT id FROM users WHERE email = ? AND is_verified = TRUE LIMIT 1`, email); err != nil {
+		// Is there such a user and are they verified? (send to the stored address)
+		var (
+			userID int64
+			email  string
+		)
+		if err := db.QueryRowx(`
+			SELECT id, email FROM users WHERE email_canonical = ? AND is_verified = TRUE LIMIT 1
+		`, emailCanon).Scan(&userID, &email); err != nil {
 			// Do not reveal if not found — return generic response
+			middlewarex.Audit(c, db, services.AuditEvent{
+				Type: services.AuditPasswordResetRequest, Outcome: services.AuditFailure, ActorLabel: emailCanon,
+				Details: map[string]any{"reason": "unknown_or_unverified_email"},
+			})
 			return c.JSON(http.StatusOK, map[string]string{"message": "If this email exists, a reset link has been sent."})
 		}
 
-		// Invalidate old open tokens (best-effort)
-		_, _ = db.Exec(`UPDATE password_reset_tokens SET used_at = NOW() WHERE user_id = ? AND used_at IS NULL`, userID)
-
-		// Create a new token (raw) and store SHA-1 only
-		raw, err := services.NewRandomBase64URL(32) // ~43 chars
-		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "token error"})
-		}
-		sum := sha1.Sum([]byte(raw))
-		sha := hex.EncodeToString(sum[:])
-
-		exp := time.Now().Add(30 * time.Minute)
-		if _, err := db.Exec(`
-			INSERT INTO password_reset_tokens (user_id, token_sha1, expires_at)
-			VALUES (?, ?, ?)`, userID, sha, exp); err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+		if err := sendPasswordReset(db, userID, email); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 		}
 
-		// Send email (safe HTML via html/template)
-		mailer, err := services.NewMailerFromEnv()
-		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "mailer error"})
-		}
-		base := os.Getenv("BACKEND_PUBLIC_URL")
-		if base == "" {
-			base = "http://localhost:8080"
-		}
-		link := strings.TrimRight(base, "/") + "/api/password/reset?token=" + url.QueryEscape(raw)
+		middlewarex.Audit(c, db, services.AuditEvent{
+			Type: services.AuditPasswordResetRequest, ActorUserID: userID, ActorLabel: email,
+			SubjectType: "user", SubjectID: strconv.FormatInt(userID, 10),
+		})
 
-		// HTML template (auto-escaping of {{.Link}})
-		tpl := template.Must(template.New("pwReset").Parse(`
+		// Always generic
+		return c.JSON(http.StatusOK, map[string]string{"message": "If this email exists, a reset link has been sent."})
+	}
+}
+
+var passwordResetMailTpl = template.Must(template.New("pwReset").Parse(`
 <h2>Password Reset</h2>
 <p>We received a request to reset your password.</p>
 <p>
@@ -84,13 +83,43 @@ func PasswordForgot(db *sqlx.DB) echo.HandlerFunc {
 <p>This link expires in 30 minutes.</p>
 `))
 
-		var buf bytes.Buffer
-		_ = tpl.Execute(&buf, struct{ Link string }{Link: link})
+// sendPasswordReset replaces any open reset token of userID with a new one and
+// emails the link. Sending is best-effort (the caller must not reveal whether
+// the address exists); returned errors are internal ones.
+func sendPasswordReset(db *sqlx.DB, userID int64, email string) error {
+	// Invalidate old open tokens (best-effort)
+	_, _ = db.Exec(`UPDATE password_reset_tokens SET used_at = NOW() WHERE user_id = ? AND used_at IS NULL`, userID)
 
-		// Best-effort send (don’t leak errors to user about existence)
-		_ = mailer.Send(email, "Reset your password", buf.String())
+	// Create a new token (raw) and store SHA-1 only
+	raw, err := services.NewRandomBase64URL(32) // ~43 chars
+	if err != nil {
+		return errors.New("token error")
+	}
+	sum := sha1.Sum([]byte(raw))
+	sha := hex.EncodeToString(sum[:])
 
-		// Always generic
-		return c.JSON(http.StatusOK, map[string]string{"message": "If this email exists, a reset link has been sent."})
+	exp := time.Now().Add(30 * time.Minute)
+	if _, err := db.Exec(`
+		INSERT INTO password_reset_tokens (user_id, token_sha1, expires_at)
+		VALUES (?, ?, ?)`, userID, sha, exp); err != nil {
+		return errors.New("db error")
 	}
+
+	// Send email (safe HTML via html/template)
+	mailer, err := services.NewMailerFromEnv()
+	if err != nil {
+		return errors.New("mailer error")
+	}
+	base := os.Getenv("BACKEND_PUBLIC_URL")
+	if base == "" {
+		base = "http://localhost:8080"
+	}
+	link := strings.TrimRight(base, "/") + "/api/password/reset?token=" + url.QueryEscape(raw)
+
+	var buf bytes.Buffer
+	_ = passwordResetMailTpl.Execute(&buf, struct{ Link string }{Link: link})
+
+	// Best-effort send (don’t leak errors to user about existence)
+	_ = mailer.Send(email, "Reset your password", buf.String())
+	return nil
 }
@@ -8,7 +8,9 @@ import (
 	"net/url"
 	"os"
 	"secure-communication-ltd/backend/config"
+	middlewarex "secure-communication-ltd/backend/internal/middleware"
 	"secure-communication-ltd/backend/internal/services"
+	"strconv"
 	"strings"
 	"time"
 
@@ -35,6 +37,9 @@ func PasswordResetLanding() echo.HandlerFunc {
 
 func PasswordReset(db *sqlx.DB) echo.HandlerFunc {
 	return func(c echo.Context) error {
+		if !services.LocalLoginEnabled() {
+			return localLoginDisabled(c)
+		}
 		pol := config.GetPolicy()
 		var req resetReq
 		if err := c.Bind(&req); err != nil {
@@ -65,12 +70,21 @@ func PasswordReset(db *sqlx.DB) echo.HandlerFunc {
 			LIMIT 1
 		`, sha).Scan(&userID, &expiresAt, &usedAt)
 		if err == sql.ErrNoRows {
+			middlewarex.Audit(c, db, services.AuditEvent{
+				Type: services.AuditPasswordReset, Outcome: services.AuditFailure,
+				Details: map[string]any{"reason": "invalid_token"},
+			})
 			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid token"})
 		}
 		if err != nil {
 			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
 		}
 		if usedAt.Valid || time.Now().After(expiresAt) {
+			middlewarex.Audit(c, db, services.AuditEvent{
+				Type: services.AuditPasswordReset, Outcome: services.AuditFailure, ActorUserID: userID,
+				SubjectType: "user", SubjectID: strconv.FormatInt(userID, 10),
+				Details: map[string]any{"reason": "token_expired_or_used"},
+			})
 			return c.JSON(http.StatusBadRequest, map[string]string{"error": "token expired or used"})
 		}
 
@@ -175,7 +189,7 @@ func PasswordReset(db *sqlx.DB) echo.HandlerFunc {
 
 		if _, err := tx.Exec(`
 			UPDATE users
-			SET password_hmac = ?, salt = ?, password_fp = ?
+			SET password_hmac = ?, salt = ?, password_fp = ?, locked_at = NULL -- ends a "this wasn't me" lockdown
 			WHERE id = ?
 		`, newHex, newSalt, newFP, userID); err != nil {
 			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "update user error"})
@@ -210,6 +224,10 @@ func PasswordReset(db *sqlx.DB) echo.HandlerFunc {
 		if err := tx.Commit(); err != nil {
 			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "commit error"})
 		}
+		middlewarex.Audit(c, db, services.AuditEvent{
+			Type: services.AuditPasswordReset, ActorUserID: userID,
+			SubjectType: "user", SubjectID: strconv.FormatInt(userID, 10),
+		})
 
 		return c.JSON(http.StatusOK, map[string]string{"message": "Password reset successfully"})
 	}
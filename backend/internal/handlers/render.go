@@ -3,7 +3,12 @@ package handlers
 import (
 	"bytes"
 	"html/template"
+	"net/http"
+	"net/url"
 	"os"
+	"strings"
+
+	"secure-communication-ltd/backend/internal/services"
 
 	"github.com/labstack/echo/v4"
 )
@@ -14,7 +19,7 @@ var verifyTpl = template.Must(template.New("verifyPage").Parse(`
 <head>
   <meta charset="utf-8">
   <title>{{.Title}}</title>
-  <style>
+  <style nonce="{{.Nonce}}">
     body {
       font-family: system-ui, Arial, sans-serif;
       background: linear-gradient(135deg, #0f1221, #1b2b4b);
@@ -56,6 +61,7 @@ var verifyTpl = template.Must(template.New("verifyPage").Parse(`
 `))
 
 type verifyPageData struct {
+	Nonce    string
 	Title    string
 	Message  string
 	Color    string
@@ -71,8 +77,10 @@ func RenderVerificationPage(c echo.Context, code int, ok bool, title, message st
 	if login == "" {
 		login = "http://localhost:3000/login"
 	}
+	nonce := setPageCSP(c)
 	var buf bytes.Buffer
 	_ = verifyTpl.Execute(&buf, verifyPageData{
+		Nonce:    nonce,
 		Title:    title,
 		Message:  message,
 		Color:    color,
@@ -80,3 +88,201 @@ func RenderVerificationPage(c echo.Context, code int, ok bool, title, message st
 	})
 	return c.HTML(code, buf.String())
 }
+
+var consentTpl = template.Must(template.New("consentPage").Parse(`
+<!doctype html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Authorize {{.ClientName}}</title>
+  <style nonce="{{.Nonce}}">
+    body {
+      font-family: system-ui, Arial, sans-serif;
+      background: linear-gradient(135deg, #0f1221, #1b2b4b);
+      color: #f0f0f0;
+      text-align: center;
+      padding-top: 8%;
+    }
+    .card {
+      display: inline-block;
+      max-width: 520px;
+      padding: 32px 48px;
+      border-radius: 16px;
+      background: rgba(255,255,255,0.05);
+      border: 1px solid rgba(255,255,255,0.15);
+      box-shadow: 0 12px 24px rgba(0,0,0,0.3);
+      text-align: left;
+    }
+    h2 { margin-bottom: 12px; color: #55e7ff; text-align: center; }
+    p { margin: 0 0 8px; }
+    ul { margin: 12px 0 20px; padding-left: 20px; }
+    li { margin-bottom: 6px; }
+    code { color: #9fb4ff; }
+    .actions { display: flex; gap: 12px; justify-content: flex-end; }
+    button {
+      padding: 10px 20px;
+      border-radius: 8px;
+      border: 0;
+      font-weight: 600;
+      cursor: pointer;
+    }
+    .allow { background: linear-gradient(135deg,#6c8bff,#55e7ff); color: #0b1120; }
+    .deny { background: rgba(255,255,255,0.12); color: #f0f0f0; }
+  </style>
+</head>
+<body>
+  <div class="card">
+    <h2>Authorize {{.ClientName}}</h2>
+    <p>Signed in as <strong>{{.Username}}</strong>.</p>
+    <p><strong>{{.ClientName}}</strong> is asking to act on your behalf with these permissions:</p>
+    <ul>
+      {{range .Scopes}}<li><code>{{.Name}}</code>{{if .Description}} &mdash; {{.Description}}{{end}}</li>{{end}}
+    </ul>
+    <p>You will be returned to <code>{{.RedirectHost}}</code>. Access can be revoked at any time.</p>
+    <form method="post" action="{{.Action}}">
+      <input type="hidden" name="consent" value="{{.Handle}}">
+      <input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+      <div class="actions">
+        <button class="deny" type="submit" name="decision" value="deny">Deny</button>
+        <button class="allow" type="submit" name="decision" value="allow">Allow</button>
+      </div>
+    </form>
+  </div>
+</body>
+</html>
+`))
+
+type consentScope struct {
+	Name        string `db:"name"`
+	Description string `db:"description"`
+}
+
+type consentPageData struct {
+	Nonce        string
+	ClientName   string
+	Username     string
+	Scopes       []consentScope
+	RedirectHost string
+	Handle       string
+	Action       string
+	CSRFToken    string
+	RedirectURI  string // the decision redirects here: form-action must allow it
+}
+
+// RenderConsentPage renders the OAuth consent screen.
+func RenderConsentPage(c echo.Context, data consentPageData) error {
+	data.Nonce = setPageCSP(c, cspSource(data.RedirectURI))
+	var buf bytes.Buffer
+	if err := consentTpl.Execute(&buf, data); err != nil {
+		return c.String(http.StatusInternalServerError, "template error")
+	}
+	c.Response().Header().Set(echo.HeaderCacheControl, "no-store")
+	return c.HTML(http.StatusOK, buf.String())
+}
+
+var lockdownTpl = template.Must(template.New("lockdownPage").Parse(`
+<!doctype html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Secure your account</title>
+  <style nonce="{{.Nonce}}">
+    body {
+      font-family: system-ui, Arial, sans-serif;
+      background: linear-gradient(135deg, #0f1221, #1b2b4b);
+      color: #f0f0f0;
+      text-align: center;
+      padding-top: 8%;
+    }
+    .card {
+      display: inline-block;
+      max-width: 520px;
+      padding: 32px 48px;
+      border-radius: 16px;
+      background: rgba(255,255,255,0.05);
+      border: 1px solid rgba(255,255,255,0.15);
+      box-shadow: 0 12px 24px rgba(0,0,0,0.3);
+      text-align: left;
+    }
+    h2 { margin-bottom: 12px; color: #ff6b6b; text-align: center; }
+    p { margin: 0 0 8px; }
+    .actions { display: flex; justify-content: flex-end; margin-top: 20px; }
+    button {
+      padding: 10px 20px;
+      border-radius: 8px;
+      border: 0;
+      font-weight: 600;
+      cursor: pointer;
+      background: #ff6b6b;
+      color: #fff;
+    }
+  </style>
+</head>
+<body>
+  <div class="card">
+    <h2>Secure your account</h2>
+    <p>Use this if you did not sign in from the device in the alert.</p>
+    <p>It signs out every session, revokes your API keys and app authorizations, locks the account and emails you a link to set a new password. The account unlocks once you do.</p>
+    <form method="post" action="{{.Action}}">
+      <input type="hidden" name="token" value="{{.Token}}">
+      <input type="hidden" name="csrf_token" value="{{.CSRFToken}}">
+      <div class="actions">
+        <button type="submit">Secure my account</button>
+      </div>
+    </form>
+  </div>
+</body>
+</html>
+`))
+
+type lockdownPageData struct {
+	Nonce     string
+	Action    string
+	Token     string
+	CSRFToken string
+}
+
+// RenderLockdownPage renders the confirmation form of a "this wasn't me" link.
+func RenderLockdownPage(c echo.Context, data lockdownPageData) error {
+	data.Nonce = setPageCSP(c)
+	var buf bytes.Buffer
+	if err := lockdownTpl.Execute(&buf, data); err != nil {
+		return c.String(http.StatusInternalServerError, "template error")
+	}
+	c.Response().Header().Set(echo.HeaderCacheControl, "no-store")
+	return c.HTML(http.StatusOK, buf.String())
+}
+
+// setPageCSP replaces the API's CSP with a strict policy for a server-rendered
+// page: only the page's own nonce-tagged <style> applies, no scripts, no
+// framing (clickjacking of the consent buttons), forms only to ourselves and
+// the given extra targets. Returns the nonce for the template.
+func setPageCSP(c echo.Context, formTargets ...string) string {
+	nonce, err := services.NewRandomBase64URL(16)
+	if err != nil {
+		nonce = "" // no styles rather than a guessable nonce
+	}
+	formAction := "'self'"
+	for _, t := range formTargets {
+		if t != "" {
+			formAction += " " + t
+		}
+	}
+	c.Response().Header().Set(echo.HeaderContentSecurityPolicy,
+		"default-src 'none'; style-src 'nonce-"+nonce+"'; img-src 'self'; base-uri 'none'; "+
+			"form-action "+formAction+"; frame-ancestors 'none'")
+	return nonce
+}
+
+// cspSource turns a redirect URI into a CSP source expression: its origin,
+// or just the scheme for native-app URIs without a host. Empty if unusable.
+func cspSource(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme == "" || strings.ContainsAny(raw, " ;,'") {
+		return ""
+	}
+	if u.Host == "" {
+		return u.Scheme + ":"
+	}
+	return u.Scheme + "://" + u.Host
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+// A rendered page gets its own CSP: only the nonce-tagged style applies.
+func TestRenderVerificationPageCSP(t *testing.T) {
+	t.Setenv("FRONTEND_LOGIN_URL", "https://app.example/login")
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/api/auth/verify", nil), rec)
+	if err := RenderVerificationPage(c, http.StatusOK, true, "Verified", `<script>alert(1)</script>`); err != nil {
+		t.Fatal(err)
+	}
+
+	csp := rec.Header().Get(echo.HeaderContentSecurityPolicy)
+	_, after, ok := strings.Cut(csp, "style-src 'nonce-")
+	nonce, _, _ := strings.Cut(after, "'")
+	if !ok || len(nonce) < 16 {
+		t.Fatalf("CSP without a nonce: %q", csp)
+	}
+	for _, want := range []string{"default-src 'none'", "form-action 'self'", "frame-ancestors 'none'"} {
+		if !strings.Contains(csp, want) {
+			t.Errorf("CSP %q lacks %q", csp, want)
+		}
+	}
+	body := rec.Body.String()
+	if !strings.Contains(body, `<style nonce="`+nonce+`">`) {
+		t.Errorf("style not tagged with the CSP nonce %q", nonce)
+	}
+	if strings.Contains(body, "<script>") {
+		t.Errorf("message not escaped: %s", body)
+	}
+
+	// a fresh nonce per response
+	rec2 := httptest.NewRecorder()
+	c = echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/api/auth/verify", nil), rec2)
+	_ = RenderVerificationPage(c, http.StatusOK, true, "Verified", "ok")
+	if rec2.Header().Get(echo.HeaderContentSecurityPolicy) == csp {
+		t.Error("nonce reused across responses")
+	}
+}
+
+func TestCSPSource(t *testing.T) {
+	for raw, want := range map[string]string{
+		"https://partner.example/cb?x=1": "https://partner.example",
+		"http://localhost:8123/cb":       "http://localhost:8123",
+		"com.partner.app:/callback":      "com.partner.app:",
+		"/relative":                      "",
+		"https://partner.example/a b":    "",
+		"https://partner.example/;x":     "",
+		"https://partner.example/'x'":    "",
+		"https://partner.example/,x":     "",
+	} {
+		if got := cspSource(raw); got != want {
+			t.Errorf("cspSource(%q) = %q, want %q", raw, got, want)
+		}
+	}
+}
@@ -0,0 +1,579 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"secure-communication-ltd/backend/internal/services"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo/v4"
+)
+
+// SCIM 2.0 Users endpoint for the HR system. Users map to rows in users
+// (service accounts are not exposed); groups map to roles (scim_groups.go).
+// Deactivation sets users.is_active = FALSE, which blocks password login and
+// is checked by RequireAuth on every request.
+
+const (
+	scimContentType     = "application/scim+json"
+	scimDefaultPageSize = 100
+	scimMaxPageSize     = 200
+)
+
+var reSCIMEmailPath = regexp.MustCompile(`^emails\[.*\]\.value$`)
+
+type scimEmail struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+type scimRef struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Ref     string `json:"$ref,omitempty"`
+}
+
+type scimMeta struct {
+	ResourceType string    `json:"resourceType"`
+	Created      time.Time `json:"created"`
+	Location     string    `json:"location"`
+}
+
+type scimUser struct {
+	Schemas    []string    `json:"schemas"`
+	ID         string      `json:"id"`
+	ExternalID string      `json:"externalId,omitempty"`
+	UserName   string      `json:"userName"`
+	Active     bool        `json:"active"`
+	Emails     []scimEmail `json:"emails"`
+	Groups     []scimRef   `json:"groups"`
+	Meta       scimMeta    `json:"meta"`
+}
+
+type scimUserRow struct {
+	ID         int64     `db:"id"`
+	Username   string    `db:"username"`
+	Email      string    `db:"email"`
+	ExternalID *string   `db:"scim_external_id"`
+	IsActive   bool      `db:"is_active"`
+	CreatedAt  time.Time `db:"created_at"`
+}
+
+type scimUserInput struct {
+	UserName   string      `json:"userName"`
+	ExternalID *string     `json:"externalId"`
+	Active     *bool       `json:"active"`
+	Emails     []scimEmail `json:"emails"`
+}
+
+type scimPatchRequest struct {
+	Schemas    []string `json:"schemas"`
+	Operations []struct {
+		Op    string          `json:"op"`
+		Path  string          `json:"path"`
+		Value json.RawMessage `json:"value"`
+	} `json:"Operations"`
+}
+
+func scimBaseURL() string {
+	base := os.Getenv("BACKEND_PUBLIC_URL")
+	if base == "" {
+		base = "http://localhost:8080"
+	}
+	return strings.TrimRight(base, "/") + "/scim/v2"
+}
+
+func scimJSON(c echo.Context, status int, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.Blob(status, scimContentType, b)
+}
+
+func scimError(c echo.Context, status int, scimType, detail string) error {
+	body := map[string]any{
+		"schemas": []string{services.SCIMSchemaError},
+		"status":  strconv.Itoa(status),
+		"detail":  detail,
+	}
+	if scimType != "" {
+		body["scimType"] = scimType
+	}
+	return scimJSON(c, status, body)
+}
+
+// decodeSCIM reads a JSON body; Bind does not accept application/scim+json.
+func decodeSCIM(c echo.Context, v any) error {
+	return json.NewDecoder(io.LimitReader(c.Request().Body, 1<<20)).Decode(v)
+}
+
+func scimListResponse(total, start, perPage int, resources any) map[string]any {
+	return map[string]any{
+		"schemas":      []string{services.SCIMSchemaListResponse},
+		"totalResults": total,
+		"startIndex":   start,
+		"itemsPerPage": perPage,
+		"Resources":    resources,
+	}
+}
+
+// scimPaging reads startIndex (1-based) and count.
+func scimPaging(c echo.Context) (start, count int) {
+	start, count = 1, scimDefaultPageSize
+	if n, err := strconv.Atoi(c.QueryParam("startIndex")); err == nil && n > 1 {
+		start = n
+	}
+	if n, err := strconv.Atoi(c.QueryParam("count")); err == nil && n >= 0 {
+		count = min(n, scimMaxPageSize)
+	}
+	return start, count
+}
+
+func toSCIMUsers(db *sqlx.DB, rows []scimUserRow) ([]scimUser, error) {
+	out := make([]scimUser, 0, len(rows))
+	if len(rows) == 0 {
+		return out, nil
+	}
+	ids := make([]int64, len(rows))
+	for i, r := range rows {
+		ids[i] = r.ID
+	}
+	var memberships []struct {
+		UserID int64  `db:"user_id"`
+		RoleID int64  `db:"role_id"`
+		Name   string `db:"name"`
+	}
+	q, args, err := sqlx.In(`
+		SELECT ur.user_id, r.id AS role_id, r.name
+		FROM user_roles ur JOIN roles r ON r.id = ur.role_id
+		WHERE ur.user_id IN (?)
+		ORDER BY r.name
+	`, ids)
+	if err != nil {
+		return nil, err
+	}
+	if err := db.Select(&memberships, db.Rebind(q), args...); err != nil {
+		return nil, err
+	}
+	groups := map[int64][]scimRef{}
+	for _, m := range memberships {
+		id := strconv.FormatInt(m.RoleID, 10)
+		groups[m.UserID] = append(groups[m.UserID], scimRef{
+			Value: id, Display: m.Name, Ref: scimBaseURL() + "/Groups/" + id,
+		})
+	}
+
+	for _, r := range rows {
+		id := strconv.FormatInt(r.ID, 10)
+		u := scimUser{
+			Schemas:  []string{services.SCIMSchemaUser},
+			ID:       id,
+			UserName: r.Username,
+			Active:   r.IsActive,
+			Emails:   []scimEmail{{Value: r.Email, Type: "work", Primary: true}},
+			Groups:   groups[r.ID],
+			Meta:     scimMeta{ResourceType: "User", Created: r.CreatedAt.UTC(), Location: scimBaseURL() + "/Users/" + id},
+		}
+		if u.Groups == nil {
+			u.Groups = []scimRef{}
+		}
+		if r.ExternalID != nil {
+			u.ExternalID = *r.ExternalID
+		}
+		out = append(out, u)
+	}
+	return out, nil
+}
+
+const scimUserSelect = `SELECT id, username, email, scim_external_id, is_active, created_at FROM users`
+
+func loadSCIMUser(db *sqlx.DB, idParam string) (*scimUser, int64, error) {
+	id, err := strconv.ParseInt(idParam, 10, 64)
+	if err != nil || id <= 0 {
+		return nil, 0, sql.ErrNoRows
+	}
+	var row scimUserRow
+	if err := db.Get(&row, scimUserSelect+` WHERE id = ? AND is_service_account = FALSE`, id); err != nil {
+		return nil, 0, err
+	}
+	users, err := toSCIMUsers(db, []scimUserRow{row})
+	if err != nil {
+		return nil, 0, err
+	}
+	return &users[0], id, nil
+}
+
+// primarySCIMEmail picks the primary address, then a work address, then the first.
+func primarySCIMEmail(emails []scimEmail) string {
+	for _, e := range emails {
+		if e.Primary {
+			return e.Value
+		}
+	}
+	for _, e := range emails {
+		if strings.EqualFold(e.Type, "work") {
+			return e.Value
+		}
+	}
+	if len(emails) > 0 {
+		return emails[0].Value
+	}
+	return ""
+}
+
+// scimIdentityError maps identifier validation and uniqueness errors.
+func scimIdentityError(c echo.Context, err error) error {
+	if errors.Is(err, services.ErrIdentityTaken) {
+		return scimError(c, http.StatusConflict, "uniqueness", err.Error())
+	}
+	if services.IsIdentityValidationError(err) {
+		return scimError(c, http.StatusBadRequest, "invalidValue", err.Error())
+	}
+	return scimError(c, http.StatusInternalServerError, "", "internal error")
+}
+
+// SCIMServiceProviderConfig advertises what this endpoint supports.
+func SCIMServiceProviderConfig() echo.HandlerFunc {
+	return func(c echo.Context) error {
+		return scimJSON(c, http.StatusOK, map[string]any{
+			"schemas":        []string{"urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"},
+			"patch":          map[string]bool{"supported": true},
+			"bulk":           map[string]any{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+			"filter":         map[string]any{"supported": true, "maxResults": scimMaxPageSize},
+			"changePassword": map[string]bool{"supported": false},
+			"sort":           map[string]bool{"supported": false},
+			"etag":           map[string]bool{"supported": false},
+			"authenticationSchemes": []map[string]any{{
+				"type": "oauthbearertoken", "name": "Bearer token", "primary": true,
+				"description": "Static provisioning token (SCIM_BEARER_TOKEN)",
+			}},
+		})
+	}
+}
+
+// SCIMListUsers handles GET /Users with optional filter and paging.
+func SCIMListUsers(db *sqlx.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		where := "is_service_account = FALSE"
+		var args []any
+		if f := c.QueryParam("filter"); f != "" {
+			cond, fargs, err := services.CompileSCIMUserFilter(f)
+			if err != nil {
+				return scimError(c, http.StatusBadRequest, "invalidFilter", err.Error())
+			}
+			where += " AND " + cond
+			args = fargs
+		}
+		start, count := scimPaging(c)
+
+		var total int
+		if err := db.Get(&total, `SELECT COUNT(*) FROM users WHERE `+where, args...); err != nil {
+			return scimError(c, http.StatusInternalServerError, "", "internal error")
+		}
+		rows := []scimUserRow{}
+		if count > 0 {
+			if err := db.Select(&rows, scimUserSelect+` WHERE `+where+` ORDER BY id LIMIT ? OFFSET ?`,
+				append(args, count, start-1)...); err != nil {
+				return scimError(c, http.StatusInternalServerError, "", "internal error")
+			}
+		}
+		users, err := toSCIMUsers(db, rows)
+		if err != nil {
+			return scimError(c, http.StatusInternalServerError, "", "internal error")
+		}
+		return scimJSON(c, http.StatusOK, scimListResponse(total, start, len(users), users))
+	}
+}
+
+// SCIMGetUser handles GET /Users/:id.
+func SCIMGetUser(db *sqlx.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		u, _, err := loadSCIMUser(db, c.Param("id"))
+		if errors.Is(err, sql.ErrNoRows) {
+			return scimError(c, http.StatusNotFound, "", "user not found")
+		}
+		if err != nil {
+			return scimError(c, http.StatusInternalServerError, "", "internal error")
+		}
+		return scimJSON(c, http.StatusOK, u)
+	}
+}
+
+// SCIMCreateUser provisions a verified account without a usable password
+// (users sign in with SSO or set a password via "forgot password"), with the
+// default role and organization.
+func SCIMCreateUser(db *sqlx.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var in scimUserInput
+		if err := decodeSCIM(c, &in); err != nil {
+			return scimError(c, http.StatusBadRequest, "invalidSyntax", "invalid json")
+		}
+		email := primarySCIMEmail(in.Emails)
+		if strings.TrimSpace(in.UserName) == "" || email == "" {
+			return scimError(c, http.StatusBadRequest, "invalidValue", "userName and emails are required")
+		}
+
+		tx, err := db.Beginx()
+		if err != nil {
+			return scimError(c, http.StatusInternalServerError, "", "internal error")
+		}
+		defer tx.Rollback()
+
+		uid, err := services.InsertUser(tx, services.NewUser{Username: in.UserName, Email: email, Verified: true})
+		if err != nil {
+			return scimIdentityError(c, err)
+		}
+		active := in.Active == nil || *in.Active
+		if _, err := tx.Exec(`UPDATE users SET scim_external_id = ?, is_active = ? WHERE id = ?`,
+			in.ExternalID, active, uid); err != nil {
+			return scimError(c, http.StatusConflict, "uniqueness", "externalId already exists")
+		}
+		if err := services.GrantRole(tx, uid, services.DefaultRole(), nil); err != nil {
+			return scimError(c, http.StatusInternalServerError, "", "internal error")
+		}
+		if slug := services.DefaultOrgSlug(); slug != "" {
+			if err := services.AddOrgMemberBySlug(tx, slug, uid, nil); err != nil {
+				return scimError(c, http.StatusInternalServerError, "", "internal error")
+			}
+		}
+		if err := tx.Commit(); err != nil {
+			return scimError(c, http.StatusInternalServerError, "", "internal error")
+		}
+
+		u, _, err := loadSCIMUser(db, strconv.FormatInt(uid, 10))
+		if err != nil {
+			return scimError(c, http.StatusInternalServerError, "", "internal error")
+		}
+		c.Response().Header().Set(echo.HeaderLocation, u.Meta.Location)
+		return scimJSON(c, http.StatusCreated, u)
+	}
+}
+
+// scimUserChange is the set of attributes a PUT or PATCH touches.
+type scimUserChange struct {
+	UserName      string
+	Email         string
+	ExternalID    *string
+	ClearExternal bool
+	Active        *bool
+}
+
+func applySCIMUserChange(db *sqlx.DB, c echo.Context, uid int64, ch scimUserChange) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return scimError(c, http.StatusInternalServerError, "", "internal error")
+	}
+	defer tx.Rollback()
+
+	if ch.UserName != "" || ch.Email != "" {
+		if err := services.UpdateUserIdentifiers(tx, uid, ch.UserName, ch.Email); err != nil {
+			return scimIdentityError(c, err)
+		}
+	}
+	if ch.ExternalID != nil || ch.ClearExternal {
+		if _, err := tx.Exec(`UPDATE users SET scim_external_id = ? WHERE id = ?`, ch.ExternalID, uid); err != nil {
+			return scimError(c, http.StatusConflict, "uniqueness", "externalId already exists")
+		}
+	}
+	if ch.Active != nil {
+		if !*ch.Active {
+			if last, err := services.IsLastActiveAdmin(tx, uid); err != nil {
+				return scimError(c, http.StatusInternalServerError, "", "internal error")
+			} else if last {
+				return scimError(c, http.StatusConflict, "mutability", "cannot deactivate the last admin")
+			}
+		}
+		if _, err := tx.Exec(`UPDATE users SET is_active = ? WHERE id = ?`, *ch.Active, uid); err != nil {
+			return scimError(c, http.StatusInternalServerError, "", "internal error")
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return scimError(c, http.StatusInternalServerError, "", "internal error")
+	}
+
+	u, _, err := loadSCIMUser(db, strconv.FormatInt(uid, 10))
+	if err != nil {
+		return scimError(c, http.StatusInternalServerError, "", "internal error")
+	}
+	return scimJSON(c, http.StatusOK, u)
+}
+
+// SCIMReplaceUser handles PUT /Users/:id.
+func SCIMReplaceUser(db *sqlx.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		_, uid, err := loadSCIMUser(db, c.Param("id"))
+		if errors.Is(err, sql.ErrNoRows) {
+			return scimError(c, http.StatusNotFound, "", "user not found")
+		}
+		if err != nil {
+			return scimError(c, http.StatusInternalServerError, "", "internal error")
+		}
+		var in scimUserInput
+		if err := decodeSCIM(c, &in); err != nil {
+			return scimError(c, http.StatusBadRequest, "invalidSyntax", "invalid json")
+		}
+		email := primarySCIMEmail(in.Emails)
+		if strings.TrimSpace(in.UserName) == "" || email == "" {
+			return scimError(c, http.StatusBadRequest, "invalidValue", "userName and emails are required")
+		}
+		active := in.Active == nil || *in.Active
+		return applySCIMUserChange(db, c, uid, scimUserChange{
+			UserName:      in.UserName,
+			Email:         email,
+			ExternalID:    in.ExternalID,
+			ClearExternal: in.ExternalID == nil,
+			Active:        &active,
+		})
+	}
+}
+
+// scimBool accepts true/false and the "True"/"False" strings some providers send.
+func scimBool(raw json.RawMessage) (bool, bool) {
+	var b bool
+	if json.Unmarshal(raw, &b) == nil {
+		return b, true
+	}
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		if v, err := strconv.ParseBool(strings.ToLower(s)); err == nil {
+			return v, true
+		}
+	}
+	return false, false
+}
+
+func scimString(raw json.RawMessage) (string, bool) {
+	var s string
+	if json.Unmarshal(raw, &s) != nil {
+		return "", false
+	}
+	return s, true
+}
+
+// setSCIMUserAttr applies one attribute from a PATCH operation. Attributes we
+// do not store (name, title, enterprise extension...) are accepted and ignored.
+func setSCIMUserAttr(ch *scimUserChange, op, path string, raw json.RawMessage) error {
+	p := strings.ToLower(path)
+	if reSCIMEmailPath.MatchString(p) {
+		p = "emails.value"
+	}
+	remove := op == "remove"
+
+	switch p {
+	case "active":
+		if remove {
+			return errors.New("active cannot be removed")
+		}
+		v, ok := scimBool(raw)
+		if !ok {
+			return errors.New("active must be a boolean")
+		}
+		ch.Active = &v
+	case "username":
+		v, ok := scimString(raw)
+		if remove || !ok || strings.TrimSpace(v) == "" {
+			return errors.New("userName must be a non-empty string")
+		}
+		ch.UserName = v
+	case "externalid":
+		if remove {
+			ch.ExternalID, ch.ClearExternal = nil, true
+			return nil
+		}
+		v, ok := scimString(raw)
+		if !ok {
+			return errors.New("externalId must be a string")
+		}
+		ch.ExternalID = &v
+	case "emails", "emails.value":
+		if remove {
+			return errors.New("the email address cannot be removed")
+		}
+		if v, ok := scimString(raw); ok {
+			ch.Email = v
+			return nil
+		}
+		var emails []scimEmail
+		if json.Unmarshal(raw, &emails) != nil || primarySCIMEmail(emails) == "" {
+			return errors.New("emails must be a list of addresses")
+		}
+		ch.Email = primarySCIMEmail(emails)
+	}
+	return nil
+}
+
+// SCIMPatchUser handles PATCH /Users/:id (add, replace, remove).
+func SCIMPatchUser(db *sqlx.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		_, uid, err := loadSCIMUser(db, c.Param("id"))
+		if errors.Is(err, sql.ErrNoRows) {
+			return scimError(c, http.StatusNotFound, "", "user not found")
+		}
+		if err != nil {
+			return scimError(c, http.StatusInternalServerError, "", "internal error")
+		}
+		var req scimPatchRequest
+		if err := decodeSCIM(c, &req); err != nil || len(req.Operations) == 0 {
+			return scimError(c, http.StatusBadRequest, "invalidSyntax", "invalid PatchOp")
+		}
+
+		var ch scimUserChange
+		for _, op := range req.Operations {
+			kind := strings.ToLower(op.Op)
+			if kind != "add" && kind != "replace" && kind != "remove" {
+				return scimError(c, http.StatusBadRequest, "invalidSyntax", "unsupported op: "+op.Op)
+			}
+			if op.Path != "" {
+				if err := setSCIMUserAttr(&ch, kind, op.Path, op.Value); err != nil {
+					return scimError(c, http.StatusBadRequest, "invalidValue", err.Error())
+				}
+				continue
+			}
+			// No path: the value is an object of attributes
+			var attrs map[string]json.RawMessage
+			if kind == "remove" || json.Unmarshal(op.Value, &attrs) != nil {
+				return scimError(c, http.StatusBadRequest, "noTarget", "path is required")
+			}
+			for k, v := range attrs {
+				if err := setSCIMUserAttr(&ch, kind, k, v); err != nil {
+					return scimError(c, http.StatusBadRequest, "invalidValue", err.Error())
+				}
+			}
+		}
+		return applySCIMUserChange(db, c, uid, ch)
+	}
+}
+
+// SCIMDeleteUser handles DELETE /Users/:id: the account is purged like a
+// completed self-deletion. Use PATCH active=false to only deactivate.
+func SCIMDeleteUser(db *sqlx.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		_, uid, err := loadSCIMUser(db, c.Param("id"))
+		if errors.Is(err, sql.ErrNoRows) {
+			return scimError(c, http.StatusNotFound, "", "user not found")
+		}
+		if err != nil {
+			return scimError(c, http.StatusInternalServerError, "", "internal error")
+		}
+		if last, err := services.IsLastActiveAdmin(db, uid); err != nil {
+			return scimError(c, http.StatusInternalServerError, "", "internal error")
+		} else if last {
+			return scimError(c, http.StatusConflict, "mutability", "cannot delete the last admin")
+		}
+		if err := services.PurgeAccount(db, uid); err != nil {
+			return scimError(c, http.StatusInternalServerError, "", "internal error")
+		}
+		return c.NoContent(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,333 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"secure-communication-ltd/backend/internal/services"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo/v4"
+)
+
+// SCIM Groups are our roles. Roles are defined by us (db/init.sql), so groups
+// cannot be created, renamed or deleted over SCIM; only membership changes.
+// Like /api/admin/users/:id/roles, changes apply at the member's next request.
+
+var (
+	reSCIMGroupFilter  = regexp.MustCompile(`(?i)^\s*displayName\s+eq\s+"([^"]*)"\s*$`)
+	reSCIMMemberFilter = regexp.MustCompile(`(?i)^members\[\s*value\s+eq\s+"([^"]*)"\s*\]$`)
+)
+
+type scimGroup struct {
+	Schemas     []string  `json:"schemas"`
+	ID          string    `json:"id"`
+	DisplayName string    `json:"displayName"`
+	Members     []scimRef `json:"members"`
+	Meta        scimMeta  `json:"meta"`
+}
+
+func loadSCIMGroups(db *sqlx.DB, where string, args ...any) ([]scimGroup, error) {
+	var roles []struct {
+		ID   int64  `db:"id"`
+		Name string `db:"name"`
+	}
+	if err := db.Select(&roles, `SELECT id, name FROM roles `+where+` ORDER BY id`, args...); err != nil {
+		return nil, err
+	}
+	out := make([]scimGroup, 0, len(roles))
+	for _, r := range roles {
+		var members []struct {
+			UserID   int64  `db:"user_id"`
+			Username string `db:"username"`
+		}
+		if err := db.Select(&members, `
+			SELECT u.id AS user_id, u.username
+			FROM user_roles ur JOIN users u ON u.id = ur.user_id
+			WHERE ur.role_id = ? AND u.is_service_account = FALSE
+			ORDER BY u.id
+		`, r.ID); err != nil {
+			return nil, err
+		}
+		id := strconv.FormatInt(r.ID, 10)
+		g := scimGroup{
+			Schemas:     []string{services.SCIMSchemaGroup},
+			ID:          id,
+			DisplayName: r.Name,
+			Members:     make([]scimRef, 0, len(members)),
+			Meta:        scimMeta{ResourceType: "Group", Location: scimBaseURL() + "/Groups/" + id},
+		}
+		for _, m := range members {
+			uid := strconv.FormatInt(m.UserID, 10)
+			g.Members = append(g.Members, scimRef{Value: uid, Display: m.Username, Ref: scimBaseURL() + "/Users/" + uid})
+		}
+		out = append(out, g)
+	}
+	return out, nil
+}
+
+// SCIMListGroups handles GET /Groups (filter: displayName eq "...").
+func SCIMListGroups(db *sqlx.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		where, args := "", []any{}
+		if f := c.QueryParam("filter"); f != "" {
+			m := reSCIMGroupFilter.FindStringSubmatch(f)
+			if m == nil {
+				return scimError(c, http.StatusBadRequest, "invalidFilter", "only displayName eq is supported")
+			}
+			where, args = "WHERE name = ?", []any{m[1]}
+		}
+		groups, err := loadSCIMGroups(db, where, args...)
+		if err != nil {
+			return scimError(c, http.StatusInternalServerError, "", "internal error")
+		}
+		if c.QueryParam("excludedAttributes") == "members" {
+			for i := range groups {
+				groups[i].Members = nil
+			}
+		}
+		return scimJSON(c, http.StatusOK, scimListResponse(len(groups), 1, len(groups), groups))
+	}
+}
+
+func loadSCIMGroup(db *sqlx.DB, idParam string) (*scimGroup, int64, error) {
+	id, err := strconv.ParseInt(idParam, 10, 64)
+	if err != nil || id <= 0 {
+		return nil, 0, sql.ErrNoRows
+	}
+	groups, err := loadSCIMGroups(db, "WHERE id = ?", id)
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(groups) == 0 {
+		return nil, 0, sql.ErrNoRows
+	}
+	return &groups[0], id, nil
+}
+
+// SCIMGetGroup handles GET /Groups/:id.
+func SCIMGetGroup(db *sqlx.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		g, _, err := loadSCIMGroup(db, c.Param("id"))
+		if errors.Is(err, sql.ErrNoRows) {
+			return scimError(c, http.StatusNotFound, "", "group not found")
+		}
+		if err != nil {
+			return scimError(c, http.StatusInternalServerError, "", "internal error")
+		}
+		return scimJSON(c, http.StatusOK, g)
+	}
+}
+
+func scimMemberIDs(raw json.RawMessage) ([]int64, error) {
+	var refs []scimRef
+	if err := json.Unmarshal(raw, &refs); err != nil {
+		return nil, errors.New("members must be a list of {value}")
+	}
+	ids := make([]int64, 0, len(refs))
+	for _, r := range refs {
+		id, err := strconv.ParseInt(r.Value, 10, 64)
+		if err != nil || id <= 0 {
+			return nil, errors.New("invalid member id: " + r.Value)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// SCIMPatchGroup handles PATCH /Groups/:id: add, remove or replace members.
+func SCIMPatchGroup(db *sqlx.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		g, roleID, err := loadSCIMGroup(db, c.Param("id"))
+		if errors.Is(err, sql.ErrNoRows) {
+			return scimError(c, http.StatusNotFound, "", "group not found")
+		}
+		if err != nil {
+			return scimError(c, http.StatusInternalServerError, "", "internal error")
+		}
+		var req scimPatchRequest
+		if err := decodeSCIM(c, &req); err != nil || len(req.Operations) == 0 {
+			return scimError(c, http.StatusBadRequest, "invalidSyntax", "invalid PatchOp")
+		}
+
+		tx, err := db.Beginx()
+		if err != nil {
+			return scimError(c, http.StatusInternalServerError, "", "internal error")
+		}
+		defer tx.Rollback()
+
+		for _, op := range req.Operations {
+			kind := strings.ToLower(op.Op)
+			path := strings.TrimSpace(op.Path)
+
+			// Azure AD style: {"op":"replace","value":{"displayName":...}} or no-path member lists
+			if path == "" {
+				var attrs map[string]json.RawMessage
+				if json.Unmarshal(op.Value, &attrs) != nil {
+					return scimError(c, http.StatusBadRequest, "noTarget", "path is required")
+				}
+				if name, ok := attrs["displayName"]; ok {
+					if v, _ := scimString(name); v != g.DisplayName {
+						return scimError(c, http.StatusBadRequest, "mutability", "groups cannot be renamed")
+					}
+				}
+				raw, ok := attrs["members"]
+				if !ok {
+					continue
+				}
+				path, op.Value = "members", raw
+			}
+
+			var ids []int64
+			if m := reSCIMMemberFilter.FindStringSubmatch(path); m != nil && kind == "remove" {
+				id, err := strconv.ParseInt(m[1], 10, 64)
+				if err != nil {
+					return scimError(c, http.StatusBadRequest, "invalidValue", "invalid member id")
+				}
+				ids = []int64{id}
+			} else if strings.EqualFold(path, "members") {
+				if kind != "remove" || len(op.Value) > 0 {
+					if ids, err = scimMemberIDs(op.Value); err != nil {
+						return scimError(c, http.StatusBadRequest, "invalidValue", err.Error())
+					}
+				}
+			} else {
+				return scimError(c, http.StatusBadRequest, "invalidPath", "unsupported path: "+op.Path)
+			}
+
+			switch kind {
+			case "add":
+				err = scimAddMembers(tx, roleID, ids)
+			case "remove":
+				if strings.EqualFold(path, "members") && len(op.Value) == 0 {
+					_, err = tx.Exec(`DELETE FROM user_roles WHERE role_id = ?`, roleID)
+				} else {
+					err = scimRemoveMembers(tx, roleID, ids)
+				}
+			case "replace":
+				if _, err = tx.Exec(`DELETE FROM user_roles WHERE role_id = ?`, roleID); err == nil {
+					err = scimAddMembers(tx, roleID, ids)
+				}
+			default:
+				return scimError(c, http.StatusBadRequest, "invalidSyntax", "unsupported op: "+op.Op)
+			}
+			if errors.Is(err, sql.ErrNoRows) {
+				return scimError(c, http.StatusBadRequest, "invalidValue", "unknown member")
+			}
+			if err != nil {
+				return scimError(c, http.StatusInternalServerError, "", "internal error")
+			}
+		}
+
+		admins, err := services.AdminCount(tx)
+		if err != nil {
+			return scimError(c, http.StatusInternalServerError, "", "internal error")
+		}
+		if admins == 0 {
+			return scimError(c, http.StatusConflict, "mutability", "cannot remove the last admin")
+		}
+		if err := tx.Commit(); err != nil {
+			return scimError(c, http.StatusInternalServerError, "", "internal error")
+		}
+
+		if g, _, err = loadSCIMGroup(db, c.Param("id")); err != nil {
+			return scimError(c, http.StatusInternalServerError, "", "internal error")
+		}
+		return scimJSON(c, http.StatusOK, g)
+	}
+}
+
+func scimAddMembers(tx *sqlx.Tx, roleID int64, ids []int64) error {
+	for _, id := range ids {
+		res, err := tx.Exec(`
+			INSERT IGNORE INTO user_roles (user_id, role_id)
+			SELECT id, ? FROM users WHERE id = ? AND is_service_account = FALSE
+		`, roleID, id)
+		if err != nil {
+			return err
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			var exists int
+			if err := tx.Get(&exists, `SELECT COUNT(*) FROM users WHERE id = ? AND is_service_account = FALSE`, id); err != nil {
+				return err
+			}
+			if exists == 0 {
+				return sql.ErrNoRows
+			}
+		}
+	}
+	return nil
+}
+
+func scimRemoveMembers(tx *sqlx.Tx, roleID int64, ids []int64) error {
+	for _, id := range ids {
+		if _, err := tx.Exec(`DELETE FROM user_roles WHERE role_id = ? AND user_id = ?`, roleID, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SCIMReplaceGroup handles PUT /Groups/:id: the member list is replaced as a whole.
+func SCIMReplaceGroup(db *sqlx.DB) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		g, roleID, err := loadSCIMGroup(db, c.Param("id"))
+		if errors.Is(err, sql.ErrNoRows) {
+			return scimError(c, http.StatusNotFound, "", "group not found")
+		}
+		if err != nil {
+			return scimError(c, http.StatusInternalServerError, "", "internal error")
+		}
+		var req struct {
+			DisplayName string          `json:"displayName"`
+			Members     json.RawMessage `json:"members"`
+		}
+		if err := decodeSCIM(c, &req); err != nil {
+			return scimError(c, http.StatusBadRequest, "invalidSyntax", "invalid json")
+		}
+		if req.DisplayName != "" && req.DisplayName != g.DisplayName {
+			return scimError(c, http.StatusBadRequest, "mutability", "groups cannot be renamed")
+		}
+		var ids []int64
+		if len(req.Members) > 0 && string(req.Members) != "null" {
+			if ids, err = scimMemberIDs(req.Members); err != nil {
+				return scimError(c, http.StatusBadRequest, "invalidValue", err.Error())
+			}
+		}
+
+		tx, err := db.Beginx()
+		if err != nil {
+			return scimError(c, http.StatusInternalServerError, "", "internal error")
+		}
+		defer tx.Rollback()
+		if _, err := tx.Exec(`DELETE FROM user_roles WHERE role_id = ?`, roleID); err != nil {
+			return scimError(c, http.StatusInternalServerError, "", "internal error")
+		}
+		err = scimAddMembers(tx, roleID, ids)
+		if errors.Is(err, sql.ErrNoRows) {
+			return scimError(c, http.StatusBadRequest, "invalidValue", "unknown member")
+		}
+		if err != nil {
+			return scimError(c, http.StatusInternalServerError, "", "internal error")
+		}
+		admins, err := services.AdminCount(tx)
+		if err != nil {
+			return scimError(c, http.StatusInternalServerError, "", "internal error")
+		}
+		if admins == 0 {
+			return scimError(c, http.StatusConflict, "mutability", "cannot remove the last admin")
+		}
+		if err := tx.Commit(); err != nil {
+			return scimError(c, http.StatusInternalServerError, "", "internal error")
+		}
+		if g, _, err = loadSCIMGroup(db, c.Param("id")); err != nil {
+			return scimError(c, http.StatusInternalServerError, "", "internal error")
+		}
+		return scimJSON(c, http.StatusOK, g)
+	}
+}
@@ -0,0 +1,83 @@
+package handlers
+
+import (
+	"log"
+	"time"
+
+	"secure-communication-ltd/backend/internal/services"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo/v4"
+)
+
+const sessionTTL = 24 * time.Hour
+
+// startSession issues the session JWT for userID and sets it as the auth cookie.
+// Every sign-in path must go through here so sessions look the same. The
+// session the request carried, if any, is revoked (it is being replaced).
+func startSession(c echo.Context, db *sqlx.DB, userID int64) error {
+	return startSessionInOrg(c, db, userID, 0)
+}
+
+// startSessionInOrg is startSession with the active organization chosen by
+// the caller (a verified membership); 0 picks it with ActiveOrgForUser.
+func startSessionInOrg(c echo.Context, db *sqlx.DB, userID, orgID int64) error {
+	claims, err := services.NewSessionClaims(db, userID)
+	if err != nil {
+		return err
+	}
+	if orgID != 0 {
+		claims.OrgID = orgID
+	}
+	claims.SessionID, err = services.CreateSession(db, userID, services.HashSHA256Hex(deviceID(c)),
+		c.RealIP(), c.Request().UserAgent(), sessionTTL)
+	if err != nil {
+		return err
+	}
+	token, err := services.CreateJWT(claims, sessionTTL)
+	if err != nil {
+		return err
+	}
+	endCurrentSession(c, db)
+
+	c.SetCookie(services.NewCookie(services.SessionCookie, token, sessionTTL))
+	return nil
+}
+
+// endCurrentSession revokes the session of the request's cookie, if valid.
+func endCurrentSession(c echo.Context, db *sqlx.DB) *services.Claims {
+	ck, err := c.Cookie(services.SessionCookieName())
+	if err != nil {
+		return nil
+	}
+	old, err := services.ParseJWT(ck.Value)
+	if err != nil {
+		return nil
+	}
+	if old.SessionID != "" {
+		if err := services.RevokeSession(db, old.UserID, old.SessionID); err != nil {
+			log.Printf("[session] revoke: %v", err)
+		}
+	}
+	return old
+}
+
+const ctxDeviceIDKey = "device_id"
+
+// deviceID returns the browser's device cookie value, issuing a new one on
+// first use (once per request).
+func deviceID(c echo.Context) string {
+	if v, ok := c.Get(ctxDeviceIDKey).(string); ok {
+		return v
+	}
+	var id string
+	if ck, err := c.Cookie(services.CookieName(services.DeviceCookie, "/")); err == nil && len(ck.Value) >= 22 && len(ck.Value) <= 64 {
+		id = ck.Value
+	} else if id, err = services.NewRandomBase64URL(24); err != nil {
+		return ""
+	}
+	// refreshed on every sign-in so an active browser keeps its identity
+	c.SetCookie(services.NewCookie(services.DeviceCookie, id, services.DeviceCookieTTL))
+	c.Set(ctxDeviceIDKey, id)
+	return id
+}
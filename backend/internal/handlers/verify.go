@@ -5,10 +5,14 @@ import (
 	"database/sql"
 	"encoding/hex"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/jmoiron/sqlx"
 	"github.com/labstack/echo/v4"
+
+	middlewarex "secure-communication-ltd/backend/internal/middleware"
+	"secure-communication-ltd/backend/internal/services"
 )
 
 func VerifyEmail(db *sqlx.DB) echo.HandlerFunc {
@@ -71,6 +75,10 @@ func VerifyEmail(db *sqlx.DB) echo.HandlerFunc {
 			return RenderVerificationPage(c, http.StatusInternalServerError, false,
 				"Server Error", "Could not finalize verification.")
 		}
+		middlewarex.Audit(c, db, services.AuditEvent{
+			Type: services.AuditEmailVerified, ActorUserID: userID,
+			SubjectType: "user", SubjectID: strconv.FormatInt(userID, 10),
+		})
 
 		return RenderVerificationPage(c, http.StatusOK, true,
 			"Email Verified", "Your account is now active. You can sign in.")
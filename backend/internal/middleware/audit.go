@@ -0,0 +1,43 @@
+package middlewarex
+
+import (
+	"log"
+
+	"secure-communication-ltd/backend/internal/services"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo/v4"
+)
+
+// Audit records a security event for the current request: client IP (see
+// TRUSTED_PROXIES), user agent and request ID are filled in, and so is the
+// actor when the request is authenticated and ev names none. In an
+// impersonation session the actor is the admin, and the impersonated user is
+// added to the details. Best-effort: a failed write is logged but never
+// changes the response.
+func Audit(c echo.Context, db *sqlx.DB, ev services.AuditEvent) {
+	if ev.ActorUserID == 0 {
+		if cl, ok := c.Get(CtxClaimsKey).(*services.Claims); ok {
+			ev.ActorUserID = cl.UserID
+			if ev.ActorLabel == "" {
+				ev.ActorLabel = cl.Username
+			}
+			if cl.Impersonating() {
+				ev.ActorUserID, ev.ActorLabel = cl.ImpersonatorID, cl.ImpersonatorName
+				if ev.Details == nil {
+					ev.Details = map[string]any{}
+				}
+				ev.Details["impersonating_user_id"] = cl.UserID
+			}
+			if ev.OrgID == 0 {
+				ev.OrgID = cl.OrgID
+			}
+		}
+	}
+	ev.IP = c.RealIP()
+	ev.UserAgent = c.Request().UserAgent()
+	ev.RequestID = c.Response().Header().Get(echo.HeaderXRequestID)
+	if err := services.AppendAudit(db, ev); err != nil {
+		log.Printf("[audit] %s: %v", ev.Type, err)
+	}
+}
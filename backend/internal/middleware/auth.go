@@ -3,31 +3,182 @@ package middlewarex
 import (
 	"errors"
 	"net/http"
+	"strconv"
+	"strings"
 
 	"secure-communication-ltd/backend/internal/services"
 
+	"github.com/jmoiron/sqlx"
 	"github.com/labstack/echo/v4"
 )
 
-const CtxUserIDKey = "user_id"
+const (
+	CtxUserIDKey = "user_id"
+	CtxClaimsKey = "claims"
+)
+
+var ErrUnauthenticated = errors.New("unauthenticated")
+
+// Authenticate resolves the caller's claims. Browser sessions use the JWT
+// cookie; machine clients send "Authorization: Bearer <token>" with an API key
+// (sclk_...) or an OAuth access token (sclo_...). A bearer header takes
+// precedence so a stray cookie cannot change what an API client is allowed to do.
+// Bad credentials yield ErrUnauthenticated; other errors are internal.
+func Authenticate(db *sqlx.DB, c echo.Context) (*services.Claims, error) {
+	if token, ok := bearerToken(c.Request()); ok {
+		var (
+			cl  *services.Claims
+			err error
+		)
+		switch {
+		case services.IsAPIKeyToken(token):
+			cl, err = services.AuthenticateAPIKey(db, token, c.RealIP())
+		case services.IsOAuthAccessToken(token):
+			cl, err = services.AuthenticateOAuthToken(db, token)
+		default:
+			return nil, ErrUnauthenticated
+		}
+		if errors.Is(err, services.ErrInvalidAPIKey) || errors.Is(err, services.ErrInvalidOAuthToken) {
+			return nil, ErrUnauthenticated
+		}
+		return cl, err
+	}
 
-// RequireAuth checks the cookie, validates JWT, and stores userID in context
-func RequireAuth(next echo.HandlerFunc) echo.HandlerFunc {
+	cookie, err := c.Cookie(services.SessionCookieName())
+	if err != nil || cookie.Value == "" {
+		return nil, ErrUnauthenticated
+	}
+	cl, err := services.ParseJWT(cookie.Value)
+	if err != nil {
+		return nil, ErrUnauthenticated
+	}
+	// Revoked sessions, and deactivated or locked accounts, stop working at once
+	active, err := services.SessionActive(db, cl.UserID, cl.SessionID)
+	if err != nil {
+		return nil, err
+	}
+	if !active {
+		return nil, ErrUnauthenticated
+	}
+	// Like API keys and OAuth tokens, access is read per request and not
+	// taken from the cookie, so role changes apply to live sessions
+	if cl.Roles, cl.Permissions, err = services.LoadUserAccess(db, cl.UserID); err != nil {
+		return nil, err
+	}
+	cl.AuthMethod = services.AuthSession
+	return cl, nil
+}
+
+// RequireAuth authenticates the request (see Authenticate) and stores userID
+// and claims in context.
+func RequireAuth(db *sqlx.DB) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			claims, err := Authenticate(db, c)
+			if errors.Is(err, ErrUnauthenticated) {
+				return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+			}
+			if err != nil {
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+			}
+
+			// Store userID in context for handlers to use
+			c.Set(CtxUserIDKey, claims.UserID)
+			c.Set(CtxClaimsKey, claims)
+
+			if !claims.Impersonating() {
+				return next(c)
+			}
+			err = next(c)
+			auditImpersonatedRequest(c, db, claims, err)
+			return err
+		}
+	}
+}
+
+// auditImpersonatedRequest records a request made in an impersonation session,
+// after the handler ran (err is its error, if any).
+func auditImpersonatedRequest(c echo.Context, db *sqlx.DB, claims *services.Claims, err error) {
+	status := c.Response().Status
+	var he *echo.HTTPError
+	if errors.As(err, &he) {
+		status = he.Code
+	}
+	outcome := services.AuditSuccess
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		outcome = services.AuditDenied
+	case status >= 400:
+		outcome = services.AuditFailure
+	}
+	Audit(c, db, services.AuditEvent{
+		Type: services.AuditImpersonatedRequest, Outcome: outcome,
+		SubjectType: "user", SubjectID: strconv.FormatInt(claims.UserID, 10),
+		Details: map[string]any{
+			"method": c.Request().Method,
+			"path":   c.Request().URL.Path,
+			"route":  c.Path(),
+			"status": status,
+		},
+	})
+}
+
+// RequireSession must run after RequireAuth. It limits a route to interactive
+// (cookie) sessions, e.g. managing API keys or changing the password; API keys
+// and OAuth access tokens are refused.
+func RequireSession(next echo.HandlerFunc) echo.HandlerFunc {
 	return func(c echo.Context) error {
-		cookie, err := c.Cookie(services.CookieName)
-		if err != nil || cookie.Value == "" {
+		claims, err := ClaimsFromCtx(c)
+		if err != nil {
 			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
 		}
+		if claims.AuthMethod != services.AuthSession {
+			return c.JSON(http.StatusForbidden, map[string]string{"error": "requires an interactive session"})
+		}
+		return next(c)
+	}
+}
 
-		claims, err := services.ParseJWT(cookie.Value)
+// DenyImpersonation must run after RequireAuth. It refuses sensitive actions
+// (password change, API keys, account deletion, ...) in an impersonation
+// session: support staff may look, not take over the account.
+func DenyImpersonation(next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		claims, err := ClaimsFromCtx(c)
 		if err != nil {
 			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
 		}
+		if claims.Impersonating() {
+			return c.JSON(http.StatusForbidden, map[string]string{"error": "not allowed while impersonating"})
+		}
+		return next(c)
+	}
+}
 
-		// Store userID in context for handlers to use
-		c.Set(CtxUserIDKey, claims.UserID)
+func bearerToken(r *http.Request) (string, bool) {
+	h := r.Header.Get(echo.HeaderAuthorization)
+	if len(h) < 7 || !strings.EqualFold(h[:7], "Bearer ") {
+		return "", false
+	}
+	return strings.TrimSpace(h[7:]), true
+}
 
-		return next(c)
+// RequirePermission must run after RequireAuth. It rejects the request with
+// 403 unless the session carries every listed permission.
+func RequirePermission(perms ...string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			claims, err := ClaimsFromCtx(c)
+			if err != nil {
+				return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+			}
+			for _, p := range perms {
+				if !claims.HasPermission(p) {
+					return c.JSON(http.StatusForbidden, map[string]string{"error": "forbidden"})
+				}
+			}
+			return next(c)
+		}
 	}
 }
 
@@ -39,3 +190,12 @@ func UserIDFromCtx(c echo.Context) (int64, error) {
 	}
 	return uid, nil
 }
+
+// ClaimsFromCtx returns the session claims stored in context by RequireAuth
+func ClaimsFromCtx(c echo.Context) (*services.Claims, error) {
+	claims, ok := c.Get(CtxClaimsKey).(*services.Claims)
+	if !ok {
+		return nil, errors.New("claims not found in context")
+	}
+	return claims, nil
+}
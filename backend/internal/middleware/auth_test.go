@@ -0,0 +1,226 @@
+package middlewarex
+
+import (
+	"database/sql/driver"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"secure-communication-ltd/backend/internal/services"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo/v4"
+)
+
+func newMockDB(t *testing.T) (*sqlx.DB, sqlmock.Sqlmock) {
+	t.Helper()
+	raw, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		raw.Close()
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+	return sqlx.NewDb(raw, "mysql"), mock
+}
+
+// sessionCookie signs a session token the way releases before per-request
+// access did: with the roles and permissions of sign-in time embedded.
+func sessionCookie(t *testing.T, uid int64, sid string, perms ...string) *http.Cookie {
+	t.Helper()
+	t.Setenv("JWT_SECRET", "test-secret")
+	now := time.Now()
+	tok, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"uid": uid, "uname": "alice", "sid": sid, "roles": []string{"admin"}, "perms": perms,
+		"iat": now.Unix(), "exp": now.Add(time.Hour).Unix(),
+	}).SignedString([]byte("test-secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &http.Cookie{Name: services.SessionCookieName(), Value: tok}
+}
+
+func authContext(ck *http.Cookie) echo.Context {
+	req := httptest.NewRequest(http.MethodGet, "/api/admin/users", nil)
+	if ck != nil {
+		req.AddCookie(ck)
+	}
+	return echo.New().NewContext(req, httptest.NewRecorder())
+}
+
+// A session's access is what the user holds now, not what the cookie says.
+func TestAuthenticateLoadsAccessPerRequest(t *testing.T) {
+	db, mock := newMockDB(t)
+	c := authContext(sessionCookie(t, 7, "sid-1", services.PermUsersAdmin, services.PermCustomersRead))
+
+	mock.ExpectQuery(`FROM user_sessions`).WithArgs("sid-1", int64(7)).
+		WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(1))
+	mock.ExpectQuery(`SELECT r\.name`).WithArgs(int64(7)).
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow("viewer"))
+	mock.ExpectQuery(`SELECT DISTINCT p\.name`).WithArgs(int64(7)).
+		WillReturnRows(sqlmock.NewRows([]string{"name"}).AddRow(services.PermCustomersRead))
+
+	cl, err := Authenticate(db, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cl.AuthMethod != services.AuthSession || cl.UserID != 7 {
+		t.Fatalf("claims: %+v", cl)
+	}
+	if len(cl.Roles) != 1 || cl.Roles[0] != "viewer" {
+		t.Errorf("roles = %v, want [viewer]", cl.Roles)
+	}
+	if cl.HasPermission(services.PermUsersAdmin) || !cl.HasPermission(services.PermCustomersRead) {
+		t.Errorf("permissions = %v, want [%s]", cl.Permissions, services.PermCustomersRead)
+	}
+}
+
+func TestAuthenticateRevokedSession(t *testing.T) {
+	db, mock := newMockDB(t)
+	c := authContext(sessionCookie(t, 7, "sid-1", services.PermUsersAdmin))
+	mock.ExpectQuery(`FROM user_sessions`).WithArgs("sid-1", int64(7)).
+		WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(0))
+
+	if _, err := Authenticate(db, c); !errors.Is(err, ErrUnauthenticated) {
+		t.Fatalf("err = %v, want ErrUnauthenticated", err)
+	}
+}
+
+func TestAuthenticateWithoutCredentials(t *testing.T) {
+	db, _ := newMockDB(t)
+	for name, ck := range map[string]*http.Cookie{
+		"no cookie":  nil,
+		"bad token":  {Name: services.SessionCookieName(), Value: "not-a-jwt"},
+		"no session": sessionCookie(t, 7, "", services.PermUsersAdmin), // pre-session token
+	} {
+		if _, err := Authenticate(db, authContext(ck)); !errors.Is(err, ErrUnauthenticated) {
+			t.Errorf("%s: err = %v, want ErrUnauthenticated", name, err)
+		}
+	}
+}
+
+// Routes guarded by RequirePermission see the reloaded permissions.
+func TestRequirePermissionAfterRoleChange(t *testing.T) {
+	db, mock := newMockDB(t)
+	mock.ExpectQuery(`FROM user_sessions`).WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(1))
+	mock.ExpectQuery(`SELECT r\.name`).WillReturnRows(sqlmock.NewRows([]string{"name"}))
+	mock.ExpectQuery(`SELECT DISTINCT p\.name`).WillReturnRows(sqlmock.NewRows([]string{"name"}))
+
+	e := echo.New()
+	e.GET("/api/admin/users", func(c echo.Context) error { return c.NoContent(http.StatusOK) },
+		RequireAuth(db), RequirePermission(services.PermUsersAdmin))
+	req := httptest.NewRequest(http.MethodGet, "/api/admin/users", nil)
+	req.AddCookie(sessionCookie(t, 7, "sid-1", services.PermUsersAdmin))
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("status = %d, want 403", rec.Code)
+	}
+}
+
+// impersonationCookie signs the session AdminImpersonate issues: user uid,
+// used by the admin adminID.
+func impersonationCookie(t *testing.T, uid, adminID int64, sid string) *http.Cookie {
+	t.Helper()
+	t.Setenv("JWT_SECRET", "test-secret")
+	tok, err := services.CreateJWT(&services.Claims{
+		UserID: uid, Username: "bob", SessionID: sid, ImpersonatorID: adminID, ImpersonatorName: "root",
+	}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &http.Cookie{Name: services.SessionCookieName(), Value: tok}
+}
+
+// captureArg matches any value and keeps it.
+type captureArg struct{ v *driver.Value }
+
+func (c captureArg) Match(v driver.Value) bool {
+	*c.v = v
+	return true
+}
+
+// Every request in an impersonation session is audited after the handler
+// ran, with the admin as the actor and the impersonated user in the details.
+func TestImpersonatedRequestsAudited(t *testing.T) {
+	for _, tc := range []struct {
+		status  int
+		outcome string
+	}{
+		{http.StatusOK, services.AuditSuccess},
+		{http.StatusForbidden, services.AuditDenied},
+		{http.StatusNotFound, services.AuditFailure},
+	} {
+		t.Setenv("AUDIT_HMAC_KEY", "test-audit-key")
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(`FROM user_sessions`).WithArgs("sid-9", int64(7)).
+			WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(1))
+		mock.ExpectQuery(`SELECT r\.name`).WillReturnRows(sqlmock.NewRows([]string{"name"}))
+		mock.ExpectQuery(`SELECT DISTINCT p\.name`).WillReturnRows(sqlmock.NewRows([]string{"name"}))
+		mock.ExpectBegin()
+		mock.ExpectQuery(`SELECT last_hash FROM audit_chain_head`).
+			WillReturnRows(sqlmock.NewRows([]string{"last_hash"}).AddRow(services.AuditChainGenesis))
+		args := make([]driver.Value, 14)
+		matchers := make([]driver.Value, len(args))
+		for i := range args {
+			matchers[i] = captureArg{&args[i]}
+		}
+		mock.ExpectExec(`INSERT INTO audit_log`).WithArgs(matchers...).WillReturnResult(sqlmock.NewResult(1, 1))
+		mock.ExpectExec(`UPDATE audit_chain_head`).WillReturnResult(sqlmock.NewResult(0, 1))
+		mock.ExpectCommit()
+
+		e := echo.New()
+		e.GET("/api/customers/:id", func(c echo.Context) error { return c.NoContent(tc.status) }, RequireAuth(db))
+		req := httptest.NewRequest(http.MethodGet, "/api/customers/5", nil)
+		req.AddCookie(impersonationCookie(t, 7, 1, "sid-9"))
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		if rec.Code != tc.status {
+			t.Fatalf("status = %d, want %d", rec.Code, tc.status)
+		}
+
+		// event_type, outcome, actor_user_id, actor_label, subject_type, subject_id
+		if args[1] != string(services.AuditImpersonatedRequest) || args[2] != tc.outcome ||
+			args[3] != int64(1) || args[4] != "root" || args[5] != "user" || args[6] != "7" {
+			t.Errorf("%d: audit entry %v", tc.status, args[1:7])
+		}
+		details, _ := args[11].(string)
+		for _, want := range []string{`"impersonating_user_id":7`, `"route":"/api/customers/:id"`, `"path":"/api/customers/5"`} {
+			if !strings.Contains(details, want) {
+				t.Errorf("%d: details %s lack %s", tc.status, details, want)
+			}
+		}
+	}
+}
+
+func TestDenyImpersonation(t *testing.T) {
+	ok := func(c echo.Context) error { return c.NoContent(http.StatusNoContent) }
+	for _, tc := range []struct {
+		claims *services.Claims
+		want   int
+	}{
+		{&services.Claims{UserID: 7}, http.StatusNoContent},
+		{&services.Claims{UserID: 7, ImpersonatorID: 1}, http.StatusForbidden},
+		{nil, http.StatusUnauthorized},
+	} {
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(httptest.NewRequest(http.MethodDelete, "/api/me", nil), rec)
+		if tc.claims != nil {
+			c.Set(CtxClaimsKey, tc.claims)
+		}
+		if err := DenyImpersonation(ok)(c); err != nil {
+			t.Fatal(err)
+		}
+		if rec.Code != tc.want {
+			t.Errorf("claims %+v: status = %d, want %d", tc.claims, rec.Code, tc.want)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package middlewarex
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"secure-communication-ltd/backend/internal/services"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+const (
+	CSRFCookieName = "csrf_token" // logical name; see services.CookieName
+	CSRFFormField  = "csrf_token" // HTML forms rendered by the backend (OAuth consent)
+	CtxCSRFKey     = "csrf"
+)
+
+// csrfExemptPaths take no cookies: client-authenticated OAuth endpoints and
+// the SCIM API (bearer token).
+var csrfExemptPaths = []string{"/oauth/token", "/oauth/introspect", "/oauth/revoke", "/scim/v2/"}
+
+// csrfSkip exempts requests that cannot ride on the browser's cookies:
+// bearer-token clients (a bearer header takes precedence over the cookie in
+// Authenticate) and the exempt paths.
+func csrfSkip(c echo.Context) bool {
+	if _, ok := bearerToken(c.Request()); ok {
+		return true
+	}
+	p := c.Request().URL.Path
+	for _, e := range csrfExemptPaths {
+		if p == e || (strings.HasSuffix(e, "/") && strings.HasPrefix(p, e)) {
+			return true
+		}
+	}
+	return false
+}
+
+func isSafeMethod(m string) bool {
+	return m == http.MethodGet || m == http.MethodHead || m == http.MethodOptions || m == http.MethodTrace
+}
+
+// CSRF protects every state-changing request (POST, PUT, PATCH, DELETE) that
+// a browser could send with our cookies:
+//
+//  1. Origin (or, without it, Referer) must be one of trustedOrigins;
+//  2. double-submit token: the X-CSRF-Token header (or csrf_token form field)
+//     must match the csrf_token cookie. The SPA gets it from GET /api/csrf.
+//
+// The token cookie is HttpOnly: the frontend may be served from another
+// host, so it reads the token from the endpoint, never from document.cookie.
+func CSRF(trustedOrigins []string) echo.MiddlewareFunc {
+	trusted := map[string]bool{}
+	for _, o := range trustedOrigins {
+		if o = normalizeOrigin(o); o != "" {
+			trusted[o] = true
+		}
+	}
+
+	// same policy as services.NewCookie (Secure, Domain, __Host- prefix, SameSite)
+	ck := services.NewCookie(CSRFCookieName, "", 0)
+	tokens := middleware.CSRFWithConfig(middleware.CSRFConfig{
+		Skipper:        csrfSkip,
+		TokenLookup:    "header:" + echo.HeaderXCSRFToken + ",form:" + CSRFFormField,
+		ContextKey:     CtxCSRFKey,
+		CookieName:     ck.Name,
+		CookieDomain:   ck.Domain,
+		CookiePath:     ck.Path,
+		CookieSecure:   ck.Secure,
+		CookieHTTPOnly: true,
+		CookieSameSite: ck.SameSite,
+		ErrorHandler: func(err error, c echo.Context) error {
+			return c.JSON(http.StatusForbidden, map[string]string{"error": "invalid or missing csrf token"})
+		},
+	})
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		checked := tokens(next)
+		return func(c echo.Context) error {
+			if !isSafeMethod(c.Request().Method) && !csrfSkip(c) {
+				if origin := requestOrigin(c.Request()); origin != "" && !trusted[origin] {
+					return c.JSON(http.StatusForbidden, map[string]string{"error": "cross-origin request refused"})
+				}
+			}
+			return checked(c)
+		}
+	}
+}
+
+// requestOrigin returns the normalized Origin header, falling back to the
+// Referer's origin. Empty when the client sent neither (non-browser clients);
+// the token check still applies then.
+func requestOrigin(r *http.Request) string {
+	if o := r.Header.Get(echo.HeaderOrigin); o != "" {
+		if o == "null" {
+			return "null" // sandboxed/opaque origin: never trusted
+		}
+		return normalizeOrigin(o)
+	}
+	if ref := r.Referer(); ref != "" {
+		if o := normalizeOrigin(ref); o != "" {
+			return o
+		}
+		return "null"
+	}
+	return ""
+}
+
+// normalizeOrigin reduces a URL to scheme://host[:port], lower-cased.
+func normalizeOrigin(raw string) string {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return ""
+	}
+	return strings.ToLower(u.Scheme + "://" + u.Host)
+}
+
+// CSRFToken returns the request's CSRF token (set by the CSRF middleware).
+func CSRFToken(c echo.Context) string {
+	t, _ := c.Get(CtxCSRFKey).(string)
+	return t
+}
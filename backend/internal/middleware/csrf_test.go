@@ -0,0 +1,129 @@
+package middlewarex
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"secure-communication-ltd/backend/internal/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+const testAppOrigin = "https://app.example"
+
+// csrfServer routes every path used below behind the CSRF middleware; each
+// handler answers 204 so a pass is easy to tell from a refusal.
+func csrfServer() *echo.Echo {
+	e := echo.New()
+	e.Use(CSRF([]string{testAppOrigin}))
+	ok := func(c echo.Context) error { return c.NoContent(http.StatusNoContent) }
+	e.GET("/api/csrf", func(c echo.Context) error { return c.String(http.StatusOK, CSRFToken(c)) })
+	for _, p := range []string{"/api/customers", "/oauth/token", "/oauth/authorize", "/scim/v2/Users"} {
+		e.POST(p, ok)
+	}
+	return e
+}
+
+// csrfToken fetches a token the way the SPA does and returns it with its cookie.
+func csrfToken(t *testing.T, e *echo.Echo) (string, *http.Cookie) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/csrf", nil))
+	name := services.NewCookie(CSRFCookieName, "", 0).Name
+	for _, ck := range rec.Result().Cookies() {
+		if ck.Name == name {
+			if !ck.HttpOnly || ck.Value != rec.Body.String() {
+				t.Fatalf("cookie %+v does not carry the token %q", ck, rec.Body)
+			}
+			return ck.Value, ck
+		}
+	}
+	t.Fatalf("no %s cookie in %v", name, rec.Result().Cookies())
+	return "", nil
+}
+
+func TestCSRF(t *testing.T) {
+	e := csrfServer()
+	token, cookie := csrfToken(t, e)
+
+	for _, tc := range []struct {
+		name    string
+		path    string
+		headers map[string]string
+		token   string // sent in X-CSRF-Token; the cookie is always sent
+		want    int
+	}{
+		{name: "valid token", path: "/api/customers", token: token, want: http.StatusNoContent},
+		{name: "valid token, trusted origin", path: "/api/customers", token: token,
+			headers: map[string]string{echo.HeaderOrigin: testAppOrigin}, want: http.StatusNoContent},
+		{name: "valid token, trusted referer", path: "/api/customers", token: token,
+			headers: map[string]string{"Referer": testAppOrigin + "/customers"}, want: http.StatusNoContent},
+		{name: "missing token", path: "/api/customers", want: http.StatusForbidden},
+		{name: "wrong token", path: "/api/customers", token: token + "x", want: http.StatusForbidden},
+		{name: "wrong origin", path: "/api/customers", token: token,
+			headers: map[string]string{echo.HeaderOrigin: "https://evil.example"}, want: http.StatusForbidden},
+		{name: "origin null", path: "/api/customers", token: token,
+			headers: map[string]string{echo.HeaderOrigin: "null"}, want: http.StatusForbidden},
+		{name: "wrong referer", path: "/api/customers", token: token,
+			headers: map[string]string{"Referer": "https://evil.example/page"}, want: http.StatusForbidden},
+		{name: "unparsable referer", path: "/api/customers", token: token,
+			headers: map[string]string{"Referer": "not a url"}, want: http.StatusForbidden},
+		{name: "bearer skipped", path: "/api/customers",
+			headers: map[string]string{echo.HeaderAuthorization: "Bearer sk_test", echo.HeaderOrigin: "https://evil.example"},
+			want:    http.StatusNoContent},
+		{name: "scim skipped", path: "/scim/v2/Users", want: http.StatusNoContent},
+		{name: "oauth token skipped", path: "/oauth/token", want: http.StatusNoContent},
+		{name: "oauth authorize not skipped", path: "/oauth/authorize", want: http.StatusForbidden},
+		{name: "oauth authorize with token", path: "/oauth/authorize", token: token, want: http.StatusNoContent},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tc.path, strings.NewReader("{}"))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			req.AddCookie(cookie)
+			if tc.token != "" {
+				req.Header.Set(echo.HeaderXCSRFToken, tc.token)
+			}
+			for k, v := range tc.headers {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			if rec.Code != tc.want {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tc.want, rec.Body)
+			}
+		})
+	}
+}
+
+// The consent page posts a form, with the token in a field instead of a header.
+func TestCSRFFormField(t *testing.T) {
+	e := csrfServer()
+	token, cookie := csrfToken(t, e)
+
+	for field, want := range map[string]int{token: http.StatusNoContent, "forged": http.StatusForbidden} {
+		req := httptest.NewRequest(http.MethodPost, "/oauth/authorize", strings.NewReader(CSRFFormField+"="+field))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+		req.AddCookie(cookie)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Errorf("field %q: status = %d, want %d", field, rec.Code, want)
+		}
+	}
+}
+
+func TestNormalizeOrigin(t *testing.T) {
+	for in, want := range map[string]string{
+		"https://App.Example":           "https://app.example",
+		" https://app.example:8443/x?y": "https://app.example:8443",
+		"app.example":                   "",
+		"/relative":                     "",
+		"":                              "",
+	} {
+		if got := normalizeOrigin(in); got != want {
+			t.Errorf("normalizeOrigin(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
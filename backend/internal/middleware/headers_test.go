@@ -0,0 +1,55 @@
+package middlewarex
+
+import (
+	"crypto/tls"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	e := echo.New()
+	e.Use(SecurityHeaders())
+	e.GET("/api/me", func(c echo.Context) error { return c.JSON(http.StatusOK, map[string]string{}) })
+
+	for _, https := range []bool{false, true} {
+		req := httptest.NewRequest(http.MethodGet, "/api/me", nil)
+		if https {
+			req.TLS = &tls.ConnectionState{}
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+
+		h := rec.Header()
+		for k, want := range map[string]string{
+			echo.HeaderContentSecurityPolicy: APIContentSecurityPolicy,
+			echo.HeaderXContentTypeOptions:   "nosniff",
+			echo.HeaderXFrameOptions:         "DENY",
+			echo.HeaderReferrerPolicy:        "same-origin",
+			"Cross-Origin-Opener-Policy":     "same-origin",
+		} {
+			if got := h.Get(k); got != want {
+				t.Errorf("https=%v: %s = %q, want %q", https, k, got, want)
+			}
+		}
+		if h.Get("Permissions-Policy") == "" {
+			t.Errorf("https=%v: no Permissions-Policy", https)
+		}
+		if hsts := h.Get(echo.HeaderStrictTransportSecurity); (hsts != "") != https {
+			t.Errorf("https=%v: Strict-Transport-Security = %q", https, hsts)
+		}
+	}
+}
+
+// Error responses, including 404s from the router, carry the headers too.
+func TestSecurityHeadersOnErrors(t *testing.T) {
+	e := echo.New()
+	e.Use(SecurityHeaders())
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/nowhere", nil))
+	if rec.Code != http.StatusNotFound || rec.Header().Get(echo.HeaderContentSecurityPolicy) != APIContentSecurityPolicy {
+		t.Fatalf("status %d, headers %v", rec.Code, rec.Header())
+	}
+}
@@ -0,0 +1,57 @@
+package middlewarex
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"secure-communication-ltd/backend/internal/services"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/labstack/echo/v4"
+)
+
+const CtxOrgIDKey = "org_id"
+
+// RequireOrg must run after RequireAuth. It takes the active organization from
+// the session, re-checks membership on every request (so removing a member
+// takes effect immediately) and stores the org ID in context. Every
+// tenant-scoped query must use OrgIDFromCtx.
+func RequireOrg(db *sqlx.DB) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			claims, err := ClaimsFromCtx(c)
+			if err != nil {
+				return c.JSON(http.StatusUnauthorized, map[string]string{"error": "unauthorized"})
+			}
+			if claims.OrgID == 0 {
+				return c.JSON(http.StatusForbidden, map[string]string{"error": "no active organization"})
+			}
+			ok, err := services.IsOrgMember(db, claims.UserID, claims.OrgID)
+			if err != nil {
+				return c.JSON(http.StatusInternalServerError, map[string]string{"error": "db error"})
+			}
+			if !ok {
+				services.RecordOrgAccessDenial(db, claims.UserID, claims.OrgID,
+					c.Request().Method+" "+c.Path(), c.RealIP())
+				Audit(c, db, services.AuditEvent{
+					Type: services.AuditOrgAccessDenied, Outcome: services.AuditDenied,
+					SubjectType: "organization", SubjectID: strconv.FormatInt(claims.OrgID, 10),
+					Details: map[string]any{"action": c.Request().Method + " " + c.Path()},
+				})
+				return c.JSON(http.StatusForbidden, map[string]string{"error": "not a member of the active organization"})
+			}
+			c.Set(CtxOrgIDKey, claims.OrgID)
+			return next(c)
+		}
+	}
+}
+
+// OrgIDFromCtx returns the active organization stored in context by RequireOrg
+func OrgIDFromCtx(c echo.Context) (int64, error) {
+	orgID, ok := c.Get(CtxOrgIDKey).(int64)
+	if !ok || orgID == 0 {
+		return 0, errors.New("org id not found in context")
+	}
+	return orgID, nil
+}
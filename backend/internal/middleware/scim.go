@@ -0,0 +1,33 @@
+package middlewarex
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+
+	"secure-communication-ltd/backend/internal/services"
+
+	"github.com/labstack/echo/v4"
+)
+
+// RequireSCIMToken protects /scim/v2 with the dedicated provisioning bearer
+// token (SCIM_BEARER_TOKEN). It is separate from user API keys: the HR system
+// is not a user and must not depend on any account staying active.
+func RequireSCIMToken(token string) echo.MiddlewareFunc {
+	want := sha256.Sum256([]byte(token))
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			got, ok := bearerToken(c.Request())
+			sum := sha256.Sum256([]byte(got))
+			if !ok || token == "" || subtle.ConstantTimeCompare(sum[:], want[:]) != 1 {
+				c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Bearer realm="scim"`)
+				return c.JSON(http.StatusUnauthorized, map[string]any{
+					"schemas": []string{services.SCIMSchemaError},
+					"status":  "401",
+					"detail":  "unauthorized",
+				})
+			}
+			return next(c)
+		}
+	}
+}
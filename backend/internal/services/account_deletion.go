@@ -0,0 +1,141 @@
+package services
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// DeletionCoolingOff is how long a confirmed deletion waits before the
+// account is purged. During this window the user can still sign in and cancel.
+func DeletionCoolingOff() time.Duration {
+	days := 7
+	if v := os.Getenv("ACCOUNT_DELETION_COOLOFF_DAYS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 && n <= 90 {
+			days = n
+		}
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// PurgeAccount removes a user and anonymizes what must stay for audit.
+// login_attempts rows are kept (lockout and security review depend on them)
+// but lose the typed identifier and IP; every other per-user table cascades.
+func PurgeAccount(db *sqlx.DB, userID int64) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var (
+		username, email       string
+		userCanon, emailCanon sql.NullString
+	)
+	if err := tx.QueryRowx(`
+		SELECT username, email, username_canonical, email_canonical
+		FROM users WHERE id = ? FOR UPDATE
+	`, userID).Scan(&username, &email, &userCanon, &emailCanon); err != nil {
+		return err
+	}
+
+	// Attempts are logged under the LoginLookup key; those made while the key
+	// matched no account carry no user_id
+	pseudonym := "deleted-user-" + strconv.FormatInt(userID, 10)
+	byUsername, byEmail := loginAttemptKeys(username, email, userCanon, emailCanon)
+	if _, err := tx.Exec(`
+		UPDATE login_attempts
+		SET username = ?, ip = NULL
+		WHERE user_id = ? OR username IN (?, ?)
+	`, pseudonym, userID, byUsername, byEmail); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`
+		UPDATE account_deletion_requests
+		SET completed_at = NOW()
+		WHERE user_id = ? AND confirmed_at IS NOT NULL AND cancelled_at IS NULL AND completed_at IS NULL
+	`, userID); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM users WHERE id = ?`, userID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// loginAttemptKeys returns the login_attempts.username values a user's
+// sign-ins were logged under: the canonical username and email, computed
+// from the raw values for rows identity-migrate has not backfilled yet.
+func loginAttemptKeys(username, email string, userCanon, emailCanon sql.NullString) (byUsername, byEmail string) {
+	byUsername, byEmail = userCanon.String, emailCanon.String
+	if !userCanon.Valid {
+		_, byUsername, _ = LoginLookup(username)
+	}
+	if !emailCanon.Valid {
+		_, byEmail, _ = LoginLookup(email)
+	}
+	return byUsername, byEmail
+}
+
+// PurgeDueAccountDeletions purges every account whose cooling-off period has
+// elapsed and returns how many were removed.
+func PurgeDueAccountDeletions(db *sqlx.DB) (int, error) {
+	var due []int64
+	if err := db.Select(&due, `
+		SELECT DISTINCT user_id
+		FROM account_deletion_requests
+		WHERE user_id IS NOT NULL
+		  AND confirmed_at IS NOT NULL
+		  AND cancelled_at IS NULL
+		  AND completed_at IS NULL
+		  AND scheduled_for <= NOW()
+	`); err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, uid := range due {
+		// Roles may have changed during the cooling-off period
+		if last, err := IsLastActiveAdmin(db, uid); err != nil {
+			log.Printf("[account-deletion] purge user %d: %v", uid, err)
+			continue
+		} else if last {
+			log.Printf("[account-deletion] purge user %d: skipped, the last admin", uid)
+			continue
+		}
+		if err := PurgeAccount(db, uid); err != nil {
+			log.Printf("[account-deletion] purge user %d: %v", uid, err)
+			continue
+		}
+		n++
+	}
+	return n, nil
+}
+
+// RunAccountPurger runs PurgeDueAccountDeletions every interval until ctx is done.
+func RunAccountPurger(ctx context.Context, db *sqlx.DB, every time.Duration) {
+	go func() {
+		t := time.NewTicker(every)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				n, err := PurgeDueAccountDeletions(db)
+				if err != nil {
+					log.Printf("[account-deletion] scan error: %v", err)
+				} else if n > 0 {
+					log.Printf("[account-deletion] purged %d account(s)", n)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,62 @@
+package services
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestLoginAttemptKeys(t *testing.T) {
+	for _, tc := range []struct {
+		name                  string
+		username, email       string
+		userCanon, emailCanon sql.NullString
+		wantUser, wantEmail   string
+	}{
+		{
+			name: "backfilled", username: "Alice", email: "Alice.Smith@Example.COM",
+			userCanon:  sql.NullString{String: "alice", Valid: true},
+			emailCanon: sql.NullString{String: "alice.smith@example.com", Valid: true},
+			wantUser:   "alice", wantEmail: "alice.smith@example.com",
+		},
+		{
+			// Keys are what Login logs, not the raw columns
+			name: "not backfilled", username: "Alice", email: "Alice.Smith@Example.COM",
+			wantUser: "alice", wantEmail: "alice.smith@example.com",
+		},
+		{
+			name: "plus address kept", username: "ÉMILE", email: "Emile+crm@Bücher.example",
+			wantUser: "émile", wantEmail: "emile+crm@xn--bcher-kva.example",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			u, e := loginAttemptKeys(tc.username, tc.email, tc.userCanon, tc.emailCanon)
+			if u != tc.wantUser || e != tc.wantEmail {
+				t.Fatalf("keys = %q, %q; want %q, %q", u, e, tc.wantUser, tc.wantEmail)
+			}
+			// Must match the key a sign-in with either identifier is logged under
+			if _, key, _ := LoginLookup(tc.username); key != u {
+				t.Errorf("LoginLookup(%q) = %q, purge matches %q", tc.username, key, u)
+			}
+			if _, key, _ := LoginLookup(tc.email); key != e {
+				t.Errorf("LoginLookup(%q) = %q, purge matches %q", tc.email, key, e)
+			}
+		})
+	}
+}
+
+func TestDeletionCoolingOff(t *testing.T) {
+	for env, want := range map[string]time.Duration{
+		"":   7 * 24 * time.Hour,
+		"0":  0,
+		"30": 30 * 24 * time.Hour,
+		"91": 7 * 24 * time.Hour, // out of range
+		"-1": 7 * 24 * time.Hour,
+		"x":  7 * 24 * time.Hour,
+	} {
+		t.Setenv("ACCOUNT_DELETION_COOLOFF_DAYS", env)
+		if got := DeletionCoolingOff(); got != want {
+			t.Errorf("%q: %v, want %v", env, got, want)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// API keys look like "sclk_<prefix>_<secret>". The prefix is stored in clear
+// for lookup and display; only the SHA-256 of the secret is stored (the secret
+// is 256 random bits, so a fast hash is sufficient).
+const APIKeyTokenPrefix = "sclk_"
+
+// How a request authenticated (Claims.AuthMethod).
+const (
+	AuthSession = "session"
+	AuthAPIKey  = "api_key"
+)
+
+var ErrInvalidAPIKey = errors.New("invalid api key")
+
+type APIKey struct {
+	ID         int64      `db:"id" json:"id"`
+	UserID     int64      `db:"user_id" json:"user_id"`
+	OrgID      *int64     `db:"org_id" json:"org_id"`
+	Name       string     `db:"name" json:"name"`
+	Prefix     string     `db:"prefix" json:"prefix"`
+	Scopes     string     `db:"scopes" json:"-"`
+	ExpiresAt  *time.Time `db:"expires_at" json:"expires_at"`
+	LastUsedAt *time.Time `db:"last_used_at" json:"last_used_at"`
+	LastUsedIP *string    `db:"last_used_ip" json:"last_used_ip"`
+	CreatedAt  time.Time  `db:"created_at" json:"created_at"`
+	RevokedAt  *time.Time `db:"revoked_at" json:"revoked_at"`
+}
+
+// ScopeList splits the stored comma-separated scopes.
+func (k *APIKey) ScopeList() []string {
+	if k.Scopes == "" {
+		return []string{}
+	}
+	return strings.Split(k.Scopes, ",")
+}
+
+// NewAPIKeyToken generates a new key. The full token is shown to the caller once.
+func NewAPIKeyToken() (token, prefix, secretSHA256 string, err error) {
+	b := make([]byte, 6)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", "", err
+	}
+	prefix = hex.EncodeToString(b)
+	secret, err := NewRandomBase64URL(32)
+	if err != nil {
+		return "", "", "", err
+	}
+	return APIKeyTokenPrefix + prefix + "_" + secret, prefix, HashSHA256Hex(secret), nil
+}
+
+// IsAPIKeyToken reports whether a bearer token has the API key shape.
+func IsAPIKeyToken(token string) bool {
+	return strings.HasPrefix(token, APIKeyTokenPrefix)
+}
+
+func parseAPIKeyToken(token string) (prefix, secret string, ok bool) {
+	rest, ok := strings.CutPrefix(token, APIKeyTokenPrefix)
+	if !ok {
+		return "", "", false
+	}
+	prefix, secret, ok = strings.Cut(rest, "_")
+	if !ok || len(prefix) != 12 || secret == "" {
+		return "", "", false
+	}
+	return prefix, secret, true
+}
+
+// AuthenticateAPIKey validates a bearer API key and returns claims for the
+// request. Permissions are the key's scopes intersected with the owner's
+// current permissions, so revoking a role also narrows every key. Like a
+// session (SessionActive), a key stops working while its owner is inactive
+// or locked.
+func AuthenticateAPIKey(db *sqlx.DB, token, ip string) (*Claims, error) {
+	prefix, secret, ok := parseAPIKeyToken(token)
+	if !ok {
+		return nil, ErrInvalidAPIKey
+	}
+
+	var row struct {
+		APIKey
+		SecretSHA256 string `db:"secret_sha256"`
+		Username     string `db:"username"`
+		IsActive     bool   `db:"is_active"`
+		IsVerified   bool   `db:"is_verified"`
+		IsLocked     bool   `db:"is_locked"`
+	}
+	err := db.Get(&row, `
+		SELECT k.id, k.user_id, k.org_id, k.name, k.prefix, k.scopes, k.expires_at,
+		       k.last_used_at, k.last_used_ip, k.created_at, k.revoked_at, k.secret_sha256,
+		       u.username, u.is_active, u.is_verified, u.locked_at IS NOT NULL AS is_locked
+		FROM api_keys k
+		JOIN users u ON u.id = k.user_id
+		WHERE k.prefix = ?
+	`, prefix)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if subtle.ConstantTimeCompare([]byte(HashSHA256Hex(secret)), []byte(row.SecretSHA256)) != 1 {
+		return nil, ErrInvalidAPIKey
+	}
+	if row.RevokedAt != nil || (row.ExpiresAt != nil && time.Now().After(*row.ExpiresAt)) ||
+		!row.IsActive || !row.IsVerified || row.IsLocked {
+		return nil, ErrInvalidAPIKey
+	}
+
+	_, ownerPerms, err := LoadUserAccess(db, row.UserID)
+	if err != nil {
+		return nil, err
+	}
+	perms := []string{}
+	for _, s := range row.ScopeList() {
+		if HasPermission(ownerPerms, s) {
+			perms = append(perms, s)
+		}
+	}
+
+	// Coarse last-used tracking: at most one write per key per minute
+	if _, err := db.Exec(`
+		UPDATE api_keys SET last_used_at = NOW(), last_used_ip = ?
+		WHERE id = ? AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL 1 MINUTE)
+	`, ip, row.ID); err != nil {
+		log.Printf("[api-keys] last-used update: %v", err)
+	}
+
+	var orgID int64
+	if row.OrgID != nil {
+		orgID = *row.OrgID
+	}
+	return &Claims{
+		UserID:      row.UserID,
+		Username:    row.Username,
+		Permissions: perms,
+		OrgID:       orgID,
+		AuthMethod:  AuthAPIKey,
+		APIKeyID:    row.ID,
+	}, nil
+}
@@ -0,0 +1,95 @@
+package services
+
+import (
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+var apiKeyColumns = []string{
+	"id", "user_id", "org_id", "name", "prefix", "scopes", "expires_at", "last_used_at", "last_used_ip",
+	"created_at", "revoked_at", "secret_sha256", "username", "is_active", "is_verified", "is_locked",
+}
+
+func TestParseAPIKeyToken(t *testing.T) {
+	token, prefix, sha, err := NewAPIKeyToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, secret, ok := parseAPIKeyToken(token)
+	if !ok || p != prefix || HashSHA256Hex(secret) != sha || !IsAPIKeyToken(token) {
+		t.Fatalf("round trip of %q: %q %q %v", token, p, secret, ok)
+	}
+	for _, bad := range []string{"", "sclk_", "sclk_abc_secret", "sclk_0123456789ab", "sclk_0123456789ab_", "sclo_0123456789ab_x"} {
+		if _, _, ok := parseAPIKeyToken(bad); ok {
+			t.Errorf("%q accepted", bad)
+		}
+	}
+}
+
+func TestAuthenticateAPIKey(t *testing.T) {
+	token, prefix, sha, err := NewAPIKeyToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	past, future := time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	row := func(edit func(v []any)) *sqlmock.Rows {
+		v := []any{int64(3), int64(7), int64(1), "ci", prefix, "customers:read,customers:write", future, nil, nil,
+			past, nil, sha, "alice", true, true, false}
+		if edit != nil {
+			edit(v)
+		}
+		vals := make([]driver.Value, len(v))
+		for i := range v {
+			vals[i] = v[i]
+		}
+		return sqlmock.NewRows(apiKeyColumns).AddRow(vals...)
+	}
+
+	for name, edit := range map[string]func(v []any){
+		"locked owner":   func(v []any) { v[15] = true },
+		"inactive owner": func(v []any) { v[13] = false },
+		"unverified":     func(v []any) { v[14] = false },
+		"revoked":        func(v []any) { v[10] = past },
+		"expired":        func(v []any) { v[6] = past },
+		"wrong secret":   func(v []any) { v[11] = strings.Repeat("0", 64) },
+	} {
+		t.Run(name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			mock.ExpectQuery(`FROM api_keys k`).WithArgs(prefix).WillReturnRows(row(edit))
+			if _, err := AuthenticateAPIKey(db, token, "192.0.2.1"); !errors.Is(err, ErrInvalidAPIKey) {
+				t.Fatalf("err = %v, want ErrInvalidAPIKey", err)
+			}
+		})
+	}
+
+	t.Run("unknown prefix", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(`FROM api_keys k`).WillReturnRows(sqlmock.NewRows(apiKeyColumns))
+		if _, err := AuthenticateAPIKey(db, token, "192.0.2.1"); !errors.Is(err, ErrInvalidAPIKey) {
+			t.Fatalf("err = %v, want ErrInvalidAPIKey", err)
+		}
+	})
+
+	// Scopes are narrowed to what the owner holds now
+	t.Run("valid", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(`locked_at IS NOT NULL AS is_locked\s+FROM api_keys k`).WithArgs(prefix).WillReturnRows(row(nil))
+		expectUserAccess(mock, 7, []string{"viewer"}, []string{PermCustomersRead})
+		mock.ExpectExec(`UPDATE api_keys SET last_used_at`).WithArgs("192.0.2.1", int64(3)).
+			WillReturnResult(sqlmock.NewResult(0, 1))
+		cl, err := AuthenticateAPIKey(db, token, "192.0.2.1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cl.UserID != 7 || cl.OrgID != 1 || cl.APIKeyID != 3 || cl.AuthMethod != AuthAPIKey ||
+			!reflect.DeepEqual(cl.Permissions, []string{PermCustomersRead}) {
+			t.Fatalf("claims: %+v", cl)
+		}
+	})
+}
@@ -0,0 +1,284 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Security audit log. Entries are append-only (database triggers refuse
+// UPDATE/DELETE) and hash-chained: each entry's HMAC covers its content and
+// the previous entry's hash, so editing or removing an entry breaks the chain
+// from that point on (see VerifyAuditChain, cmd/audit-verify).
+
+// AuditEventType names a kind of security event. New types are added here so
+// the set of events stays documented in one place.
+type AuditEventType string
+
+const (
+	AuditUserRegistered       AuditEventType = "user.registered"
+	AuditEmailVerified        AuditEventType = "user.email_verified"
+	AuditLoginPassword        AuditEventType = "auth.login.password" // step 1; failure = bad credentials or lockout
+	AuditLoginMFA             AuditEventType = "auth.login.mfa"      // step 2; failure = wrong/expired OTP
+	AuditLoginOIDC            AuditEventType = "auth.login.oidc"
+	AuditLogout               AuditEventType = "auth.logout"
+	AuditSessionRevoked       AuditEventType = "auth.session_revoked" // signed out elsewhere from the sessions list
+	AuditNewDeviceSignIn      AuditEventType = "auth.new_device"
+	AuditAccountLockdown      AuditEventType = "auth.lockdown" // "this wasn't me" from a new-device alert
+	AuditPasswordChanged      AuditEventType = "auth.password.changed"
+	AuditPasswordResetRequest AuditEventType = "auth.password.reset_requested"
+	AuditPasswordReset        AuditEventType = "auth.password.reset"
+	AuditCustomerCreated      AuditEventType = "customer.created"
+	AuditCustomerUpdated      AuditEventType = "customer.updated"
+	AuditCustomerDeleted      AuditEventType = "customer.deleted"
+	AuditCustomerRestored     AuditEventType = "customer.restored"  // a version from its history
+	AuditCustomerUndeleted    AuditEventType = "customer.undeleted" // taken out of the trash
+	AuditCustomerPurged       AuditEventType = "customer.purged"    // trash retention elapsed
+	AuditCustomersImported    AuditEventType = "customer.imported"  // one bulk import, with its counts
+	AuditOrgAccessDenied      AuditEventType = "access.org_denied"
+	AuditRolesChanged         AuditEventType = "admin.roles_changed"
+	AuditUserActivated        AuditEventType = "admin.user_activated"
+	AuditUserDeactivated      AuditEventType = "admin.user_deactivated"
+	AuditUserPasswordReset    AuditEventType = "admin.password_reset_forced"
+	AuditUserUnlocked         AuditEventType = "admin.user_unlocked"
+	AuditVerificationResent   AuditEventType = "admin.verification_resent"
+	AuditUserDeleted          AuditEventType = "admin.user_deleted"
+	AuditImpersonationStart   AuditEventType = "admin.impersonation_started"
+	AuditImpersonationEnd     AuditEventType = "admin.impersonation_ended"
+	AuditOrgCreated           AuditEventType = "admin.org_created"
+	AuditOrgMemberAdded       AuditEventType = "admin.org_member_added"
+	AuditOrgMemberRemoved     AuditEventType = "admin.org_member_removed"
+	AuditImpersonatedRequest  AuditEventType = "impersonation.request" // every request made in an impersonation session
+	AuditAPIKeyCreated        AuditEventType = "apikey.created"
+	AuditAPIKeyRevoked        AuditEventType = "apikey.revoked"
+)
+
+// Outcomes.
+const (
+	AuditSuccess = "success"
+	AuditFailure = "failure"
+	AuditDenied  = "denied"
+)
+
+// AuditEvent is one entry as written by callers. Details must never contain
+// secrets (passwords, tokens, OTPs).
+type AuditEvent struct {
+	Type        AuditEventType
+	Outcome     string
+	ActorUserID int64  // 0 = anonymous / unknown
+	ActorLabel  string // username or the identifier that was tried
+	SubjectType string // e.g. "user", "customer"
+	SubjectID   string
+	OrgID       int64
+	IP          string
+	UserAgent   string
+	RequestID   string
+	Details     map[string]any
+}
+
+// AuditEntry is a stored audit row.
+type AuditEntry struct {
+	ID          int64          `db:"id" json:"id"`
+	OccurredAt  time.Time      `db:"occurred_at" json:"occurred_at"`
+	EventType   string         `db:"event_type" json:"event_type"`
+	Outcome     string         `db:"outcome" json:"outcome"`
+	ActorUserID sql.NullInt64  `db:"actor_user_id" json:"-"`
+	ActorLabel  string         `db:"actor_label" json:"actor_label"`
+	SubjectType string         `db:"subject_type" json:"subject_type,omitempty"`
+	SubjectID   string         `db:"subject_id" json:"subject_id,omitempty"`
+	OrgID       sql.NullInt64  `db:"org_id" json:"-"`
+	IP          string         `db:"ip" json:"ip"`
+	UserAgent   string         `db:"user_agent" json:"user_agent"`
+	RequestID   string         `db:"request_id" json:"request_id"`
+	Details     sql.NullString `db:"details" json:"-"`
+	PrevHash    string         `db:"prev_hash" json:"prev_hash"`
+	EntryHash   string         `db:"entry_hash" json:"entry_hash"`
+}
+
+// AuditChainGenesis is the prev_hash of the first entry.
+const AuditChainGenesis = "0000000000000000000000000000000000000000000000000000000000000000"
+
+var ErrAuditKey = errors.New("missing AUDIT_HMAC_KEY (or HMAC_SECRET)")
+
+func auditKey() ([]byte, error) {
+	k := os.Getenv("AUDIT_HMAC_KEY")
+	if k == "" {
+		k = os.Getenv("HMAC_SECRET")
+	}
+	if k == "" {
+		return nil, ErrAuditKey
+	}
+	return []byte(k), nil
+}
+
+// auditHash is HMAC-SHA256(key, prev_hash || canonical JSON of the entry).
+// The field list is fixed here; changing it invalidates existing chains.
+func auditHash(key []byte, e *AuditEntry) string {
+	canon, _ := json.Marshal([]any{
+		e.OccurredAt.UTC().Format("2006-01-02T15:04:05.000000Z"),
+		e.EventType, e.Outcome,
+		e.ActorUserID.Int64, e.ActorLabel,
+		e.SubjectType, e.SubjectID, e.OrgID.Int64,
+		e.IP, e.UserAgent, e.RequestID, e.Details.String,
+	})
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(e.PrevHash))
+	m.Write(canon)
+	return hex.EncodeToString(m.Sum(nil))
+}
+
+func clip(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	s = s[:n]
+	for !utf8.ValidString(s) {
+		s = s[:len(s)-1]
+	}
+	return s
+}
+
+// AppendAudit writes one entry at the end of the chain. Appends are
+// serialized on the audit_chain_head row, in their own transaction so an
+// entry is recorded even when the caller's transaction rolls back.
+func AppendAudit(db *sqlx.DB, ev AuditEvent) error {
+	key, err := auditKey()
+	if err != nil {
+		return err
+	}
+	e := &AuditEntry{
+		OccurredAt:  time.Now().UTC().Truncate(time.Microsecond),
+		EventType:   string(ev.Type),
+		Outcome:     ev.Outcome,
+		ActorUserID: sql.NullInt64{Int64: ev.ActorUserID, Valid: ev.ActorUserID > 0},
+		ActorLabel:  clip(ev.ActorLabel, 254),
+		SubjectType: clip(ev.SubjectType, 32),
+		SubjectID:   clip(ev.SubjectID, 64),
+		OrgID:       sql.NullInt64{Int64: ev.OrgID, Valid: ev.OrgID > 0},
+		IP:          clip(ev.IP, 45),
+		UserAgent:   clip(ev.UserAgent, 255),
+		RequestID:   clip(ev.RequestID, 64),
+	}
+	if e.Outcome == "" {
+		e.Outcome = AuditSuccess
+	}
+	if len(ev.Details) > 0 {
+		b, err := json.Marshal(ev.Details)
+		if err != nil {
+			return err
+		}
+		e.Details = sql.NullString{String: string(b), Valid: true}
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := tx.Get(&e.PrevHash, `SELECT last_hash FROM audit_chain_head WHERE id = 1 FOR UPDATE`); err != nil {
+		return err
+	}
+	e.EntryHash = auditHash(key, e)
+	res, err := tx.Exec(`
+		INSERT INTO audit_log (occurred_at, event_type, outcome, actor_user_id, actor_label,
+		                       subject_type, subject_id, org_id, ip, user_agent, request_id,
+		                       details, prev_hash, entry_hash)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, e.OccurredAt, e.EventType, e.Outcome, e.ActorUserID, e.ActorLabel, e.SubjectType, e.SubjectID,
+		e.OrgID, e.IP, e.UserAgent, e.RequestID, e.Details, e.PrevHash, e.EntryHash)
+	if err != nil {
+		return err
+	}
+	e.ID, _ = res.LastInsertId()
+	if _, err := tx.Exec(`
+		UPDATE audit_chain_head SET last_id = ?, last_hash = ?, entries = entries + 1 WHERE id = 1
+	`, e.ID, e.EntryHash); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	exportAudit(e) // SIEM forwarding, see SetAuditExporter
+	return nil
+}
+
+// AuditChainReport is the result of VerifyAuditChain.
+type AuditChainReport struct {
+	Entries  int64
+	LastID   int64
+	LastHash string
+	// Problems lists every inconsistency found; empty means the chain is intact.
+	Problems []string
+}
+
+// VerifyAuditChain recomputes every entry's hash in id order and checks the
+// links between entries and the chain head. It reads in batches of batchSize.
+func VerifyAuditChain(db *sqlx.DB, batchSize int) (*AuditChainReport, error) {
+	key, err := auditKey()
+	if err != nil {
+		return nil, err
+	}
+	if batchSize <= 0 {
+		batchSize = 1000
+	}
+	rep := &AuditChainReport{LastHash: AuditChainGenesis}
+	problem := func(id int64, msg string) {
+		if len(rep.Problems) < 100 {
+			rep.Problems = append(rep.Problems, "entry "+strconv.FormatInt(id, 10)+": "+msg)
+		}
+	}
+
+	after := int64(0)
+	for {
+		var rows []AuditEntry
+		if err := db.Select(&rows, `
+			SELECT id, occurred_at, event_type, outcome, actor_user_id, actor_label, subject_type,
+			       subject_id, org_id, ip, user_agent, request_id, details, prev_hash, entry_hash
+			FROM audit_log WHERE id > ? ORDER BY id LIMIT ?
+		`, after, batchSize); err != nil {
+			return nil, err
+		}
+		for i := range rows {
+			e := &rows[i]
+			if e.PrevHash != rep.LastHash {
+				problem(e.ID, "previous hash does not match (entries before it were removed or altered)")
+			}
+			if auditHash(key, e) != e.EntryHash {
+				problem(e.ID, "content does not match its hash (entry was altered)")
+			}
+			rep.Entries++
+			rep.LastID, rep.LastHash = e.ID, e.EntryHash
+		}
+		if len(rows) < batchSize {
+			break
+		}
+		after = rows[len(rows)-1].ID
+	}
+
+	var head struct {
+		LastID   int64  `db:"last_id"`
+		LastHash string `db:"last_hash"`
+		Entries  int64  `db:"entries"`
+	}
+	if err := db.Get(&head, `SELECT last_id, last_hash, entries FROM audit_chain_head WHERE id = 1`); err != nil {
+		return nil, err
+	}
+	if head.LastID != rep.LastID || head.LastHash != rep.LastHash {
+		problem(head.LastID, "chain head does not match the last entry (entries at the end were removed)")
+	}
+	if head.Entries != rep.Entries {
+		rep.Problems = append(rep.Problems, "chain head counts "+strconv.FormatInt(head.Entries, 10)+
+			" entries, found "+strconv.FormatInt(rep.Entries, 10))
+	}
+	return rep, nil
+}
@@ -0,0 +1,267 @@
+package services
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+const testAuditKey = "audit-test-key"
+
+var auditLogColumns = []string{
+	"id", "occurred_at", "event_type", "outcome", "actor_user_id", "actor_label", "subject_type",
+	"subject_id", "org_id", "ip", "user_agent", "request_id", "details", "prev_hash", "entry_hash",
+}
+
+// auditChain returns n entries chained the way AppendAudit stores them.
+func auditChain(t *testing.T, n int) []AuditEntry {
+	t.Helper()
+	t.Setenv("AUDIT_HMAC_KEY", testAuditKey)
+	var out []AuditEntry
+	prev := AuditChainGenesis
+	for i := 1; i <= n; i++ {
+		e := *testAuditEntry(int64(i))
+		e.OccurredAt = e.OccurredAt.Add(time.Duration(i) * time.Second)
+		e.SubjectID = strconv.Itoa(i)
+		e.PrevHash = prev
+		e.EntryHash = auditHash([]byte(testAuditKey), &e)
+		prev = e.EntryHash
+		out = append(out, e)
+	}
+	return out
+}
+
+func auditLogRow(e AuditEntry) []driver.Value {
+	uid, _ := e.ActorUserID.Value()
+	org, _ := e.OrgID.Value()
+	details, _ := e.Details.Value()
+	return []driver.Value{e.ID, e.OccurredAt, e.EventType, e.Outcome, uid, e.ActorLabel, e.SubjectType,
+		e.SubjectID, org, e.IP, e.UserAgent, e.RequestID, details, e.PrevHash, e.EntryHash}
+}
+
+// expectAuditRead serves rows to VerifyAuditChain in pages of batch, then
+// the chain head as it was written for the untampered chain.
+func expectAuditRead(mock sqlmock.Sqlmock, rows []AuditEntry, batch int, head AuditEntry, entries int64) {
+	after := int64(0)
+	for i := 0; ; i += batch {
+		page := sqlmock.NewRows(auditLogColumns)
+		end := min(i+batch, len(rows))
+		for _, e := range rows[i:end] {
+			page.AddRow(auditLogRow(e)...)
+		}
+		mock.ExpectQuery(`FROM audit_log WHERE id > \? ORDER BY id LIMIT \?`).
+			WithArgs(after, batch).WillReturnRows(page)
+		if end-i < batch {
+			break
+		}
+		after = rows[end-1].ID
+	}
+	mock.ExpectQuery(`FROM audit_chain_head`).WillReturnRows(sqlmock.NewRows([]string{"last_id", "last_hash", "entries"}).
+		AddRow(head.ID, head.EntryHash, entries))
+}
+
+const (
+	auditPrevMismatch = "previous hash does not match (entries before it were removed or altered)"
+	auditHashMismatch = "content does not match its hash (entry was altered)"
+	auditHeadMismatch = "chain head does not match the last entry (entries at the end were removed)"
+)
+
+// Every kind of tampering is reported at the entry where the chain breaks.
+func TestVerifyAuditChain(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		tamper func(rows []AuditEntry) []AuditEntry
+		want   []string
+	}{
+		{name: "intact", tamper: func(rows []AuditEntry) []AuditEntry { return rows }},
+		{name: "edited", tamper: func(rows []AuditEntry) []AuditEntry {
+			rows[2].Outcome = AuditSuccess
+			return rows
+		}, want: []string{"entry 3: " + auditHashMismatch}},
+		{name: "edited details", tamper: func(rows []AuditEntry) []AuditEntry {
+			rows[3].Details = sql.NullString{}
+			return rows
+		}, want: []string{"entry 4: " + auditHashMismatch}},
+		{name: "edited and re-hashed without the key", tamper: func(rows []AuditEntry) []AuditEntry {
+			rows[2].ActorLabel = "mallory"
+			rows[2].EntryHash = auditHash([]byte("guessed-key"), &rows[2])
+			return rows
+		}, want: []string{"entry 3: " + auditHashMismatch, "entry 4: " + auditPrevMismatch}},
+		{name: "deleted", tamper: func(rows []AuditEntry) []AuditEntry {
+			return append(rows[:2], rows[3:]...)
+		}, want: []string{"entry 4: " + auditPrevMismatch, "chain head counts 5 entries, found 4"}},
+		{name: "first deleted", tamper: func(rows []AuditEntry) []AuditEntry {
+			return rows[1:]
+		}, want: []string{"entry 2: " + auditPrevMismatch, "chain head counts 5 entries, found 4"}},
+		{name: "last deleted", tamper: func(rows []AuditEntry) []AuditEntry {
+			return rows[:4]
+		}, want: []string{"entry 5: " + auditHeadMismatch, "chain head counts 5 entries, found 4"}},
+		{name: "reordered", tamper: func(rows []AuditEntry) []AuditEntry {
+			rows[2], rows[3] = rows[3], rows[2]
+			rows[2].ID, rows[3].ID = 3, 4
+			return rows
+		}, want: []string{"entry 3: " + auditPrevMismatch, "entry 4: " + auditPrevMismatch, "entry 5: " + auditPrevMismatch}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			chain := auditChain(t, 5)
+			head := chain[4]
+			rows := tc.tamper(append([]AuditEntry(nil), chain...))
+
+			db, mock := newMockDB(t)
+			expectAuditRead(mock, rows, 2, head, 5)
+			rep, err := VerifyAuditChain(db, 2)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(rep.Problems, tc.want) {
+				t.Fatalf("problems:\n got %q\nwant %q", rep.Problems, tc.want)
+			}
+		})
+	}
+}
+
+func TestVerifyAuditChainEmpty(t *testing.T) {
+	t.Setenv("AUDIT_HMAC_KEY", testAuditKey)
+	db, mock := newMockDB(t)
+	expectAuditRead(mock, nil, 1000, AuditEntry{EntryHash: AuditChainGenesis}, 0)
+	rep, err := VerifyAuditChain(db, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rep.Problems) != 0 || rep.Entries != 0 || rep.LastHash != AuditChainGenesis {
+		t.Fatalf("report: %+v", rep)
+	}
+}
+
+// A different key means a different chain: nothing verifies.
+func TestVerifyAuditChainWrongKey(t *testing.T) {
+	chain := auditChain(t, 2)
+	t.Setenv("AUDIT_HMAC_KEY", "another-key")
+	db, mock := newMockDB(t)
+	expectAuditRead(mock, chain, 10, chain[1], 2)
+	rep, err := VerifyAuditChain(db, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"entry 1: " + auditHashMismatch, "entry 2: " + auditHashMismatch}; !reflect.DeepEqual(rep.Problems, want) {
+		t.Fatalf("problems = %q, want %q", rep.Problems, want)
+	}
+}
+
+// captureArg matches any value and keeps it.
+type captureArg struct{ v *driver.Value }
+
+func (c captureArg) Match(v driver.Value) bool {
+	*c.v = v
+	return true
+}
+
+// Oversized fields are clipped before hashing, on rune boundaries and within
+// their columns, so the stored row verifies exactly as it was written.
+func TestAppendAuditClipsBeforeHashing(t *testing.T) {
+	t.Setenv("AUDIT_HMAC_KEY", testAuditKey)
+	db, mock := newMockDB(t)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT last_hash FROM audit_chain_head WHERE id = 1 FOR UPDATE`).
+		WillReturnRows(sqlmock.NewRows([]string{"last_hash"}).AddRow(AuditChainGenesis))
+	args := make([]driver.Value, 14)
+	matchers := make([]driver.Value, 14)
+	for i := range args {
+		matchers[i] = captureArg{&args[i]}
+	}
+	mock.ExpectExec(`INSERT INTO audit_log`).WithArgs(matchers...).WillReturnResult(sqlmock.NewResult(8, 1))
+	mock.ExpectExec(`UPDATE audit_chain_head`).WithArgs(int64(8), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	if err := AppendAudit(db, AuditEvent{
+		Type:        AuditLoginPassword,
+		Outcome:     AuditFailure,
+		ActorLabel:  strings.Repeat("é", 200), // 400 bytes into 254
+		SubjectType: "user",
+		SubjectID:   strings.Repeat("9", 70),                       // 64
+		IP:          strings.Repeat("ffff:", 10),                   // 45
+		UserAgent:   "Mozilla " + strings.Repeat("\U0001F600", 80), // 4-byte runes into 255
+		RequestID:   "req-" + strings.Repeat("x", 80),
+		Details:     map[string]any{"reason": "bad password"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, limit := range map[int]int{4: 254, 5: 32, 6: 64, 8: 45, 9: 255, 10: 64} {
+		s := args[i].(string)
+		if len(s) > limit || !utf8.ValidString(s) {
+			t.Errorf("arg %d: %d bytes, valid UTF-8 %v; want at most %d", i, len(s), utf8.ValidString(s), limit)
+		}
+	}
+	if n := len(args[4].(string)); n != 254 {
+		t.Errorf("actor_label clipped to %d bytes, want 254 (127 runes)", n)
+	}
+	if n := len(args[9].(string)); n != 252 {
+		t.Errorf("user_agent clipped to %d bytes, want 252 (8 + 61 runes)", n)
+	}
+
+	// Read back as the database returns it, in another time zone.
+	stored := AuditEntry{
+		ID:          8,
+		OccurredAt:  args[0].(time.Time).In(time.FixedZone("CEST", 2*3600)),
+		EventType:   args[1].(string),
+		Outcome:     args[2].(string),
+		ActorLabel:  args[4].(string),
+		SubjectType: args[5].(string),
+		SubjectID:   args[6].(string),
+		IP:          args[8].(string),
+		UserAgent:   args[9].(string),
+		RequestID:   args[10].(string),
+		Details:     sql.NullString{String: args[11].(string), Valid: true},
+		PrevHash:    args[12].(string),
+		EntryHash:   args[13].(string),
+	}
+	if args[3] != nil || args[7] != nil {
+		t.Errorf("actor_user_id, org_id = %v, %v; want NULL", args[3], args[7])
+	}
+	if got := auditHash([]byte(testAuditKey), &stored); got != stored.EntryHash {
+		t.Fatalf("stored row hashes to %s, entry_hash is %s", got, stored.EntryHash)
+	}
+
+	db, mock = newMockDB(t)
+	expectAuditRead(mock, []AuditEntry{stored}, 10, stored, 1)
+	rep, err := VerifyAuditChain(db, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rep.Problems) != 0 {
+		t.Fatalf("problems: %q", rep.Problems)
+	}
+}
+
+func TestClip(t *testing.T) {
+	for _, tc := range []struct {
+		in   string
+		n    int
+		want string
+	}{
+		{"short", 10, "short"},
+		{"exact", 5, "exact"},
+		{"abcdef", 3, "abc"},
+		{"aé", 2, "a"}, // never half a rune
+		{"ééé", 5, "éé"},
+		{"a\U0001F600", 4, "a"},
+		{"a\U0001F600", 5, "a\U0001F600"},
+	} {
+		got := clip(tc.in, tc.n)
+		if got != tc.want {
+			t.Errorf("clip(%q, %d) = %q, want %q", tc.in, tc.n, got, tc.want)
+		}
+		if again := clip(got, tc.n); again != got {
+			t.Errorf("clip(%q, %d) is not stable: %q", got, tc.n, again)
+		}
+	}
+}
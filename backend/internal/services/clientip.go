@@ -0,0 +1,151 @@
+package services
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// ProxyTrust resolves the client address of a request. The forwarding header
+// is only believed for hops that arrive from a trusted proxy; anything a
+// client writes into it itself is ignored.
+type ProxyTrust struct {
+	nets   []*net.IPNet
+	header string // the one forwarding header the proxies write, canonical form
+}
+
+// ParseTrustedProxies parses a comma-separated list of CIDRs or single IPs
+// and the name of the header those proxies append to: X-Forwarded-For
+// (default) or Forwarded. Only that header is read; a proxy passes the other
+// one through from the client untouched, so it is never believed.
+func ParseTrustedProxies(list, header string) (*ProxyTrust, error) {
+	t := &ProxyTrust{}
+	switch strings.ToLower(strings.TrimSpace(header)) {
+	case "", "x-forwarded-for":
+		t.header = "X-Forwarded-For"
+	case "forwarded":
+		t.header = "Forwarded"
+	default:
+		return nil, fmt.Errorf("invalid trusted proxy header %q (want X-Forwarded-For or Forwarded)", header)
+	}
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			ip := net.ParseIP(s)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", s)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			t.nets = append(t.nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", s)
+		}
+		t.nets = append(t.nets, n)
+	}
+	return t, nil
+}
+
+// TrustedProxiesFromEnv reads TRUSTED_PROXIES (empty = trust no proxy: the
+// TCP peer is the client) and TRUSTED_PROXY_HEADER.
+func TrustedProxiesFromEnv() (*ProxyTrust, error) {
+	return ParseTrustedProxies(os.Getenv("TRUSTED_PROXIES"), os.Getenv("TRUSTED_PROXY_HEADER"))
+}
+
+func (t *ProxyTrust) trusted(ip net.IP) bool {
+	for _, n := range t.nets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ClientIP returns the client address of r. Starting at the TCP peer, it walks
+// the forwarding chain from the right as long as the current hop is a trusted
+// proxy; the first untrusted address is the client. A malformed entry stops
+// the walk at the proxy that relayed it. Forwarded (RFC 7239) takes precedence
+// over X-Forwarded-For when present.
+func (t *ProxyTrust) ClientIP(r *http.Request) string {
+	peer := parseHostIP(r.RemoteAddr)
+	if peer == nil {
+		return r.RemoteAddr
+	}
+	if t == nil || !t.trusted(peer) {
+		return peer.String()
+	}
+	var chain []string
+	if t.header == "Forwarded" {
+		chain = forwardedFor(r.Header.Values("Forwarded"))
+	} else {
+		chain = xForwardedFor(r.Header.Values("X-Forwarded-For"))
+	}
+	cur := peer
+	for i := len(chain) - 1; i >= 0 && t.trusted(cur); i-- {
+		ip := parseHostIP(chain[i])
+		if ip == nil {
+			break
+		}
+		cur = ip
+	}
+	return cur.String()
+}
+
+func xForwardedFor(values []string) []string {
+	var out []string
+	for _, v := range values {
+		for _, p := range strings.Split(v, ",") {
+			out = append(out, strings.TrimSpace(p))
+		}
+	}
+	return out
+}
+
+// forwardedFor extracts the for= parameters of Forwarded headers, in order.
+// An element without one yields "", which stops the walk.
+func forwardedFor(values []string) []string {
+	var out []string
+	for _, v := range values {
+		for _, elem := range strings.Split(v, ",") {
+			found := false
+			for _, pair := range strings.Split(elem, ";") {
+				k, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if ok && strings.EqualFold(k, "for") {
+					out = append(out, strings.Trim(val, `"`))
+					found = true
+				}
+			}
+			if !found {
+				out = append(out, "") // an element without for= breaks the chain
+			}
+		}
+	}
+	return out
+}
+
+// parseHostIP accepts "ip", "ip:port", "[v6]" and "[v6]:port".
+func parseHostIP(s string) net.IP {
+	s = strings.TrimSpace(s)
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	s = strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+	if i := strings.IndexByte(s, '%'); i >= 0 {
+		s = s[:i] // zone
+	}
+	ip := net.ParseIP(s)
+	if v4 := ip.To4(); v4 != nil {
+		return v4
+	}
+	return ip
+}
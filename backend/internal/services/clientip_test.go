@@ -0,0 +1,135 @@
+package services
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	const proxies = "10.0.0.0/8, 192.168.1.1, fd00::/8"
+	for _, tc := range []struct {
+		name    string
+		header  string // TRUSTED_PROXY_HEADER
+		peer    string
+		headers map[string][]string
+		want    string
+	}{
+		// untrusted peers: every forwarding header is the client's own
+		{name: "direct client", peer: "203.0.113.5:4711", want: "203.0.113.5"},
+		{name: "untrusted peer with XFF", peer: "203.0.113.5:4711",
+			headers: map[string][]string{"X-Forwarded-For": {"1.1.1.1"}}, want: "203.0.113.5"},
+		{name: "untrusted peer with Forwarded", header: "Forwarded", peer: "203.0.113.5:4711",
+			headers: map[string][]string{"Forwarded": {"for=1.1.1.1"}}, want: "203.0.113.5"},
+		{name: "single IP outside its /32", peer: "192.168.1.2:80",
+			headers: map[string][]string{"X-Forwarded-For": {"1.1.1.1"}}, want: "192.168.1.2"},
+
+		// X-Forwarded-For through trusted proxies
+		{name: "proxy without header", peer: "10.0.0.2:80", want: "10.0.0.2"},
+		{name: "one proxy", peer: "10.0.0.2:80",
+			headers: map[string][]string{"X-Forwarded-For": {"198.51.100.7"}}, want: "198.51.100.7"},
+		{name: "two proxies", peer: "10.0.0.2:80",
+			headers: map[string][]string{"X-Forwarded-For": {"198.51.100.7, 192.168.1.1"}}, want: "198.51.100.7"},
+		{name: "spoofed XFF prefix", peer: "10.0.0.2:80",
+			headers: map[string][]string{"X-Forwarded-For": {"10.9.9.9, 127.0.0.1, 198.51.100.7"}}, want: "198.51.100.7"},
+		{name: "spoofed trusted address left of an untrusted hop", peer: "10.0.0.2:80",
+			headers: map[string][]string{"X-Forwarded-For": {"10.1.1.1, 198.51.100.7, 10.0.0.3"}}, want: "198.51.100.7"},
+		{name: "XFF over several header lines", peer: "10.0.0.2:80",
+			headers: map[string][]string{"X-Forwarded-For": {"6.6.6.6", "198.51.100.7"}}, want: "198.51.100.7"},
+		{name: "XFF with ports", peer: "10.0.0.2:80",
+			headers: map[string][]string{"X-Forwarded-For": {"198.51.100.7:5555, [fd00::1]:443"}}, want: "198.51.100.7"},
+
+		// spoofed Forwarded: the proxy only appends to X-Forwarded-For
+		{name: "client Forwarded ignored", peer: "10.0.0.2:80",
+			headers: map[string][]string{"Forwarded": {"for=1.1.1.1"}, "X-Forwarded-For": {"198.51.100.7"}},
+			want:    "198.51.100.7"},
+		{name: "client Forwarded without XFF", peer: "10.0.0.2:80",
+			headers: map[string][]string{"Forwarded": {"for=1.1.1.1"}}, want: "10.0.0.2"},
+
+		// Forwarded configured: X-Forwarded-For is the client's own
+		{name: "Forwarded", header: "forwarded", peer: "10.0.0.2:80",
+			headers: map[string][]string{"Forwarded": {`for=198.51.100.7;proto=https;by=10.0.0.2`}}, want: "198.51.100.7"},
+		{name: "Forwarded chain", header: "Forwarded", peer: "10.0.0.2:80",
+			headers: map[string][]string{"Forwarded": {`for=6.6.6.6, For="198.51.100.7", for=10.0.0.3`}}, want: "198.51.100.7"},
+		{name: "Forwarded quoted IPv6 with port", header: "Forwarded", peer: "10.0.0.2:80",
+			headers: map[string][]string{"Forwarded": {`for="[2001:db8::7]:4711"`}}, want: "2001:db8::7"},
+		{name: "client XFF ignored", header: "Forwarded", peer: "10.0.0.2:80",
+			headers: map[string][]string{"Forwarded": {"for=198.51.100.7"}, "X-Forwarded-For": {"1.1.1.1"}},
+			want:    "198.51.100.7"},
+		{name: "client XFF without Forwarded", header: "Forwarded", peer: "10.0.0.2:80",
+			headers: map[string][]string{"X-Forwarded-For": {"1.1.1.1"}}, want: "10.0.0.2"},
+
+		// malformed entries stop the walk at the proxy that relayed them
+		{name: "garbage XFF", peer: "10.0.0.2:80",
+			headers: map[string][]string{"X-Forwarded-For": {"not-an-ip"}}, want: "10.0.0.2"},
+		{name: "garbage behind a second proxy", peer: "10.0.0.2:80",
+			headers: map[string][]string{"X-Forwarded-For": {"1.1.1.1, bogus, 10.0.0.3"}}, want: "10.0.0.3"},
+		{name: "empty XFF entry", peer: "10.0.0.2:80",
+			headers: map[string][]string{"X-Forwarded-For": {"1.1.1.1,,10.0.0.3"}}, want: "10.0.0.3"},
+		{name: "Forwarded element without for", header: "Forwarded", peer: "10.0.0.2:80",
+			headers: map[string][]string{"Forwarded": {"for=1.1.1.1, proto=https"}}, want: "10.0.0.2"},
+		{name: "Forwarded obfuscated node", header: "Forwarded", peer: "10.0.0.2:80",
+			headers: map[string][]string{"Forwarded": {`for="_hidden"`}}, want: "10.0.0.2"},
+		{name: "Forwarded unknown", header: "Forwarded", peer: "10.0.0.2:80",
+			headers: map[string][]string{"Forwarded": {"for=unknown"}}, want: "10.0.0.2"},
+
+		// IPv6, with zones
+		{name: "IPv6 peer", peer: "[2001:db8::5]:4711", want: "2001:db8::5"},
+		{name: "IPv6 peer with zone", peer: "[fe80::1%eth0]:4711", want: "fe80::1"},
+		{name: "IPv6 proxy, IPv6 client with zone", peer: "[fd00::2]:80",
+			headers: map[string][]string{"X-Forwarded-For": {"fe80::7%25eth0"}}, want: "fe80::7"},
+		{name: "IPv6 proxy, bracketed client with zone", peer: "[fd00::2]:80",
+			headers: map[string][]string{"X-Forwarded-For": {"[fe80::7%eth0]:5555"}}, want: "fe80::7"},
+		{name: "zone does not make an address trusted", peer: "[fe80::9%fd00]:80",
+			headers: map[string][]string{"X-Forwarded-For": {"1.1.1.1"}}, want: "fe80::9"},
+		{name: "IPv4-mapped peer", peer: "[::ffff:10.0.0.2]:80",
+			headers: map[string][]string{"X-Forwarded-For": {"198.51.100.7"}}, want: "198.51.100.7"},
+
+		// a peer address that does not parse is passed through as is
+		{name: "unparsable peer", peer: "pipe", want: "pipe"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			pt, err := ParseTrustedProxies(proxies, tc.header)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tc.peer
+			for k, vs := range tc.headers {
+				for _, v := range vs {
+					r.Header.Add(k, v)
+				}
+			}
+			if got := pt.ClientIP(r); got != tc.want {
+				t.Fatalf("ClientIP = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+// Without TRUSTED_PROXIES, nothing a client sends is believed.
+func TestClientIPNoProxies(t *testing.T) {
+	for _, pt := range []*ProxyTrust{nil, {}} {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.RemoteAddr = "10.0.0.2:80"
+		r.Header.Set("X-Forwarded-For", "1.1.1.1")
+		r.Header.Set("Forwarded", "for=1.1.1.1")
+		if got := pt.ClientIP(r); got != "10.0.0.2" {
+			t.Errorf("ClientIP = %q, want the peer", got)
+		}
+	}
+}
+
+func TestParseTrustedProxiesRejects(t *testing.T) {
+	for _, tc := range []struct{ list, header string }{
+		{"10.0.0.0/33", ""},
+		{"10.0.0", ""},
+		{"proxy.internal", ""},
+		{"10.0.0.1", "X-Real-IP"},
+		{"10.0.0.1", "X-Forwarded-For, Forwarded"},
+	} {
+		if _, err := ParseTrustedProxies(tc.list, tc.header); err == nil {
+			t.Errorf("ParseTrustedProxies(%q, %q) accepted", tc.list, tc.header)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package services
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// CookieConfig is the deployment's cookie policy. Every cookie we set is
+// built by NewCookie / ExpiredCookie so the flags cannot drift between handlers.
+type CookieConfig struct {
+	Secure     bool          // COOKIE_SECURE (default false: plain-HTTP development)
+	Domain     string        // COOKIE_DOMAIN (default: host-only)
+	HostPrefix bool          // COOKIE_HOST_PREFIX: "__Host-" names for Path=/ cookies
+	SameSite   http.SameSite // COOKIE_SAMESITE: strict (default), lax or none
+}
+
+// CookieConfigFromEnv reads the cookie policy. Inconsistent settings are
+// reported as an error (main refuses to start) and neutralized in the
+// returned config, so handlers never emit a cookie the browser would drop.
+func CookieConfigFromEnv() (CookieConfig, error) {
+	cfg := CookieConfig{
+		Secure:     envBool("COOKIE_SECURE", false),
+		Domain:     strings.TrimSpace(os.Getenv("COOKIE_DOMAIN")),
+		HostPrefix: envBool("COOKIE_HOST_PREFIX", false),
+		SameSite:   http.SameSiteStrictMode,
+	}
+	var errs []error
+	switch strings.ToLower(strings.TrimSpace(os.Getenv("COOKIE_SAMESITE"))) {
+	case "", "strict":
+	case "lax":
+		cfg.SameSite = http.SameSiteLaxMode
+	case "none":
+		cfg.SameSite = http.SameSiteNoneMode
+		if !cfg.Secure {
+			errs = append(errs, errors.New("COOKIE_SAMESITE=none requires COOKIE_SECURE=true"))
+			cfg.SameSite = http.SameSiteLaxMode
+		}
+	default:
+		errs = append(errs, errors.New("COOKIE_SAMESITE must be strict, lax or none"))
+	}
+	if cfg.HostPrefix && (!cfg.Secure || cfg.Domain != "") {
+		errs = append(errs, errors.New("COOKIE_HOST_PREFIX requires COOKIE_SECURE=true and no COOKIE_DOMAIN"))
+		cfg.HostPrefix = false
+	}
+	return cfg, errors.Join(errs...)
+}
+
+func cookieConfig() CookieConfig {
+	cfg, _ := CookieConfigFromEnv()
+	return cfg
+}
+
+// Name returns the on-the-wire name of a cookie with the given path:
+// "__Host-" for Path=/ cookies when HostPrefix is on, "__Secure-" for other
+// secure cookies when it is on, the plain name otherwise.
+func (cfg CookieConfig) Name(base, path string) string {
+	switch {
+	case cfg.HostPrefix && path == "/":
+		return "__Host-" + base
+	case cfg.HostPrefix:
+		return "__Secure-" + base
+	}
+	return base
+}
+
+// CookieName returns the on-the-wire name of a cookie (see CookieConfig.Name).
+func CookieName(base, path string) string {
+	return cookieConfig().Name(base, path)
+}
+
+// SessionCookieName is the name of the session (JWT) cookie.
+func SessionCookieName() string {
+	return CookieName(SessionCookie, "/")
+}
+
+// CookieOption adjusts a cookie built by NewCookie.
+type CookieOption func(*http.Cookie)
+
+// WithCookiePath scopes a cookie to a path (default "/").
+func WithCookiePath(path string) CookieOption {
+	return func(c *http.Cookie) { c.Path = path }
+}
+
+// WithSameSiteLax relaxes SameSite for cookies that must survive a top-level
+// cross-site redirect (e.g. the OIDC callback). It never tightens None.
+func WithSameSiteLax() CookieOption {
+	return func(c *http.Cookie) {
+		if c.SameSite == http.SameSiteStrictMode {
+			c.SameSite = http.SameSiteLaxMode
+		}
+	}
+}
+
+// NewCookie builds an HttpOnly cookie under the deployment's policy.
+// base is the logical name; the prefix (if any) is added here.
+func NewCookie(base, value string, ttl time.Duration, opts ...CookieOption) *http.Cookie {
+	cfg := cookieConfig()
+	c := &http.Cookie{
+		Value:    value,
+		Path:     "/",
+		Expires:  time.Now().Add(ttl),
+		MaxAge:   int(ttl.Seconds()),
+		HttpOnly: true,
+		Secure:   cfg.Secure,
+		SameSite: cfg.SameSite,
+	}
+	for _, o := range opts {
+		o(c)
+	}
+	c.Name = cfg.Name(base, c.Path)
+	if !strings.HasPrefix(c.Name, "__Host-") {
+		c.Domain = cfg.Domain
+	}
+	return c
+}
+
+// ExpiredCookie deletes a cookie set by NewCookie with the same base and options.
+func ExpiredCookie(base string, opts ...CookieOption) *http.Cookie {
+	c := NewCookie(base, "", 0, opts...)
+	c.Expires = time.Unix(0, 0)
+	c.MaxAge = -1
+	return c
+}
@@ -0,0 +1,94 @@
+package services
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func setCookieEnv(t *testing.T, secure, domain, hostPrefix, sameSite string) {
+	t.Helper()
+	t.Setenv("COOKIE_SECURE", secure)
+	t.Setenv("COOKIE_DOMAIN", domain)
+	t.Setenv("COOKIE_HOST_PREFIX", hostPrefix)
+	t.Setenv("COOKIE_SAMESITE", sameSite)
+}
+
+func TestCookieConfigFromEnv(t *testing.T) {
+	for _, tc := range []struct {
+		name                                 string
+		secure, domain, hostPrefix, sameSite string
+		want                                 CookieConfig
+		err                                  string
+	}{
+		{name: "defaults", want: CookieConfig{SameSite: http.SameSiteStrictMode}},
+		{name: "production", secure: "true", hostPrefix: "yes", sameSite: "Strict",
+			want: CookieConfig{Secure: true, HostPrefix: true, SameSite: http.SameSiteStrictMode}},
+		{name: "lax with domain", secure: "1", domain: " example.com ", sameSite: "lax",
+			want: CookieConfig{Secure: true, Domain: "example.com", SameSite: http.SameSiteLaxMode}},
+		{name: "none", secure: "true", sameSite: "none",
+			want: CookieConfig{Secure: true, SameSite: http.SameSiteNoneMode}},
+		// inconsistent settings are errors, and neutralized
+		{name: "none without secure", sameSite: "none",
+			want: CookieConfig{SameSite: http.SameSiteLaxMode}, err: "COOKIE_SAMESITE=none requires COOKIE_SECURE=true"},
+		{name: "host prefix without secure", hostPrefix: "true",
+			want: CookieConfig{SameSite: http.SameSiteStrictMode}, err: "COOKIE_HOST_PREFIX requires"},
+		{name: "host prefix with domain", secure: "true", domain: "example.com", hostPrefix: "true",
+			want: CookieConfig{Secure: true, Domain: "example.com", SameSite: http.SameSiteStrictMode},
+			err:  "COOKIE_HOST_PREFIX requires"},
+		{name: "unknown samesite", sameSite: "relaxed",
+			want: CookieConfig{SameSite: http.SameSiteStrictMode}, err: "COOKIE_SAMESITE must be"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			setCookieEnv(t, tc.secure, tc.domain, tc.hostPrefix, tc.sameSite)
+			got, err := CookieConfigFromEnv()
+			if got != tc.want {
+				t.Errorf("config = %+v, want %+v", got, tc.want)
+			}
+			if tc.err == "" && err != nil || tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+				t.Errorf("err = %v, want %q", err, tc.err)
+			}
+		})
+	}
+}
+
+func TestNewCookie(t *testing.T) {
+	t.Run("host prefix", func(t *testing.T) {
+		setCookieEnv(t, "true", "", "true", "strict")
+		c := NewCookie(SessionCookie, "v", time.Hour)
+		if c.Name != "__Host-"+SessionCookie || !c.Secure || !c.HttpOnly || c.Domain != "" || c.Path != "/" ||
+			c.SameSite != http.SameSiteStrictMode || c.MaxAge != 3600 {
+			t.Fatalf("cookie: %+v", c)
+		}
+		if SessionCookieName() != c.Name {
+			t.Errorf("SessionCookieName() = %q, want %q", SessionCookieName(), c.Name)
+		}
+		// __Host- needs Path=/: other paths get __Secure-
+		c = NewCookie("oidc_state", "v", time.Minute, WithCookiePath("/api/auth/oidc"), WithSameSiteLax())
+		if c.Name != "__Secure-oidc_state" || c.Path != "/api/auth/oidc" || c.SameSite != http.SameSiteLaxMode {
+			t.Fatalf("cookie: %+v", c)
+		}
+	})
+	t.Run("domain", func(t *testing.T) {
+		setCookieEnv(t, "false", "example.com", "false", "")
+		c := NewCookie(SessionCookie, "v", time.Hour)
+		if c.Name != SessionCookie || c.Secure || c.Domain != "example.com" {
+			t.Fatalf("cookie: %+v", c)
+		}
+	})
+	t.Run("lax never tightens none", func(t *testing.T) {
+		setCookieEnv(t, "true", "", "", "none")
+		if c := NewCookie("x", "v", time.Minute, WithSameSiteLax()); c.SameSite != http.SameSiteNoneMode {
+			t.Fatalf("SameSite = %v, want None", c.SameSite)
+		}
+	})
+	t.Run("expired", func(t *testing.T) {
+		setCookieEnv(t, "true", "", "true", "")
+		c := ExpiredCookie("oidc_state", WithCookiePath("/api/auth/oidc"))
+		if c.Name != "__Secure-oidc_state" || c.Path != "/api/auth/oidc" || c.MaxAge != -1 || c.Value != "" ||
+			!c.Expires.Equal(time.Unix(0, 0)) {
+			t.Fatalf("cookie: %+v", c)
+		}
+	})
+}
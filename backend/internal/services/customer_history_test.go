@@ -0,0 +1,89 @@
+package services
+
+import (
+	"database/sql/driver"
+	"reflect"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestDiffCustomer(t *testing.T) {
+	acme := CustomerFields{Name: "Acme", Email: "ops@acme.example", Tags: Tags{"b2b", "vip"}}
+	renamed := acme
+	renamed.Name, renamed.Phone, renamed.Tags = "Acme Corp", "+1 555", Tags{"vip"}
+
+	for _, tc := range []struct {
+		name          string
+		before, after *CustomerFields
+		want          map[string]FieldChange
+	}{
+		{"unchanged", &acme, &acme, map[string]FieldChange{}},
+		{"update", &acme, &renamed, map[string]FieldChange{
+			"name":  {Old: "Acme", New: "Acme Corp"},
+			"phone": {Old: "", New: "+1 555"},
+			"tags":  {Old: "b2b, vip", New: "vip"},
+		}},
+		{"create", nil, &acme, map[string]FieldChange{
+			"name":  {New: "Acme"},
+			"email": {New: "ops@acme.example"},
+			"tags":  {New: "b2b, vip"},
+		}},
+		{"delete", &acme, nil, map[string]FieldChange{
+			"name":  {Old: "Acme"},
+			"email": {Old: "ops@acme.example"},
+			"tags":  {Old: "b2b, vip"},
+		}},
+	} {
+		if got := DiffCustomer(tc.before, tc.after); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: diff = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestChangedFields(t *testing.T) {
+	got := ChangedFields(map[string]FieldChange{"tags": {}, "name": {}, "notes": {}})
+	if want := []string{"name", "notes", "tags"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ChangedFields = %v, want %v", got, want)
+	}
+	if got := ChangedFields(nil); got == nil || len(got) != 0 {
+		t.Errorf("ChangedFields(nil) = %#v, want an empty list", got)
+	}
+}
+
+// The snapshot is the state after the change, or before it for a delete; no
+// actor and no details are stored as NULL.
+func TestRecordCustomerVersion(t *testing.T) {
+	before := CustomerFields{Name: "Acme", Email: "ops@acme.example", Tags: Tags{}}
+	after := before
+	after.Notes = "call first"
+
+	for _, tc := range []struct {
+		name          string
+		action        string
+		actor         int64
+		before, after *CustomerFields
+		details       map[string]any
+		args          []driver.Value // actor, changes, snapshot, details
+	}{
+		{"update", CustomerActionUpdate, 7, &before, &after, nil, []driver.Value{
+			int64(7), `{"notes":{"old":"","new":"call first"}}`,
+			`{"name":"Acme","email":"ops@acme.example","phone":"","notes":"call first","tags":[]}`, nil}},
+		{"delete", CustomerActionDelete, 7, &before, nil, nil, []driver.Value{
+			int64(7), `{"email":{"old":"ops@acme.example","new":""},"name":{"old":"Acme","new":""}}`,
+			`{"name":"Acme","email":"ops@acme.example","phone":"","notes":"","tags":[]}`, nil}},
+		{"restore by the system", CustomerActionRestore, 0, &after, &before, map[string]any{"restored_from": 2}, []driver.Value{
+			nil, `{"notes":{"old":"call first","new":""}}`,
+			`{"name":"Acme","email":"ops@acme.example","phone":"","notes":"","tags":[]}`, `{"restored_from":2}`}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			mock.ExpectExec(`INSERT INTO customer_history`).
+				WithArgs(append([]driver.Value{int64(1), int64(42), int64(3), tc.action}, tc.args...)...).
+				WillReturnResult(sqlmock.NewResult(1, 1))
+			if err := RecordCustomerVersion(db, 1, 42, 3, tc.action, tc.actor, tc.before, tc.after, tc.details); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
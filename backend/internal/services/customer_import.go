@@ -0,0 +1,138 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Customer imports that run in the background are tracked in
+// customer_import_jobs: progress while they run, then the per-row report.
+// Reports contain customer emails, so finished jobs are deleted after
+// ImportJobRetention.
+
+// Import job statuses.
+const (
+	ImportQueued  = "queued"
+	ImportRunning = "running"
+	ImportDone    = "done"
+	ImportFailed  = "failed"
+)
+
+const ImportJobRetention = 7 * 24 * time.Hour
+
+// ImportCounts sums up the rows of an import.
+type ImportCounts struct {
+	Processed int `db:"rows_processed" json:"processed"`
+	Created   int `db:"created_count" json:"created"`
+	Updated   int `db:"updated_count" json:"updated"`
+	Unchanged int `db:"unchanged_count" json:"unchanged"`
+	Rejected  int `db:"rejected_count" json:"rejected"`
+}
+
+// CreateImportJob records a queued import of bytesTotal bytes.
+func CreateImportJob(db *sqlx.DB, orgID, userID int64, format string, dryRun bool, bytesTotal int64) (int64, error) {
+	res, err := db.Exec(`
+		INSERT INTO customer_import_jobs (org_id, user_id, format, dry_run, status, bytes_total)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, orgID, userID, format, dryRun, ImportQueued, bytesTotal)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// StartImportJob marks a job as running.
+func StartImportJob(db *sqlx.DB, id int64) error {
+	_, err := db.Exec(`
+		UPDATE customer_import_jobs SET status = ?, started_at = NOW() WHERE id = ?
+	`, ImportRunning, id)
+	return err
+}
+
+// UpdateImportProgress stores the counts so far and how much of the upload
+// has been read.
+func UpdateImportProgress(db *sqlx.DB, id int64, n ImportCounts, bytesRead int64) error {
+	_, err := db.Exec(`
+		UPDATE customer_import_jobs
+		SET rows_processed = ?, created_count = ?, updated_count = ?, unchanged_count = ?, rejected_count = ?,
+		    bytes_read = ?
+		WHERE id = ?
+	`, n.Processed, n.Created, n.Updated, n.Unchanged, n.Rejected, bytesRead, id)
+	return err
+}
+
+// FinishImportJob stores the final counts and the per-row report.
+func FinishImportJob(db *sqlx.DB, id int64, n ImportCounts, report any) error {
+	b, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`
+		UPDATE customer_import_jobs
+		SET status = ?, rows_processed = ?, created_count = ?, updated_count = ?, unchanged_count = ?,
+		    rejected_count = ?, bytes_read = bytes_total, report = ?, finished_at = NOW()
+		WHERE id = ?
+	`, ImportDone, n.Processed, n.Created, n.Updated, n.Unchanged, n.Rejected, string(b), id)
+	return err
+}
+
+// FailImportJob ends a job with an error. Rows written before it stay.
+func FailImportJob(db *sqlx.DB, id int64, n ImportCounts, msg string) error {
+	_, err := db.Exec(`
+		UPDATE customer_import_jobs
+		SET status = ?, rows_processed = ?, created_count = ?, updated_count = ?, unchanged_count = ?,
+		    rejected_count = ?, error = ?, finished_at = NOW()
+		WHERE id = ?
+	`, ImportFailed, n.Processed, n.Created, n.Updated, n.Unchanged, n.Rejected, clip(msg, 255), id)
+	return err
+}
+
+// FailInterruptedImports fails the jobs a previous run of the server left
+// queued or running; their uploads are gone.
+func FailInterruptedImports(db *sqlx.DB) (int64, error) {
+	res, err := db.Exec(`
+		UPDATE customer_import_jobs
+		SET status = ?, error = 'interrupted by a server restart', finished_at = NOW()
+		WHERE status IN (?, ?)
+	`, ImportFailed, ImportQueued, ImportRunning)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// PurgeOldImportJobs deletes jobs that finished more than ImportJobRetention ago.
+func PurgeOldImportJobs(db *sqlx.DB) (int64, error) {
+	res, err := db.Exec(`
+		DELETE FROM customer_import_jobs WHERE finished_at IS NOT NULL AND finished_at <= ?
+	`, time.Now().UTC().Add(-ImportJobRetention))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// RunImportJobPurger runs PurgeOldImportJobs every interval until ctx is done.
+func RunImportJobPurger(ctx context.Context, db *sqlx.DB, every time.Duration) {
+	go func() {
+		t := time.NewTicker(every)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				n, err := PurgeOldImportJobs(db)
+				if err != nil {
+					log.Printf("[customer-import] purge error: %v", err)
+				} else if n > 0 {
+					log.Printf("[customer-import] deleted %d finished import job(s)", n)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,304 @@
+package services
+
+import (
+	"html"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Customer search runs on the FULLTEXT indexes of customers (name, email,
+// notes together and each column alone) in boolean mode, so it is ranked by
+// relevance. The query syntax:
+//
+//	alice             word, in name, email or notes
+//	ali*              prefix
+//	"acme corp"       phrase
+//	email:example.org one field: name:, email: or notes: (also with a prefix or phrase)
+//
+// Every term must match. The boolean-mode expression is built from the words
+// only, so operators typed by the user are never passed through. Words the
+// index does not hold (shorter than ftMinWordLen, or InnoDB stopwords) make
+// their term fall back to an escaped LIKE substring match on the same columns.
+
+// SearchTerm is one parsed term of a customer search query.
+type SearchTerm struct {
+	Field  string   // "" = name, email and notes
+	Raw    string   // the term as typed, without quotes and trailing *
+	Words  []string // lower-cased; more than one = phrase
+	Prefix bool
+}
+
+// CustomerSearchFields maps a field scope to its columns. The column lists
+// match the FULLTEXT indexes of customers.
+var CustomerSearchFields = map[string][]string{
+	"":      {"name", "email", "notes"},
+	"name":  {"name"},
+	"email": {"email"},
+	"notes": {"notes"},
+}
+
+var searchFieldOrder = []string{"", "name", "email", "notes"}
+
+// innodb_ft_min_token_size (default 3)
+const ftMinWordLen = 3
+
+// INNODB_FT_DEFAULT_STOPWORD
+var ftStopwords = map[string]bool{
+	"a": true, "about": true, "an": true, "are": true, "as": true, "at": true, "be": true, "by": true,
+	"com": true, "de": true, "en": true, "for": true, "from": true, "how": true, "i": true, "in": true,
+	"is": true, "it": true, "la": true, "of": true, "on": true, "or": true, "that": true, "the": true,
+	"this": true, "to": true, "was": true, "what": true, "when": true, "where": true, "who": true,
+	"will": true, "with": true, "und": true, "www": true,
+}
+
+// isWordRune reports whether r belongs to a word for the FULLTEXT parser.
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'
+}
+
+func splitWords(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool { return !isWordRune(r) })
+}
+
+// ParseCustomerQuery splits a search query into terms. A "field:" prefix with
+// an unknown field name is searched as text. Terms without any word
+// characters are dropped.
+func ParseCustomerQuery(q string) []SearchTerm {
+	var terms []SearchTerm
+	rs := []rune(q)
+	for i := 0; i < len(rs); {
+		if unicode.IsSpace(rs[i]) {
+			i++
+			continue
+		}
+		t := SearchTerm{}
+		j := i
+		for j < len(rs) && unicode.IsLetter(rs[j]) {
+			j++
+		}
+		if j > i && j < len(rs) && rs[j] == ':' {
+			if f := strings.ToLower(string(rs[i:j])); CustomerSearchFields[f] != nil {
+				t.Field = f
+				i = j + 1
+			}
+		}
+		if i < len(rs) && rs[i] == '"' {
+			j := i + 1
+			for j < len(rs) && rs[j] != '"' {
+				j++
+			}
+			t.Raw = string(rs[i+1 : j])
+			i = min(j+1, len(rs))
+		} else {
+			j := i
+			for j < len(rs) && !unicode.IsSpace(rs[j]) {
+				j++
+			}
+			t.Raw = string(rs[i:j])
+			i = j
+			if strings.HasSuffix(t.Raw, "*") {
+				t.Raw = strings.TrimRight(t.Raw, "*")
+				t.Prefix = true
+			}
+		}
+		t.Raw = strings.TrimSpace(t.Raw)
+		if t.Words = splitWords(t.Raw); len(t.Words) > 0 {
+			terms = append(terms, t)
+		}
+	}
+	return terms
+}
+
+// indexed reports whether the FULLTEXT index can answer the term.
+func (t SearchTerm) indexed() bool {
+	for _, w := range t.Words {
+		if utf8.RuneCountInString(w) < ftMinWordLen || ftStopwords[w] {
+			return false
+		}
+	}
+	return true
+}
+
+// boolean renders the term as a required boolean-mode clause.
+func (t SearchTerm) boolean() string {
+	switch {
+	case len(t.Words) == 1 && t.Prefix:
+		return "+" + t.Words[0] + "*"
+	case len(t.Words) == 1:
+		return "+" + t.Words[0]
+	case t.Prefix:
+		// a phrase cannot end in a prefix: require the words separately
+		parts := make([]string, len(t.Words))
+		for i, w := range t.Words {
+			parts[i] = "+" + w
+		}
+		parts[len(parts)-1] += "*"
+		return strings.Join(parts, " ")
+	default:
+		return `+"` + strings.Join(t.Words, " ") + `"`
+	}
+}
+
+// BuildCustomerSearch turns terms into a WHERE condition and a relevance
+// expression (the sum of the MATCH scores) over the customers table; both
+// come with their own arguments.
+func BuildCustomerSearch(terms []SearchTerm) (where string, whereArgs []any, score string, scoreArgs []any) {
+	var conds, scores []string
+	for _, field := range searchFieldOrder {
+		cols := CustomerSearchFields[field]
+		var clauses []string
+		for _, t := range terms {
+			if t.Field != field {
+				continue
+			}
+			if t.indexed() {
+				clauses = append(clauses, t.boolean())
+				continue
+			}
+			like := "%" + LikeEscape(t.Raw) + "%"
+			ors := make([]string, len(cols))
+			for i, col := range cols {
+				ors[i] = col + ` LIKE ? ESCAPE '\\'`
+				whereArgs = append(whereArgs, like)
+			}
+			conds = append(conds, "("+strings.Join(ors, " OR ")+")")
+		}
+		if len(clauses) > 0 {
+			match := "MATCH(" + strings.Join(cols, ", ") + ") AGAINST (? IN BOOLEAN MODE)"
+			expr := strings.Join(clauses, " ")
+			conds = append(conds, match)
+			whereArgs = append(whereArgs, expr)
+			scores = append(scores, match)
+			scoreArgs = append(scoreArgs, expr)
+		}
+	}
+	if len(conds) == 0 {
+		conds = []string{"1=1"}
+	}
+	if len(scores) == 0 {
+		scores = []string{"0"}
+	}
+	return strings.Join(conds, " AND "), whereArgs, strings.Join(scores, " + "), scoreArgs
+}
+
+// Snippet length for long fields (notes), in runes.
+const (
+	snippetLen    = 160
+	snippetBefore = 50
+)
+
+// HighlightCustomerField returns the text of field with the matches of terms
+// wrapped in <mark>; long text is cut to a snippet around the first match.
+// The text is HTML-escaped, so <mark> is the only markup. ok is false if
+// nothing in the field matches.
+func HighlightCustomerField(terms []SearchTerm, field, text string) (snippet string, ok bool) {
+	var spans [][2]int
+	for _, t := range terms {
+		if t.Field != "" && t.Field != field {
+			continue
+		}
+		if t.indexed() {
+			spans = append(spans, wordMatches(text, t)...)
+		} else {
+			re := regexp.MustCompile(`(?i)` + regexp.QuoteMeta(t.Raw))
+			for _, m := range re.FindAllStringIndex(text, -1) {
+				spans = append(spans, [2]int{m[0], m[1]})
+			}
+		}
+	}
+	if len(spans) == 0 {
+		return "", false
+	}
+	spans = mergeSpans(spans)
+
+	// Cut long text around the first match, on rune boundaries
+	from, to := 0, len(text)
+	if utf8.RuneCountInString(text) > snippetLen {
+		from = spans[0][0]
+		for n := 0; n < snippetBefore && from > 0; n++ {
+			_, size := utf8.DecodeLastRuneInString(text[:from])
+			from -= size
+		}
+		to = from
+		for n := 0; n < snippetLen && to < len(text); n++ {
+			_, size := utf8.DecodeRuneInString(text[to:])
+			to += size
+		}
+	}
+
+	var b strings.Builder
+	if from > 0 {
+		b.WriteString("…")
+	}
+	pos := from
+	for _, s := range spans {
+		start, end := max(s[0], from), min(s[1], to)
+		if start >= end {
+			continue
+		}
+		b.WriteString(html.EscapeString(text[pos:start]))
+		b.WriteString("<mark>" + html.EscapeString(text[start:end]) + "</mark>")
+		pos = end
+	}
+	b.WriteString(html.EscapeString(text[pos:to]))
+	if to < len(text) {
+		b.WriteString("…")
+	}
+	return b.String(), true
+}
+
+// wordMatches finds the byte spans where the words of t occur as whole words
+// in order (the last one as a prefix for prefix terms), like the index does.
+func wordMatches(text string, t SearchTerm) [][2]int {
+	var words [][2]int
+	start := -1
+	for i, r := range text {
+		switch {
+		case isWordRune(r) && start < 0:
+			start = i
+		case !isWordRune(r) && start >= 0:
+			words = append(words, [2]int{start, i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		words = append(words, [2]int{start, len(text)})
+	}
+
+	var out [][2]int
+	for i := 0; i+len(t.Words) <= len(words); i++ {
+		ok := true
+		for k, w := range t.Words {
+			got := strings.ToLower(text[words[i+k][0]:words[i+k][1]])
+			if k == len(t.Words)-1 && t.Prefix {
+				ok = strings.HasPrefix(got, w)
+			} else {
+				ok = got == w
+			}
+			if !ok {
+				break
+			}
+		}
+		if ok {
+			out = append(out, [2]int{words[i][0], words[i+len(t.Words)-1][1]})
+		}
+	}
+	return out
+}
+
+// mergeSpans sorts spans and joins overlapping ones.
+func mergeSpans(spans [][2]int) [][2]int {
+	sort.Slice(spans, func(i, j int) bool { return spans[i][0] < spans[j][0] })
+	out := spans[:1]
+	for _, s := range spans[1:] {
+		if last := &out[len(out)-1]; s[0] <= last[1] {
+			last[1] = max(last[1], s[1])
+		} else {
+			out = append(out, s)
+		}
+	}
+	return out
+}
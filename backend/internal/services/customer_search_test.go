@@ -0,0 +1,179 @@
+package services
+
+import (
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestParseCustomerQuery(t *testing.T) {
+	for _, tc := range []struct {
+		q    string
+		want []SearchTerm
+	}{
+		{`alice`, []SearchTerm{{Raw: "alice", Words: []string{"alice"}}}},
+		{`  Alice   Smith `, []SearchTerm{
+			{Raw: "Alice", Words: []string{"alice"}},
+			{Raw: "Smith", Words: []string{"smith"}},
+		}},
+		{`ali*`, []SearchTerm{{Raw: "ali", Words: []string{"ali"}, Prefix: true}}},
+		{`"Acme  Corp"`, []SearchTerm{{Raw: "Acme  Corp", Words: []string{"acme", "corp"}}}},
+		{`email:example.org`, []SearchTerm{{Field: "email", Raw: "example.org", Words: []string{"example", "org"}}}},
+		{`NAME:"van der Berg" notes:vip*`, []SearchTerm{
+			{Field: "name", Raw: "van der Berg", Words: []string{"van", "der", "berg"}},
+			{Field: "notes", Raw: "vip", Words: []string{"vip"}, Prefix: true},
+		}},
+		// unknown fields are text
+		{`phone:555`, []SearchTerm{{Raw: "phone:555", Words: []string{"phone", "555"}}}},
+		// an unterminated phrase runs to the end
+		{`"open phrase`, []SearchTerm{{Raw: "open phrase", Words: []string{"open", "phrase"}}}},
+		// multibyte words
+		{`Müller-Lüdenscheidt`, []SearchTerm{{Raw: "Müller-Lüdenscheidt", Words: []string{"müller", "lüdenscheidt"}}}},
+		{`東京`, []SearchTerm{{Raw: "東京", Words: []string{"東京"}}}},
+		// nothing searchable
+		{``, nil},
+		{`+ - > < ( ) ~ * " @`, nil},
+		{`name: "" ***`, nil},
+	} {
+		if got := ParseCustomerQuery(tc.q); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%q:\n got %+v\nwant %+v", tc.q, got, tc.want)
+		}
+	}
+}
+
+// Boolean-mode operators typed by the user never reach AGAINST: every
+// expression is made of required words, phrases and a trailing prefix only.
+func TestBuildCustomerSearchNeutralizesOperators(t *testing.T) {
+	clause := `\+(?:"[\p{L}\p{N}_]+(?: [\p{L}\p{N}_]+)*"|[\p{L}\p{N}_]+\*?)`
+	safe := regexp.MustCompile(`^` + clause + `(?: ` + clause + `)*$`)
+	for _, q := range []string{
+		`+alice -bob`,
+		`>alice <bob ~carol`,
+		`(alice bob)`,
+		`alice* "bob carol"*`,
+		`-"alice" +(bob)`,
+		`@alice >>> <<<bob`,
+		`"alice" "bob`,
+		`name:"alice -bob" notes:+carol*`,
+		`alice\" OR 1=1 --`,
+		`ali'ce ") AGAINST ('x') OR ("`,
+	} {
+		where, whereArgs, score, scoreArgs := BuildCustomerSearch(ParseCustomerQuery(q))
+		for _, a := range append(whereArgs, scoreArgs...) {
+			s, _ := a.(string)
+			if strings.HasPrefix(s, "%") {
+				continue // LIKE fallback, escaped separately
+			}
+			if !safe.MatchString(s) {
+				t.Errorf("%q: unsafe boolean expression %q", q, s)
+			}
+		}
+		if sql := strings.ReplaceAll(where+score, `ESCAPE '\\'`, ""); strings.ContainsAny(sql, `"'`) {
+			t.Errorf("%q: user text in SQL: %s / %s", q, where, score)
+		}
+	}
+}
+
+func TestBuildCustomerSearch(t *testing.T) {
+	const (
+		all   = `MATCH(name, email, notes) AGAINST (? IN BOOLEAN MODE)`
+		likes = `(name LIKE ? ESCAPE '\\' OR email LIKE ? ESCAPE '\\' OR notes LIKE ? ESCAPE '\\')`
+	)
+	for _, tc := range []struct {
+		q         string
+		where     string
+		whereArgs []any
+		score     string
+		scoreArgs []any
+	}{
+		{`alice smith*`, all, []any{"+alice +smith*"}, all, []any{"+alice +smith*"}},
+		{`"acme corp"`, all, []any{`+"acme corp"`}, all, []any{`+"acme corp"`}},
+		// a phrase cannot end in a prefix: its words are required separately
+		{`acme-corp*`, all, []any{"+acme +corp*"}, all, []any{"+acme +corp*"}},
+		// a star after a phrase is a separate (empty) term
+		{`"acme corp"*`, all, []any{`+"acme corp"`}, all, []any{`+"acme corp"`}},
+		// short words and stopwords are not in the index: LIKE on the same columns
+		{`jo`, likes, []any{"%jo%", "%jo%", "%jo%"}, "0", nil},
+		{`the`, likes, []any{"%the%", "%the%", "%the%"}, "0", nil},
+		{`"the who"`, likes, []any{"%the who%", "%the who%", "%the who%"}, "0", nil},
+		{`Ål`, likes, []any{"%Ål%", "%Ål%", "%Ål%"}, "0", nil},
+		{`ab*`, likes, []any{"%ab%", "%ab%", "%ab%"}, "0", nil},
+		// the LIKE pattern is escaped
+		{`5%_`, likes, []any{`%5\%\_%`, `%5\%\_%`, `%5\%\_%`}, "0", nil},
+		// mixed: the index for the long word, LIKE for the short one
+		{`alice jo`, likes + " AND " + all, []any{"%jo%", "%jo%", "%jo%", "+alice"}, all, []any{"+alice"}},
+		// field scopes use their own index
+		{`email:example.org notes:vi`,
+			`MATCH(email) AGAINST (? IN BOOLEAN MODE) AND (notes LIKE ? ESCAPE '\\')`,
+			[]any{`+"example org"`, "%vi%"},
+			`MATCH(email) AGAINST (? IN BOOLEAN MODE)`, []any{`+"example org"`}},
+		{`name:alice bob`, all + ` AND MATCH(name) AGAINST (? IN BOOLEAN MODE)`, []any{"+bob", "+alice"},
+			all + ` + MATCH(name) AGAINST (? IN BOOLEAN MODE)`, []any{"+bob", "+alice"}},
+		{``, "1=1", nil, "0", nil},
+	} {
+		where, whereArgs, score, scoreArgs := BuildCustomerSearch(ParseCustomerQuery(tc.q))
+		if where != tc.where || !reflect.DeepEqual(whereArgs, tc.whereArgs) ||
+			score != tc.score || !reflect.DeepEqual(scoreArgs, tc.scoreArgs) {
+			t.Errorf("%q:\n got %s %q | %s %q\nwant %s %q | %s %q", tc.q,
+				where, whereArgs, score, scoreArgs, tc.where, tc.whereArgs, tc.score, tc.scoreArgs)
+		}
+	}
+}
+
+func TestHighlightCustomerField(t *testing.T) {
+	for _, tc := range []struct {
+		q, field, text string
+		want           string
+		ok             bool
+	}{
+		{`alice`, "name", "Alice Smith", "<mark>Alice</mark> Smith", true},
+		{`ali*`, "name", "Alice Alison alias", "<mark>Alice</mark> <mark>Alison</mark> <mark>alias</mark>", true},
+		// whole words only for indexed terms, substrings for the LIKE fallback
+		{`ali`, "name", "Alice", "", false},
+		{`li`, "name", "Alice", "A<mark>li</mark>ce", true},
+		{`"acme corp"`, "name", "ACME, Corp. and Acme Inc", "<mark>ACME, Corp</mark>. and Acme Inc", true},
+		// field scope
+		{`email:alice`, "name", "Alice", "", false},
+		{`email:alice`, "email", "alice@example.org", "<mark>alice</mark>@example.org", true},
+		// escaped; <mark> is the only markup
+		{`bold`, "notes", `<b>bold</b> & "quoted"`, `&lt;b&gt;<mark>bold</mark>&lt;/b&gt; &amp; &#34;quoted&#34;`, true},
+		{`<b>`, "notes", `<b>x`, "<mark>&lt;b&gt;</mark>x", true},
+		// multibyte text, case-folded
+		{`lüd*`, "name", "Müller-Lüdenscheidt GmbH", "Müller-<mark>Lüdenscheidt</mark> GmbH", true},
+		{`ÜL`, "name", "Müller", "M<mark>ül</mark>ler", true},
+		{`東京`, "notes", "本社は東京です", "本社は<mark>東京</mark>です", true},
+		// overlapping and touching spans are merged, on rune boundaries
+		{`café é`, "name", "Café Olé", "<mark>Café</mark> Ol<mark>é</mark>", true},
+		{`müller ll`, "name", "Müller", "<mark>Müller</mark>", true},
+		{`ül le`, "name", "Müller", "M<mark>ülle</mark>r", true},
+		{`ßö öß`, "notes", "xßößöy", "x<mark>ßößö</mark>y", true},
+	} {
+		got, ok := HighlightCustomerField(ParseCustomerQuery(tc.q), tc.field, tc.text)
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("%q in %s %q:\n got %q %v\nwant %q %v", tc.q, tc.field, tc.text, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+// Long text is cut to a snippet around the first match without splitting a
+// rune, with the match intact.
+func TestHighlightCustomerFieldSnippet(t *testing.T) {
+	text := strings.Repeat("ä", 200) + " Nadel " + strings.Repeat("€", 200) + " nadel"
+	got, ok := HighlightCustomerField(ParseCustomerQuery("nadel"), "notes", text)
+	if !ok || !utf8.ValidString(got) {
+		t.Fatalf("snippet %q, %v", got, ok)
+	}
+	want := "…" + strings.Repeat("ä", snippetBefore-1) + " <mark>Nadel</mark> " +
+		strings.Repeat("€", snippetLen-snippetBefore-len("Nadel ")) + "…"
+	if got != want {
+		t.Fatalf("snippet:\n got %q\nwant %q", got, want)
+	}
+
+	// a match at the start needs no leading ellipsis
+	got, _ = HighlightCustomerField(ParseCustomerQuery("ää*"), "notes", strings.Repeat("ä", 400))
+	if !strings.HasPrefix(got, "<mark>") || utf8.RuneCountInString(got) != snippetLen+len("<mark></mark>")+1 {
+		t.Fatalf("snippet %q", got)
+	}
+}
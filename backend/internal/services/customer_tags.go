@@ -0,0 +1,85 @@
+package services
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Customer tags are short labels (lower-case letters, digits, '-' and '_')
+// stored as a JSON array in customers.tags, with a multi-valued index for the
+// tag filter of the customer listing.
+const (
+	MaxCustomerTags   = 20
+	MaxCustomerTagLen = 32 // CAST(tags AS CHAR(32) ARRAY)
+)
+
+var ErrInvalidTag = errors.New("invalid tag")
+
+// Tags is a customer's tag list; it reads and writes the JSON column.
+type Tags []string
+
+// Scan implements sql.Scanner.
+func (t *Tags) Scan(src any) error {
+	var b []byte
+	switch v := src.(type) {
+	case nil:
+		*t = Tags{}
+		return nil
+	case []byte:
+		b = v
+	case string:
+		b = []byte(v)
+	default:
+		return fmt.Errorf("tags: cannot scan %T", src)
+	}
+	out := Tags{}
+	if err := json.Unmarshal(b, &out); err != nil {
+		return err
+	}
+	*t = out
+	return nil
+}
+
+// Value implements driver.Valuer.
+func (t Tags) Value() (driver.Value, error) {
+	if t == nil {
+		t = Tags{}
+	}
+	b, err := json.Marshal([]string(t))
+	return string(b), err
+}
+
+// NormalizeTags trims and lower-cases tags, drops empty ones and duplicates,
+// and sorts them. It fails for too many tags or one that is too long or has
+// other characters.
+func NormalizeTags(in []string) (Tags, error) {
+	seen := map[string]bool{}
+	out := Tags{}
+	for _, t := range in {
+		t = strings.ToLower(strings.TrimSpace(t))
+		if t == "" || seen[t] {
+			continue
+		}
+		if utf8.RuneCountInString(t) > MaxCustomerTagLen {
+			return nil, ErrInvalidTag
+		}
+		for _, r := range t {
+			if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-' && r != '_' {
+				return nil, ErrInvalidTag
+			}
+		}
+		seen[t] = true
+		out = append(out, t)
+	}
+	if len(out) > MaxCustomerTags {
+		return nil, ErrInvalidTag
+	}
+	sort.Strings(out)
+	return out, nil
+}
@@ -0,0 +1,75 @@
+package services
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeTags(t *testing.T) {
+	for _, tc := range []struct {
+		in   []string
+		want Tags
+	}{
+		{nil, Tags{}},
+		{[]string{" VIP ", "b2b", "vip", "", "Über_kunde-2"}, Tags{"b2b", "vip", "über_kunde-2"}},
+		{[]string{strings.Repeat("x", MaxCustomerTagLen)}, Tags{strings.Repeat("x", MaxCustomerTagLen)}},
+	} {
+		got, err := NormalizeTags(tc.in)
+		if err != nil || !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("NormalizeTags(%q) = %q, %v; want %q", tc.in, got, err, tc.want)
+		}
+	}
+
+	many := make([]string, MaxCustomerTags+1)
+	for i := range many {
+		many[i] = "t" + strings.Repeat("x", i)
+	}
+	for name, in := range map[string][]string{
+		"too long": {strings.Repeat("x", MaxCustomerTagLen+1)},
+		"space":    {"key account"},
+		"comma":    {"a,b"},
+		"json":     {`a"]`},
+		"too many": many,
+		"one bad":  {"ok", "not/ok"},
+	} {
+		if _, err := NormalizeTags(in); !errors.Is(err, ErrInvalidTag) {
+			t.Errorf("%s: err = %v, want ErrInvalidTag", name, err)
+		}
+	}
+	// duplicates do not count towards the limit
+	if got, err := NormalizeTags(append(many[:MaxCustomerTags:MaxCustomerTags], "T", " t")); err != nil || len(got) != MaxCustomerTags {
+		t.Errorf("%d tags with duplicates: %d, %v", MaxCustomerTags, len(got), err)
+	}
+}
+
+func TestTagsScanValue(t *testing.T) {
+	for src, want := range map[any]Tags{
+		nil:                {},
+		`[]`:               {},
+		`["b2b","vip"]`:    {"b2b", "vip"},
+		string(`["über"]`): {"über"},
+	} {
+		var got Tags
+		if err := got.Scan(src); err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("Scan(%v) = %q, %v; want %q", src, got, err, want)
+		}
+	}
+	var got Tags
+	if err := got.Scan([]byte(`["vip"]`)); err != nil || !reflect.DeepEqual(got, Tags{"vip"}) {
+		t.Errorf("Scan([]byte) = %q, %v", got, err)
+	}
+	if err := got.Scan(42); err == nil {
+		t.Error("scanned an int")
+	}
+
+	for _, tc := range []struct {
+		in   Tags
+		want string
+	}{{nil, `[]`}, {Tags{}, `[]`}, {Tags{"b2b", "vip"}, `["b2b","vip"]`}} {
+		if v, err := tc.in.Value(); err != nil || v != tc.want {
+			t.Errorf("Value(%q) = %v, %v; want %s", tc.in, v, err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package services
+
+import (
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// CustomerTrashRetention is how long a deleted customer stays in the trash,
+// where an admin can restore it, before it is purged for good.
+func CustomerTrashRetention() time.Duration {
+	days := 30
+	if v := os.Getenv("CUSTOMER_TRASH_RETENTION_DAYS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n >= 0 && n <= 365 {
+			days = n
+		}
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// PurgeCustomer removes a trashed customer and its change history, which
+// holds copies of the personal data. A customer restored in the meantime is
+// left alone (ok = false).
+func PurgeCustomer(db *sqlx.DB, customerID int64) (ok bool, err error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`DELETE FROM customers WHERE id = ? AND deleted_at IS NOT NULL`, customerID)
+	if err != nil {
+		return false, err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return false, nil
+	}
+	if _, err := tx.Exec(`DELETE FROM customer_history WHERE customer_id = ?`, customerID); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// PurgeDueCustomers purges every customer that has been in the trash longer
+// than CustomerTrashRetention and returns how many were removed.
+func PurgeDueCustomers(db *sqlx.DB) (int, error) {
+	var due []struct {
+		ID    int64 `db:"id"`
+		OrgID int64 `db:"org_id"`
+	}
+	if err := db.Select(&due, `
+		SELECT id, org_id
+		FROM customers
+		WHERE deleted_at IS NOT NULL AND deleted_at <= ?
+	`, time.Now().UTC().Add(-CustomerTrashRetention())); err != nil {
+		return 0, err
+	}
+
+	n := 0
+	for _, cu := range due {
+		ok, err := PurgeCustomer(db, cu.ID)
+		if err != nil {
+			log.Printf("[customer-trash] purge customer %d: %v", cu.ID, err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		n++
+		if err := AppendAudit(db, AuditEvent{
+			Type: AuditCustomerPurged, ActorLabel: "system", OrgID: cu.OrgID,
+			SubjectType: "customer", SubjectID: strconv.FormatInt(cu.ID, 10),
+		}); err != nil {
+			log.Printf("[audit] %s: %v", AuditCustomerPurged, err)
+		}
+	}
+	return n, nil
+}
+
+// RunCustomerTrashPurger runs PurgeDueCustomers every interval until ctx is done.
+func RunCustomerTrashPurger(ctx context.Context, db *sqlx.DB, every time.Duration) {
+	go func() {
+		t := time.NewTicker(every)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				n, err := PurgeDueCustomers(db)
+				if err != nil {
+					log.Printf("[customer-trash] scan error: %v", err)
+				} else if n > 0 {
+					log.Printf("[customer-trash] purged %d customer(s)", n)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,252 @@
+package services
+
+import (
+	"errors"
+	"net/mail"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/net/idna"
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+const (
+	UsernameMinLen = 3
+	UsernameMaxLen = 32
+	EmailMaxLen    = 254 // RFC 5321 forward-path limit
+	emailLocalMax  = 64
+)
+
+// Names that could be mistaken for the system or its staff. Compared by
+// reservedSkeleton, so "adm1n", "rnoderator" and "аdmin" (Cyrillic а) are
+// caught as well.
+var reservedUsernames = []string{
+	"admin", "administrator", "root", "system", "sysadmin", "support", "security",
+	"help", "helpdesk", "info", "staff", "owner", "moderator", "api", "www",
+	"mail", "email", "postmaster", "hostmaster", "webmaster", "abuse", "noreply",
+	"no-reply", "mailer-daemon", "null", "undefined", "anonymous", "me", "self",
+	"deleted-user",
+}
+
+var reservedSkeletons = func() map[string]bool {
+	m := make(map[string]bool, len(reservedUsernames))
+	for _, r := range reservedUsernames {
+		m[reservedSkeleton(r)] = true
+	}
+	return m
+}()
+
+// Single code points that render like a Latin letter. This is the practical
+// subset of the Unicode confusables table (UTS #39) that matters for short
+// Latin-looking handles. The skeleton is a unique key, so only true
+// homoglyphs belong here: a fold that merely looks close ("i" and "l") would
+// lock unrelated names out of each other.
+var confusables = map[rune]string{
+	'0': "o", '1': "l", '|': "l",
+	// Cyrillic
+	'а': "a", 'в': "b", 'е': "e", 'ё': "e", 'һ': "h", 'і': "i", 'ї': "i", 'ј': "j",
+	'к': "k", 'м': "m", 'н': "h", 'о': "o", 'р': "p", 'с': "c", 'т': "t",
+	'у': "y", 'х': "x", 'ѕ': "s", 'ԁ': "d", 'ԛ': "q", 'ԝ': "w", 'ь': "b",
+	// Greek
+	'α': "a", 'β': "b", 'ε': "e", 'η': "n", 'ι': "i", 'κ': "k", 'ν': "v",
+	'ο': "o", 'ρ': "p", 'τ': "t", 'υ': "u", 'χ': "x", 'ω': "w", 'γ': "y",
+	// Latin look-alikes
+	'ı': "i", 'ȷ': "j", 'ɡ': "g", 'ɑ': "a", 'ʀ': "r", 'ʏ': "y", 'ℓ': "l",
+}
+
+// Looser folds, applied on top of the skeleton for reserved names only:
+// look-alike digits and letters, and sequences that render like a single
+// letter in most fonts. Too coarse for user-to-user uniqueness ("clara" and
+// "dara", "kim" and "klm").
+var (
+	reservedFolds     = strings.NewReplacer("i", "l", "3", "e", "5", "s", "8", "b")
+	reservedSequences = strings.NewReplacer("rn", "m", "vv", "w", "cl", "d")
+)
+
+var (
+	ErrUsernameLength   = errors.New("username must be 3-32 characters")
+	ErrUsernameChars    = errors.New("username may only contain letters, digits, '.', '_' and '-', and must start with a letter or digit")
+	ErrUsernameMixed    = errors.New("username must not mix letters from different scripts")
+	ErrUsernameEmail    = errors.New("username must not look like an email address")
+	ErrUsernameReserved = errors.New("username is reserved")
+	ErrEmailInvalid     = errors.New("invalid email")
+)
+
+// IsIdentityValidationError reports whether err is one of the username/email
+// rule violations above (safe to show to the caller).
+func IsIdentityValidationError(err error) bool {
+	for _, e := range []error{ErrUsernameLength, ErrUsernameChars, ErrUsernameMixed,
+		ErrUsernameEmail, ErrUsernameReserved, ErrEmailInvalid} {
+		if errors.Is(err, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// FoldUsername is the case-insensitive comparison form of a username:
+// NFKC, Unicode case folding, NFKC again (folding can denormalize).
+func FoldUsername(s string) string {
+	s = norm.NFKC.String(strings.TrimSpace(s))
+	s = cases.Fold().String(s)
+	return norm.NFKC.String(s)
+}
+
+// UsernameSkeleton maps a username onto a form where visually identical
+// names collide: diacritics are stripped, homoglyphs from other scripts and
+// digits are replaced by their Latin counterparts, and separators are
+// dropped ("j.doe", "j_doe" and "jdoe" share a skeleton).
+func UsernameSkeleton(s string) string {
+	s = norm.NFD.String(FoldUsername(s))
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case unicode.Is(unicode.Mn, r):
+			continue
+		case r == '.' || r == '_' || r == '-':
+			continue
+		}
+		if m, ok := confusables[r]; ok {
+			b.WriteString(m)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// reservedSkeleton is the form reserved names are compared in.
+func reservedSkeleton(s string) string {
+	return reservedSequences.Replace(reservedFolds.Replace(UsernameSkeleton(s)))
+}
+
+// CanonicalUsername validates a username for registration and returns the
+// display form (NFKC, trimmed) and the canonical form used for uniqueness
+// and lookups.
+func CanonicalUsername(raw string) (display, canonical string, err error) {
+	display = norm.NFKC.String(strings.TrimSpace(raw))
+	canonical = FoldUsername(display)
+
+	if strings.Contains(canonical, "@") {
+		return "", "", ErrUsernameEmail
+	}
+	n := utf8.RuneCountInString(canonical)
+	if n < UsernameMinLen || n > UsernameMaxLen {
+		return "", "", ErrUsernameLength
+	}
+
+	var script *unicode.RangeTable
+	for i, r := range canonical {
+		switch {
+		case unicode.IsLetter(r):
+			rs := scriptOf(r)
+			if rs == nil {
+				return "", "", ErrUsernameChars
+			}
+			if script == nil {
+				script = rs
+			} else if rs != script {
+				return "", "", ErrUsernameMixed
+			}
+		case unicode.IsDigit(r):
+		case (r == '.' || r == '_' || r == '-') && i > 0:
+		default:
+			return "", "", ErrUsernameChars
+		}
+	}
+	if strings.HasSuffix(canonical, ".") {
+		return "", "", ErrUsernameChars
+	}
+	// "john.example.com" is not an email but would read like a domain and
+	// invites confusion in emails we send; treat it the same way.
+	if strings.Count(canonical, ".") >= 2 {
+		return "", "", ErrUsernameEmail
+	}
+
+	sk := reservedSkeleton(canonical)
+	if reservedSkeletons[sk] || strings.HasPrefix(sk, reservedSkeleton("deleted-user")) {
+		return "", "", ErrUsernameReserved
+	}
+	return display, canonical, nil
+}
+
+func scriptOf(r rune) *unicode.RangeTable {
+	for _, t := range []*unicode.RangeTable{
+		unicode.Latin, unicode.Cyrillic, unicode.Greek, unicode.Hebrew,
+		unicode.Arabic, unicode.Han, unicode.Hiragana, unicode.Katakana,
+	} {
+		if unicode.Is(t, r) {
+			return t
+		}
+	}
+	return nil
+}
+
+// CanonicalEmail parses a bare RFC 5322 addr-spec and returns the address to
+// store and send to (domain converted to its IDNA ASCII form) and the
+// canonical form used for uniqueness and lookups (additionally lowercased).
+//
+// Display names ("Name <a@b>"), quoted local parts and non-ASCII local parts
+// are rejected: they are legal but we cannot deliver to them reliably and they
+// make look-alike addresses easy.
+func CanonicalEmail(raw string) (addr, canonical string, err error) {
+	raw = strings.TrimSpace(raw)
+	if raw == "" || len(raw) > EmailMaxLen*4 || strings.ContainsAny(raw, "<>\"") {
+		return "", "", ErrEmailInvalid
+	}
+	parsed, err := mail.ParseAddress(raw)
+	if err != nil || parsed.Name != "" {
+		return "", "", ErrEmailInvalid
+	}
+
+	at := strings.LastIndexByte(parsed.Address, '@')
+	if at <= 0 {
+		return "", "", ErrEmailInvalid
+	}
+	local, domain := parsed.Address[:at], parsed.Address[at+1:]
+
+	if len(local) > emailLocalMax || strings.HasPrefix(local, ".") ||
+		strings.HasSuffix(local, ".") || strings.Contains(local, "..") {
+		return "", "", ErrEmailInvalid
+	}
+	for i := 0; i < len(local); i++ {
+		if local[i] >= utf8.RuneSelf || local[i] <= ' ' {
+			return "", "", ErrEmailInvalid
+		}
+	}
+
+	ascii, err := idna.Lookup.ToASCII(norm.NFKC.String(domain))
+	if err != nil || !strings.Contains(ascii, ".") || len(ascii) > 253 {
+		return "", "", ErrEmailInvalid
+	}
+	ascii = strings.ToLower(strings.TrimSuffix(ascii, "."))
+	labels := strings.Split(ascii, ".")
+	if tld := labels[len(labels)-1]; len(tld) < 2 || strings.Trim(tld, "0123456789") == "" {
+		return "", "", ErrEmailInvalid
+	}
+
+	addr = local + "@" + ascii
+	if len(addr) > EmailMaxLen {
+		return "", "", ErrEmailInvalid
+	}
+	return addr, strings.ToLower(local) + "@" + ascii, nil
+}
+
+// LoginLookup decides how a sign-in identifier is matched. Usernames can
+// never contain '@', so anything with one is an email and everything else is
+// a username; the two namespaces cannot overlap.
+// Returns the users column to match and the canonical key (also used as the
+// login_attempts.username value so lockout counts are case-insensitive).
+// ok is false when the identifier cannot belong to any account.
+func LoginLookup(id string) (column, key string, ok bool) {
+	if strings.Contains(id, "@") {
+		_, canon, err := CanonicalEmail(id)
+		if err != nil {
+			return "email_canonical", strings.ToLower(strings.TrimSpace(id)), false
+		}
+		return "email_canonical", canon, true
+	}
+	return "username_canonical", FoldUsername(id), true
+}
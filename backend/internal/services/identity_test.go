@@ -0,0 +1,136 @@
+package services
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestCanonicalUsername(t *testing.T) {
+	for _, tc := range []struct {
+		raw, display, canonical string
+		err                     error
+	}{
+		{raw: "  Alice ", display: "Alice", canonical: "alice"},
+		{raw: "j.doe-2", display: "j.doe-2", canonical: "j.doe-2"},
+		{raw: "Ｂｏｂ", display: "Bob", canonical: "bob"}, // fullwidth, NFKC
+		{raw: "STRAẞE", display: "STRAẞE", canonical: "strasse"},
+		{raw: "Дмитрий", display: "Дмитрий", canonical: "дмитрий"},
+		{raw: "ab", err: ErrUsernameLength},
+		{raw: "a234567890123456789012345678901234", err: ErrUsernameLength},
+		{raw: ".alice", err: ErrUsernameChars},
+		{raw: "alice.", err: ErrUsernameChars},
+		{raw: "al ice", err: ErrUsernameChars},
+		{raw: "al\u200bice", err: ErrUsernameChars}, // zero-width space
+		{raw: "alice@example.com", err: ErrUsernameEmail},
+		{raw: "alice@", err: ErrUsernameEmail},
+		{raw: "alice.example.com", err: ErrUsernameEmail},
+		// mixed scripts
+		{raw: "pаypal", err: ErrUsernameMixed}, // Cyrillic а
+		{raw: "αlice", err: ErrUsernameMixed},  // Greek α
+		{raw: "иvan", err: ErrUsernameMixed},
+		// reserved, also through homoglyphs and look-alike sequences
+		{raw: "admin", err: ErrUsernameReserved},
+		{raw: "Admin", err: ErrUsernameReserved},
+		{raw: "adm1n", err: ErrUsernameReserved},
+		{raw: "adm-in", err: ErrUsernameReserved},
+		{raw: "rnoderator", err: ErrUsernameReserved},
+		{raw: "supp0rt", err: ErrUsernameReserved},
+		{raw: "deleted-user-42", err: ErrUsernameReserved},
+		{raw: "ѕуѕтем", err: ErrUsernameReserved}, // all Cyrillic "system"
+	} {
+		display, canonical, err := CanonicalUsername(tc.raw)
+		if !errors.Is(err, tc.err) {
+			t.Errorf("%q: err = %v, want %v", tc.raw, err, tc.err)
+			continue
+		}
+		if display != tc.display || canonical != tc.canonical {
+			t.Errorf("%q: (%q, %q), want (%q, %q)", tc.raw, display, canonical, tc.display, tc.canonical)
+		}
+	}
+}
+
+func TestUsernameSkeleton(t *testing.T) {
+	collide := [][2]string{
+		{"alice", "аlice"},  // Cyrillic а
+		{"alice", "ALICE"},  // case
+		{"jdoe", "j.doe"},   // separators
+		{"jdoe", "j_d-o-e"}, // separators
+		{"rene", "René"},    // diacritics
+		{"oscar", "0scar"},  // digit
+		{"paul", "pαul"},    // Greek α
+		{"hello", "һеllо"},  // Cyrillic һ, е, о
+	}
+	for _, p := range collide {
+		if a, b := UsernameSkeleton(p[0]), UsernameSkeleton(p[1]); a != b {
+			t.Errorf("%q -> %q and %q -> %q should collide", p[0], a, p[1], b)
+		}
+	}
+	// Folds only meant for reserved names must not merge real users
+	distinct := [][2]string{
+		{"clara", "dara"},
+		{"kim", "klm"},
+		{"bill", "biii"},
+		{"modem", "rnodem"},
+	}
+	for _, p := range distinct {
+		if UsernameSkeleton(p[0]) == UsernameSkeleton(p[1]) {
+			t.Errorf("%q and %q should not collide", p[0], p[1])
+		}
+	}
+}
+
+func TestCanonicalEmail(t *testing.T) {
+	for _, tc := range []struct {
+		raw, addr, canonical string
+		ok                   bool
+	}{
+		{raw: " Alice@Example.COM ", addr: "Alice@example.com", canonical: "alice@example.com", ok: true},
+		// dots and plus tags are part of the mailbox: never stripped
+		{raw: "a.l.i.c.e@example.com", addr: "a.l.i.c.e@example.com", canonical: "a.l.i.c.e@example.com", ok: true},
+		{raw: "alice+crm@example.com", addr: "alice+crm@example.com", canonical: "alice+crm@example.com", ok: true},
+		{raw: "bob@Bücher.example", addr: "bob@xn--bcher-kva.example", canonical: "bob@xn--bcher-kva.example", ok: true},
+		{raw: "Alice <alice@example.com>"},
+		{raw: `"alice"@example.com`},
+		{raw: "ålice@example.com"},
+		{raw: ".alice@example.com"},
+		{raw: "alice.@example.com"},
+		{raw: "al..ice@example.com"},
+		{raw: "bob@example.com."},
+		{raw: "alice@localhost"},
+		{raw: "alice@example.123"},
+		{raw: "alice"},
+		{raw: ""},
+	} {
+		addr, canonical, err := CanonicalEmail(tc.raw)
+		if !tc.ok {
+			if !errors.Is(err, ErrEmailInvalid) {
+				t.Errorf("%q: err = %v, want ErrEmailInvalid", tc.raw, err)
+			}
+			continue
+		}
+		if err != nil || addr != tc.addr || canonical != tc.canonical {
+			t.Errorf("%q: (%q, %q, %v), want (%q, %q)", tc.raw, addr, canonical, err, tc.addr, tc.canonical)
+		}
+	}
+}
+
+func TestLoginLookup(t *testing.T) {
+	for _, tc := range []struct {
+		id, column, key string
+		ok              bool
+	}{
+		{"Alice", "username_canonical", "alice", true},
+		{" ALICE ", "username_canonical", "alice", true},
+		{"j.doe", "username_canonical", "j.doe", true},
+		{"Alice@Example.com", "email_canonical", "alice@example.com", true},
+		{"alice+crm@example.com", "email_canonical", "alice+crm@example.com", true},
+		// an '@' always means the email column, even when invalid
+		{"Not An@Email", "email_canonical", "not an@email", false},
+		{"@", "email_canonical", "@", false},
+	} {
+		column, key, ok := LoginLookup(tc.id)
+		if column != tc.column || key != tc.key || ok != tc.ok {
+			t.Errorf("%q: (%s, %q, %v), want (%s, %q, %v)", tc.id, column, key, ok, tc.column, tc.key, tc.ok)
+		}
+	}
+}
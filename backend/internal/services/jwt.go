@@ -5,31 +5,91 @@ import (
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
+	"github.com/jmoiron/sqlx"
 )
 
-const CookieName = "auth_token"
+// SessionCookie is the logical name of the session cookie (see SessionCookieName).
+const SessionCookie = "auth_token"
 
 type Claims struct {
-	UserID   int64  `json:"uid"`
-	Username string `json:"uname"`
+	UserID    int64  `json:"uid"`
+	Username  string `json:"uname"`
+	OrgID     int64  `json:"org,omitempty"` // active organization; 0 = none
+	SessionID string `json:"sid,omitempty"` // user_sessions row; browser sessions only
+
+	// Impersonation sessions: the admin actually at the keyboard
+	ImpersonatorID   int64  `json:"imp,omitempty"`
+	ImpersonatorName string `json:"imp_name,omitempty"`
+
+	// Set per request by the auth middleware, never serialized into a token,
+	// so role changes apply to live sessions at once
+	Roles         []string `json:"-"`
+	Permissions   []string `json:"-"`
+	AuthMethod    string   `json:"-"` // AuthSession, AuthAPIKey or AuthOAuth
+	APIKeyID      int64    `json:"-"`
+	OAuthClientID string   `json:"-"` // client the access token was issued to
+
 	jwt.RegisteredClaims
 }
 
-func CreateJWT(userID int64, username string, ttl time.Duration) (string, error) {
+// HasPermission reports whether the session carries perm.
+func (c *Claims) HasPermission(perm string) bool {
+	return HasPermission(c.Permissions, perm)
+}
+
+// Impersonating reports whether an admin is using the session on the user's behalf.
+func (c *Claims) Impersonating() bool {
+	return c.ImpersonatorID != 0
+}
+
+// NewSessionClaims loads everything a session token carries for userID, plus
+// the current roles and permissions for the sign-in response.
+func NewSessionClaims(db *sqlx.DB, userID int64) (*Claims, error) {
+	var u struct {
+		Username string `db:"username"`
+		IsActive bool   `db:"is_active"`
+		IsLocked bool   `db:"is_locked"`
+	}
+	if err := db.Get(&u, `
+		SELECT username, is_active, locked_at IS NOT NULL AS is_locked FROM users WHERE id = ?
+	`, userID); err != nil {
+		return nil, err
+	}
+	if !u.IsActive {
+		return nil, ErrAccountInactive
+	}
+	if u.IsLocked {
+		return nil, ErrAccountLocked
+	}
+	roles, perms, err := LoadUserAccess(db, userID)
+	if err != nil {
+		return nil, err
+	}
+	orgID, err := ActiveOrgForUser(db, userID)
+	if err != nil {
+		return nil, err
+	}
+	return &Claims{
+		UserID:      userID,
+		Username:    u.Username,
+		Roles:       roles,
+		Permissions: perms,
+		OrgID:       orgID,
+	}, nil
+}
+
+// CreateJWT signs claims, filling in the registered claims (issuer, times, subject).
+func CreateJWT(claims *Claims, ttl time.Duration) (string, error) {
 	secret := os.Getenv("JWT_SECRET")
 	if secret == "" {
 		secret = "change_me_jwt"
 	}
 	now := time.Now()
-	claims := &Claims{
-		UserID:   userID,
-		Username: username,
-		RegisteredClaims: jwt.RegisteredClaims{
-			Issuer:    "communication_ltd",
-			IssuedAt:  jwt.NewNumericDate(now),
-			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
-			Subject:   username,
-		},
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		Issuer:    "communication_ltd",
+		IssuedAt:  jwt.NewNumericDate(now),
+		ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		Subject:   claims.Username,
 	}
 	tok := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
 	return tok.SignedString([]byte(secret))
@@ -42,7 +102,7 @@ func ParseJWT(tokenStr string) (*Claims, error) {
 	}
 	t, err := jwt.ParseWithClaims(tokenStr, &Claims{}, func(token *jwt.Token) (interface{}, error) {
 		return []byte(secret), nil
-	})
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,37 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+)
+
+func newMockDB(t *testing.T) (*sqlx.DB, sqlmock.Sqlmock) {
+	t.Helper()
+	raw, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		raw.Close()
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+	return sqlx.NewDb(raw, "mysql"), mock
+}
+
+// expectUserAccess expects LoadUserAccess for userID.
+func expectUserAccess(mock sqlmock.Sqlmock, userID int64, roles, perms []string) {
+	r := sqlmock.NewRows([]string{"name"})
+	for _, v := range roles {
+		r.AddRow(v)
+	}
+	p := sqlmock.NewRows([]string{"name"})
+	for _, v := range perms {
+		p.AddRow(v)
+	}
+	mock.ExpectQuery(`SELECT r\.name`).WithArgs(userID).WillReturnRows(r)
+	mock.ExpectQuery(`SELECT DISTINCT p\.name`).WithArgs(userID).WillReturnRows(p)
+}
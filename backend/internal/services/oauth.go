@@ -0,0 +1,504 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// OAuth 2.0 authorization server (RFC 6749 with PKCE, RFC 7662 introspection,
+// RFC 7009 revocation). Scopes are permission names; an access token's
+// effective permissions are its scopes intersected with the user's current
+// permissions, exactly like API keys. Access tokens are opaque ("sclo_...")
+// and stored as SHA-256, like every other secret we hand out.
+
+const (
+	OAuthAccessTokenPrefix = "sclo_"
+	AuthOAuth              = "oauth"
+
+	OAuthAccessTokenTTL = time.Hour
+	oauthCodeTTL        = 2 * time.Minute
+	oauthConsentTTL     = 10 * time.Minute
+
+	GrantAuthorizationCode = "authorization_code"
+	GrantClientCredentials = "client_credentials"
+)
+
+var (
+	ErrInvalidOAuthToken   = errors.New("invalid access token")
+	ErrOAuthClientNotFound = errors.New("unknown oauth client")
+	ErrOAuthConsent        = errors.New("invalid or expired consent request")
+)
+
+// OAuthError is a protocol error returned to clients as {"error": Code, "error_description": Description}.
+type OAuthError struct {
+	Code        string
+	Description string
+}
+
+func (e *OAuthError) Error() string { return e.Code + ": " + e.Description }
+
+func oauthErr(code, desc string) *OAuthError { return &OAuthError{Code: code, Description: desc} }
+
+type OAuthClient struct {
+	ID            int64      `db:"id" json:"id"`
+	ClientID      string     `db:"client_id" json:"client_id"`
+	Name          string     `db:"name" json:"name"`
+	SecretSHA256  *string    `db:"secret_sha256" json:"-"`
+	RedirectURIs  string     `db:"redirect_uris" json:"-"`
+	Scopes        string     `db:"scopes" json:"-"`
+	GrantTypes    string     `db:"grant_types" json:"-"`
+	ServiceUserID *int64     `db:"service_user_id" json:"service_user_id"`
+	CreatedAt     time.Time  `db:"created_at" json:"created_at"`
+	RevokedAt     *time.Time `db:"revoked_at" json:"revoked_at"`
+}
+
+const oauthClientColumns = `id, client_id, name, secret_sha256, redirect_uris, scopes, grant_types,
+	service_user_id, created_at, revoked_at`
+
+// Confidential clients authenticate with a secret; public clients rely on PKCE alone.
+func (c *OAuthClient) Confidential() bool { return c.SecretSHA256 != nil }
+
+func (c *OAuthClient) RedirectURIList() []string { return splitList(c.RedirectURIs, "\n") }
+func (c *OAuthClient) ScopeList() []string       { return splitList(c.Scopes, ",") }
+func (c *OAuthClient) GrantTypeList() []string   { return splitList(c.GrantTypes, ",") }
+
+func (c *OAuthClient) AllowsGrant(g string) bool {
+	for _, x := range c.GrantTypeList() {
+		if x == g {
+			return true
+		}
+	}
+	return false
+}
+
+// HasRedirectURI requires an exact match with a registered URI.
+func (c *OAuthClient) HasRedirectURI(u string) bool {
+	for _, x := range c.RedirectURIList() {
+		if x == u {
+			return true
+		}
+	}
+	return false
+}
+
+func splitList(s, sep string) []string {
+	out := []string{}
+	for _, x := range strings.Split(s, sep) {
+		if x = strings.TrimSpace(x); x != "" {
+			out = append(out, x)
+		}
+	}
+	return out
+}
+
+// ParseScope splits a space-separated scope parameter, dropping duplicates.
+func ParseScope(s string) []string {
+	return uniqueStrings(strings.Fields(s))
+}
+
+// ValidRedirectURI accepts absolute https URIs, or http on loopback hosts
+// (native apps, development). Fragments are not allowed (RFC 6749 3.1.2).
+func ValidRedirectURI(raw string) bool {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" || u.Fragment != "" || u.User != nil {
+		return false
+	}
+	switch u.Scheme {
+	case "https":
+		return true
+	case "http":
+		h := u.Hostname()
+		return h == "localhost" || h == "127.0.0.1" || h == "::1"
+	}
+	return false
+}
+
+// NewOAuthClientCredentials generates a client_id and, for confidential clients, a secret.
+func NewOAuthClientCredentials(confidential bool) (clientID, secret string, secretSHA256 *string, err error) {
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", nil, err
+	}
+	clientID = "sclc_" + hex.EncodeToString(b)
+	if !confidential {
+		return clientID, "", nil, nil
+	}
+	if secret, err = NewRandomBase64URL(32); err != nil {
+		return "", "", nil, err
+	}
+	h := HashSHA256Hex(secret)
+	return clientID, secret, &h, nil
+}
+
+// LoadOAuthClient returns an active (not revoked) client.
+func LoadOAuthClient(db sqlx.Queryer, clientID string) (*OAuthClient, error) {
+	var c OAuthClient
+	err := sqlx.Get(db, &c, `SELECT `+oauthClientColumns+` FROM oauth_clients
+		WHERE client_id = ? AND revoked_at IS NULL`, clientID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrOAuthClientNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// AuthenticateOAuthClient checks client credentials at the token, introspection
+// and revocation endpoints. Public clients must not send a secret.
+func AuthenticateOAuthClient(db *sqlx.DB, clientID, secret string) (*OAuthClient, error) {
+	if clientID == "" {
+		return nil, oauthErr("invalid_client", "client authentication failed")
+	}
+	c, err := LoadOAuthClient(db, clientID)
+	if errors.Is(err, ErrOAuthClientNotFound) {
+		return nil, oauthErr("invalid_client", "client authentication failed")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if c.Confidential() {
+		if secret == "" || subtle.ConstantTimeCompare([]byte(HashSHA256Hex(secret)), []byte(*c.SecretSHA256)) != 1 {
+			return nil, oauthErr("invalid_client", "client authentication failed")
+		}
+	} else if secret != "" {
+		return nil, oauthErr("invalid_client", "client authentication failed")
+	}
+	return c, nil
+}
+
+// OAuthConsentRequest is a validated authorization request waiting for the
+// user's decision on the consent screen.
+type OAuthConsentRequest struct {
+	ClientID      int64  `db:"client_id"` // oauth_clients.id
+	UserID        int64  `db:"user_id"`
+	OrgID         *int64 `db:"org_id"`
+	RedirectURI   string `db:"redirect_uri"`
+	Scopes        string `db:"scopes"`
+	State         string `db:"state"`
+	CodeChallenge string `db:"code_challenge"`
+}
+
+// CreateOAuthConsentRequest stores the request and returns the handle embedded in the consent form.
+func CreateOAuthConsentRequest(db sqlx.Execer, r *OAuthConsentRequest) (string, error) {
+	handle, err := NewRandomBase64URL(32)
+	if err != nil {
+		return "", err
+	}
+	_, _ = db.Exec(`DELETE FROM oauth_consent_requests WHERE expires_at < NOW() - INTERVAL 1 DAY`)
+	_, err = db.Exec(`
+		INSERT INTO oauth_consent_requests
+		  (handle_sha256, client_id, user_id, org_id, redirect_uri, scopes, state, code_challenge, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, HashSHA256Hex(handle), r.ClientID, r.UserID, r.OrgID, r.RedirectURI, r.Scopes, r.State,
+		r.CodeChallenge, time.Now().Add(oauthConsentTTL))
+	return handle, err
+}
+
+// ConsumeOAuthConsentRequest returns the pending request (single use). It must
+// belong to userID: a consent form cannot be submitted from another session.
+func ConsumeOAuthConsentRequest(db *sqlx.DB, handle string, userID int64) (*OAuthConsentRequest, error) {
+	hash := HashSHA256Hex(handle)
+	res, err := db.Exec(`
+		UPDATE oauth_consent_requests SET consumed_at = NOW()
+		WHERE handle_sha256 = ? AND user_id = ? AND consumed_at IS NULL AND expires_at > NOW()
+	`, hash, userID)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n != 1 {
+		return nil, ErrOAuthConsent
+	}
+	var r OAuthConsentRequest
+	if err := db.Get(&r, `
+		SELECT client_id, user_id, org_id, redirect_uri, scopes, state, code_challenge
+		FROM oauth_consent_requests WHERE handle_sha256 = ?
+	`, hash); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+// IssueOAuthCode creates a short-lived, single-use authorization code for an approved request.
+func IssueOAuthCode(db sqlx.Execer, r *OAuthConsentRequest) (string, error) {
+	code, err := NewRandomBase64URL(32)
+	if err != nil {
+		return "", err
+	}
+	_, err = db.Exec(`
+		INSERT INTO oauth_auth_codes
+		  (code_sha256, client_id, user_id, org_id, redirect_uri, scopes, code_challenge, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, HashSHA256Hex(code), r.ClientID, r.UserID, r.OrgID, r.RedirectURI, r.Scopes, r.CodeChallenge,
+		time.Now().Add(oauthCodeTTL))
+	return code, err
+}
+
+// OAuthTokenResponse is the token endpoint's success body.
+type OAuthTokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+	Scope       string `json:"scope"`
+}
+
+func issueOAuthAccessToken(db sqlx.Execer, clientID, userID int64, orgID *int64, scopes []string, codeID *int64) (*OAuthTokenResponse, error) {
+	secret, err := NewRandomBase64URL(32)
+	if err != nil {
+		return nil, err
+	}
+	token := OAuthAccessTokenPrefix + secret
+	if _, err := db.Exec(`
+		INSERT INTO oauth_access_tokens (token_sha256, client_id, user_id, org_id, scopes, auth_code_id, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, HashSHA256Hex(token), clientID, userID, orgID, strings.Join(scopes, ","), codeID,
+		time.Now().Add(OAuthAccessTokenTTL)); err != nil {
+		return nil, err
+	}
+	return &OAuthTokenResponse{
+		AccessToken: token,
+		TokenType:   "Bearer",
+		ExpiresIn:   int(OAuthAccessTokenTTL.Seconds()),
+		Scope:       strings.Join(scopes, " "),
+	}, nil
+}
+
+// RedeemOAuthCode exchanges an authorization code (grant_type=authorization_code).
+// A code presented twice is treated as stolen: tokens issued from it are revoked.
+func RedeemOAuthCode(db *sqlx.DB, client *OAuthClient, code, redirectURI, verifier string) (*OAuthTokenResponse, error) {
+	if !client.AllowsGrant(GrantAuthorizationCode) {
+		return nil, oauthErr("unauthorized_client", "grant type not allowed for this client")
+	}
+	if code == "" || verifier == "" {
+		return nil, oauthErr("invalid_request", "code and code_verifier are required")
+	}
+
+	tx, err := db.Beginx()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var row struct {
+		ID            int64      `db:"id"`
+		ClientID      int64      `db:"client_id"`
+		UserID        int64      `db:"user_id"`
+		OrgID         *int64     `db:"org_id"`
+		RedirectURI   string     `db:"redirect_uri"`
+		Scopes        string     `db:"scopes"`
+		CodeChallenge string     `db:"code_challenge"`
+		ExpiresAt     time.Time  `db:"expires_at"`
+		ConsumedAt    *time.Time `db:"consumed_at"`
+	}
+	err = tx.Get(&row, `
+		SELECT id, client_id, user_id, org_id, redirect_uri, scopes, code_challenge, expires_at, consumed_at
+		FROM oauth_auth_codes WHERE code_sha256 = ? FOR UPDATE
+	`, HashSHA256Hex(code))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, oauthErr("invalid_grant", "invalid authorization code")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if row.ClientID != client.ID {
+		return nil, oauthErr("invalid_grant", "invalid authorization code")
+	}
+	if row.ConsumedAt != nil {
+		if _, err := tx.Exec(`
+			UPDATE oauth_access_tokens SET revoked_at = NOW() WHERE auth_code_id = ? AND revoked_at IS NULL
+		`, row.ID); err != nil {
+			return nil, err
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, err
+		}
+		return nil, oauthErr("invalid_grant", "authorization code already used")
+	}
+	if time.Now().After(row.ExpiresAt) {
+		return nil, oauthErr("invalid_grant", "authorization code expired")
+	}
+	if redirectURI != row.RedirectURI {
+		return nil, oauthErr("invalid_grant", "redirect_uri mismatch")
+	}
+	if subtle.ConstantTimeCompare([]byte(PKCEChallenge(verifier)), []byte(row.CodeChallenge)) != 1 {
+		return nil, oauthErr("invalid_grant", "code_verifier mismatch")
+	}
+
+	if _, err := tx.Exec(`UPDATE oauth_auth_codes SET consumed_at = NOW() WHERE id = ?`, row.ID); err != nil {
+		return nil, err
+	}
+	resp, err := issueOAuthAccessToken(tx, client.ID, row.UserID, row.OrgID, splitList(row.Scopes, ","), &row.ID)
+	if err != nil {
+		return nil, err
+	}
+	return resp, tx.Commit()
+}
+
+// IssueClientCredentialsToken handles grant_type=client_credentials. The
+// client acts as its service account, never as a person.
+func IssueClientCredentialsToken(db *sqlx.DB, client *OAuthClient, scope string) (*OAuthTokenResponse, error) {
+	if !client.Confidential() || !client.AllowsGrant(GrantClientCredentials) || client.ServiceUserID == nil {
+		return nil, oauthErr("unauthorized_client", "grant type not allowed for this client")
+	}
+	scopes := ParseScope(scope)
+	if len(scopes) == 0 {
+		scopes = client.ScopeList()
+	}
+	for _, s := range scopes {
+		if !HasPermission(client.ScopeList(), s) {
+			return nil, oauthErr("invalid_scope", "scope not allowed for this client: "+s)
+		}
+	}
+
+	uid := *client.ServiceUserID
+	var active bool
+	if err := db.Get(&active, `SELECT is_active FROM users WHERE id = ?`, uid); err != nil {
+		return nil, err
+	}
+	if !active {
+		return nil, oauthErr("unauthorized_client", "service account is disabled")
+	}
+	var orgID *int64
+	if id, err := ActiveOrgForUser(db, uid); err != nil {
+		return nil, err
+	} else if id != 0 {
+		orgID = &id
+	}
+	return issueOAuthAccessToken(db, client.ID, uid, orgID, scopes, nil)
+}
+
+type oauthTokenRow struct {
+	ID         int64      `db:"id"`
+	ClientDBID int64      `db:"client_db_id"`
+	ClientID   string     `db:"client_id"`
+	UserID     int64      `db:"user_id"`
+	OrgID      *int64     `db:"org_id"`
+	Scopes     string     `db:"scopes"`
+	ExpiresAt  time.Time  `db:"expires_at"`
+	CreatedAt  time.Time  `db:"created_at"`
+	RevokedAt  *time.Time `db:"revoked_at"`
+	Username   string     `db:"username"`
+	IsActive   bool       `db:"is_active"`
+	IsVerified bool       `db:"is_verified"`
+	IsLocked   bool       `db:"is_locked"`
+	ClientGone bool       `db:"client_revoked"`
+}
+
+func loadOAuthToken(db sqlx.Queryer, token string) (*oauthTokenRow, error) {
+	var row oauthTokenRow
+	err := sqlx.Get(db, &row, `
+		SELECT t.id, t.client_id AS client_db_id, c.client_id, t.user_id, t.org_id, t.scopes,
+		       t.expires_at, t.created_at, t.revoked_at, u.username, u.is_active, u.is_verified,
+		       u.locked_at IS NOT NULL AS is_locked, (c.revoked_at IS NOT NULL) AS client_revoked
+		FROM oauth_access_tokens t
+		JOIN oauth_clients c ON c.id = t.client_id
+		JOIN users u ON u.id = t.user_id
+		WHERE t.token_sha256 = ?
+	`, HashSHA256Hex(token))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInvalidOAuthToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &row, nil
+}
+
+func (t *oauthTokenRow) usable() bool {
+	return t.RevokedAt == nil && !t.ClientGone && time.Now().Before(t.ExpiresAt) &&
+		t.IsActive && t.IsVerified && !t.IsLocked
+}
+
+// IsOAuthAccessToken reports whether a bearer token has the access token shape.
+func IsOAuthAccessToken(token string) bool {
+	return strings.HasPrefix(token, OAuthAccessTokenPrefix)
+}
+
+// AuthenticateOAuthToken validates a bearer access token and returns claims for the request.
+func AuthenticateOAuthToken(db *sqlx.DB, token string) (*Claims, error) {
+	t, err := loadOAuthToken(db, token)
+	if err != nil {
+		return nil, err
+	}
+	if !t.usable() {
+		return nil, ErrInvalidOAuthToken
+	}
+	_, userPerms, err := LoadUserAccess(db, t.UserID)
+	if err != nil {
+		return nil, err
+	}
+	perms := []string{}
+	for _, s := range splitList(t.Scopes, ",") {
+		if HasPermission(userPerms, s) {
+			perms = append(perms, s)
+		}
+	}
+
+	if _, err := db.Exec(`
+		UPDATE oauth_access_tokens SET last_used_at = NOW()
+		WHERE id = ? AND (last_used_at IS NULL OR last_used_at < NOW() - INTERVAL 1 MINUTE)
+	`, t.ID); err != nil {
+		log.Printf("[oauth] last-used update: %v", err)
+	}
+
+	var orgID int64
+	if t.OrgID != nil {
+		orgID = *t.OrgID
+	}
+	return &Claims{
+		UserID:        t.UserID,
+		Username:      t.Username,
+		Permissions:   perms,
+		OrgID:         orgID,
+		AuthMethod:    AuthOAuth,
+		OAuthClientID: t.ClientID,
+	}, nil
+}
+
+// IntrospectOAuthToken implements RFC 7662 for the calling client. Tokens
+// issued to other clients are reported as inactive.
+func IntrospectOAuthToken(db *sqlx.DB, client *OAuthClient, token string) (map[string]any, error) {
+	inactive := map[string]any{"active": false}
+	if !IsOAuthAccessToken(token) {
+		return inactive, nil
+	}
+	t, err := loadOAuthToken(db, token)
+	if errors.Is(err, ErrInvalidOAuthToken) {
+		return inactive, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if t.ClientDBID != client.ID || !t.usable() {
+		return inactive, nil
+	}
+	return map[string]any{
+		"active":     true,
+		"scope":      strings.Join(splitList(t.Scopes, ","), " "),
+		"client_id":  t.ClientID,
+		"username":   t.Username,
+		"sub":        t.UserID,
+		"token_type": "Bearer",
+		"exp":        t.ExpiresAt.Unix(),
+		"iat":        t.CreatedAt.Unix(),
+	}, nil
+}
+
+// RevokeOAuthToken implements RFC 7009: revoking an unknown token, or another
+// client's token, is not an error and changes nothing.
+func RevokeOAuthToken(db *sqlx.DB, client *OAuthClient, token string) error {
+	_, err := db.Exec(`
+		UPDATE oauth_access_tokens SET revoked_at = NOW()
+		WHERE token_sha256 = ? AND client_id = ? AND revoked_at IS NULL
+	`, HashSHA256Hex(token), client.ID)
+	return err
+}
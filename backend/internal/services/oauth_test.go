@@ -0,0 +1,193 @@
+package services
+
+import (
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+const (
+	testRedirectURI = "https://partner.example/cb"
+	testVerifier    = "verifier-verifier-verifier-verifier-verifier-1"
+)
+
+var authCodeColumns = []string{
+	"id", "client_id", "user_id", "org_id", "redirect_uri", "scopes", "code_challenge", "expires_at", "consumed_at",
+}
+
+func testOAuthClient() *OAuthClient {
+	return &OAuthClient{
+		ID: 4, ClientID: "sclc_partner", RedirectURIs: testRedirectURI,
+		Scopes: "customers:read,customers:write", GrantTypes: GrantAuthorizationCode,
+	}
+}
+
+// authCodeRow is the stored code 11 of client 4 for user 7, as issued by
+// IssueOAuthCode; edit may change it first.
+func authCodeRow(edit func(v []driver.Value)) *sqlmock.Rows {
+	v := []driver.Value{int64(11), int64(4), int64(7), int64(1), testRedirectURI, "customers:read,customers:write",
+		PKCEChallenge(testVerifier), time.Now().Add(time.Minute), nil}
+	if edit != nil {
+		edit(v)
+	}
+	return sqlmock.NewRows(authCodeColumns).AddRow(v...)
+}
+
+func expectAuthCode(mock sqlmock.Sqlmock, code string, rows *sqlmock.Rows) {
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM oauth_auth_codes WHERE code_sha256 = \? FOR UPDATE`).
+		WithArgs(HashSHA256Hex(code)).WillReturnRows(rows)
+}
+
+func wantOAuthError(t *testing.T, err error, code, desc string) {
+	t.Helper()
+	var oe *OAuthError
+	if !errors.As(err, &oe) || oe.Code != code || !strings.Contains(oe.Description, desc) {
+		t.Fatalf("err = %v, want %s (%s)", err, code, desc)
+	}
+}
+
+func TestRedeemOAuthCode(t *testing.T) {
+	db, mock := newMockDB(t)
+	expectAuthCode(mock, "code-1", authCodeRow(nil))
+	mock.ExpectExec(`UPDATE oauth_auth_codes SET consumed_at = NOW\(\) WHERE id = \?`).WithArgs(int64(11)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`INSERT INTO oauth_access_tokens`).
+		WithArgs(sqlmock.AnyArg(), int64(4), int64(7), sqlmock.AnyArg(), "customers:read,customers:write", int64(11), sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(21, 1))
+	mock.ExpectCommit()
+
+	resp, err := RedeemOAuthCode(db, testOAuthClient(), "code-1", testRedirectURI, testVerifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsOAuthAccessToken(resp.AccessToken) || resp.TokenType != "Bearer" || resp.Scope != "customers:read customers:write" {
+		t.Fatalf("response: %+v", resp)
+	}
+}
+
+func TestRedeemOAuthCodeRejects(t *testing.T) {
+	for _, tc := range []struct {
+		name, redirectURI, verifier string
+		edit                        func(v []driver.Value)
+		desc                        string
+	}{
+		{name: "verifier mismatch", redirectURI: testRedirectURI, verifier: testVerifier + "x", desc: "code_verifier mismatch"},
+		{name: "redirect_uri mismatch", redirectURI: testRedirectURI + "/other", verifier: testVerifier, desc: "redirect_uri mismatch"},
+		{name: "no redirect_uri", redirectURI: "", verifier: testVerifier, desc: "redirect_uri mismatch"},
+		{name: "other client", redirectURI: testRedirectURI, verifier: testVerifier,
+			edit: func(v []driver.Value) { v[1] = int64(5) }, desc: "invalid authorization code"},
+		{name: "expired", redirectURI: testRedirectURI, verifier: testVerifier,
+			edit: func(v []driver.Value) { v[7] = time.Now().Add(-time.Second) }, desc: "expired"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			expectAuthCode(mock, "code-1", authCodeRow(tc.edit))
+			mock.ExpectRollback() // nothing consumed, nothing issued
+
+			_, err := RedeemOAuthCode(db, testOAuthClient(), "code-1", tc.redirectURI, tc.verifier)
+			wantOAuthError(t, err, "invalid_grant", tc.desc)
+		})
+	}
+}
+
+// A code presented a second time is treated as stolen: every token issued
+// from it is revoked, and that revocation is committed.
+func TestRedeemOAuthCodeReuseRevokesTokens(t *testing.T) {
+	db, mock := newMockDB(t)
+	expectAuthCode(mock, "code-1", authCodeRow(func(v []driver.Value) { v[8] = time.Now().Add(-time.Second) }))
+	mock.ExpectExec(`UPDATE oauth_access_tokens SET revoked_at = NOW\(\) WHERE auth_code_id = \? AND revoked_at IS NULL`).
+		WithArgs(int64(11)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	_, err := RedeemOAuthCode(db, testOAuthClient(), "code-1", testRedirectURI, testVerifier)
+	wantOAuthError(t, err, "invalid_grant", "already used")
+}
+
+func TestRedeemOAuthCodeNeedsVerifier(t *testing.T) {
+	db, _ := newMockDB(t)
+	_, err := RedeemOAuthCode(db, testOAuthClient(), "code-1", testRedirectURI, "")
+	wantOAuthError(t, err, "invalid_request", "code_verifier")
+
+	cl := testOAuthClient()
+	cl.GrantTypes = GrantClientCredentials
+	_, err = RedeemOAuthCode(db, cl, "code-1", testRedirectURI, testVerifier)
+	wantOAuthError(t, err, "unauthorized_client", "grant type")
+}
+
+var oauthTokenColumns = []string{
+	"id", "client_db_id", "client_id", "user_id", "org_id", "scopes", "expires_at", "created_at", "revoked_at",
+	"username", "is_active", "is_verified", "is_locked", "client_revoked",
+}
+
+func oauthTokenRows(edit func(v []driver.Value)) *sqlmock.Rows {
+	v := []driver.Value{int64(21), int64(4), "sclc_partner", int64(7), int64(1), "customers:read,customers:write",
+		time.Now().Add(time.Hour), time.Now(), nil, "alice", true, true, false, false}
+	if edit != nil {
+		edit(v)
+	}
+	return sqlmock.NewRows(oauthTokenColumns).AddRow(v...)
+}
+
+// The token's scopes are narrowed to the owner's permissions at use time.
+func TestAuthenticateOAuthTokenNarrowsScopes(t *testing.T) {
+	const token = OAuthAccessTokenPrefix + "secret"
+	db, mock := newMockDB(t)
+	mock.ExpectQuery(`FROM oauth_access_tokens t`).WithArgs(HashSHA256Hex(token)).WillReturnRows(oauthTokenRows(nil))
+	expectUserAccess(mock, 7, []string{"viewer"}, []string{PermCustomersRead, PermUsersAdmin})
+	mock.ExpectExec(`UPDATE oauth_access_tokens SET last_used_at`).WithArgs(int64(21)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	cl, err := AuthenticateOAuthToken(db, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// customers:write was dropped with the role; users:admin was never granted to the token
+	if !reflect.DeepEqual(cl.Permissions, []string{PermCustomersRead}) ||
+		cl.AuthMethod != AuthOAuth || cl.OAuthClientID != "sclc_partner" || cl.OrgID != 1 {
+		t.Fatalf("claims: %+v", cl)
+	}
+}
+
+func TestAuthenticateOAuthTokenRejects(t *testing.T) {
+	const token = OAuthAccessTokenPrefix + "secret"
+	for name, edit := range map[string]func(v []driver.Value){
+		"revoked":        func(v []driver.Value) { v[8] = time.Now() },
+		"expired":        func(v []driver.Value) { v[6] = time.Now().Add(-time.Second) },
+		"inactive owner": func(v []driver.Value) { v[10] = false },
+		"locked owner":   func(v []driver.Value) { v[12] = true },
+		"client revoked": func(v []driver.Value) { v[13] = true },
+	} {
+		t.Run(name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			mock.ExpectQuery(`FROM oauth_access_tokens t`).WillReturnRows(oauthTokenRows(edit))
+			if _, err := AuthenticateOAuthToken(db, token); !errors.Is(err, ErrInvalidOAuthToken) {
+				t.Fatalf("err = %v, want ErrInvalidOAuthToken", err)
+			}
+		})
+	}
+}
+
+func TestValidRedirectURI(t *testing.T) {
+	for raw, want := range map[string]bool{
+		"https://partner.example/cb":       true,
+		"http://localhost:8123/cb":         true,
+		"http://127.0.0.1/cb":              true,
+		"http://[::1]:9000/cb":             true,
+		"http://partner.example/cb":        false,
+		"https://partner.example/cb#frag":  false,
+		"https://user@partner.example/cb":  false,
+		"/relative/cb":                     false,
+		"javascript:alert(1)":              false,
+		"custom-scheme://partner.example/": false,
+	} {
+		if got := ValidRedirectURI(raw); got != want {
+			t.Errorf("%q: %v, want %v", raw, got, want)
+		}
+	}
+}
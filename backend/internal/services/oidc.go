@@ -0,0 +1,431 @@
+package services
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// OIDC relying party (authorization code flow with PKCE). The provider is
+// described by its issuer URL; endpoints and signing keys come from discovery.
+// The HTTP client is injectable so the flow can run against an in-process
+// provider (httptest.Server) as well as a real IdP.
+
+var (
+	ErrOIDCDiscovery = errors.New("oidc discovery failed")
+	ErrOIDCExchange  = errors.New("oidc code exchange failed")
+	ErrOIDCIDToken   = errors.New("invalid id token")
+)
+
+const (
+	oidcJWKSMaxAge      = time.Hour
+	oidcJWKSMinInterval = time.Minute // on unknown kid, refetch at most this often
+	oidcClockSkew       = time.Minute
+)
+
+type OIDCConfig struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string // empty = public client (PKCE only)
+	RedirectURL  string
+	Scopes       []string
+	ProviderName string // shown on the sign-in button
+
+	// Account mapping rules (see ResolveOIDCUser)
+	JITProvisioning bool
+	LinkByEmail     bool
+	AllowedDomains  []string // empty = any domain
+}
+
+// OIDCConfigFromEnv reads OIDC_* variables. ok is false when OIDC_ISSUER is unset (SSO disabled).
+func OIDCConfigFromEnv() (cfg OIDCConfig, ok bool, err error) {
+	cfg.Issuer = strings.TrimSpace(os.Getenv("OIDC_ISSUER"))
+	if cfg.Issuer == "" {
+		return cfg, false, nil
+	}
+	cfg.ClientID = strings.TrimSpace(os.Getenv("OIDC_CLIENT_ID"))
+	if cfg.ClientID == "" {
+		return cfg, false, errors.New("OIDC_CLIENT_ID is required when OIDC_ISSUER is set")
+	}
+	cfg.ClientSecret = os.Getenv("OIDC_CLIENT_SECRET")
+
+	cfg.RedirectURL = strings.TrimSpace(os.Getenv("OIDC_REDIRECT_URL"))
+	if cfg.RedirectURL == "" {
+		base := os.Getenv("BACKEND_PUBLIC_URL")
+		if base == "" {
+			base = "http://localhost:8080"
+		}
+		cfg.RedirectURL = strings.TrimRight(base, "/") + "/api/auth/oidc/callback"
+	}
+
+	cfg.Scopes = strings.Fields(os.Getenv("OIDC_SCOPES"))
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"openid", "email", "profile"}
+	}
+	hasOpenID := false
+	for _, s := range cfg.Scopes {
+		hasOpenID = hasOpenID || s == "openid"
+	}
+	if !hasOpenID {
+		cfg.Scopes = append([]string{"openid"}, cfg.Scopes...)
+	}
+
+	cfg.ProviderName = strings.TrimSpace(os.Getenv("OIDC_PROVIDER_NAME"))
+	if cfg.ProviderName == "" {
+		cfg.ProviderName = "Single Sign-On"
+	}
+
+	cfg.JITProvisioning = envBool("OIDC_JIT_PROVISIONING", false)
+	cfg.LinkByEmail = envBool("OIDC_LINK_BY_EMAIL", false)
+	for _, d := range strings.Split(os.Getenv("OIDC_ALLOWED_DOMAINS"), ",") {
+		if d = strings.ToLower(strings.TrimSpace(d)); d != "" {
+			cfg.AllowedDomains = append(cfg.AllowedDomains, d)
+		}
+	}
+	// Linking skips the password and the email OTP: only for domains the
+	// company controls, never for any address an IdP will vouch for
+	if cfg.LinkByEmail && len(cfg.AllowedDomains) == 0 {
+		return cfg, false, errors.New("OIDC_LINK_BY_EMAIL requires OIDC_ALLOWED_DOMAINS")
+	}
+	return cfg, true, nil
+}
+
+// LocalLoginEnabled reports whether username/password sign-in (and
+// self-registration) is offered. Env LOCAL_LOGIN_ENABLED, default true.
+func LocalLoginEnabled() bool {
+	return envBool("LOCAL_LOGIN_ENABLED", true)
+}
+
+func envBool(name string, def bool) bool {
+	switch strings.ToLower(strings.TrimSpace(os.Getenv(name))) {
+	case "1", "true", "yes", "on":
+		return true
+	case "0", "false", "no", "off":
+		return false
+	}
+	return def
+}
+
+type oidcMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type OIDCProvider struct {
+	Config OIDCConfig
+	client *http.Client
+
+	mu          sync.Mutex
+	meta        *oidcMetadata
+	keys        map[string]any // kid -> *rsa.PublicKey | *ecdsa.PublicKey
+	keysFetched time.Time
+}
+
+// NewOIDCProvider creates a provider. A nil client uses a default with a timeout.
+func NewOIDCProvider(cfg OIDCConfig, client *http.Client) *OIDCProvider {
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	return &OIDCProvider{Config: cfg, client: client}
+}
+
+// NewOIDCProviderFromEnv returns nil (and no error) when SSO is not configured.
+func NewOIDCProviderFromEnv() (*OIDCProvider, error) {
+	cfg, ok, err := OIDCConfigFromEnv()
+	if err != nil || !ok {
+		return nil, err
+	}
+	return NewOIDCProvider(cfg, nil), nil
+}
+
+func (p *OIDCProvider) getJSON(ctx context.Context, u string, out any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: status %d", u, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(out)
+}
+
+// metadata fetches the discovery document once; failures are not cached.
+func (p *OIDCProvider) metadata(ctx context.Context) (*oidcMetadata, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.meta != nil {
+		return p.meta, nil
+	}
+	var m oidcMetadata
+	wellKnown := strings.TrimRight(p.Config.Issuer, "/") + "/.well-known/openid-configuration"
+	if err := p.getJSON(ctx, wellKnown, &m); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrOIDCDiscovery, err)
+	}
+	// The discovery document must describe the configured issuer exactly
+	if m.Issuer != p.Config.Issuer {
+		return nil, fmt.Errorf("%w: issuer mismatch %q", ErrOIDCDiscovery, m.Issuer)
+	}
+	if m.AuthorizationEndpoint == "" || m.TokenEndpoint == "" || m.JWKSURI == "" {
+		return nil, fmt.Errorf("%w: incomplete provider metadata", ErrOIDCDiscovery)
+	}
+	p.meta = &m
+	return p.meta, nil
+}
+
+// PKCEChallenge returns the S256 code challenge for a verifier.
+func PKCEChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL builds the authorization request the browser is redirected to.
+func (p *OIDCProvider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	m, err := p.metadata(ctx)
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(m.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrOIDCDiscovery, err)
+	}
+	q := u.Query()
+	q.Set("response_type", "code")
+	q.Set("client_id", p.Config.ClientID)
+	q.Set("redirect_uri", p.Config.RedirectURL)
+	q.Set("scope", strings.Join(p.Config.Scopes, " "))
+	q.Set("state", state)
+	q.Set("nonce", nonce)
+	q.Set("code_challenge", PKCEChallenge(verifier))
+	q.Set("code_challenge_method", "S256")
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// Exchange redeems an authorization code and returns the raw ID token.
+func (p *OIDCProvider) Exchange(ctx context.Context, code, verifier string) (string, error) {
+	m, err := p.metadata(ctx)
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.Config.RedirectURL},
+		"code_verifier": {verifier},
+	}
+	if p.Config.ClientSecret == "" {
+		form.Set("client_id", p.Config.ClientID)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if p.Config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(p.Config.ClientID), url.QueryEscape(p.Config.ClientSecret))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrOIDCExchange, err)
+	}
+	defer resp.Body.Close()
+	var body struct {
+		IDToken string `json:"id_token"`
+		Error   string `json:"error"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&body); err != nil {
+		return "", fmt.Errorf("%w: status %d", ErrOIDCExchange, resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusOK || body.IDToken == "" {
+		return "", fmt.Errorf("%w: status %d %s", ErrOIDCExchange, resp.StatusCode, body.Error)
+	}
+	return body.IDToken, nil
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func (k jwk) publicKey() (any, error) {
+	dec := base64.RawURLEncoding.DecodeString
+	switch k.Kty {
+	case "RSA":
+		n, err := dec(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := dec(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("bad rsa exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, errors.New("unsupported curve")
+		}
+		x, err := dec(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := dec(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return nil, errors.New("point not on curve")
+		}
+		return pub, nil
+	}
+	return nil, errors.New("unsupported key type")
+}
+
+// signingKey returns the provider key for kid, refreshing the JWKS when the
+// cache is stale or the kid is unknown (key rotation).
+func (p *OIDCProvider) signingKey(ctx context.Context, kid string) (any, error) {
+	m, err := p.metadata(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	lookup := func() any {
+		if kid == "" && len(p.keys) == 1 {
+			for _, k := range p.keys {
+				return k
+			}
+		}
+		return p.keys[kid]
+	}
+	fresh := time.Since(p.keysFetched) < oidcJWKSMaxAge
+	if k := lookup(); k != nil && fresh {
+		return k, nil
+	}
+	if fresh && time.Since(p.keysFetched) < oidcJWKSMinInterval {
+		return nil, errors.New("unknown signing key")
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := p.getJSON(ctx, m.JWKSURI, &set); err != nil {
+		return nil, fmt.Errorf("%w: jwks: %v", ErrOIDCDiscovery, err)
+	}
+	keys := map[string]any{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if pub, err := k.publicKey(); err == nil {
+			keys[k.Kid] = pub
+		}
+	}
+	p.keys, p.keysFetched = keys, time.Now()
+	if k := lookup(); k != nil {
+		return k, nil
+	}
+	return nil, errors.New("unknown signing key")
+}
+
+// OIDCIdentity is the verified subset of ID token claims we use.
+type OIDCIdentity struct {
+	Issuer            string
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	PreferredUsername string
+	Name              string
+}
+
+type idTokenClaims struct {
+	Nonce             string `json:"nonce"`
+	AuthorizedParty   string `json:"azp"`
+	Email             string `json:"email"`
+	EmailVerified     any    `json:"email_verified"` // bool, or "true" from some providers
+	PreferredUsername string `json:"preferred_username"`
+	Name              string `json:"name"`
+	jwt.RegisteredClaims
+}
+
+// VerifyIDToken checks signature, issuer, audience, expiry and nonce.
+func (p *OIDCProvider) VerifyIDToken(ctx context.Context, raw, nonce string) (*OIDCIdentity, error) {
+	var claims idTokenClaims
+	_, err := jwt.ParseWithClaims(raw, &claims, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		return p.signingKey(ctx, kid)
+	},
+		jwt.WithValidMethods([]string{"RS256", "ES256"}),
+		jwt.WithIssuer(p.Config.Issuer),
+		jwt.WithAudience(p.Config.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(oidcClockSkew),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrOIDCIDToken, err)
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: missing sub", ErrOIDCIDToken)
+	}
+	if nonce == "" || subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1 {
+		return nil, fmt.Errorf("%w: nonce mismatch", ErrOIDCIDToken)
+	}
+	// With several audiences the token must have been issued to us
+	if (len(claims.Audience) > 1 || claims.AuthorizedParty != "") && claims.AuthorizedParty != p.Config.ClientID {
+		return nil, fmt.Errorf("%w: azp mismatch", ErrOIDCIDToken)
+	}
+
+	verified := false
+	switch v := claims.EmailVerified.(type) {
+	case bool:
+		verified = v
+	case string:
+		verified = v == "true"
+	}
+	return &OIDCIdentity{
+		Issuer:            claims.Issuer,
+		Subject:           claims.Subject,
+		Email:             strings.TrimSpace(claims.Email),
+		EmailVerified:     verified,
+		PreferredUsername: strings.TrimSpace(claims.PreferredUsername),
+		Name:              strings.TrimSpace(claims.Name),
+	}, nil
+}
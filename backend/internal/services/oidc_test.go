@@ -0,0 +1,204 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	testClientID = "backend"
+	testKID      = "k1"
+)
+
+// mockOIDC is an in-process provider: discovery, JWKS and a token endpoint
+// that answers every code with idToken and records the form it was sent.
+type mockOIDC struct {
+	srv *httptest.Server
+	key *rsa.PrivateKey
+
+	mu      sync.Mutex
+	idToken string
+	form    url.Values
+}
+
+func newMockOIDC(t *testing.T) *mockOIDC {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := &mockOIDC{key: key}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 m.srv.URL,
+			"authorization_endpoint": m.srv.URL + "/authorize",
+			"token_endpoint":         m.srv.URL + "/token",
+			"jwks_uri":               m.srv.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		enc := base64.RawURLEncoding.EncodeToString
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA", "kid": testKID, "use": "sig",
+			"n": enc(key.N.Bytes()), "e": enc(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		m.mu.Lock()
+		m.form = r.PostForm
+		tok := m.idToken
+		m.mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]string{"id_token": tok, "token_type": "Bearer"})
+	})
+	m.srv = httptest.NewServer(mux)
+	t.Cleanup(m.srv.Close)
+	return m
+}
+
+func (m *mockOIDC) provider() *OIDCProvider {
+	return NewOIDCProvider(OIDCConfig{
+		Issuer:      m.srv.URL,
+		ClientID:    testClientID,
+		RedirectURL: "http://localhost:8080/api/auth/oidc/callback",
+		Scopes:      []string{"openid", "email"},
+	}, m.srv.Client())
+}
+
+// sign issues an ID token for nonce; edit may change the claims first.
+func (m *mockOIDC) sign(t *testing.T, key *rsa.PrivateKey, nonce string, edit func(jwt.MapClaims)) string {
+	t.Helper()
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss": m.srv.URL, "sub": "user-1", "aud": testClientID, "nonce": nonce,
+		"iat": now.Unix(), "exp": now.Add(5 * time.Minute).Unix(),
+		"email": "alice@example.com", "email_verified": true,
+	}
+	if edit != nil {
+		edit(claims)
+	}
+	tok := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	tok.Header["kid"] = testKID
+	s, err := tok.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestOIDCAuthCodeFlow(t *testing.T) {
+	m := newMockOIDC(t)
+	p := m.provider()
+	ctx := context.Background()
+	const state, nonce, verifier = "state-1", "nonce-1", "verifier-verifier-verifier-verifier-1234"
+
+	authURL, err := p.AuthCodeURL(ctx, state, nonce, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if u.Path != "/authorize" || q.Get("state") != state || q.Get("nonce") != nonce ||
+		q.Get("client_id") != testClientID || q.Get("response_type") != "code" {
+		t.Fatalf("authorization request: %s", authURL)
+	}
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") != PKCEChallenge(verifier) {
+		t.Fatalf("code challenge: %s", authURL)
+	}
+
+	m.idToken = m.sign(t, m.key, nonce, nil)
+	raw, err := p.Exchange(ctx, "code-1", verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The token endpoint must get the verifier behind the challenge
+	if m.form.Get("code_verifier") != verifier || m.form.Get("code") != "code-1" ||
+		m.form.Get("grant_type") != "authorization_code" || m.form.Get("client_id") != testClientID {
+		t.Fatalf("token request: %v", m.form)
+	}
+
+	id, err := p.VerifyIDToken(ctx, raw, nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id.Issuer != m.srv.URL || id.Subject != "user-1" || id.Email != "alice@example.com" || !id.EmailVerified {
+		t.Fatalf("identity: %+v", id)
+	}
+}
+
+func TestOIDCVerifyIDTokenRejects(t *testing.T) {
+	m := newMockOIDC(t)
+	p := m.provider()
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const nonce = "nonce-1"
+
+	for name, raw := range map[string]string{
+		"bad signature":  m.sign(t, other, nonce, nil),
+		"wrong issuer":   m.sign(t, m.key, nonce, func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" }),
+		"wrong audience": m.sign(t, m.key, nonce, func(c jwt.MapClaims) { c["aud"] = "someone-else" }),
+		"expired":        m.sign(t, m.key, nonce, func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }),
+		"other nonce":    m.sign(t, m.key, "nonce-2", nil),
+		"no nonce":       m.sign(t, m.key, "", nil),
+		"foreign azp": m.sign(t, m.key, nonce, func(c jwt.MapClaims) {
+			c["aud"] = []string{testClientID, "someone-else"}
+			c["azp"] = "someone-else"
+		}),
+	} {
+		t.Run(name, func(t *testing.T) {
+			if _, err := p.VerifyIDToken(context.Background(), raw, nonce); !errors.Is(err, ErrOIDCIDToken) {
+				t.Fatalf("err = %v, want ErrOIDCIDToken", err)
+			}
+		})
+	}
+}
+
+func TestOIDCDiscoveryIssuerMismatch(t *testing.T) {
+	m := newMockOIDC(t)
+	cfg := m.provider().Config
+	cfg.Issuer = m.srv.URL + "/" // must match the discovery document exactly
+	p := NewOIDCProvider(cfg, m.srv.Client())
+	if _, err := p.AuthCodeURL(context.Background(), "s", "n", "v"); !errors.Is(err, ErrOIDCDiscovery) {
+		t.Fatalf("err = %v, want ErrOIDCDiscovery", err)
+	}
+}
+
+func TestOIDCConfigLinkByEmailNeedsDomains(t *testing.T) {
+	t.Setenv("OIDC_ISSUER", "https://idp.example.com")
+	t.Setenv("OIDC_CLIENT_ID", testClientID)
+	t.Setenv("OIDC_ALLOWED_DOMAINS", "")
+	t.Setenv("OIDC_LINK_BY_EMAIL", "")
+	if cfg, ok, err := OIDCConfigFromEnv(); err != nil || !ok || cfg.LinkByEmail {
+		t.Fatalf("default: link=%v ok=%v err=%v, want linking off", cfg.LinkByEmail, ok, err)
+	}
+	t.Setenv("OIDC_LINK_BY_EMAIL", "true")
+	if _, _, err := OIDCConfigFromEnv(); err == nil {
+		t.Fatal("linking without OIDC_ALLOWED_DOMAINS accepted")
+	}
+	t.Setenv("OIDC_ALLOWED_DOMAINS", "example.com")
+	if cfg, _, err := OIDCConfigFromEnv(); err != nil || !cfg.LinkByEmail {
+		t.Fatalf("link=%v err=%v", cfg.LinkByEmail, err)
+	}
+}
@@ -0,0 +1,253 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+const oidcStateTTL = 10 * time.Minute
+
+var (
+	ErrOIDCState           = errors.New("invalid or expired sign-in state")
+	ErrOIDCNotProvisioned  = errors.New("no account is linked to this identity")
+	ErrOIDCDomainForbidden = errors.New("email domain is not allowed")
+	ErrOIDCAccountDisabled = errors.New("account is disabled")
+)
+
+// OIDCLoginState is what we keep server-side between the redirect to the
+// provider and the callback. Only a hash of the state value is stored.
+type OIDCLoginState struct {
+	Nonce        string `db:"nonce"`
+	CodeVerifier string `db:"code_verifier"`
+	RedirectTo   string `db:"redirect_to"`
+}
+
+// NewOIDCLoginState creates and stores state, nonce and PKCE verifier.
+func NewOIDCLoginState(db sqlx.Execer, redirectTo string) (string, *OIDCLoginState, error) {
+	state, err := NewRandomBase64URL(32)
+	if err != nil {
+		return "", nil, err
+	}
+	nonce, err := NewRandomBase64URL(32)
+	if err != nil {
+		return "", nil, err
+	}
+	verifier, err := NewRandomBase64URL(48)
+	if err != nil {
+		return "", nil, err
+	}
+	st := &OIDCLoginState{Nonce: nonce, CodeVerifier: verifier, RedirectTo: redirectTo}
+
+	// Opportunistic cleanup of abandoned sign-ins
+	_, _ = db.Exec(`DELETE FROM oidc_login_states WHERE expires_at < NOW() - INTERVAL 1 DAY`)
+	if _, err := db.Exec(`
+		INSERT INTO oidc_login_states (state_sha256, nonce, code_verifier, redirect_to, expires_at)
+		VALUES (?, ?, ?, ?, ?)
+	`, HashSHA256Hex(state), nonce, verifier, redirectTo, time.Now().Add(oidcStateTTL)); err != nil {
+		return "", nil, err
+	}
+	return state, st, nil
+}
+
+// ConsumeOIDCLoginState marks the state used (single use) and returns it.
+func ConsumeOIDCLoginState(db *sqlx.DB, state string) (*OIDCLoginState, error) {
+	hash := HashSHA256Hex(state)
+	res, err := db.Exec(`
+		UPDATE oidc_login_states SET consumed_at = NOW()
+		WHERE state_sha256 = ? AND consumed_at IS NULL AND expires_at > NOW()
+	`, hash)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := res.RowsAffected(); n != 1 {
+		return nil, ErrOIDCState
+	}
+	var st OIDCLoginState
+	if err := db.Get(&st, `
+		SELECT nonce, code_verifier, redirect_to FROM oidc_login_states WHERE state_sha256 = ?
+	`, hash); err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
+
+func (cfg OIDCConfig) domainAllowed(emailCanon string) bool {
+	return len(cfg.AllowedDomains) == 0 || emailDomainIn(emailCanon, cfg.AllowedDomains)
+}
+
+// ResolveOIDCUser maps a verified external identity to a local user:
+//  1. an existing link (issuer, subject) wins;
+//  2. otherwise, with a provider-verified email in an allowed domain, an
+//     account with that email is linked (OIDC_LINK_BY_EMAIL), unless it
+//     holds users:admin;
+//  3. otherwise a new account is provisioned (OIDC_JIT_PROVISIONING).
+//
+// Service accounts and disabled accounts are never signed in.
+func ResolveOIDCUser(db *sqlx.DB, cfg OIDCConfig, id *OIDCIdentity) (int64, error) {
+	tx, err := db.Beginx()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var uid int64
+	err = tx.Get(&uid, `
+		SELECT user_id FROM user_identities WHERE issuer = ? AND subject = ? FOR UPDATE
+	`, id.Issuer, id.Subject)
+	switch {
+	case err == nil:
+		if err := checkOIDCUsable(tx, uid); err != nil {
+			return 0, err
+		}
+		if _, err := tx.Exec(`
+			UPDATE user_identities SET last_login_at = NOW(), email = ? WHERE issuer = ? AND subject = ?
+		`, id.Email, id.Issuer, id.Subject); err != nil {
+			return 0, err
+		}
+		return uid, tx.Commit()
+	case !errors.Is(err, sql.ErrNoRows):
+		return 0, err
+	}
+
+	// No link yet: everything below relies on the email the provider vouches for
+	if id.Email == "" || !id.EmailVerified {
+		return 0, ErrOIDCNotProvisioned
+	}
+	email, emailCanon, err := CanonicalEmail(id.Email)
+	if err != nil {
+		return 0, ErrOIDCNotProvisioned
+	}
+	if !cfg.domainAllowed(emailCanon) {
+		return 0, ErrOIDCDomainForbidden
+	}
+
+	err = tx.Get(&uid, `SELECT id FROM users WHERE email_canonical = ? FOR UPDATE`, emailCanon)
+	switch {
+	case err == nil:
+		if !cfg.LinkByEmail {
+			return 0, ErrOIDCNotProvisioned
+		}
+		if err := checkOIDCUsable(tx, uid); err != nil {
+			return 0, err
+		}
+		// An IdP account must not take over an admin by email alone
+		_, perms, err := LoadUserAccess(tx, uid)
+		if err != nil {
+			return 0, err
+		}
+		for _, p := range perms {
+			if p == PermUsersAdmin {
+				return 0, ErrOIDCNotProvisioned
+			}
+		}
+		// The provider verified the address, so the local account is verified too
+		if _, err := tx.Exec(`UPDATE users SET is_verified = TRUE WHERE id = ?`, uid); err != nil {
+			return 0, err
+		}
+	case errors.Is(err, sql.ErrNoRows):
+		if !cfg.JITProvisioning {
+			return 0, ErrOIDCNotProvisioned
+		}
+		if uid, err = provisionOIDCUser(tx, id, email); err != nil {
+			return 0, err
+		}
+	default:
+		return 0, err
+	}
+
+	if _, err := tx.Exec(`
+		INSERT INTO user_identities (user_id, issuer, subject, email, last_login_at)
+		VALUES (?, ?, ?, ?, NOW())
+	`, uid, id.Issuer, id.Subject, id.Email); err != nil {
+		return 0, err
+	}
+	return uid, tx.Commit()
+}
+
+func checkOIDCUsable(tx *sqlx.Tx, uid int64) error {
+	var u struct {
+		IsActive  bool `db:"is_active"`
+		IsService bool `db:"is_service_account"`
+	}
+	if err := tx.Get(&u, `SELECT is_active, is_service_account FROM users WHERE id = ?`, uid); err != nil {
+		return err
+	}
+	if !u.IsActive || u.IsService {
+		return ErrOIDCAccountDisabled
+	}
+	return nil
+}
+
+// provisionOIDCUser creates a verified account without a usable password,
+// with the default role and organization. The username is derived from
+// preferred_username or the email local part; a numeric suffix resolves clashes.
+func provisionOIDCUser(tx *sqlx.Tx, id *OIDCIdentity, email string) (int64, error) {
+	base := oidcUsernameBase(id.PreferredUsername)
+	if base == "" {
+		base = oidcUsernameBase(email[:strings.LastIndexByte(email, '@')])
+	}
+	if base == "" {
+		base = "user"
+	}
+
+	var uid int64
+	var err error
+	for i := 1; i <= 50; i++ {
+		name := base
+		if i > 1 {
+			name = base + strconv.Itoa(i)
+		}
+		uid, err = InsertUser(tx, NewUser{Username: name, Email: email, Verified: true})
+		if err == nil {
+			break
+		}
+		if errors.Is(err, ErrUsernameReserved) && base != "user" {
+			base, i = "user", 0
+			continue
+		}
+		if !errors.Is(err, ErrIdentityTaken) {
+			return 0, err
+		}
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if err := GrantRole(tx, uid, DefaultRole(), nil); err != nil {
+		return 0, err
+	}
+	if slug := DefaultOrgSlug(); slug != "" {
+		if err := AddOrgMemberBySlug(tx, slug, uid, nil); err != nil {
+			return 0, err
+		}
+	}
+	return uid, nil
+}
+
+// oidcUsernameBase reduces a provider-supplied name to something that passes
+// CanonicalUsername: ASCII letters, digits, '_' and '-', at most 28 characters
+// (room for a suffix).
+func oidcUsernameBase(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '_', r == '-':
+			b.WriteRune(r)
+		case r == '.' || r == ' ':
+			b.WriteRune('_')
+		}
+		if b.Len() >= 28 {
+			break
+		}
+	}
+	out := strings.Trim(b.String(), "_-")
+	if len(out) < 3 {
+		return ""
+	}
+	return out
+}
@@ -0,0 +1,70 @@
+package services
+
+import (
+	"database/sql"
+	"log"
+	"os"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// DefaultOrgSlug is the organization self-registered users join.
+// An empty DEFAULT_ORG_SLUG is not distinguishable from unset, so use "-" to disable.
+func DefaultOrgSlug() string {
+	v := os.Getenv("DEFAULT_ORG_SLUG")
+	switch v {
+	case "":
+		return "default"
+	case "-":
+		return ""
+	}
+	return v
+}
+
+// ActiveOrgForUser picks the organization a new session starts in: the one
+// used most recently, otherwise the oldest membership. Returns 0 when the
+// user belongs to no organization.
+func ActiveOrgForUser(db sqlx.Queryer, userID int64) (int64, error) {
+	var orgID int64
+	err := sqlx.Get(db, &orgID, `
+		SELECT org_id FROM organization_members
+		WHERE user_id = ?
+		ORDER BY last_used_at IS NULL, last_used_at DESC, joined_at, org_id
+		LIMIT 1
+	`, userID)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return orgID, err
+}
+
+func IsOrgMember(db sqlx.Queryer, userID, orgID int64) (bool, error) {
+	var n int
+	if err := sqlx.Get(db, &n, `
+		SELECT COUNT(*) FROM organization_members WHERE user_id = ? AND org_id = ?
+	`, userID, orgID); err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// AddOrgMemberBySlug adds userID to the organization with the given slug, if it exists.
+func AddOrgMemberBySlug(db sqlx.Execer, slug string, userID int64, addedBy *int64) error {
+	_, err := db.Exec(`
+		INSERT IGNORE INTO organization_members (org_id, user_id, added_by)
+		SELECT id, ?, ? FROM organizations WHERE slug = ?
+	`, userID, addedBy, slug)
+	return err
+}
+
+// RecordOrgAccessDenial stores a rejected cross-tenant access attempt. Best-effort:
+// the request is rejected either way.
+func RecordOrgAccessDenial(db sqlx.Execer, userID, orgID int64, action, ip string) {
+	if _, err := db.Exec(`
+		INSERT INTO org_access_denials (user_id, org_id, action, ip)
+		VALUES (?, ?, ?, ?)
+	`, userID, orgID, action, ip); err != nil {
+		log.Printf("[tenancy] record denial: %v", err)
+	}
+	log.Printf("[tenancy] denied user=%d org=%d action=%s ip=%s", userID, orgID, action, ip)
+}
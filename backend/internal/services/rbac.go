@@ -0,0 +1,158 @@
+package services
+
+import (
+	"errors"
+	"os"
+	"sort"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Permission names. They are stored in the permissions table and carried in
+// the session claims; handlers refer to them only through these constants.
+const (
+	PermCustomersRead   = "customers:read"
+	PermCustomersWrite  = "customers:write"
+	PermCustomersExport = "customers:export"
+	PermUsersAdmin      = "users:admin"
+	PermAuditRead       = "audit:read"
+)
+
+const RoleAdmin = "admin"
+
+var ErrUnknownRole = errors.New("unknown role")
+
+// DefaultRole is granted to self-registered users.
+func DefaultRole() string {
+	if r := os.Getenv("DEFAULT_USER_ROLE"); r != "" {
+		return r
+	}
+	return "staff"
+}
+
+// LoadUserAccess returns the user's role names and the union of their permissions, sorted.
+func LoadUserAccess(db sqlx.Queryer, userID int64) (roles, perms []string, err error) {
+	roles = []string{}
+	if err := sqlx.Select(db, &roles, `
+		SELECT r.name
+		FROM user_roles ur
+		JOIN roles r ON r.id = ur.role_id
+		WHERE ur.user_id = ?
+		ORDER BY r.name
+	`, userID); err != nil {
+		return nil, nil, err
+	}
+	perms = []string{}
+	if err := sqlx.Select(db, &perms, `
+		SELECT DISTINCT p.name
+		FROM user_roles ur
+		JOIN role_permissions rp ON rp.role_id = ur.role_id
+		JOIN permissions p ON p.id = rp.permission_id
+		WHERE ur.user_id = ?
+	`, userID); err != nil {
+		return nil, nil, err
+	}
+	sort.Strings(perms)
+	return roles, perms, nil
+}
+
+// GrantRole adds a role by name. Granting a role the user already has is a no-op.
+func GrantRole(db sqlx.Ext, userID int64, role string, grantedBy *int64) error {
+	res, err := db.Exec(`
+		INSERT IGNORE INTO user_roles (user_id, role_id, granted_by)
+		SELECT ?, id, ? FROM roles WHERE name = ?
+	`, userID, grantedBy, role)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		var exists int
+		if err := sqlx.Get(db, &exists, `SELECT COUNT(*) FROM roles WHERE name = ?`, role); err != nil {
+			return err
+		}
+		if exists == 0 {
+			return ErrUnknownRole
+		}
+	}
+	return nil
+}
+
+// SetUserRoles replaces the user's roles with exactly the given set.
+func SetUserRoles(tx *sqlx.Tx, userID int64, roles []string, grantedBy int64) error {
+	if len(roles) > 0 {
+		q, args, err := sqlx.In(`SELECT COUNT(*) FROM roles WHERE name IN (?)`, roles)
+		if err != nil {
+			return err
+		}
+		var n int
+		if err := tx.Get(&n, tx.Rebind(q), args...); err != nil {
+			return err
+		}
+		if n != len(uniqueStrings(roles)) {
+			return ErrUnknownRole
+		}
+	}
+
+	if _, err := tx.Exec(`DELETE FROM user_roles WHERE user_id = ?`, userID); err != nil {
+		return err
+	}
+	for _, r := range uniqueStrings(roles) {
+		if err := GrantRole(tx, userID, r, &grantedBy); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AdminCount returns how many users hold the admin role. Callers changing
+// roles check it inside their transaction to never remove the last admin.
+func AdminCount(db sqlx.Queryer) (int, error) {
+	var n int
+	err := sqlx.Get(db, &n, `
+		SELECT COUNT(*) FROM user_roles ur JOIN roles r ON r.id = ur.role_id
+		WHERE r.name = ?
+	`, RoleAdmin)
+	return n, err
+}
+
+// IsLastActiveAdmin reports whether uid is the only active admin left.
+func IsLastActiveAdmin(db sqlx.Queryer, uid int64) (bool, error) {
+	var others, self int
+	if err := sqlx.Get(db, &others, `
+		SELECT COUNT(*) FROM user_roles ur
+		JOIN roles r ON r.id = ur.role_id
+		JOIN users u ON u.id = ur.user_id
+		WHERE r.name = ? AND u.is_active AND u.id <> ?
+	`, RoleAdmin, uid); err != nil {
+		return false, err
+	}
+	if err := sqlx.Get(db, &self, `
+		SELECT COUNT(*) FROM user_roles ur JOIN roles r ON r.id = ur.role_id
+		WHERE r.name = ? AND ur.user_id = ?
+	`, RoleAdmin, uid); err != nil {
+		return false, err
+	}
+	return self > 0 && others == 0, nil
+}
+
+// HasPermission reports whether perm is in the (sorted or unsorted) list.
+func HasPermission(perms []string, perm string) bool {
+	for _, p := range perms {
+		if p == perm {
+			return true
+		}
+	}
+	return false
+}
+
+func uniqueStrings(in []string) []string {
+	seen := make(map[string]bool, len(in))
+	out := make([]string, 0, len(in))
+	for _, s := range in {
+		if !seen[s] {
+			seen[s] = true
+			out = append(out, s)
+		}
+	}
+	return out
+}
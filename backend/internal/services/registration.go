@@ -0,0 +1,162 @@
+package services
+
+import (
+	"database/sql"
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Self-registration modes (REGISTRATION_MODE).
+const (
+	RegistrationModeOpen    = "open"    // anyone with an email address
+	RegistrationModeInvite  = "invite"  // only with an admin-issued invite
+	RegistrationModeDomains = "domains" // emails in REGISTRATION_ALLOWED_DOMAINS, or with an invite
+)
+
+const InviteTTL = 7 * 24 * time.Hour
+
+var (
+	ErrRegistrationClosed = errors.New("registration requires an invitation")
+	ErrInviteInvalid      = errors.New("invitation is invalid, expired or already used")
+)
+
+// RegistrationMode reads REGISTRATION_MODE. Unknown values fall back to
+// invite-only so a typo never opens sign-up to everyone.
+func RegistrationMode() string {
+	switch m := strings.ToLower(strings.TrimSpace(os.Getenv("REGISTRATION_MODE"))); m {
+	case RegistrationModeOpen, RegistrationModeDomains:
+		return m
+	}
+	return RegistrationModeInvite
+}
+
+// RegistrationAllowedDomains reads REGISTRATION_ALLOWED_DOMAINS (comma-separated).
+func RegistrationAllowedDomains() []string {
+	var out []string
+	for _, d := range strings.Split(os.Getenv("REGISTRATION_ALLOWED_DOMAINS"), ",") {
+		if d = strings.ToLower(strings.TrimSpace(d)); d != "" {
+			out = append(out, d)
+		}
+	}
+	return out
+}
+
+// emailDomainIn reports whether the domain of a canonical email is one of domains.
+func emailDomainIn(emailCanon string, domains []string) bool {
+	at := strings.LastIndexByte(emailCanon, '@')
+	if at < 0 {
+		return false
+	}
+	domain := emailCanon[at+1:]
+	for _, d := range domains {
+		if domain == d {
+			return true
+		}
+	}
+	return false
+}
+
+// RegistrationAllowedWithoutInvite reports whether the mode lets this
+// (canonical) email register on its own.
+func RegistrationAllowedWithoutInvite(mode, emailCanon string) bool {
+	switch mode {
+	case RegistrationModeOpen:
+		return true
+	case RegistrationModeDomains:
+		return emailDomainIn(emailCanon, RegistrationAllowedDomains())
+	}
+	return false
+}
+
+// RegistrationInvite is a row of registration_invites (role_name joined from roles).
+type RegistrationInvite struct {
+	ID        int64          `db:"id"`
+	Email     string         `db:"email"`
+	Role      sql.NullString `db:"role_name"`
+	ExpiresAt time.Time      `db:"expires_at"`
+	UsedAt    sql.NullTime   `db:"used_at"`
+	UsedBy    sql.NullInt64  `db:"used_by"`
+	RevokedAt sql.NullTime   `db:"revoked_at"`
+	CreatedBy sql.NullInt64  `db:"created_by"`
+	CreatedAt time.Time      `db:"created_at"`
+}
+
+// CreateRegistrationInvite stores a single-use invite bound to one email
+// address and returns the raw token (only its SHA-256 is kept). role may be
+// empty for the default role.
+func CreateRegistrationInvite(db sqlx.Ext, email, role string, ttl time.Duration, createdBy int64) (string, int64, error) {
+	email, emailCanon, err := CanonicalEmail(email)
+	if err != nil {
+		return "", 0, err
+	}
+	var roleID any
+	if role != "" {
+		var id int64
+		err := sqlx.Get(db, &id, `SELECT id FROM roles WHERE name = ?`, role)
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", 0, ErrUnknownRole
+		}
+		if err != nil {
+			return "", 0, err
+		}
+		roleID = id
+	}
+	raw, err := NewRandomBase64URL(32)
+	if err != nil {
+		return "", 0, err
+	}
+	res, err := db.Exec(`
+		INSERT INTO registration_invites (token_sha256, email, email_canonical, role_id, expires_at, created_by)
+		VALUES (?, ?, ?, ?, ?, ?)
+	`, HashSHA256Hex(raw), email, emailCanon, roleID, time.Now().Add(ttl), createdBy)
+	if err != nil {
+		return "", 0, err
+	}
+	id, _ := res.LastInsertId()
+	return raw, id, nil
+}
+
+// ConsumeRegistrationInvite marks the invite used by userID inside the
+// registration transaction and returns the pre-assigned role ("" = default).
+// The invite only works for the email address it was issued to.
+func ConsumeRegistrationInvite(tx *sqlx.Tx, raw, emailCanon string, userID int64) (string, error) {
+	var inv struct {
+		ID   int64          `db:"id"`
+		Role sql.NullString `db:"role_name"`
+	}
+	err := tx.Get(&inv, `
+		SELECT i.id, r.name AS role_name
+		FROM registration_invites i LEFT JOIN roles r ON r.id = i.role_id
+		WHERE i.token_sha256 = ? AND i.email_canonical = ?
+		  AND i.used_at IS NULL AND i.revoked_at IS NULL AND i.expires_at > NOW()
+		FOR UPDATE
+	`, HashSHA256Hex(raw), emailCanon)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", ErrInviteInvalid
+	}
+	if err != nil {
+		return "", err
+	}
+	if _, err := tx.Exec(`
+		UPDATE registration_invites SET used_at = NOW(), used_by = ? WHERE id = ?
+	`, userID, inv.ID); err != nil {
+		return "", err
+	}
+	return inv.Role.String, nil
+}
+
+// RegistrationInviteValid checks an invite without consuming it, so Register
+// can refuse uninvited sign-ups before revealing whether an identity exists.
+func RegistrationInviteValid(db sqlx.Queryer, raw, emailCanon string) (bool, error) {
+	var n int
+	err := sqlx.Get(db, &n, `
+		SELECT COUNT(*) FROM registration_invites
+		WHERE token_sha256 = ? AND email_canonical = ?
+		  AND used_at IS NULL AND revoked_at IS NULL AND expires_at > NOW()
+	`, HashSHA256Hex(raw), emailCanon)
+	return n > 0, err
+}
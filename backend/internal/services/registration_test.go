@@ -0,0 +1,91 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestRegistrationMode(t *testing.T) {
+	for env, want := range map[string]string{
+		"open":      RegistrationModeOpen,
+		" Domains ": RegistrationModeDomains,
+		"invite":    RegistrationModeInvite,
+		"":          RegistrationModeInvite,
+		"opn":       RegistrationModeInvite, // a typo never opens sign-up
+		"closed":    RegistrationModeInvite,
+	} {
+		t.Setenv("REGISTRATION_MODE", env)
+		if got := RegistrationMode(); got != want {
+			t.Errorf("REGISTRATION_MODE=%q: %q, want %q", env, got, want)
+		}
+	}
+}
+
+func TestRegistrationAllowedWithoutInvite(t *testing.T) {
+	t.Setenv("REGISTRATION_ALLOWED_DOMAINS", " Example.com ,, corp.example ")
+	for _, tc := range []struct {
+		mode, email string
+		want        bool
+	}{
+		{RegistrationModeOpen, "anyone@elsewhere.example", true},
+		{RegistrationModeInvite, "alice@example.com", false},
+		{RegistrationModeDomains, "alice@example.com", true},
+		{RegistrationModeDomains, "bob@corp.example", true},
+		// exact domains only
+		{RegistrationModeDomains, "alice@sub.example.com", false},
+		{RegistrationModeDomains, "alice@example.com.evil.example", false},
+		{RegistrationModeDomains, "alice@notexample.com", false},
+		// the last @ names the domain
+		{RegistrationModeDomains, `"a@example.com"@evil.example`, false},
+		{RegistrationModeDomains, "no-at-sign", false},
+		{"", "alice@example.com", false},
+	} {
+		if got := RegistrationAllowedWithoutInvite(tc.mode, tc.email); got != tc.want {
+			t.Errorf("%s %q: %v, want %v", tc.mode, tc.email, got, tc.want)
+		}
+	}
+
+	t.Setenv("REGISTRATION_ALLOWED_DOMAINS", "")
+	if RegistrationAllowedWithoutInvite(RegistrationModeDomains, "alice@example.com") {
+		t.Error("domains mode without domains let an email in")
+	}
+}
+
+func TestConsumeRegistrationInvite(t *testing.T) {
+	db, mock := newMockDB(t)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM registration_invites i LEFT JOIN roles r`).WithArgs(HashSHA256Hex("invite-1"), "alice@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "role_name"}).AddRow(5, "manager"))
+	mock.ExpectExec(`UPDATE registration_invites SET used_at = NOW\(\), used_by = \? WHERE id = \?`).
+		WithArgs(int64(9), int64(5)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectRollback()
+
+	tx, err := db.Beginx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	role, err := ConsumeRegistrationInvite(tx, "invite-1", "alice@example.com", 9)
+	if err != nil || role != "manager" {
+		t.Fatalf("role %q, err %v", role, err)
+	}
+}
+
+// Used, revoked, expired or someone else's invites are not found: one error for all.
+func TestConsumeRegistrationInviteInvalid(t *testing.T) {
+	db, mock := newMockDB(t)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM registration_invites i`).WillReturnRows(sqlmock.NewRows([]string{"id", "role_name"}))
+	mock.ExpectRollback()
+
+	tx, err := db.Beginx()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if _, err := ConsumeRegistrationInvite(tx, "invite-1", "mallory@example.com", 9); !errors.Is(err, ErrInviteInvalid) {
+		t.Fatalf("err = %v, want ErrInviteInvalid", err)
+	}
+}
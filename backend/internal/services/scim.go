@@ -0,0 +1,191 @@
+package services
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// SCIM 2.0 (RFC 7644) support for the HR provisioning integration.
+
+const (
+	SCIMSchemaUser         = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SCIMSchemaGroup        = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	SCIMSchemaListResponse = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	SCIMSchemaPatchOp      = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	SCIMSchemaError        = "urn:ietf:params:scim:api:messages:2.0:Error"
+)
+
+var ErrSCIMFilter = errors.New("unsupported or invalid filter")
+
+// reEmailValueFilter collapses `emails[type eq "work"].value` to `emails.value`:
+// we keep a single (work) address per user.
+var reEmailValueFilter = regexp.MustCompile(`(?i)emails\[[^\]]*\]\.value`)
+
+// CompileSCIMUserFilter turns the filter subset identity providers actually
+// send into a SQL condition over users:
+//
+//	attr op value [and attr op value ...]
+//
+// attr: id, userName, externalId, emails.value, active; op: eq, ne, co, sw, ew, pr.
+// userName and emails compare case-insensitively through their canonical columns.
+func CompileSCIMUserFilter(filter string) (string, []any, error) {
+	toks, err := scimTokens(reEmailValueFilter.ReplaceAllString(strings.TrimSpace(filter), "emails.value"))
+	if err != nil {
+		return "", nil, err
+	}
+	if len(toks) == 0 {
+		return "", nil, ErrSCIMFilter
+	}
+
+	var conds []string
+	var args []any
+	for i := 0; i < len(toks); {
+		if i > 0 {
+			if !strings.EqualFold(toks[i].text, "and") || toks[i].quoted {
+				return "", nil, ErrSCIMFilter
+			}
+			i++
+		}
+		if i+1 >= len(toks) {
+			return "", nil, ErrSCIMFilter
+		}
+		if toks[i].quoted || toks[i+1].quoted {
+			return "", nil, ErrSCIMFilter
+		}
+		attr, op := strings.ToLower(toks[i].text), strings.ToLower(toks[i+1].text)
+		i += 2
+
+		col, kind := "", ""
+		switch attr {
+		case "id":
+			col, kind = "id", "int"
+		case "username":
+			col, kind = "username_canonical", "username"
+		case "externalid":
+			col, kind = "scim_external_id", "string"
+		case "emails", "emails.value":
+			col, kind = "email_canonical", "email"
+		case "active":
+			col, kind = "is_active", "bool"
+		default:
+			return "", nil, ErrSCIMFilter
+		}
+
+		if op == "pr" {
+			conds = append(conds, "("+col+" IS NOT NULL AND "+col+" <> '')")
+			continue
+		}
+		if i >= len(toks) {
+			return "", nil, ErrSCIMFilter
+		}
+		val := toks[i]
+		i++
+
+		var arg any
+		switch kind {
+		case "int":
+			n, err := strconv.ParseInt(val.text, 10, 64)
+			if err != nil {
+				return "", nil, ErrSCIMFilter
+			}
+			arg = n
+		case "bool":
+			b, err := strconv.ParseBool(strings.ToLower(val.text))
+			if err != nil || val.quoted {
+				return "", nil, ErrSCIMFilter
+			}
+			arg = b
+		case "username":
+			arg = FoldUsername(val.text)
+		case "email":
+			arg = strings.ToLower(val.text)
+		default:
+			arg = val.text
+		}
+
+		switch op {
+		case "eq":
+			conds = append(conds, col+" = ?")
+		case "ne":
+			conds = append(conds, col+" <> ?")
+		case "co", "sw", "ew":
+			s, ok := arg.(string)
+			if !ok {
+				return "", nil, ErrSCIMFilter
+			}
+			s = LikeEscape(s)
+			switch op {
+			case "co":
+				s = "%" + s + "%"
+			case "sw":
+				s = s + "%"
+			case "ew":
+				s = "%" + s
+			}
+			conds = append(conds, col+` LIKE ? ESCAPE '\\'`)
+			arg = s
+		default:
+			return "", nil, ErrSCIMFilter
+		}
+		args = append(args, arg)
+	}
+	return strings.Join(conds, " AND "), args, nil
+}
+
+// LikeEscape escapes the LIKE wildcards in user input (backslash is MySQL's escape character).
+func LikeEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+type scimToken struct {
+	text   string
+	quoted bool
+}
+
+func scimTokens(s string) ([]scimToken, error) {
+	var out []scimToken
+	for i := 0; i < len(s); {
+		switch {
+		case isSCIMSpace(s[i]):
+			i++
+		case s[i] == '"':
+			var b strings.Builder
+			i++
+			for {
+				if i >= len(s) {
+					return nil, ErrSCIMFilter
+				}
+				if s[i] == '\\' && i+1 < len(s) {
+					b.WriteByte(s[i+1])
+					i += 2
+					continue
+				}
+				if s[i] == '"' {
+					i++
+					break
+				}
+				b.WriteByte(s[i])
+				i++
+			}
+			out = append(out, scimToken{text: b.String(), quoted: true})
+		case s[i] == '(' || s[i] == ')' || s[i] == '[' || s[i] == ']':
+			return nil, ErrSCIMFilter // grouping / complex filters are not supported
+		default:
+			j := i
+			for j < len(s) && !isSCIMSpace(s[j]) && s[j] != '"' {
+				j++
+			}
+			out = append(out, scimToken{text: s[i:j]})
+			i = j
+		}
+	}
+	return out, nil
+}
+
+// isSCIMSpace reports ASCII white space. The tokenizer walks bytes, and
+// unicode.IsSpace on a byte would also split UTF-8 sequences (0x85, 0xA0).
+func isSCIMSpace(b byte) bool {
+	return b == ' ' || b == '\t' || b == '\n' || b == '\r' || b == '\v' || b == '\f'
+}
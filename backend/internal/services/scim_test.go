@@ -0,0 +1,104 @@
+package services
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestCompileSCIMUserFilter(t *testing.T) {
+	for _, tc := range []struct {
+		filter string
+		where  string
+		args   []any
+	}{
+		// each operator
+		{`userName eq "Alice"`, `username_canonical = ?`, []any{"alice"}},
+		{`userName ne "alice"`, `username_canonical <> ?`, []any{"alice"}},
+		{`userName co "li"`, `username_canonical LIKE ? ESCAPE '\\'`, []any{"%li%"}},
+		{`userName sw "Al"`, `username_canonical LIKE ? ESCAPE '\\'`, []any{"al%"}},
+		{`userName ew "CE"`, `username_canonical LIKE ? ESCAPE '\\'`, []any{"%ce"}},
+		{`externalId pr`, `(scim_external_id IS NOT NULL AND scim_external_id <> '')`, nil},
+		// attributes, case-insensitive names and operators
+		{`ID EQ "42"`, `id = ?`, []any{int64(42)}},
+		{`id eq 42`, `id = ?`, []any{int64(42)}},
+		{`externalId eq "E-1"`, `scim_external_id = ?`, []any{"E-1"}},
+		{`active eq false`, `is_active = ?`, []any{false}},
+		{`emails.value eq "Alice@Example.com"`, `email_canonical = ?`, []any{"alice@example.com"}},
+		{`emails eq "a@b.example"`, `email_canonical = ?`, []any{"a@b.example"}},
+		{`emails[type eq "work"].value eq "a@b.example"`, `email_canonical = ?`, []any{"a@b.example"}},
+		// "and" chains, with every condition kept in order
+		{`userName sw "a" and active eq true AND externalId pr`,
+			`username_canonical LIKE ? ESCAPE '\\' AND is_active = ? AND (scim_external_id IS NOT NULL AND scim_external_id <> '')`,
+			[]any{"a%", true}},
+		// quoted strings with escapes and multibyte text
+		{`externalId eq "say \"hi\" \\ bye"`, `scim_external_id = ?`, []any{`say "hi" \ bye`}},
+		{`externalId eq "and"`, `scim_external_id = ?`, []any{"and"}},
+		{`userName eq "José"`, `username_canonical = ?`, []any{"josé"}},
+		{`userName eq José`, `username_canonical = ?`, []any{"josé"}}, // 'é' ends in 0xA9, 'à' in 0xA0
+		{`externalId eq voilà`, `scim_external_id = ?`, []any{"voilà"}},
+		// LIKE wildcards in the value match literally
+		{`externalId co "50%_off\\"`, `scim_external_id LIKE ? ESCAPE '\\'`, []any{`%50\%\_off\\%`}},
+	} {
+		where, args, err := CompileSCIMUserFilter(tc.filter)
+		if err != nil {
+			t.Errorf("%s: %v", tc.filter, err)
+			continue
+		}
+		if where != tc.where || !reflect.DeepEqual(args, tc.args) {
+			t.Errorf("%s:\n got %s %#v\nwant %s %#v", tc.filter, where, args, tc.where, tc.args)
+		}
+	}
+}
+
+func TestCompileSCIMUserFilterRejects(t *testing.T) {
+	for _, filter := range []string{
+		``,
+		`   `,
+		// "or", "not" and grouping are not supported: no precedence to get wrong
+		`userName eq "a" or userName eq "b"`,
+		`userName eq "a" and userName eq "b" or active eq true`,
+		`not (userName eq "a")`,
+		`(userName eq "a")`,
+		`members[value eq "1"]`,
+		// unknown attributes and operators
+		`password eq "x"`,
+		`name.givenName eq "Alice"`,
+		`userName gt "a"`,
+		`userName lk "a"`,
+		// malformed
+		`userName`,
+		`userName eq`,
+		`userName eq "a" and`,
+		`userName eq "a" userName eq "b"`,
+		`userName eq "a" "and" active eq true`,
+		`"userName" eq "a"`,
+		`userName "eq" "a"`,
+		`userName eq "unterminated`,
+		// typed values
+		`id eq "abc"`,
+		`id co "1"`,
+		`active eq "true"`,
+		`active eq yes`,
+		`active sw "t"`,
+	} {
+		if where, args, err := CompileSCIMUserFilter(filter); !errors.Is(err, ErrSCIMFilter) {
+			t.Errorf("%q: (%q, %v, %v), want ErrSCIMFilter", filter, where, args, err)
+		}
+	}
+}
+
+func TestLikeEscape(t *testing.T) {
+	for in, want := range map[string]string{
+		`plain`:    `plain`,
+		`100%`:     `100\%`,
+		`a_b`:      `a\_b`,
+		`back\`:    `back\\`,
+		`\%_`:      `\\\%\_`,
+		`ünïcödé%`: `ünïcödé\%`,
+	} {
+		if got := LikeEscape(in); got != want {
+			t.Errorf("LikeEscape(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
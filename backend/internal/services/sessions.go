@@ -0,0 +1,262 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Server-side session records. The session JWT carries a session ID (sid);
+// a token is only accepted while its session row is live, so sessions can be
+// revoked before they expire (logout, "this wasn't me").
+
+var (
+	ErrAccountLocked = errors.New("account is locked")
+	ErrLockdownLink  = errors.New("invalid or expired link")
+)
+
+// DeviceCookie identifies a browser across sign-ins (random, no user data).
+const (
+	DeviceCookie    = "device_id"
+	DeviceCookieTTL = 400 * 24 * time.Hour
+)
+
+// LockdownLinkTTL is how long a "this wasn't me" link works.
+const LockdownLinkTTL = 7 * 24 * time.Hour
+
+// Impersonation sessions are short-lived. While one is in use, the admin's
+// own session token waits in ImpersonatorCookie to be restored.
+const (
+	ImpersonationTTL   = 30 * time.Minute
+	ImpersonatorCookie = "impersonator_token"
+)
+
+// CreateSession records a new session for userID and returns its sid.
+func CreateSession(db sqlx.Execer, userID int64, deviceSHA, ip, userAgent string, ttl time.Duration) (string, error) {
+	return createSession(db, userID, sql.NullInt64{}, deviceSHA, ip, userAgent, ttl)
+}
+
+// CreateImpersonationSession records a session of userID used by the admin
+// impersonatorID. It ends early if the admin is deactivated or locked.
+func CreateImpersonationSession(db sqlx.Execer, userID, impersonatorID int64, deviceSHA, ip, userAgent string, ttl time.Duration) (string, error) {
+	return createSession(db, userID, sql.NullInt64{Int64: impersonatorID, Valid: true}, deviceSHA, ip, userAgent, ttl)
+}
+
+func createSession(db sqlx.Execer, userID int64, impersonatorID sql.NullInt64, deviceSHA, ip, userAgent string, ttl time.Duration) (string, error) {
+	sid, err := NewRandomBase64URL(18)
+	if err != nil {
+		return "", err
+	}
+	if _, err := db.Exec(`
+		INSERT INTO user_sessions (sid, user_id, impersonator_id, device_sha256, ip, user_agent, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, sid, userID, impersonatorID, deviceSHA, ip, clip(userAgent, 255), time.Now().Add(ttl)); err != nil {
+		return "", err
+	}
+	return sid, nil
+}
+
+// SessionActive reports whether sid is a live session of userID: not revoked
+// or expired, and the account (and impersonating admin, if any) is active and
+// not locked. Checked on every cookie-authenticated request.
+func SessionActive(db sqlx.Queryer, userID int64, sid string) (bool, error) {
+	if sid == "" {
+		return false, nil // issued before server-side sessions existed
+	}
+	var n int
+	err := sqlx.Get(db, &n, `
+		SELECT COUNT(*) FROM user_sessions s
+		JOIN users u ON u.id = s.user_id
+		LEFT JOIN users a ON a.id = s.impersonator_id
+		WHERE s.sid = ? AND s.user_id = ? AND s.revoked_at IS NULL AND s.expires_at > NOW()
+		  AND u.is_active = TRUE AND u.locked_at IS NULL
+		  AND (s.impersonator_id IS NULL OR (a.is_active = TRUE AND a.locked_at IS NULL))
+	`, sid, userID)
+	return n > 0, err
+}
+
+// RevokeSession ends one session (e.g. on logout).
+func RevokeSession(db sqlx.Execer, userID int64, sid string) error {
+	_, err := db.Exec(`
+		UPDATE user_sessions SET revoked_at = NOW()
+		WHERE sid = ? AND user_id = ? AND revoked_at IS NULL
+	`, sid, userID)
+	return err
+}
+
+// RevokeSessionByID ends one session of userID picked from its sessions list.
+// It reports the session's sid, or ok=false if it is not live.
+func RevokeSessionByID(db *sqlx.DB, userID, id int64) (sid string, ok bool, err error) {
+	err = db.Get(&sid, `
+		SELECT sid FROM user_sessions
+		WHERE id = ? AND user_id = ? AND revoked_at IS NULL AND expires_at > NOW()
+	`, id, userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return sid, true, RevokeSession(db, userID, sid)
+}
+
+// RevokeOtherSessions ends every live session of userID except keepSID and
+// returns how many there were.
+func RevokeOtherSessions(db sqlx.Execer, userID int64, keepSID string) (int64, error) {
+	res, err := db.Exec(`
+		UPDATE user_sessions SET revoked_at = NOW()
+		WHERE user_id = ? AND sid <> ? AND revoked_at IS NULL AND expires_at > NOW()
+	`, userID, keepSID)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// RevokeUserSessions ends every session of userID and returns how many were live.
+func RevokeUserSessions(db sqlx.Execer, userID int64) (int64, error) {
+	res, err := db.Exec(`
+		UPDATE user_sessions SET revoked_at = NOW()
+		WHERE user_id = ? AND revoked_at IS NULL AND expires_at > NOW()
+	`, userID)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// RecordSignInDevice remembers the device a user signed in from and reports
+// whether it is new: neither its device cookie nor its IP + user agent
+// combination was seen for this user before. An account's first device is
+// never reported (there is nothing to compare it with). deviceID is the row
+// to reference in a lockdown link.
+func RecordSignInDevice(db *sqlx.DB, userID int64, deviceSHA, ip, userAgent string) (deviceID int64, isNew bool, err error) {
+	userAgent = clip(userAgent, 255)
+
+	err = db.Get(&deviceID, `SELECT id FROM user_devices WHERE user_id = ? AND device_sha256 = ?`, userID, deviceSHA)
+	if err == nil {
+		_, err = db.Exec(`
+			UPDATE user_devices SET ip = ?, user_agent = ?, last_seen_at = NOW() WHERE id = ?
+		`, ip, userAgent, deviceID)
+		return deviceID, false, err
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return 0, false, err
+	}
+
+	var seen struct {
+		Devices   int `db:"devices"`
+		SameCombo int `db:"same_combo"`
+	}
+	if err := db.Get(&seen, `
+		SELECT COUNT(*) AS devices,
+		       COALESCE(SUM(ip = ? AND user_agent = ?), 0) AS same_combo
+		FROM user_devices WHERE user_id = ?
+	`, ip, userAgent, userID); err != nil {
+		return 0, false, err
+	}
+	res, err := db.Exec(`
+		INSERT INTO user_devices (user_id, device_sha256, ip, user_agent) VALUES (?, ?, ?, ?)
+	`, userID, deviceSHA, ip, userAgent)
+	if err != nil {
+		return 0, false, err
+	}
+	deviceID, _ = res.LastInsertId()
+	return deviceID, seen.Devices > 0 && seen.SameCombo == 0, nil
+}
+
+// NewLockdownToken signs a "this wasn't me" link for the sign-in from deviceID:
+// base64url("uid.deviceID.expiryUnix") + "." + base64url(HMAC-SHA256).
+func NewLockdownToken(userID, deviceID int64, expires time.Time) (string, error) {
+	key, err := lockdownKey()
+	if err != nil {
+		return "", err
+	}
+	payload := fmt.Sprintf("%d.%d.%d", userID, deviceID, expires.Unix())
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+		base64.RawURLEncoding.EncodeToString(lockdownMAC(key, payload)), nil
+}
+
+// ParseLockdownToken checks the signature and expiry of a lockdown link.
+func ParseLockdownToken(token string) (userID, deviceID int64, err error) {
+	key, err := lockdownKey()
+	if err != nil {
+		return 0, 0, err
+	}
+	p64, m64, ok := strings.Cut(token, ".")
+	if !ok {
+		return 0, 0, ErrLockdownLink
+	}
+	payload, err1 := base64.RawURLEncoding.DecodeString(p64)
+	mac, err2 := base64.RawURLEncoding.DecodeString(m64)
+	if err1 != nil || err2 != nil || !hmac.Equal(mac, lockdownMAC(key, string(payload))) {
+		return 0, 0, ErrLockdownLink
+	}
+	parts := strings.Split(string(payload), ".")
+	if len(parts) != 3 {
+		return 0, 0, ErrLockdownLink
+	}
+	userID, err1 = strconv.ParseInt(parts[0], 10, 64)
+	deviceID, err2 = strconv.ParseInt(parts[1], 10, 64)
+	exp, err3 := strconv.ParseInt(parts[2], 10, 64)
+	if err1 != nil || err2 != nil || err3 != nil || time.Now().Unix() > exp {
+		return 0, 0, ErrLockdownLink
+	}
+	return userID, deviceID, nil
+}
+
+func lockdownKey() ([]byte, error) {
+	k := os.Getenv("HMAC_SECRET")
+	if k == "" {
+		return nil, errors.New("missing HMAC_SECRET")
+	}
+	return []byte(k), nil
+}
+
+func lockdownMAC(key []byte, payload string) []byte {
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte("lockdown|" + payload)) // domain-separated from other uses of the key
+	return m.Sum(nil)
+}
+
+// LockDownAccount handles a "this wasn't me" report for the sign-in from
+// deviceID: the account is locked until its password is reset, and every
+// session, API key and OAuth access token of the user is revoked. Each link
+// works once (ErrLockdownLink afterwards).
+func LockDownAccount(db *sqlx.DB, userID, deviceID int64) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var used sql.NullTime
+	err = tx.Get(&used, `SELECT lockdown_at FROM user_devices WHERE id = ? AND user_id = ? FOR UPDATE`, deviceID, userID)
+	if errors.Is(err, sql.ErrNoRows) || used.Valid {
+		return ErrLockdownLink
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE user_devices SET lockdown_at = NOW() WHERE id = ?`, deviceID); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(`UPDATE users SET locked_at = NOW() WHERE id = ?`, userID); err != nil {
+		return err
+	}
+	for _, table := range []string{"user_sessions", "api_keys", "oauth_access_tokens"} {
+		if _, err := tx.Exec(`UPDATE `+table+` SET revoked_at = NOW() WHERE user_id = ? AND revoked_at IS NULL`, userID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
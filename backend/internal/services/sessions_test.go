@@ -0,0 +1,149 @@
+package services
+
+import (
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestLockdownToken(t *testing.T) {
+	t.Setenv("HMAC_SECRET", "lockdown-test-key")
+	tok, err := NewLockdownToken(7, 31, time.Now().Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	uid, dev, err := ParseLockdownToken(tok)
+	if err != nil || uid != 7 || dev != 31 {
+		t.Fatalf("ParseLockdownToken = %d, %d, %v", uid, dev, err)
+	}
+
+	p64, m64, _ := strings.Cut(tok, ".")
+	forged := base64.RawURLEncoding.EncodeToString([]byte("8.31." + strings.Split(mustDecode(t, p64), ".")[2]))
+	expired, _ := NewLockdownToken(7, 31, time.Now().Add(-time.Second))
+	for name, bad := range map[string]string{
+		"other user":  forged + "." + m64,
+		"cut mac":     p64 + "." + m64[:len(m64)-2],
+		"no mac":      p64,
+		"empty":       "",
+		"not base64":  "!!!.???",
+		"expired":     expired,
+		"two payload": p64 + "." + p64,
+	} {
+		if _, _, err := ParseLockdownToken(bad); !errors.Is(err, ErrLockdownLink) {
+			t.Errorf("%s: err = %v, want ErrLockdownLink", name, err)
+		}
+	}
+
+	t.Setenv("HMAC_SECRET", "another-key")
+	if _, _, err := ParseLockdownToken(tok); !errors.Is(err, ErrLockdownLink) {
+		t.Errorf("other key: err = %v, want ErrLockdownLink", err)
+	}
+	t.Setenv("HMAC_SECRET", "")
+	if _, err := NewLockdownToken(7, 31, time.Now()); err == nil {
+		t.Error("signed a link without HMAC_SECRET")
+	}
+}
+
+func mustDecode(t *testing.T, s string) string {
+	t.Helper()
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestRecordSignInDevice(t *testing.T) {
+	const ua = "Mozilla/5.0"
+	t.Run("known device", func(t *testing.T) {
+		db, mock := newMockDB(t)
+		mock.ExpectQuery(`SELECT id FROM user_devices WHERE user_id = \? AND device_sha256 = \?`).
+			WithArgs(int64(7), "dev-sha").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(3))
+		mock.ExpectExec(`UPDATE user_devices SET ip = \?, user_agent = \?, last_seen_at = NOW\(\) WHERE id = \?`).
+			WithArgs("198.51.100.7", ua, int64(3)).WillReturnResult(sqlmock.NewResult(0, 1))
+
+		id, isNew, err := RecordSignInDevice(db, 7, "dev-sha", "198.51.100.7", ua)
+		if err != nil || id != 3 || isNew {
+			t.Fatalf("= %d, %v, %v", id, isNew, err)
+		}
+	})
+	for _, tc := range []struct {
+		name             string
+		devices, sameIPs int
+		isNew            bool
+	}{
+		{"first device of the account", 0, 0, false},
+		{"new cookie, known IP and user agent", 2, 1, false},
+		{"new device", 2, 0, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			mock.ExpectQuery(`SELECT id FROM user_devices`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+			mock.ExpectQuery(`AS same_combo\s+FROM user_devices WHERE user_id = \?`).WithArgs("198.51.100.7", ua, int64(7)).
+				WillReturnRows(sqlmock.NewRows([]string{"devices", "same_combo"}).AddRow(tc.devices, tc.sameIPs))
+			mock.ExpectExec(`INSERT INTO user_devices`).WithArgs(int64(7), "dev-sha", "198.51.100.7", ua).
+				WillReturnResult(sqlmock.NewResult(4, 1))
+
+			id, isNew, err := RecordSignInDevice(db, 7, "dev-sha", "198.51.100.7", ua)
+			if err != nil || id != 4 || isNew != tc.isNew {
+				t.Fatalf("= %d, %v, %v; want 4, %v", id, isNew, err, tc.isNew)
+			}
+		})
+	}
+}
+
+// A lockdown locks the account and revokes every credential, in one transaction.
+func TestLockDownAccount(t *testing.T) {
+	db, mock := newMockDB(t)
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT lockdown_at FROM user_devices WHERE id = \? AND user_id = \? FOR UPDATE`).
+		WithArgs(int64(31), int64(7)).WillReturnRows(sqlmock.NewRows([]string{"lockdown_at"}).AddRow(nil))
+	mock.ExpectExec(`UPDATE user_devices SET lockdown_at = NOW\(\) WHERE id = \?`).WithArgs(int64(31)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE users SET locked_at = NOW\(\) WHERE id = \?`).WithArgs(int64(7)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	for _, table := range []string{"user_sessions", "api_keys", "oauth_access_tokens"} {
+		mock.ExpectExec(`UPDATE ` + table + ` SET revoked_at = NOW\(\) WHERE user_id = \? AND revoked_at IS NULL`).
+			WithArgs(int64(7)).WillReturnResult(sqlmock.NewResult(0, 2))
+	}
+	mock.ExpectCommit()
+
+	if err := LockDownAccount(db, 7, 31); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Each link works once; another user's device is not found.
+func TestLockDownAccountOnce(t *testing.T) {
+	for name, rows := range map[string]*sqlmock.Rows{
+		"used":           sqlmock.NewRows([]string{"lockdown_at"}).AddRow(time.Now()),
+		"other's device": sqlmock.NewRows([]string{"lockdown_at"}),
+	} {
+		t.Run(name, func(t *testing.T) {
+			db, mock := newMockDB(t)
+			mock.ExpectBegin()
+			mock.ExpectQuery(`SELECT lockdown_at FROM user_devices`).WillReturnRows(rows)
+			mock.ExpectRollback()
+			if err := LockDownAccount(db, 7, 31); !errors.Is(err, ErrLockdownLink) {
+				t.Fatalf("err = %v, want ErrLockdownLink", err)
+			}
+		})
+	}
+}
+
+func TestSessionActive(t *testing.T) {
+	db, mock := newMockDB(t)
+	// tokens from before server-side sessions have no sid: never active, no query
+	if ok, err := SessionActive(db, 7, ""); ok || err != nil {
+		t.Fatalf("empty sid: %v, %v", ok, err)
+	}
+	mock.ExpectQuery(`FROM user_sessions s`).WithArgs("sid-1", int64(7)).
+		WillReturnRows(sqlmock.NewRows([]string{"n"}).AddRow(0))
+	if ok, err := SessionActive(db, 7, "sid-1"); ok || err != nil {
+		t.Fatalf("revoked sid: %v, %v", ok, err)
+	}
+}
@@ -0,0 +1,471 @@
+package services
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SIEM export: audit entries are forwarded, after they are stored, to the
+// SOC's collector as RFC 5424 syslog, CEF or JSON lines. Export is
+// asynchronous through a bounded queue; when the collector is slow or down,
+// the queue fills and new events are dropped (and counted) rather than
+// slowing requests down. The audit log stays the source of truth.
+
+const (
+	SIEMFormatSyslog = "syslog"
+	SIEMFormatCEF    = "cef"
+	SIEMFormatJSON   = "json"
+)
+
+// syslogFacilityAuthPriv is the facility of every message (security/authorization, private).
+const syslogFacilityAuthPriv = 10
+
+// siemSDID is the RFC 5424 structured data ID. 32473 is the example
+// enterprise number reserved for documentation (RFC 5612).
+const siemSDID = "audit@32473"
+
+// SIEMConfig selects where and how events are exported.
+type SIEMConfig struct {
+	Target  *url.URL // udp://host:port, tcp://host:port, tls://host:port or file:///path
+	Format  string
+	Buffer  int      // queue size
+	Events  []string // event types, or prefixes ending in "."; empty = all
+	AppName string
+	TLS     *tls.Config
+}
+
+// SIEMConfigFromEnv reads SIEM_TARGET, SIEM_FORMAT, SIEM_BUFFER, SIEM_EVENTS,
+// SIEM_APP_NAME and SIEM_TLS_CA_FILE. It returns nil when SIEM_TARGET is
+// empty (export disabled).
+func SIEMConfigFromEnv() (*SIEMConfig, error) {
+	raw := strings.TrimSpace(os.Getenv("SIEM_TARGET"))
+	if raw == "" {
+		return nil, nil
+	}
+	return ParseSIEMConfig(raw, os.Getenv("SIEM_FORMAT"), os.Getenv("SIEM_BUFFER"),
+		os.Getenv("SIEM_EVENTS"), os.Getenv("SIEM_APP_NAME"), os.Getenv("SIEM_TLS_CA_FILE"))
+}
+
+// ParseSIEMConfig validates the settings; empty values take the defaults
+// (format syslog, buffer 1000, all events, app name "secure-comm").
+func ParseSIEMConfig(target, format, buffer, events, appName, caFile string) (*SIEMConfig, error) {
+	u, err := url.Parse(strings.TrimSpace(target))
+	if err != nil {
+		return nil, fmt.Errorf("invalid SIEM_TARGET: %w", err)
+	}
+	cfg := &SIEMConfig{Target: u, Format: SIEMFormatSyslog, Buffer: 1000, AppName: "secure-comm"}
+	switch u.Scheme {
+	case "udp", "tcp", "tls":
+		if u.Port() == "" {
+			return nil, errors.New("SIEM_TARGET needs host:port")
+		}
+	case "file":
+		if u.Path == "" {
+			return nil, errors.New("SIEM_TARGET file:// needs an absolute path")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported SIEM_TARGET scheme %q (udp, tcp, tls, file)", u.Scheme)
+	}
+
+	switch f := strings.ToLower(strings.TrimSpace(format)); f {
+	case "":
+	case SIEMFormatSyslog, SIEMFormatCEF, SIEMFormatJSON:
+		cfg.Format = f
+	default:
+		return nil, fmt.Errorf("unsupported SIEM_FORMAT %q (syslog, cef, json)", format)
+	}
+	if b := strings.TrimSpace(buffer); b != "" {
+		n, err := strconv.Atoi(b)
+		if err != nil || n < 1 || n > 1000000 {
+			return nil, errors.New("SIEM_BUFFER must be between 1 and 1000000")
+		}
+		cfg.Buffer = n
+	}
+	for _, e := range strings.Split(events, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			cfg.Events = append(cfg.Events, e)
+		}
+	}
+	if a := strings.TrimSpace(appName); a != "" {
+		cfg.AppName = a
+	}
+
+	if u.Scheme == "tls" {
+		cfg.TLS = &tls.Config{ServerName: u.Hostname(), MinVersion: tls.VersionTLS12}
+		if caFile != "" {
+			pem, err := os.ReadFile(caFile)
+			if err != nil {
+				return nil, fmt.Errorf("SIEM_TLS_CA_FILE: %w", err)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, errors.New("SIEM_TLS_CA_FILE: no certificates found")
+			}
+			cfg.TLS.RootCAs = pool
+		}
+	}
+	return cfg, nil
+}
+
+func (cfg *SIEMConfig) wants(eventType string) bool {
+	if len(cfg.Events) == 0 {
+		return true
+	}
+	for _, e := range cfg.Events {
+		if e == eventType || (strings.HasSuffix(e, ".") && strings.HasPrefix(eventType, e)) {
+			return true
+		}
+	}
+	return false
+}
+
+// SIEMExporter ships audit entries in the background. Use NewSIEMExporter,
+// then Publish from any goroutine and Close on shutdown.
+type SIEMExporter struct {
+	cfg      *SIEMConfig
+	hostname string
+	queue    chan *AuditEntry
+	dropped  atomic.Int64
+	lost     atomic.Int64 // given up on during shutdown
+	stop     chan struct{}
+	done     chan struct{}
+	closing  sync.Once
+
+	conn net.Conn // network targets; only used by run
+	file *os.File // file target; only used by run
+}
+
+// NewSIEMExporter starts the export worker. Nothing is dialed until the
+// first event, so a collector that is down at startup does not block it.
+func NewSIEMExporter(cfg *SIEMConfig) *SIEMExporter {
+	host, _ := os.Hostname()
+	if host == "" {
+		host = "-"
+	}
+	x := &SIEMExporter{
+		cfg:      cfg,
+		hostname: host,
+		queue:    make(chan *AuditEntry, cfg.Buffer),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go x.run()
+	return x
+}
+
+// Publish queues e for export without blocking. It returns false when the
+// event was dropped because the queue is full or the exporter is closed.
+func (x *SIEMExporter) Publish(e *AuditEntry) bool {
+	if !x.cfg.wants(e.EventType) {
+		return true
+	}
+	select {
+	case <-x.stop:
+		return false
+	default:
+	}
+	select {
+	case x.queue <- e:
+		return true
+	default:
+		x.dropped.Add(1)
+		return false
+	}
+}
+
+// Close stops accepting events and waits until the queue is flushed or ctx
+// ends; events still queued then are lost.
+func (x *SIEMExporter) Close(ctx context.Context) error {
+	x.closing.Do(func() { close(x.stop) })
+	select {
+	case <-x.done:
+		if n := x.lost.Load(); n > 0 {
+			return fmt.Errorf("siem: %d event(s) not delivered", n)
+		}
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("siem: %d event(s) not delivered: %w", len(x.queue), ctx.Err())
+	}
+}
+
+func (x *SIEMExporter) run() {
+	defer close(x.done)
+	defer x.disconnect()
+	for {
+		var e *AuditEntry
+		select {
+		case e = <-x.queue:
+		case <-x.stop:
+			// drain what is queued, then exit; Close bounds how long this may take
+			select {
+			case e = <-x.queue:
+			default:
+				return
+			}
+		}
+		if !x.deliver(x.frame(e)) {
+			x.lost.Add(1)
+		}
+		if n := x.dropped.Swap(0); n > 0 {
+			log.Printf("[siem] dropped %d event(s): queue full", n)
+		}
+	}
+}
+
+// deliver writes one frame, reconnecting with exponential backoff (1s to
+// 30s) until it succeeds. Once Close was called it retries only once, so the
+// shutdown deadline is not spent on a dead collector.
+func (x *SIEMExporter) deliver(frame []byte) bool {
+	backoff := time.Second
+	for attempt := 0; ; attempt++ {
+		err := x.write(frame)
+		if err == nil {
+			return true
+		}
+		x.disconnect()
+		if attempt == 0 {
+			log.Printf("[siem] write to %s failed, retrying: %v", x.cfg.Target.Redacted(), err)
+		}
+		select {
+		case <-x.stop:
+			if attempt > 0 {
+				return false
+			}
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, 30*time.Second)
+	}
+}
+
+func (x *SIEMExporter) write(frame []byte) error {
+	if x.cfg.Target.Scheme == "file" {
+		if x.file == nil {
+			f, err := os.OpenFile(x.cfg.Target.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+			if err != nil {
+				return err
+			}
+			x.file = f
+		}
+		_, err := x.file.Write(frame)
+		return err
+	}
+	if x.conn == nil {
+		if err := x.connect(); err != nil {
+			return err
+		}
+	}
+	_ = x.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	_, err := x.conn.Write(frame)
+	return err
+}
+
+func (x *SIEMExporter) connect() error {
+	d := &net.Dialer{Timeout: 5 * time.Second, KeepAlive: 30 * time.Second}
+	var err error
+	switch x.cfg.Target.Scheme {
+	case "tls":
+		x.conn, err = tls.DialWithDialer(d, "tcp", x.cfg.Target.Host, x.cfg.TLS)
+	default:
+		x.conn, err = d.Dial(x.cfg.Target.Scheme, x.cfg.Target.Host)
+	}
+	return err
+}
+
+func (x *SIEMExporter) disconnect() {
+	if x.conn != nil {
+		_ = x.conn.Close()
+		x.conn = nil
+	}
+	if x.file != nil {
+		_ = x.file.Close()
+		x.file = nil
+	}
+}
+
+// frame renders e in the configured format and frames it for the transport:
+// one datagram per event over UDP, octet counting (RFC 6587) for syslog over
+// TCP/TLS, and newline-terminated lines otherwise.
+func (x *SIEMExporter) frame(e *AuditEntry) []byte {
+	var msg string
+	switch x.cfg.Format {
+	case SIEMFormatCEF:
+		msg = FormatCEF(e)
+	case SIEMFormatJSON:
+		msg = FormatAuditJSON(e)
+	default:
+		msg = FormatSyslog5424(e, x.hostname, x.cfg.AppName)
+	}
+	switch {
+	case x.cfg.Target.Scheme == "udp":
+		return []byte(msg)
+	case x.cfg.Format == SIEMFormatSyslog && x.cfg.Target.Scheme != "file":
+		return []byte(strconv.Itoa(len(msg)) + " " + msg)
+	}
+	return []byte(msg + "\n")
+}
+
+// auditSeverity maps an outcome to a syslog severity and a CEF severity (0-10).
+func auditSeverity(outcome string) (syslog, cef int) {
+	switch outcome {
+	case AuditDenied:
+		return 4, 7 // warning: lockouts, throttling, access refused
+	case AuditFailure:
+		return 5, 5 // notice: bad credentials or codes
+	}
+	return 6, 3 // informational
+}
+
+// FormatSyslog5424 renders e as an RFC 5424 message with the entry's fields as
+// structured data and the details as the (UTF-8) message.
+func FormatSyslog5424(e *AuditEntry, hostname, appName string) string {
+	sev, _ := auditSeverity(e.Outcome)
+	var b strings.Builder
+	fmt.Fprintf(&b, "<%d>1 %s %s %s %d %s [%s",
+		syslogFacilityAuthPriv*8+sev,
+		e.OccurredAt.UTC().Format("2006-01-02T15:04:05.000000Z"),
+		syslogHeaderField(hostname, 255), syslogHeaderField(appName, 48), os.Getpid(),
+		syslogHeaderField(e.EventType, 32), siemSDID)
+	param := func(name, v string) {
+		if v != "" {
+			b.WriteString(" " + name + `="` + syslogParamEscaper.Replace(v) + `"`)
+		}
+	}
+	param("id", strconv.FormatInt(e.ID, 10))
+	param("outcome", e.Outcome)
+	if e.ActorUserID.Valid {
+		param("actorId", strconv.FormatInt(e.ActorUserID.Int64, 10))
+	}
+	param("actor", e.ActorLabel)
+	param("subjectType", e.SubjectType)
+	param("subjectId", e.SubjectID)
+	if e.OrgID.Valid {
+		param("orgId", strconv.FormatInt(e.OrgID.Int64, 10))
+	}
+	param("ip", e.IP)
+	param("userAgent", e.UserAgent)
+	param("requestId", e.RequestID)
+	param("hash", e.EntryHash)
+	b.WriteString("]")
+	if e.Details.Valid {
+		b.WriteString(" \ufeff" + e.Details.String) // BOM marks the message as UTF-8
+	}
+	return b.String()
+}
+
+var syslogParamEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`)
+
+// syslogHeaderField keeps printable US-ASCII only, as header fields require.
+func syslogHeaderField(s string, max int) string {
+	s = strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 {
+			return -1
+		}
+		return r
+	}, s)
+	if s == "" {
+		return "-"
+	}
+	return clip(s, max)
+}
+
+// FormatCEF renders e as an ArcSight Common Event Format line.
+func FormatCEF(e *AuditEntry) string {
+	_, sev := auditSeverity(e.Outcome)
+	var b strings.Builder
+	fmt.Fprintf(&b, "CEF:0|Secure Communication Ltd|secure-comm|1.0|%s|%s|%d|",
+		cefHeaderEscaper.Replace(e.EventType),
+		cefHeaderEscaper.Replace(e.EventType+" "+e.Outcome), sev)
+	ext := []string{}
+	add := func(k, v string) {
+		if v != "" {
+			ext = append(ext, k+"="+cefExtEscaper.Replace(v))
+		}
+	}
+	add("rt", strconv.FormatInt(e.OccurredAt.UnixMilli(), 10))
+	add("externalId", strconv.FormatInt(e.ID, 10))
+	add("outcome", e.Outcome)
+	add("src", e.IP)
+	add("suser", e.ActorLabel)
+	if e.ActorUserID.Valid {
+		add("suid", strconv.FormatInt(e.ActorUserID.Int64, 10))
+	}
+	add("requestClientApplication", e.UserAgent)
+	if e.SubjectType != "" {
+		add("cs1Label", "subject")
+		add("cs1", e.SubjectType+":"+e.SubjectID)
+	}
+	if e.RequestID != "" {
+		add("cs2Label", "requestId")
+		add("cs2", e.RequestID)
+	}
+	if e.Details.Valid {
+		add("cs3Label", "details")
+		add("cs3", e.Details.String)
+	}
+	if e.OrgID.Valid {
+		add("cn1Label", "orgId")
+		add("cn1", strconv.FormatInt(e.OrgID.Int64, 10))
+	}
+	b.WriteString(strings.Join(ext, " "))
+	return b.String()
+}
+
+var (
+	cefHeaderEscaper = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\r", " ", "\n", " ")
+	cefExtEscaper    = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\r", `\r`, "\n", `\n`)
+)
+
+// FormatAuditJSON renders e as one JSON object (no trailing newline).
+func FormatAuditJSON(e *AuditEntry) string {
+	m := map[string]any{
+		"id":           e.ID,
+		"occurred_at":  e.OccurredAt.UTC().Format("2006-01-02T15:04:05.000000Z"),
+		"event_type":   e.EventType,
+		"outcome":      e.Outcome,
+		"actor_label":  e.ActorLabel,
+		"subject_type": e.SubjectType,
+		"subject_id":   e.SubjectID,
+		"ip":           e.IP,
+		"user_agent":   e.UserAgent,
+		"request_id":   e.RequestID,
+		"entry_hash":   e.EntryHash,
+	}
+	if e.ActorUserID.Valid {
+		m["actor_user_id"] = e.ActorUserID.Int64
+	}
+	if e.OrgID.Valid {
+		m["org_id"] = e.OrgID.Int64
+	}
+	if e.Details.Valid {
+		m["details"] = json.RawMessage(e.Details.String)
+	}
+	b, _ := json.Marshal(m)
+	return string(b)
+}
+
+var auditExporter atomic.Pointer[SIEMExporter]
+
+// SetAuditExporter makes AppendAudit forward every stored entry to x
+// (nil = stop exporting).
+func SetAuditExporter(x *SIEMExporter) {
+	auditExporter.Store(x)
+}
+
+func exportAudit(e *AuditEntry) {
+	if x := auditExporter.Load(); x != nil {
+		x.Publish(e)
+	}
+}
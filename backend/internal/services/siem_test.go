@@ -0,0 +1,297 @@
+package services
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testAuditEntry(id int64) *AuditEntry {
+	return &AuditEntry{
+		ID:          id,
+		OccurredAt:  time.Date(2026, 3, 1, 12, 30, 45, 123456000, time.UTC),
+		EventType:   "auth.login_locked",
+		Outcome:     AuditDenied,
+		ActorUserID: sql.NullInt64{Int64: 42, Valid: true},
+		ActorLabel:  "alice",
+		SubjectType: "user",
+		SubjectID:   "42",
+		OrgID:       sql.NullInt64{Int64: 3, Valid: true},
+		IP:          "192.0.2.10",
+		UserAgent:   `curl/8 "x" [y] \z`,
+		RequestID:   "req-1",
+		Details:     sql.NullString{String: `{"attempts":5}`, Valid: true},
+		EntryHash:   "abc123",
+	}
+}
+
+func testSIEMExporter(t *testing.T, target, format string, buffer int) *SIEMExporter {
+	t.Helper()
+	cfg, err := ParseSIEMConfig(target, format, strconv.Itoa(buffer), "", "test-app", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewSIEMExporter(cfg)
+}
+
+func closeSIEM(t *testing.T, x *SIEMExporter) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := x.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSIEMSyslogUDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	x := testSIEMExporter(t, "udp://"+pc.LocalAddr().String(), "", 10)
+	defer closeSIEM(t, x)
+
+	x.Publish(testAuditEntry(7))
+	_ = pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 64<<10)
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := string(buf[:n])
+
+	// <authpriv.warning>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID [SD] BOM MSG
+	hdr := regexp.MustCompile(`^<84>1 2026-03-01T12:30:45\.123456Z (\S+) test-app (\d+) auth\.login_locked \[audit@32473 `)
+	m := hdr.FindStringSubmatch(msg)
+	if m == nil {
+		t.Fatalf("header: %q", msg)
+	}
+	if m[2] != strconv.Itoa(os.Getpid()) {
+		t.Errorf("procid = %s", m[2])
+	}
+	for _, want := range []string{
+		`id="7"`, `outcome="denied"`, `actorId="42"`, `actor="alice"`, `subjectType="user"`,
+		`subjectId="42"`, `orgId="3"`, `ip="192.0.2.10"`, `requestId="req-1"`, `hash="abc123"`,
+		`userAgent="curl/8 \"x\" [y\] \\z"`,
+	} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("missing %s in %q", want, msg)
+		}
+	}
+	if !strings.HasSuffix(msg, "] \ufeff"+`{"attempts":5}`) {
+		t.Errorf("message: %q", msg)
+	}
+}
+
+// Syslog over TCP is octet-counted (RFC 6587): "LEN SP MSG", no delimiter.
+func TestSIEMSyslogTCPOctetCounting(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	x := testSIEMExporter(t, "tcp://"+ln.Addr().String(), SIEMFormatSyslog, 10)
+	defer closeSIEM(t, x)
+
+	e1, e2 := testAuditEntry(1), testAuditEntry(2)
+	e2.Details = sql.NullString{String: "{\"note\":\"two\\nlines\"}", Valid: true}
+	x.Publish(e1)
+	x.Publish(e2)
+
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+	for _, want := range []*AuditEntry{e1, e2} {
+		lenField, err := r.ReadString(' ')
+		if err != nil {
+			t.Fatal(err)
+		}
+		n, err := strconv.Atoi(strings.TrimSuffix(lenField, " "))
+		if err != nil {
+			t.Fatalf("length field %q", lenField)
+		}
+		msg := make([]byte, n)
+		if _, err := io.ReadFull(r, msg); err != nil {
+			t.Fatal(err)
+		}
+		if !strings.HasPrefix(string(msg), "<84>1 ") || !strings.Contains(string(msg), `id="`+strconv.FormatInt(want.ID, 10)+`"`) {
+			t.Fatalf("frame: %q", msg)
+		}
+		if !strings.HasSuffix(string(msg), want.Details.String) {
+			t.Fatalf("frame %d cut: %q", want.ID, msg)
+		}
+	}
+}
+
+func TestFormatCEFEscaping(t *testing.T) {
+	e := testAuditEntry(9)
+	e.EventType = `odd|type\x` + "\nnext"
+	e.Outcome = AuditSuccess
+	e.ActorLabel = "a=b|c\\d\r\ne"
+	got := FormatCEF(e)
+
+	parts := strings.SplitN(got, "|", 8)
+	if len(parts) != 8 {
+		t.Fatalf("header: %q", got)
+	}
+	// Unescaped pipes split the header; the escaped ones must not
+	head := regexp.MustCompile(`^CEF:0\|Secure Communication Ltd\|secure-comm\|1\.0\|odd\\\|type\\\\x next\|odd\\\|type\\\\x next success\|3\|`)
+	if !head.MatchString(got) {
+		t.Fatalf("header: %q", got)
+	}
+	for _, want := range []string{
+		`suser=a\=b|c\\d\r\ne`,
+		`rt=` + strconv.FormatInt(e.OccurredAt.UnixMilli(), 10),
+		`externalId=9`, `src=192.0.2.10`, `suid=42`,
+		`cs1Label=subject cs1=user:42`, `cs2Label=requestId cs2=req-1`,
+		`cs3Label=details cs3={"attempts":5}`, `cn1Label=orgId cn1=3`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("missing %s in %q", want, got)
+		}
+	}
+	if strings.ContainsAny(got, "\r\n") {
+		t.Errorf("raw line break in %q", got)
+	}
+}
+
+func TestSIEMJSONLinesTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	x := testSIEMExporter(t, "tcp://"+ln.Addr().String(), SIEMFormatJSON, 10)
+	defer closeSIEM(t, x)
+	x.Publish(testAuditEntry(5))
+	x.Publish(testAuditEntry(6))
+
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+	for _, id := range []float64{5, 6} {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got map[string]any
+		if err := json.Unmarshal([]byte(line), &got); err != nil {
+			t.Fatalf("%v: %q", err, line)
+		}
+		details, _ := got["details"].(map[string]any)
+		if got["id"] != id || got["event_type"] != "auth.login_locked" || got["outcome"] != "denied" ||
+			got["occurred_at"] != "2026-03-01T12:30:45.123456Z" || got["actor_user_id"] != float64(42) ||
+			got["org_id"] != float64(3) || got["entry_hash"] != "abc123" || details["attempts"] != float64(5) {
+			t.Fatalf("line: %q", line)
+		}
+	}
+}
+
+// deadTCPAddr returns an address nothing listens on.
+func deadTCPAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	return addr
+}
+
+// A collector that comes up after the first attempt gets the event on a retry.
+func TestSIEMRetriesUntilCollectorIsUp(t *testing.T) {
+	addr := deadTCPAddr(t)
+	x := testSIEMExporter(t, "tcp://"+addr, SIEMFormatJSON, 10)
+	defer closeSIEM(t, x)
+	x.Publish(testAuditEntry(1))
+
+	time.Sleep(200 * time.Millisecond) // first attempt fails, backoff starts
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Skipf("address reused meanwhile: %v", err)
+	}
+	defer ln.Close()
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || !strings.Contains(line, `"id":1`) {
+		t.Fatalf("line %q, err %v", line, err)
+	}
+}
+
+// With the collector down, a full queue drops new events and Close reports
+// the queued ones as lost instead of waiting on the backoff.
+func TestSIEMDeadTargetDropsAndCloseReportsLoss(t *testing.T) {
+	x := testSIEMExporter(t, "tcp://"+deadTCPAddr(t), SIEMFormatJSON, 2)
+	if !x.Publish(testAuditEntry(1)) {
+		t.Fatal("first event dropped")
+	}
+	// wait for the worker to take it and start retrying
+	deadline := time.Now().Add(5 * time.Second)
+	for len(x.queue) > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !x.Publish(testAuditEntry(2)) || !x.Publish(testAuditEntry(3)) {
+		t.Fatal("queued events dropped")
+	}
+	if x.Publish(testAuditEntry(4)) {
+		t.Fatal("event accepted by a full queue")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	start := time.Now()
+	err := x.Close(ctx)
+	if err == nil || !strings.Contains(err.Error(), "3 event(s) not delivered") {
+		t.Fatalf("Close = %v", err)
+	}
+	if d := time.Since(start); d > 3*time.Second {
+		t.Fatalf("Close took %v", d)
+	}
+	if x.Publish(testAuditEntry(5)) {
+		t.Fatal("event accepted after Close")
+	}
+}
+
+func TestParseSIEMConfig(t *testing.T) {
+	for _, bad := range []string{"", "udp://collector", "http://collector:514", "file://"} {
+		if _, err := ParseSIEMConfig(bad, "", "", "", "", ""); err == nil {
+			t.Errorf("target %q accepted", bad)
+		}
+	}
+	cfg, err := ParseSIEMConfig("tls://siem.example.com:6514", "CEF", "50", "auth., admin.user_deleted", "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Format != SIEMFormatCEF || cfg.Buffer != 50 || cfg.TLS == nil || cfg.TLS.ServerName != "siem.example.com" {
+		t.Fatalf("cfg: %+v", cfg)
+	}
+	for ev, want := range map[string]bool{"auth.login_locked": true, "admin.user_deleted": true, "admin.user_unlocked": false} {
+		if cfg.wants(ev) != want {
+			t.Errorf("wants(%s) = %v", ev, !want)
+		}
+	}
+}
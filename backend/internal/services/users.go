@@ -0,0 +1,144 @@
+package services
+
+import (
+	"errors"
+
+	"github.com/jmoiron/sqlx"
+)
+
+var (
+	ErrIdentityTaken   = errors.New("username or email already exists")
+	ErrAccountInactive = errors.New("account is disabled")
+)
+
+// NewUser describes an account created outside of self-registration
+// (bootstrap, provisioning). An empty Password leaves the account without a
+// usable password: a random one is hashed and immediately forgotten.
+type NewUser struct {
+	Username string
+	Email    string
+	Password string
+	Verified bool
+}
+
+// InsertUser canonicalizes the identifiers, checks them against existing
+// accounts and inserts the user. Canonicalization errors are returned as is.
+func InsertUser(db sqlx.Ext, u NewUser) (int64, error) {
+	username, usernameCanon, err := CanonicalUsername(u.Username)
+	if err != nil {
+		return 0, err
+	}
+	email, emailCanon, err := CanonicalEmail(u.Email)
+	if err != nil {
+		return 0, err
+	}
+	skeleton := UsernameSkeleton(usernameCanon)
+
+	var exists int
+	if err := sqlx.Get(db, &exists, `
+		SELECT COUNT(*) FROM users
+		WHERE username_canonical = ? OR username_skeleton = ? OR email_canonical = ?
+	`, usernameCanon, skeleton, emailCanon); err != nil {
+		return 0, err
+	}
+	if exists > 0 {
+		return 0, ErrIdentityTaken
+	}
+
+	password := u.Password
+	if password == "" {
+		if password, err = NewRandomBase64URL(32); err != nil {
+			return 0, err
+		}
+	}
+	salt, err := GenerateSalt16()
+	if err != nil {
+		return 0, err
+	}
+	hashHex, err := HashPasswordHMACHex(password, salt)
+	if err != nil {
+		return 0, err
+	}
+	fpHex, err := HashPasswordFingerprintHex(password)
+	if err != nil {
+		return 0, err
+	}
+
+	res, err := db.Exec(`
+		INSERT INTO users (username, email, username_canonical, username_skeleton, email_canonical,
+		                   password_hmac, salt, password_fp, is_verified)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, username, email, usernameCanon, skeleton, emailCanon, hashHex, salt, fpHex, u.Verified)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// UpdateUserIdentifiers changes username and/or email (empty = keep), with
+// the same canonicalization and collision rules as InsertUser.
+func UpdateUserIdentifiers(db sqlx.Ext, userID int64, username, email string) error {
+	var cur struct {
+		Username string `db:"username"`
+		Email    string `db:"email"`
+	}
+	if err := sqlx.Get(db, &cur, `SELECT username, email FROM users WHERE id = ?`, userID); err != nil {
+		return err
+	}
+	if username == "" {
+		username = cur.Username
+	}
+	if email == "" {
+		email = cur.Email
+	}
+	username, usernameCanon, err := CanonicalUsername(username)
+	if err != nil {
+		return err
+	}
+	email, emailCanon, err := CanonicalEmail(email)
+	if err != nil {
+		return err
+	}
+	skeleton := UsernameSkeleton(usernameCanon)
+
+	var exists int
+	if err := sqlx.Get(db, &exists, `
+		SELECT COUNT(*) FROM users
+		WHERE id <> ? AND (username_canonical = ? OR username_skeleton = ? OR email_canonical = ?)
+	`, userID, usernameCanon, skeleton, emailCanon); err != nil {
+		return err
+	}
+	if exists > 0 {
+		return ErrIdentityTaken
+	}
+	_, err = db.Exec(`
+		UPDATE users
+		SET username = ?, email = ?, username_canonical = ?, username_skeleton = ?, email_canonical = ?
+		WHERE id = ?
+	`, username, email, usernameCanon, skeleton, emailCanon, userID)
+	return err
+}
+
+// DisablePassword replaces userID's password with a random one nobody knows,
+// so the account can only be signed in to again through a reset link.
+func DisablePassword(db sqlx.Execer, userID int64) error {
+	password, err := NewRandomBase64URL(32)
+	if err != nil {
+		return err
+	}
+	salt, err := GenerateSalt16()
+	if err != nil {
+		return err
+	}
+	hashHex, err := HashPasswordHMACHex(password, salt)
+	if err != nil {
+		return err
+	}
+	fpHex, err := HashPasswordFingerprintHex(password)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`UPDATE users SET password_hmac = ?, salt = ?, password_fp = ? WHERE id = ?`,
+		hashHex, salt, fpHex, userID)
+	return err
+}
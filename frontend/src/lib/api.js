@@ -13,13 +13,36 @@ async function assertOk(res) {
   return res;
 }
 
-async function post(path, body, { withCredentials = true } = {}) {
+// CSRF: state-changing requests carry X-CSRF-Token matching the server's
+// csrf_token cookie, so they always go out with credentials.
+let csrfPromise = null;
+function csrfToken(refresh = false) {
+  if (!csrfPromise || refresh) {
+    csrfPromise = fetch(`${BASE_URL}/api/csrf`, { credentials: "include" })
+      .then(parseJson)
+      .then((d) => d?.csrf_token || "")
+      .catch((err) => { csrfPromise = null; throw err; });
+  }
+  return csrfPromise;
+}
+
+// send performs a POST/PUT/DELETE; a rejected token (e.g. expired cookie) is refreshed once.
+async function send(method, path, body, retried = false) {
   const res = await fetch(`${BASE_URL}${path}`, {
-    method: "POST",
-    headers: { "Content-Type": "application/json" },
-    credentials: withCredentials ? "include" : "omit",
-    body: JSON.stringify(body),
+    method,
+    headers: { "Content-Type": "application/json", "X-CSRF-Token": await csrfToken(retried) },
+    credentials: "include",
+    body: body === undefined ? undefined : JSON.stringify(body),
   });
+  if (res.status === 403 && !retried) {
+    const data = await res.clone().json().catch(() => ({}));
+    if (/csrf/i.test(data?.error || "")) return send(method, path, body, true);
+  }
+  return res;
+}
+
+async function post(path, body) {
+  const res = await send("POST", path, body);
   await assertOk(res);
   return parseJson(res);
 }
@@ -35,12 +58,7 @@ async function get(path) {
 
 
 export async function apiRegister(payload) {
-  const res = await fetch(`${BASE_URL}/api/register`, {
-    method: "POST",
-    headers: { "Content-Type": "application/json" },
-    credentials: "omit",
-    body: JSON.stringify(payload),
-  });
+  const res = await send("POST", "/api/register", payload);
   const data = await res.json().catch(() => ({}));
   if (!res.ok) {
     throw new Error(data?.error || data?.message || "Registration failed");
@@ -59,58 +77,46 @@ export async function apiLoginMFA({ id, code }) {
 }
 
 
+// Which sign-in methods the server offers (password and/or SSO)
+export async function apiAuthConfig() {
+  return get("/api/auth/config");
+}
+
+// Full-page navigation target for single sign-on
+export function oidcLoginURL(redirect = "/dashboard") {
+  return `${BASE_URL}/api/auth/oidc/login?redirect=${encodeURIComponent(redirect)}`;
+}
+
 export async function apiLogout() {
-  return post("/api/logout", {}, { withCredentials: true });
+  return post("/api/logout", {});
 }
 export async function apiMe() {
   return get("/api/me");
 }
 
 export async function apiForgotPassword(email) {
-  
-  const res = await fetch(`${BASE_URL}/api/password/forgot`, {
-    method: "POST",
-    headers: { "Content-Type": "application/json" },
-    credentials: "omit",
-    body: JSON.stringify({ email }),
-  });
+  const res = await send("POST", "/api/password/forgot", { email });
   const data = await res.json().catch(() => ({}));
   if (!res.ok) throw new Error(data?.error || "Request failed");
   return data;
 }
 
 export async function apiResetPassword({ token, newPassword }) {
-  const res = await fetch(`${BASE_URL}/api/password/reset`, {
-    method: "POST",
-    headers: { "Content-Type": "application/json" },
-    credentials: "omit",
-    body: JSON.stringify({ token, new_password: newPassword }),
-  });
+  const res = await send("POST", "/api/password/reset", { token, new_password: newPassword });
   const data = await res.json().catch(() => ({}));
   if (!res.ok) throw new Error(data?.error || "Request failed");
   return data;
 }
 
 export async function apiPasswordChange({ oldPassword, newPassword }) {
-
-  const res = await fetch(`${BASE_URL}/api/password/change`, {
-    method: "POST",
-    headers: { "Content-Type": "application/json" },
-    credentials: "include",
-    body: JSON.stringify({ old_password: oldPassword, new_password: newPassword })
-  });
+  const res = await send("POST", "/api/password/change", { old_password: oldPassword, new_password: newPassword });
   const data = await res.json().catch(() => ({}));
   if (!res.ok) throw new Error(data?.error || "Request failed");
   return data;
 }
 
 export async function apiCreateCustomer(payload) {
-  const res = await fetch(`${BASE_URL}/api/customers`, {
-    method: "POST",
-    headers: { "Content-Type": "application/json" },
-    credentials: "include", 
-    body: JSON.stringify(payload),
-  });
+  const res = await send("POST", "/api/customers", payload);
   const data = await res.json().catch(() => ({}));
   if (!res.ok) throw new Error(data?.error || "Create customer failed");
   return data; 
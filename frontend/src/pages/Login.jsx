@@ -1,6 +1,6 @@
-import { useState } from "react";
+import { useEffect, useState } from "react";
 import { useNavigate } from "react-router-dom";
-import { apiLogin, apiLoginMFA, apiForgotPassword } from "../lib/api";
+import { apiLogin, apiLoginMFA, apiForgotPassword, apiAuthConfig, oidcLoginURL } from "../lib/api";
 
 export default function Login() {
   const nav = useNavigate();
@@ -18,6 +18,11 @@ export default function Login() {
   const [forgotLoading, setForgotLoading] = useState(false);
   const [forgotMsg, setForgotMsg] = useState({ type: "", text: "" });
 
+  const [authCfg, setAuthCfg] = useState({ local_login_enabled: true, oidc_enabled: false });
+  useEffect(() => {
+    apiAuthConfig().then(setAuthCfg).catch(() => {});
+  }, []);
+
   const onChange = (e) => setForm({ ...form, [e.target.name]: e.target.value });
 
   const looksLikeEmail = (s) => s.includes("@") && s.includes(".");
@@ -136,7 +141,19 @@ export default function Login() {
               }`}
         </p>
 
-        {step === "password" ? (
+        {step === "password" && authCfg.oidc_enabled && (
+          <div className="actions" style={{ marginTop: 12, justifyContent: "center" }}>
+            <button
+              className="btn primary"
+              type="button"
+              onClick={() => (window.location.href = oidcLoginURL("/dashboard"))}
+            >
+              Sign in with {authCfg.oidc_provider_name || "SSO"}
+            </button>
+          </div>
+        )}
+
+        {step === "password" && !authCfg.local_login_enabled ? null : step === "password" ? (
           <>
             <form onSubmit={onSubmitPassword} style={{ textAlign: "left", marginTop: 12 }}>
               <label style={{ display: "block", marginBottom: 6 }}>Email or Username</label>
@@ -285,7 +302,7 @@ export default function Login() {
           </form>
         )}
 
-        {step === "password" && (
+        {step === "password" && authCfg.local_login_enabled && (
           <div className="footer-note">
             <span className="dot" /> Don’t have an account?
             <button className="btn ghost" onClick={() => nav("/register")}>
@@ -1,8 +1,12 @@
-import React, { useState } from "react";
-import { apiRegister } from "../lib/api";
+import React, { useEffect, useState } from "react";
+import { useSearchParams } from "react-router-dom";
+import { apiAuthConfig, apiRegister } from "../lib/api";
 
 export default function Register() {
-  const [form, setForm] = useState({ username: "", email: "", password: "", confirm: "" });
+  const [sp] = useSearchParams();
+  const invite = sp.get("invite") || "";
+  const [form, setForm] = useState({ username: "", email: sp.get("email") || "", password: "", confirm: "" });
+  const [mode, setMode] = useState("");
   const [submitting, setSubmitting] = useState(false);
   const [msg, setMsg] = useState({ type: "", text: "" });
 
@@ -12,6 +16,12 @@ export default function Register() {
     setMsg({ type: "", text: "" });
   };
 
+  useEffect(() => {
+    apiAuthConfig()
+      .then((cfg) => setMode(cfg?.registration_mode || ""))
+      .catch(() => {});
+  }, []);
+
   const isEmail = (s) => /^[^\s@]+@[^\s@]+\.[^\s@]+$/.test(s);
   const basicOk =
     form.username.trim() &&
@@ -30,6 +40,7 @@ export default function Register() {
         username: form.username.trim(),
         email: form.email.trim(),
         password: form.password,
+        ...(invite ? { invite } : {}),
       });
       setMsg({ type: "ok", text: "Account created. And verify mail sent to your email." });
       setForm({ username: "", email: "", password: "", confirm: "" });
@@ -50,6 +61,13 @@ export default function Register() {
           Basic client-side checks. Password policy is enforced on the server.
         </p>
 
+        {!invite && mode === "invite" && (
+          <Note type="warn">Registration is by invitation only. Use the link from your invitation email.</Note>
+        )}
+        {!invite && mode === "domains" && (
+          <Note type="warn">Registration is limited to company email addresses unless you were invited.</Note>
+        )}
+
         <form onSubmit={onSubmit} noValidate>
           <Field label="Username">
             <input name="username" value={form.username} onChange={onChange}
@@ -58,7 +76,8 @@ export default function Register() {
 
           <Field label="Email">
             <input type="email" name="email" value={form.email} onChange={onChange}
-              placeholder="you@example.com" required className="input" autoComplete="email" />
+              placeholder="you@example.com" required className="input" autoComplete="email"
+              readOnly={!!invite} />
             {form.email && !isEmail(form.email) && (
               <Note type="warn">Please enter a valid email.</Note>
             )}